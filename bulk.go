@@ -0,0 +1,78 @@
+package metrics
+
+import "strings"
+
+// BulkRegistry is a Registry which can operate on many metrics at once, such
+// as all those belonging to a connection or tenant whose names share a
+// prefix.  EachPrefixed, GetAll and RemovePrefix fall back to Each and
+// Unregister for other Registries.
+type BulkRegistry interface {
+	Registry
+
+	// Call the given function for each registered metric whose name begins
+	// with the given prefix.
+	EachPrefixed(string, func(string, interface{}))
+
+	// Get the values of every registered metric, keyed by name and then by
+	// field, as serialized by MarshalJSON.
+	GetAll() map[string]map[string]interface{}
+
+	// Unregister every metric whose name begins with the given prefix.
+	RemovePrefix(string)
+}
+
+// EachPrefixed calls the given function for each metric in the given
+// registry whose name begins with the given prefix.
+func EachPrefixed(r Registry, prefix string, f func(string, interface{})) {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	if br, ok := r.(BulkRegistry); ok {
+		br.EachPrefixed(prefix, f)
+		return
+	}
+	r.Each(func(name string, i interface{}) {
+		if strings.HasPrefix(name, prefix) {
+			f(name, i)
+		}
+	})
+}
+
+// GetAll returns the values of every metric in the given registry, keyed by
+// name and then by field, as serialized by MarshalJSON.
+func GetAll(r Registry) map[string]map[string]interface{} {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	if br, ok := r.(BulkRegistry); ok {
+		return br.GetAll()
+	}
+	return getAll(r)
+}
+
+// RemovePrefix unregisters every metric in the given registry whose name
+// begins with the given prefix.
+func RemovePrefix(r Registry, prefix string) {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	if br, ok := r.(BulkRegistry); ok {
+		br.RemovePrefix(prefix)
+		return
+	}
+	var names []string
+	EachPrefixed(r, prefix, func(name string, _ interface{}) {
+		names = append(names, name)
+	})
+	for _, name := range names {
+		r.Unregister(name)
+	}
+}
+
+func getAll(r Registry) map[string]map[string]interface{} {
+	data := make(map[string]map[string]interface{})
+	r.Snapshot().Each(func(name string, i interface{}) {
+		data[name] = metricValues(i)
+	})
+	return data
+}
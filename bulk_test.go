@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestRemovePrefix(t *testing.T) {
+	r := NewRegistry()
+	r.Register("conn.1.bytes", NewCounter())
+	r.Register("conn.1.errors", NewCounter())
+	r.Register("conn.10.bytes", NewCounter())
+	r.Register("uptime", NewGauge())
+	r.(MetadataRegistry).SetMetadata("conn.1.bytes", Metadata{Unit: "bytes"})
+	RemovePrefix(r, "conn.1.")
+	if nil != r.Get("conn.1.bytes") || nil != r.Get("conn.1.errors") {
+		t.Error("conn.1. wasn't removed")
+	}
+	if nil == r.Get("conn.10.bytes") || nil == r.Get("uptime") {
+		t.Error("too much was removed")
+	}
+	if _, ok := r.(MetadataRegistry).Metadata("conn.1.bytes"); ok {
+		t.Error("metadata wasn't removed")
+	}
+}
+
+func TestEachPrefixed(t *testing.T) {
+	r := NewRegistry()
+	r.Register("tenant.a.requests", NewCounter())
+	r.Register("tenant.a.latency", NewTimer())
+	r.Register("tenant.b.requests", NewCounter())
+	var names []string
+	EachPrefixed(r, "tenant.a.", func(name string, _ interface{}) {
+		names = append(names, name)
+	})
+	sort.Strings(names)
+	if 2 != len(names) || "tenant.a.latency" != names[0] || "tenant.a.requests" != names[1] {
+		t.Errorf("names: %v", names)
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredCounter("counter", r).Inc(47)
+	NewRegisteredGauge("gauge", r).Update(9)
+	NewRegisteredHistogram("histogram", r, NewUniformSample(100)).Update(3)
+	all := GetAll(r)
+	if 3 != len(all) {
+		t.Fatalf("len(all): 3 != %v", len(all))
+	}
+	if count := all["counter"]["count"]; int64(47) != count {
+		t.Errorf("counter count: 47 != %v", count)
+	}
+	if value := all["gauge"]["value"]; int64(9) != value {
+		t.Errorf("gauge value: 9 != %v", value)
+	}
+	if max := all["histogram"]["max"]; int64(3) != max {
+		t.Errorf("histogram max: 3 != %v", max)
+	}
+}
+
+func TestPrefixedRegistryRemovePrefix(t *testing.T) {
+	parent := NewRegistry()
+	r := NewPrefixedChildRegistry(parent, "conn.1.")
+	r.Register("bytes", NewCounter())
+	parent.Register("conn.2.bytes", NewCounter())
+	n := 0
+	r.(BulkRegistry).EachPrefixed("", func(string, interface{}) { n++ })
+	if 1 != n {
+		t.Errorf("n: 1 != %v", n)
+	}
+	r.(BulkRegistry).RemovePrefix("")
+	if nil != parent.Get("conn.1.bytes") || nil == parent.Get("conn.2.bytes") {
+		t.Error("conn.1. wasn't removed alone")
+	}
+}
+
+func TestMergedRegistryRemovePrefix(t *testing.T) {
+	a, b := NewRegistry(), NewRegistry()
+	a.Register("conn.1.bytes", NewCounter())
+	b.Register("conn.1.bytes", NewCounter())
+	b.Register("conn.1.errors", NewCounter())
+	RemovePrefix(NewMergedRegistry(a, b), "conn.1.")
+	if nil != a.Get("conn.1.bytes") || nil != b.Get("conn.1.bytes") || nil != b.Get("conn.1.errors") {
+		t.Error("conn.1. wasn't removed from every child")
+	}
+}
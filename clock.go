@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of the time for meters, timers and exporters built
+// with one, so that tests may drive their decay and flushes
+// deterministically with a MockClock instead of waiting on the system
+// clock.
+type Clock interface {
+	Now() time.Time
+
+	// Tick returns a channel delivering the time every d and a function
+	// which stops it.
+	Tick(d time.Duration) (<-chan time.Time, func())
+}
+
+// systemClock is the Clock of the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) Tick(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
+// after returns a channel which receives the time of c once d has passed by
+// it, as time.After does, and a function which stops the ticker it's read
+// from.
+func after(c Clock, d time.Duration) (<-chan time.Time, func()) {
+	if d <= 0 {
+		now := make(chan time.Time, 1)
+		now <- c.Now()
+		return now, func() {}
+	}
+	return c.Tick(d)
+}
+
+// MockClock is a Clock which stands still until it's moved by Add or Set.
+// Its tickers deliver a tick each time it's moved past their next tick,
+// dropping ticks which the receiver isn't ready for, as time.Ticker does.
+type MockClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	tickers map[*mockTicker]struct{}
+}
+
+// NewMockClock constructs a new MockClock standing at now.
+func NewMockClock(now time.Time) *MockClock {
+	return &MockClock{now: now, tickers: make(map[*mockTicker]struct{})}
+}
+
+// Add moves the clock forward by d.
+func (c *MockClock) Add(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.set(c.now.Add(d))
+}
+
+// Now returns the time the clock stands at.
+func (c *MockClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// Set moves the clock to now.
+func (c *MockClock) Set(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.set(now)
+}
+
+// Tick returns a channel delivering the clock's time whenever it's moved
+// past a multiple of d since now, and a function which stops it.  Like
+// time.NewTicker, it panics if d isn't positive.
+func (c *MockClock) Tick(d time.Duration) (<-chan time.Time, func()) {
+	if d <= 0 {
+		panic("non-positive interval for MockClock.Tick")
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t := &mockTicker{c: make(chan time.Time, 1), d: d, next: c.now.Add(d)}
+	c.tickers[t] = struct{}{}
+	return t.c, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		delete(c.tickers, t)
+	}
+}
+
+// set moves the clock to now and ticks the tickers which are due.  It should
+// run with c.mutex held.
+func (c *MockClock) set(now time.Time) {
+	c.now = now
+	for t := range c.tickers {
+		if now.Before(t.next) {
+			continue
+		}
+		for !now.Before(t.next) {
+			t.next = t.next.Add(t.d)
+		}
+		select {
+		case t.c <- now:
+		default:
+		}
+	}
+}
+
+// mockTicker is a ticker of a MockClock.
+type mockTicker struct {
+	c    chan time.Time
+	d    time.Duration
+	next time.Time
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMockClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c := NewMockClock(start)
+	ticks, stop := c.Tick(10 * time.Second)
+	c.Add(5 * time.Second)
+	select {
+	case now := <-ticks:
+		t.Fatalf("ticked early at %v", now)
+	default:
+	}
+	c.Add(5 * time.Second)
+	if now := <-ticks; !now.Equal(start.Add(10 * time.Second)) {
+		t.Errorf("ticked at %v", now)
+	}
+	c.Set(start.Add(time.Minute)) // Ticks missed meanwhile are dropped
+	<-ticks
+	c.Add(9 * time.Second)
+	select {
+	case now := <-ticks:
+		t.Fatalf("ticked early at %v", now)
+	default:
+	}
+	stop()
+	c.Add(time.Hour)
+	select {
+	case now := <-ticks:
+		t.Fatalf("ticked at %v after stopping", now)
+	default:
+	}
+	if now := c.Now(); !now.Equal(start.Add(time.Hour + 69*time.Second)) {
+		t.Errorf("c.Now(): %v", now)
+	}
+}
+
+func TestMeterWithClock(t *testing.T) {
+	c := NewMockClock(time.Unix(1000, 0))
+	m := NewMeterWithClock(c)
+	m.Mark(3)
+	if rate := m.Rate1(); 0 != rate {
+		t.Errorf("m.Rate1() before a tick: %v", rate)
+	}
+	c.Add(5 * time.Second)
+	if rate := m.Rate1(); 0.6 != rate {
+		t.Errorf("m.Rate1(): 0.6 != %v", rate)
+	}
+	if rate := m.RateMean(); 0.6 != rate {
+		t.Errorf("m.RateMean(): 0.6 != %v", rate)
+	}
+	c.Add(time.Minute)
+	if rate := m.Snapshot().Rate1(); 0.22072766470286553 != rate {
+		t.Errorf("m.Rate1() a minute later: 0.22072766470286553 != %v", rate)
+	}
+}
+
+func TestTimerWithClock(t *testing.T) {
+	c := NewMockClock(time.Unix(1000, 0))
+	tm := NewTimerWithClock(c)
+	tm.Time(func() { c.Add(time.Second) })
+	start := c.Now()
+	c.Add(3 * time.Second)
+	tm.UpdateSince(start)
+	if min, max := tm.Min(), tm.Max(); int64(time.Second) != min || int64(3*time.Second) != max {
+		t.Errorf("tm.Min(), tm.Max(): %v, %v", min, max)
+	}
+	c.Add(time.Second)
+	if rate := tm.Rate1(); 0.4 != rate {
+		t.Errorf("tm.Rate1(): 0.4 != %v", rate)
+	}
+}
+
+// waitForTickers waits until n tickers of c are running.
+func waitForTickers(t *testing.T, c *MockClock, n int) {
+	for i := 0; i < 1000; i++ {
+		c.mutex.Lock()
+		running := len(c.tickers)
+		c.mutex.Unlock()
+		if n <= running {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("fewer than %d tickers", n)
+}
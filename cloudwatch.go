@@ -0,0 +1,382 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CloudWatchMaxDatapoints is the most datapoints CloudWatch accepts in one
+// PutMetricData call.
+const CloudWatchMaxDatapoints = 1000
+
+// CloudWatchConfig provides a container with configuration parameters for
+// the CloudWatch exporter.
+type CloudWatchConfig struct {
+	Registry        Registry          // Registry to be exported
+	FlushInterval   time.Duration     // Flush interval
+	DurationUnit    time.Duration     // Time conversion unit for durations, defaults to nanoseconds
+	Prefix          string            // Namespace of the metrics
+	Tags            map[string]string // Dimensions common to every metric
+	Region          string            // AWS region, defaults to AWS_REGION
+	AccessKeyID     string            // Credentials, default to AWS_ACCESS_KEY_ID,
+	SecretAccessKey string            // AWS_SECRET_ACCESS_KEY
+	SessionToken    string            // and AWS_SESSION_TOKEN
+	Endpoint        string            // PutMetricData URL, defaults to that of the Region
+	Client          *http.Client      // HTTP client, defaults to http.DefaultClient
+	Filter          func(string) bool // Metrics whose names it returns false for aren't exported, all are if nil
+	BatchSize       int               // Datapoints per call, at most and defaulting to CloudWatchMaxDatapoints
+}
+
+// CloudWatch is a blocking exporter function which reports the metrics in
+// c.Registry to Amazon CloudWatch every c.FlushInterval, in calls of at most
+// c.BatchSize datapoints.  Counters and meters are reported as their change
+// since the previous flush, in the Count unit, gauges as their values and
+// histograms and timers as statistic sets of the count and sum of the values
+// recorded since the previous flush and the minimum and maximum of the
+// histogram's whole sample.  The tags of Tagged metrics become dimensions.
+// Healthchecks aren't reported.
+func CloudWatch(c CloudWatchConfig) {
+	if err := CloudWatchContext(context.Background(), c); nil != err {
+		log.Println(err)
+	}
+}
+
+// CloudWatchContext is like CloudWatch but returns once ctx is done, after a
+// final flush which is given one FlushInterval to complete.  Errors from
+// flushes other than the final one are logged.
+func CloudWatchContext(ctx context.Context, c CloudWatchConfig) error {
+	e, err := newCloudWatchExporter(c)
+	if nil != err {
+		return err
+	}
+	return FlushLoopWithConfig(ctx, FlushLoopConfig{
+		Registry:      c.Registry,
+		FlushInterval: c.FlushInterval,
+		Exporter:      e,
+	})
+}
+
+// NewCloudWatchExporter constructs a new Exporter which flushes the
+// registry it's given to CloudWatch as configured by c, so that it can be
+// run by FlushLoop or be a sink of a FanoutExporter.  c.Registry, which it
+// ignores, needn't be set.
+func NewCloudWatchExporter(c CloudWatchConfig) (Exporter, error) {
+	if nil == c.Registry {
+		c.Registry = DefaultRegistry
+	}
+	return newCloudWatchExporter(c)
+}
+
+// cloudWatchExporter holds the state of a CloudWatch exporter between
+// flushes.
+type cloudWatchExporter struct {
+	config CloudWatchConfig
+	counts map[string]int64 // Counts at the last successful flush
+	sums   map[string]int64 // Sums of histograms and timers at the last successful flush
+}
+
+func newCloudWatchExporter(c CloudWatchConfig) (*cloudWatchExporter, error) {
+	if nil == c.Registry {
+		return nil, errors.New("cloudwatch: config has no Registry to export")
+	}
+	if "" == c.Prefix {
+		return nil, errors.New("cloudwatch: config has no Prefix to use as the namespace")
+	}
+	if "" == c.Region {
+		c.Region = os.Getenv("AWS_REGION")
+	}
+	if "" == c.AccessKeyID && "" == c.SecretAccessKey {
+		c.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		c.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		c.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if "" == c.Region || "" == c.AccessKeyID || "" == c.SecretAccessKey {
+		return nil, errors.New("cloudwatch: config has no Region or credentials")
+	}
+	if "" == c.Endpoint {
+		c.Endpoint = "https://monitoring." + c.Region + ".amazonaws.com/"
+	}
+	if nil == c.Client {
+		c.Client = http.DefaultClient
+	}
+	if 0 == c.DurationUnit {
+		c.DurationUnit = time.Nanosecond
+	}
+	if c.BatchSize <= 0 || CloudWatchMaxDatapoints < c.BatchSize {
+		c.BatchSize = CloudWatchMaxDatapoints
+	}
+	return &cloudWatchExporter{config: c, counts: make(map[string]int64), sums: make(map[string]int64)}, nil
+}
+
+// cloudWatchDatum is a single datapoint of a PutMetricData call, with either
+// a value or a statistic set.
+type cloudWatchDatum struct {
+	name       string
+	dimensions map[string]string
+	unit       string
+	value      float64
+	stats      *cloudWatchStatistics
+}
+
+// cloudWatchStatistics is the statistic set of a histogram or timer.
+type cloudWatchStatistics struct {
+	count, sum, min, max float64
+}
+
+// Export flushes r, for FlushLoop.
+func (e *cloudWatchExporter) Export(r Registry) error {
+	return e.ExportContext(context.Background(), r)
+}
+
+// ExportContext flushes r, for FlushLoop.
+func (e *cloudWatchExporter) ExportContext(ctx context.Context, r Registry) error {
+	e.config.Registry = r
+	return e.flush(ctx, time.Now())
+}
+
+// flush sends every metric in the registry, stamped with now, in batches of
+// at most BatchSize.  Counts are measured from the last flush whose batches
+// were all sent.
+func (e *cloudWatchExporter) flush(ctx context.Context, now time.Time) error {
+	data, counts, sums := e.collect()
+	for 0 < len(data) {
+		n := len(data)
+		if e.config.BatchSize < n {
+			n = e.config.BatchSize
+		}
+		if err := e.put(ctx, data[:n], now); nil != err {
+			return err
+		}
+		data = data[n:]
+	}
+	e.counts, e.sums = counts, sums
+	return nil
+}
+
+// collect reads every metric in the registry, returning them along with the
+// counts and sums that counters and statistic sets were measured from.
+func (e *cloudWatchExporter) collect() ([]cloudWatchDatum, map[string]int64, map[string]int64) {
+	du := float64(e.config.DurationUnit)
+	unit := cloudWatchUnit(e.config.DurationUnit)
+	tagged := make(map[string]map[string]string)
+	exported := make(map[string]string)
+	e.config.Registry.Each(func(name string, i interface{}) {
+		if t, ok := i.(Tagged); ok {
+			tagged[name] = t.Tags()
+		}
+		exported[name] = exportName(name, i)
+	})
+	var data []cloudWatchDatum
+	counts, sums := make(map[string]int64), make(map[string]int64)
+	var key string // Registry name of the metric being collected
+	put := func(d cloudWatchDatum) {
+		d.name = exported[key]
+		if "" == d.name {
+			d.name = key
+		}
+		d.dimensions = e.config.Tags
+		if t := tagged[key]; 0 < len(t) {
+			d.dimensions = make(map[string]string, len(e.config.Tags)+len(t))
+			for k, v := range e.config.Tags {
+				d.dimensions[k] = v
+			}
+			for k, v := range t {
+				d.dimensions[k] = v
+			}
+		}
+		data = append(data, d)
+	}
+	count := func(n int64) {
+		counts[key] = n
+		prev := e.counts[key]
+		if n < prev {
+			prev = 0
+		}
+		put(cloudWatchDatum{unit: "Count", value: float64(n - prev)})
+	}
+	statistics := func(h interface {
+		Count() int64
+		Max() int64
+		Mean() float64
+		Min() int64
+		Sum() int64
+	}, scale float64, unit string) {
+		n, sum := h.Count()-e.counts[key], h.Sum()-e.sums[key]
+		if n < 0 {
+			n, sum = h.Count(), h.Sum()
+		}
+		counts[key], sums[key] = h.Count(), h.Sum()
+		if 0 == n {
+			return
+		}
+		put(cloudWatchDatum{unit: unit, stats: &cloudWatchStatistics{
+			count: float64(n),
+			sum:   float64(sum) / scale,
+			min:   float64(h.Min()) / scale,
+			max:   float64(h.Max()) / scale,
+		}})
+	}
+	e.config.Registry.Snapshot().Each(func(name string, i interface{}) {
+		key = name
+		if n, ok := exported[key]; ok {
+			name = n
+		}
+		if nil != e.config.Filter && !e.config.Filter(name) {
+			return
+		}
+		switch metric := i.(type) {
+		case Counter:
+			count(metric.Count())
+		case Gauge:
+			put(cloudWatchDatum{unit: "None", value: float64(metric.Value())})
+		case GaugeFloat64:
+			put(cloudWatchDatum{unit: "None", value: metric.Value()})
+		case Histogram:
+			statistics(metric, 1, "None")
+		case Meter:
+			count(metric.Count())
+		case Timer:
+			statistics(metric, du, unit)
+		}
+	})
+	return data, counts, sums
+}
+
+// put sends data in one PutMetricData call.
+func (e *cloudWatchExporter) put(ctx context.Context, data []cloudWatchDatum, now time.Time) error {
+	form := url.Values{}
+	form.Set("Action", "PutMetricData")
+	form.Set("Version", "2010-08-01")
+	form.Set("Namespace", e.config.Prefix)
+	timestamp := now.UTC().Format(time.RFC3339)
+	for i, d := range data {
+		p := "MetricData.member." + strconv.Itoa(i+1) + "."
+		form.Set(p+"MetricName", d.name)
+		form.Set(p+"Timestamp", timestamp)
+		form.Set(p+"Unit", d.unit)
+		if nil != d.stats {
+			form.Set(p+"StatisticValues.SampleCount", cloudWatchFloat(d.stats.count))
+			form.Set(p+"StatisticValues.Sum", cloudWatchFloat(d.stats.sum))
+			form.Set(p+"StatisticValues.Minimum", cloudWatchFloat(d.stats.min))
+			form.Set(p+"StatisticValues.Maximum", cloudWatchFloat(d.stats.max))
+		} else {
+			form.Set(p+"Value", cloudWatchFloat(d.value))
+		}
+		keys := make([]string, 0, len(d.dimensions))
+		for k := range d.dimensions {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for j, k := range keys {
+			dp := p + "Dimensions.member." + strconv.Itoa(j+1) + "."
+			form.Set(dp+"Name", k)
+			form.Set(dp+"Value", d.dimensions[k])
+		}
+	}
+	body := []byte(form.Encode())
+	req, err := http.NewRequest("POST", e.config.Endpoint, bytes.NewReader(body))
+	if nil != err {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	if "" != e.config.SessionToken {
+		req.Header.Set("X-Amz-Security-Token", e.config.SessionToken)
+	}
+	signAWS(req, body, e.config.Region, "monitoring", e.config.AccessKeyID, e.config.SecretAccessKey, time.Now())
+	resp, err := e.config.Client.Do(req.WithContext(ctx))
+	if nil != err {
+		return err
+	}
+	defer resp.Body.Close()
+	if http.StatusOK != resp.StatusCode {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("cloudwatch: %s: %s", resp.Status, msg)
+	}
+	return nil
+}
+
+// cloudWatchFloat formats v, which CloudWatch rejects unless it's finite.
+func cloudWatchFloat(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		v = 0
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// cloudWatchUnit returns the CloudWatch unit of durations in d.
+func cloudWatchUnit(d time.Duration) string {
+	switch d {
+	case time.Microsecond:
+		return "Microseconds"
+	case time.Millisecond:
+		return "Milliseconds"
+	case time.Second:
+		return "Seconds"
+	}
+	return "None"
+}
+
+// signAWS signs req, whose body is body, with AWS Signature Version 4 for
+// the given region and service, signing the host and every header of req.
+//
+// <https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html>
+func signAWS(req *http.Request, body []byte, region, service, accessKeyID, secretAccessKey string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	headers := map[string]string{"host": req.URL.Host}
+	for k, vs := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(vs, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonical bytes.Buffer
+	path := req.URL.EscapedPath()
+	if "" == path {
+		path = "/"
+	}
+	canonical.WriteString(req.Method + "\n" + path + "\n" + req.URL.RawQuery + "\n")
+	for _, k := range names {
+		canonical.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonical.WriteString("\n" + signedHeaders + "\n" + sha256Hex(body))
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex(canonical.Bytes())
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+hex.EncodeToString(hmacSHA256(key, stringToSign)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCloudWatch(t *testing.T) {
+	var forms []url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(auth, "/us-east-1/monitoring/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=") {
+			t.Errorf("Authorization: %q", auth)
+		}
+		if err := r.ParseForm(); nil != err {
+			t.Fatal(err)
+		}
+		forms = append(forms, r.PostForm)
+	}))
+	defer ts.Close()
+
+	r := NewRegistry()
+	c := NewRegisteredCounter("c", r)
+	NewRegisteredGauge("g", r).Update(3)
+	tm := NewRegisteredTimer("t", r)
+	GetOrRegisterTaggedCounter("requests", map[string]string{"route": "/login"}, r)
+	e, err := newCloudWatchExporter(CloudWatchConfig{
+		Registry:        r,
+		DurationUnit:    time.Millisecond,
+		Prefix:          "App",
+		Tags:            map[string]string{"host": "web1"},
+		Region:          "us-east-1",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		Endpoint:        ts.URL,
+		BatchSize:       2,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	c.Inc(40)
+	tm.Update(2 * time.Millisecond)
+	tm.Update(4 * time.Millisecond)
+	if err := e.flush(context.Background(), time.Unix(1000, 0)); nil != err {
+		t.Fatal(err)
+	}
+	if 2 != len(forms) {
+		t.Fatalf("%d calls", len(forms))
+	}
+	data := make(map[string]url.Values)
+	for _, form := range forms {
+		if "PutMetricData" != form.Get("Action") || "App" != form.Get("Namespace") {
+			t.Errorf("form: %v", form)
+		}
+		for i := 1; "" != form.Get("MetricData.member."+strconv.Itoa(i)+".MetricName"); i++ {
+			p := "MetricData.member." + strconv.Itoa(i) + "."
+			d := url.Values{}
+			for k, v := range form {
+				if strings.HasPrefix(k, p) {
+					d[strings.TrimPrefix(k, p)] = v
+				}
+			}
+			data[d.Get("MetricName")] = d
+		}
+	}
+	if 4 != len(data) {
+		t.Fatalf("data: %v", data)
+	}
+	if d := data["c"]; "40" != d.Get("Value") || "Count" != d.Get("Unit") || "1970-01-01T00:16:40Z" != d.Get("Timestamp") {
+		t.Errorf("c: %v", d)
+	}
+	if d := data["c"]; "host" != d.Get("Dimensions.member.1.Name") || "web1" != d.Get("Dimensions.member.1.Value") {
+		t.Errorf("c: %v", d)
+	}
+	if d := data["g"]; "3" != d.Get("Value") || "None" != d.Get("Unit") {
+		t.Errorf("g: %v", d)
+	}
+	if d := data["t"]; "2" != d.Get("StatisticValues.SampleCount") || "6" != d.Get("StatisticValues.Sum") ||
+		"2" != d.Get("StatisticValues.Minimum") || "4" != d.Get("StatisticValues.Maximum") || "Milliseconds" != d.Get("Unit") {
+		t.Errorf("t: %v", d)
+	}
+	if d := data["requests"]; "route" != d.Get("Dimensions.member.2.Name") || "/login" != d.Get("Dimensions.member.2.Value") {
+		t.Errorf("requests: %v", d)
+	}
+
+	forms = nil
+	c.Inc(2)
+	if err := e.flush(context.Background(), time.Unix(1010, 0)); nil != err {
+		t.Fatal(err)
+	}
+	if v := forms[0].Get("MetricData.member.1.Value"); 2 != len(forms) || "" == v {
+		t.Fatalf("second flush: %v", forms)
+	}
+	for _, form := range forms {
+		for k := range form {
+			if strings.HasSuffix(k, "StatisticValues.SampleCount") {
+				t.Errorf("unchanged timer reported: %v", form)
+			}
+		}
+	}
+
+	forms = nil
+	tm.Update(10 * time.Millisecond)
+	if err := e.flush(context.Background(), time.Unix(1020, 0)); nil != err {
+		t.Fatal(err)
+	}
+	sums := 0
+	for _, form := range forms {
+		for k, v := range form {
+			if strings.HasSuffix(k, "StatisticValues.Sum") {
+				sums++
+				if "10" != v[0] {
+					t.Errorf("third flush's sum: 10 != %v", v[0])
+				}
+			}
+		}
+	}
+	if 1 != sums {
+		t.Errorf("third flush: %v", forms)
+	}
+}
+
+func TestCloudWatchRejected(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "InvalidClientTokenId", http.StatusForbidden)
+	}))
+	defer ts.Close()
+	r := NewRegistry()
+	NewRegisteredCounter("c", r).Inc(1)
+	e, _ := newCloudWatchExporter(CloudWatchConfig{
+		Registry: r, Prefix: "App", Region: "us-east-1", AccessKeyID: "AKID", SecretAccessKey: "secret", Endpoint: ts.URL,
+	})
+	err := e.flush(context.Background(), time.Now())
+	if nil == err || !strings.HasPrefix(err.Error(), "cloudwatch: 403 Forbidden: InvalidClientTokenId") {
+		t.Fatal(err)
+	}
+	if 0 != len(e.counts) {
+		t.Error("counts of a failed flush kept")
+	}
+}
+
+func TestCloudWatchConfigErrors(t *testing.T) {
+	if _, err := newCloudWatchExporter(CloudWatchConfig{Registry: NewRegistry(), Region: "us-east-1"}); nil == err {
+		t.Error("no error without a Prefix")
+	}
+}
+
+// TestSignAWS checks signAWS against the get-vanilla case of the AWS
+// Signature Version 4 test suite.
+func TestSignAWS(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	signAWS(req, nil, "us-east-1", "service", "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); want != got {
+		t.Errorf("\n%s !=\n%s", want, got)
+	}
+}
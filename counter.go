@@ -28,14 +28,10 @@ func NewCounter() Counter {
 	return &StandardCounter{0}
 }
 
-// NewRegisteredCounter constructs and registers a new StandardCounter.
+// NewRegisteredCounter constructs and registers a new StandardCounter, or
+// returns the Counter already registered under the given name.
 func NewRegisteredCounter(name string, r Registry) Counter {
-	c := NewCounter()
-	if nil == r {
-		r = DefaultRegistry
-	}
-	r.Register(name, c)
-	return c
+	return GetOrRegisterCounter(name, r)
 }
 
 // CounterSnapshot is a read-only copy of another Counter.
@@ -71,6 +67,9 @@ func (NilCounter) Clear() {}
 // Count is a no-op.
 func (NilCounter) Count() int64 { return 0 }
 
+// CountAndClear is a no-op.
+func (NilCounter) CountAndClear() int64 { return 0 }
+
 // Dec is a no-op.
 func (NilCounter) Dec(i int64) {}
 
@@ -96,6 +95,12 @@ func (c *StandardCounter) Count() int64 {
 	return atomic.LoadInt64(&c.count)
 }
 
+// CountAndClear atomically returns the current count and sets the counter to
+// zero, so that no increment is lost between reading and clearing it.
+func (c *StandardCounter) CountAndClear() int64 {
+	return atomic.SwapInt64(&c.count, 0)
+}
+
 // Dec decrements the counter by the given amount.
 func (c *StandardCounter) Dec(i int64) {
 	atomic.AddInt64(&c.count, -i)
@@ -110,3 +115,73 @@ func (c *StandardCounter) Inc(i int64) {
 func (c *StandardCounter) Snapshot() Counter {
 	return CounterSnapshot(c.Count())
 }
+
+// GetOrRegisterNonNegativeCounter returns an existing Counter or constructs
+// and registers a new NonNegativeCounter.
+func GetOrRegisterNonNegativeCounter(name string, r Registry) Counter {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return r.GetOrRegister(name, NewNonNegativeCounter).(Counter)
+}
+
+// NewNonNegativeCounter constructs a new NonNegativeCounter.
+func NewNonNegativeCounter() Counter {
+	if UseNilMetrics {
+		return NilCounter{}
+	}
+	return &NonNegativeCounter{}
+}
+
+// NewRegisteredNonNegativeCounter constructs and registers a new
+// NonNegativeCounter, or returns the Counter already registered under the
+// given name.
+func NewRegisteredNonNegativeCounter(name string, r Registry) Counter {
+	return GetOrRegisterNonNegativeCounter(name, r)
+}
+
+// NonNegativeCounter is a StandardCounter which stops at zero rather than
+// going negative, for counts of things in use such as open connections,
+// which an unmatched Dec would otherwise leave below zero for good.
+type NonNegativeCounter struct {
+	StandardCounter
+}
+
+// Dec decrements the counter by the given amount, to no less than zero.
+func (c *NonNegativeCounter) Dec(i int64) {
+	for {
+		old := atomic.LoadInt64(&c.count)
+		n := old - i
+		if n < 0 {
+			n = 0
+		}
+		if atomic.CompareAndSwapInt64(&c.count, old, n) {
+			return
+		}
+	}
+}
+
+// Inc increments the counter by the given amount, to no less than zero if
+// the amount is negative.
+func (c *NonNegativeCounter) Inc(i int64) {
+	if i < 0 {
+		c.Dec(-i)
+		return
+	}
+	atomic.AddInt64(&c.count, i)
+}
+
+// countAndClear returns the count of c and takes it off c, atomically if c
+// has a CountAndClear method.  Otherwise c is decremented by the count it
+// returned rather than cleared, so that increments between reading and
+// decrementing it are kept for the next read instead of being lost.
+func countAndClear(c Counter) int64 {
+	if cc, ok := c.(interface {
+		CountAndClear() int64
+	}); ok {
+		return cc.CountAndClear()
+	}
+	n := c.Count()
+	c.Dec(n)
+	return n
+}
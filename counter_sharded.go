@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+// GetOrRegisterShardedCounter returns an existing Counter or constructs and
+// registers a new ShardedCounter.
+func GetOrRegisterShardedCounter(name string, r Registry) Counter {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return r.GetOrRegister(name, NewShardedCounter).(Counter)
+}
+
+// NewShardedCounter constructs a new ShardedCounter with a shard for each
+// processor.
+func NewShardedCounter() Counter {
+	if UseNilMetrics {
+		return NilCounter{}
+	}
+	return newShardedCounter()
+}
+
+// NewRegisteredShardedCounter constructs and registers a new ShardedCounter,
+// or returns the Counter already registered under the given name.
+func NewRegisteredShardedCounter(name string, r Registry) Counter {
+	return GetOrRegisterShardedCounter(name, r)
+}
+
+// ShardedCounter is a Counter for counters updated by many goroutines at
+// once.  Rather than a single int64 which every update contends for, it
+// keeps one per processor, rounded up to a power of two, each in a cache line
+// of its own, and sums them when read.  Updates are cheaper under contention
+// and reads dearer.
+type ShardedCounter struct {
+	shift  uint // 64 less the number of bits indexing shards
+	shards []counterShard
+}
+
+// counterShard is a count padded to fill a cache line.
+type counterShard struct {
+	count int64
+	_     [56]byte
+}
+
+func newShardedCounter() *ShardedCounter {
+	n, shift := 1, uint(64)
+	for n < runtime.GOMAXPROCS(0) {
+		n <<= 1
+		shift--
+	}
+	return &ShardedCounter{shift: shift, shards: make([]counterShard, n)}
+}
+
+// Clear sets the counter to zero.  Updates made while it is clearing may be
+// kept.
+func (c *ShardedCounter) Clear() {
+	for i := range c.shards {
+		atomic.StoreInt64(&c.shards[i].count, 0)
+	}
+}
+
+// Count returns the current count.
+func (c *ShardedCounter) Count() int64 {
+	var count int64
+	for i := range c.shards {
+		count += atomic.LoadInt64(&c.shards[i].count)
+	}
+	return count
+}
+
+// CountAndClear returns the current count and sets the counter to zero,
+// taking each shard's count off it atomically so that no update is lost or
+// counted twice.
+func (c *ShardedCounter) CountAndClear() int64 {
+	var count int64
+	for i := range c.shards {
+		count += atomic.SwapInt64(&c.shards[i].count, 0)
+	}
+	return count
+}
+
+// Dec decrements the counter by the given amount.
+func (c *ShardedCounter) Dec(i int64) {
+	atomic.AddInt64(c.shard(), -i)
+}
+
+// Inc increments the counter by the given amount.
+func (c *ShardedCounter) Inc(i int64) {
+	atomic.AddInt64(c.shard(), i)
+}
+
+// Snapshot returns a read-only copy of the counter.
+func (c *ShardedCounter) Snapshot() Counter {
+	return CounterSnapshot(c.Count())
+}
+
+// shard returns the count of a shard chosen by hashing the address of the
+// calling goroutine's stack, so that goroutines running at once mostly update
+// different shards without the cost of finding out which processor they are
+// running on.
+func (c *ShardedCounter) shard() *int64 {
+	if 64 == c.shift {
+		return &c.shards[0].count
+	}
+	var local byte
+	h := uint64(uintptr(unsafe.Pointer(&local))) * 0x9e3779b97f4a7c15 // Fibonacci hashing
+	return &c.shards[h>>c.shift].count
+}
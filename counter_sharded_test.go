@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"sync"
+	"testing"
+)
+
+// BenchmarkCounterParallel and BenchmarkShardedCounterParallel compare
+// counters incremented by every processor at once.
+func BenchmarkCounterParallel(b *testing.B) {
+	benchmarkCounterParallel(b, NewCounter())
+}
+
+func BenchmarkShardedCounterParallel(b *testing.B) {
+	benchmarkCounterParallel(b, NewShardedCounter())
+}
+
+func benchmarkCounterParallel(b *testing.B, c Counter) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Inc(1)
+		}
+	})
+}
+
+func TestShardedCounter(t *testing.T) {
+	c := NewShardedCounter()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Inc(2)
+				c.Dec(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if count := c.Count(); 8000 != count {
+		t.Errorf("c.Count(): 8000 != %v\n", count)
+	}
+	if snapshot := c.Snapshot(); 8000 != snapshot.Count() {
+		t.Errorf("snapshot.Count(): 8000 != %v\n", snapshot.Count())
+	}
+	c.Clear()
+	if count := c.Count(); 0 != count {
+		t.Errorf("c.Count(): 0 != %v\n", count)
+	}
+}
+
+func TestShardedCounterCountAndClear(t *testing.T) {
+	c := NewShardedCounter()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10000; i++ {
+			c.Inc(1)
+		}
+		close(done)
+	}()
+	var total int64
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		total += countAndClear(c)
+	}
+	total += countAndClear(c)
+	if 10000 != total {
+		t.Errorf("total: 10000 != %v\n", total)
+	}
+}
+
+func TestGetOrRegisterShardedCounter(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredShardedCounter("foo", r).Inc(47)
+	if c := GetOrRegisterShardedCounter("foo", r); 47 != c.Count() {
+		t.Fatal(c)
+	}
+}
@@ -1,6 +1,9 @@
 package metrics
 
-import "testing"
+import (
+	"sync"
+	"testing"
+)
 
 func BenchmarkCounter(b *testing.B) {
 	c := NewCounter()
@@ -75,3 +78,73 @@ func TestGetOrRegisterCounter(t *testing.T) {
 		t.Fatal(c)
 	}
 }
+
+func TestCounterCountAndClear(t *testing.T) {
+	c := NewCounter().(*StandardCounter)
+	c.Inc(47)
+	if count := c.CountAndClear(); 47 != count {
+		t.Errorf("c.CountAndClear(): 47 != %v\n", count)
+	}
+	if count := c.Count(); 0 != count {
+		t.Errorf("c.Count(): 0 != %v\n", count)
+	}
+}
+
+func TestCountAndClearWithoutCountAndClear(t *testing.T) {
+	c := struct{ Counter }{NewCounter()}
+	c.Inc(47)
+	if count := countAndClear(c); 47 != count {
+		t.Errorf("countAndClear(c): 47 != %v\n", count)
+	}
+	if count := c.Count(); 0 != count {
+		t.Errorf("c.Count(): 0 != %v\n", count)
+	}
+}
+
+func TestNonNegativeCounter(t *testing.T) {
+	c := NewNonNegativeCounter()
+	c.Inc(2)
+	c.Dec(5)
+	if count := c.Count(); 0 != count {
+		t.Errorf("c.Count(): 0 != %v\n", count)
+	}
+	c.Inc(3)
+	c.Inc(-1)
+	if count := c.Count(); 2 != count {
+		t.Errorf("c.Count(): 2 != %v\n", count)
+	}
+	c.Inc(-47)
+	if count := c.Count(); 0 != count {
+		t.Errorf("c.Count(): 0 != %v\n", count)
+	}
+}
+
+func TestNonNegativeCounterConcurrent(t *testing.T) {
+	c := NewNonNegativeCounter()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Inc(1)
+				c.Dec(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if count := c.Count(); 0 != count {
+		t.Errorf("c.Count(): 0 != %v\n", count)
+	}
+}
+
+func TestGetOrRegisterNonNegativeCounter(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredNonNegativeCounter("foo", r).Inc(47)
+	if c := GetOrRegisterNonNegativeCounter("foo", r); 47 != c.Count() {
+		t.Fatal(c)
+	}
+	if _, ok := GetOrRegisterCounter("foo", r).(*NonNegativeCounter); !ok {
+		t.Fatal("not a NonNegativeCounter")
+	}
+}
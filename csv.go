@@ -0,0 +1,238 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSVConfig provides a container with configuration parameters for the CSV
+// exporter.
+type CSVConfig struct {
+	Dir           string        // Directory to write a file per metric to, created if missing
+	Registry      Registry      // Registry to be exported
+	FlushInterval time.Duration // Flush interval
+	DurationUnit  time.Duration // Time conversion unit for durations, defaults to nanoseconds
+	MaxFileSize   int64         // Size in bytes beyond which a file is rotated, never if zero
+	MaxBackups    int           // Rotated files kept per metric, as name.csv.1 the latest to name.csv.N, all if zero
+	ErrorHandler  func(error)   // Called with each flush error, defaults to log.Println
+}
+
+// CSV is a blocking exporter function which appends a row of the values of
+// each metric in r, every d duration, to a CSV file of its own in dir.
+func CSV(r Registry, d time.Duration, dir string) {
+	CSVWithConfig(CSVConfig{
+		Dir:           dir,
+		Registry:      r,
+		FlushInterval: d,
+	})
+}
+
+// CSVWithConfig is a blocking exporter function just like CSV, but it takes
+// a CSVConfig instead.
+func CSVWithConfig(c CSVConfig) {
+	if err := CSVWithConfigContext(context.Background(), c); nil != err {
+		log.Println(err)
+	}
+}
+
+// CSVWithConfigContext is like CSVWithConfig but returns once the context is
+// done, after a final flush which is given one FlushInterval to complete and
+// whose error it returns.  Errors from flushes other than the final one are
+// passed to the ErrorHandler.
+func CSVWithConfigContext(ctx context.Context, c CSVConfig) error {
+	e, err := newCSVExporter(c)
+	if nil != err {
+		return err
+	}
+	return FlushLoopWithConfig(ctx, FlushLoopConfig{
+		Registry:      c.Registry,
+		FlushInterval: c.FlushInterval,
+		Exporter:      e,
+		ErrorHandler:  c.ErrorHandler,
+	})
+}
+
+// NewCSVExporter constructs a new Exporter which appends the metrics of the
+// registry it's given to CSV files as configured by c, so that it can be run
+// by FlushLoop or be a sink of a FanoutExporter.  c.Registry, which it
+// ignores, needn't be set.
+func NewCSVExporter(c CSVConfig) (Exporter, error) {
+	if nil == c.Registry {
+		c.Registry = DefaultRegistry
+	}
+	return newCSVExporter(c)
+}
+
+// csvExporter holds the configuration of a CSV exporter.
+type csvExporter struct {
+	config CSVConfig
+}
+
+func newCSVExporter(c CSVConfig) (*csvExporter, error) {
+	if nil == c.Registry {
+		return nil, errors.New("csv: config has no Registry to export")
+	}
+	if "" == c.Dir {
+		return nil, errors.New("csv: config has no Dir to export to")
+	}
+	return &csvExporter{config: c}, nil
+}
+
+// Export appends a row for each metric in r, for FlushLoop.
+func (e *csvExporter) Export(r Registry) error {
+	e.config.Registry = r
+	return CSVOnce(e.config)
+}
+
+// CSVOnce appends a row of the values of each metric in the registry to its
+// file in the directory, named by the metric with a .csv extension and
+// headed by the names of its columns, the first of which is the Unix time.
+// A file which the row would take beyond MaxFileSize is first rotated.
+// Files are opened for each flush, so they may be moved away between
+// flushes.  The first error is returned, after every metric has been tried.
+func CSVOnce(c CSVConfig) error {
+	if err := os.MkdirAll(c.Dir, 0755); nil != err {
+		return err
+	}
+	du := float64(c.DurationUnit)
+	if 0 == du {
+		du = float64(time.Nanosecond)
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	var firstErr error
+	c.Registry.Snapshot().Each(func(name string, i interface{}) {
+		header, row := csvRow(i, du)
+		if nil == header {
+			return
+		}
+		path := filepath.Join(c.Dir, csvFileName(name))
+		if err := c.write(path, header, append([]string{now}, row...)); nil != err && nil == firstErr {
+			firstErr = err
+		}
+	})
+	return firstErr
+}
+
+// write appends row to the file at path, rotating it first if the row
+// would take it beyond MaxFileSize, and heads a new file with header.
+func (c *CSVConfig) write(path string, header, row []string) error {
+	line := csvLine(row)
+	fi, err := os.Stat(path)
+	if nil != err && !os.IsNotExist(err) {
+		return err
+	}
+	exists := nil == err
+	if exists && 0 < c.MaxFileSize && c.MaxFileSize < fi.Size()+int64(len(line)) {
+		if err := rotateCSV(path, c.MaxBackups); nil != err {
+			return err
+		}
+		exists = false
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if nil != err {
+		return err
+	}
+	if !exists {
+		line = csvLine(append([]string{"t"}, header...)) + line
+	}
+	if _, err := f.WriteString(line); nil != err {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotateCSV renames the file at path to path.1, and each path.N to
+// path.N+1, first removing those which would be beyond path.n if n isn't
+// zero.
+func rotateCSV(path string, n int) error {
+	last := 0
+	for {
+		if _, err := os.Stat(fmt.Sprintf("%s.%d", path, last+1)); nil != err {
+			break
+		}
+		last++
+	}
+	for ; 0 != n && n <= last; last-- {
+		if err := os.Remove(fmt.Sprintf("%s.%d", path, last)); nil != err {
+			return err
+		}
+	}
+	for i := last; 0 < i; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1)); nil != err {
+			return err
+		}
+	}
+	return os.Rename(path, path+".1")
+}
+
+// csvFileName returns the name of the file of the metric by the given name,
+// with the path separators it may contain replaced by underscores.
+func csvFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if '/' == r || '\\' == r || 0 == r {
+			return '_'
+		}
+		return r
+	}, name) + ".csv"
+}
+
+// csvLine returns the record encoded as a line of CSV.
+func csvLine(record []string) string {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write(record)
+	w.Flush()
+	return b.String()
+}
+
+// csvRow returns the names of the columns of the given metric and its values,
+// or nil if it isn't exported.
+func csvRow(i interface{}, du float64) (header, row []string) {
+	percentiles := defaultPercentiles
+	percentileHeader := make([]string, len(percentiles))
+	for i, p := range percentiles {
+		percentileHeader[i] = "p" + strings.TrimSuffix(percentileSuffix(p), "-percentile")
+	}
+	d := func(v int64) string { return strconv.FormatInt(v, 10) }
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	switch metric := i.(type) {
+	case Counter:
+		return []string{"count"}, []string{d(metric.Count())}
+	case Gauge:
+		return []string{"value"}, []string{d(metric.Value())}
+	case GaugeFloat64:
+		return []string{"value"}, []string{f(metric.Value())}
+	case Histogram:
+		ps := metric.Percentiles(percentiles)
+		header = append([]string{"count", "min", "max", "mean", "stddev"}, percentileHeader...)
+		row = []string{d(metric.Count()), d(metric.Min()), d(metric.Max()), f(metric.Mean()), f(metric.StdDev())}
+		for _, p := range ps {
+			row = append(row, f(p))
+		}
+		return header, row
+	case Meter:
+		return []string{"count", "mean_rate", "m1_rate", "m5_rate", "m15_rate"},
+			[]string{d(metric.Count()), f(metric.RateMean()), f(metric.Rate1()), f(metric.Rate5()), f(metric.Rate15())}
+	case Timer:
+		ps := metric.Percentiles(percentiles)
+		header = append([]string{"count", "min", "max", "mean", "stddev"}, percentileHeader...)
+		header = append(header, "mean_rate", "m1_rate", "m5_rate", "m15_rate")
+		row = []string{d(metric.Count()), f(float64(metric.Min()) / du), f(float64(metric.Max()) / du), f(metric.Mean() / du), f(metric.StdDev() / du)}
+		for _, p := range ps {
+			row = append(row, f(p/du))
+		}
+		row = append(row, f(metric.RateMean()), f(metric.Rate1()), f(metric.Rate5()), f(metric.Rate15()))
+		return header, row
+	}
+	return nil, nil
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSVOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics-csv")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r := NewRegistry()
+	c := NewRegisteredCounter("requests", r)
+	NewRegisteredTimer("db/latency", r).Update(2 * time.Millisecond)
+	config := CSVConfig{Dir: dir, Registry: r, DurationUnit: time.Millisecond}
+	c.Inc(1)
+	if err := CSVOnce(config); nil != err {
+		t.Fatal(err)
+	}
+	c.Inc(1)
+	if err := CSVOnce(config); nil != err {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "requests.csv"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if 3 != len(lines) || "t,count" != lines[0] || !strings.HasSuffix(lines[1], ",1") || !strings.HasSuffix(lines[2], ",2") {
+		t.Errorf("requests.csv: %q", lines)
+	}
+	b, err = ioutil.ReadFile(filepath.Join(dir, "db_latency.csv"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSpace(string(b)), "\n")
+	if "t,count,min,max,mean,stddev,p50,p75,p95,p99,p999,mean_rate,m1_rate,m5_rate,m15_rate" != lines[0] {
+		t.Error(lines[0])
+	}
+	if fields := strings.Split(lines[1], ","); "1" != fields[1] || "2" != fields[3] {
+		t.Error(lines[1])
+	}
+}
+
+func TestCSVWithConfigContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics-csv")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r := NewRegistry()
+	NewRegisteredCounter("requests", r).Inc(47)
+	c := CSVConfig{Dir: dir, Registry: r}
+	if err := CSVWithConfigContext(context.Background(), c); nil == err {
+		t.Error("no error for a zero FlushInterval")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.FlushInterval = time.Hour
+	if err := CSVWithConfigContext(ctx, c); nil != err {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "requests.csv"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(string(b)), ",47") {
+		t.Errorf("requests.csv: %q", b)
+	}
+}
+
+func TestCSVRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics-csv")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r := NewRegistry()
+	NewRegisteredCounter("c", r)
+	config := CSVConfig{Dir: dir, Registry: r, MaxFileSize: 30, MaxBackups: 2}
+	for i := 0; i < 10; i++ {
+		if err := CSVOnce(config); nil != err {
+			t.Fatal(err)
+		}
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if 3 != len(names) {
+		t.Fatalf("files: %v", names)
+	}
+	for _, name := range names {
+		b, _ := ioutil.ReadFile(name)
+		if 30 < len(b) || !strings.HasPrefix(string(b), "t,count\n") {
+			t.Errorf("%s: %q", name, b)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// dropwizardVersion is the version of the Dropwizard metrics library whose
+// JSON format DropwizardJSON marshals.
+const dropwizardVersion = "4.0.0"
+
+// dropwizardPercentiles are the quantiles of histograms and timers in the
+// Dropwizard format, and dropwizardPercentileKeys their keys.
+var (
+	dropwizardPercentiles    = []float64{0.5, 0.75, 0.95, 0.98, 0.99, 0.999}
+	dropwizardPercentileKeys = []string{"p50", "p75", "p95", "p98", "p99", "p999"}
+)
+
+// dropwizardDurationUnits names the units of durations Dropwizard knows.
+var dropwizardDurationUnits = map[time.Duration]string{
+	time.Nanosecond:  "nanoseconds",
+	time.Microsecond: "microseconds",
+	time.Millisecond: "milliseconds",
+	time.Second:      "seconds",
+	time.Minute:      "minutes",
+	time.Hour:        "hours",
+}
+
+// DropwizardJSON marshals a snapshot of its Registry to JSON in the format
+// of the Dropwizard, formerly Coda Hale, metrics library's MetricsServlet,
+// so that dashboards and collectors written for Java services can read Go
+// services' metrics:
+//
+//	{"version": "4.0.0", "gauges": {...}, "counters": {...},
+//	 "histograms": {...}, "meters": {...}, "timers": {...}}
+//
+// Gauges and GaugeFloat64s are gauges.  Rates are per second.  Healthchecks
+// aren't marshaled, Dropwizard serving them separately.
+type DropwizardJSON struct {
+	Registry     Registry      // Registry to be marshaled, defaults to DefaultRegistry
+	DurationUnit time.Duration // Time conversion unit for timers, defaults to seconds as in Dropwizard
+}
+
+// MarshalDropwizardJSON returns a JSON representation of all the metrics in
+// r in the Dropwizard format, with timers in seconds.
+func MarshalDropwizardJSON(r Registry) ([]byte, error) {
+	return json.Marshal(DropwizardJSON{Registry: r})
+}
+
+// MarshalJSON returns the JSON representation of a snapshot of the registry
+// in the Dropwizard format.
+func (d DropwizardJSON) MarshalJSON() ([]byte, error) {
+	r := d.Registry
+	if nil == r {
+		r = DefaultRegistry
+	}
+	du := d.DurationUnit
+	if 0 == du {
+		du = time.Second
+	}
+	durationUnits, ok := dropwizardDurationUnits[du]
+	if !ok {
+		durationUnits = du.String()
+	}
+	scale := float64(du)
+	sections := map[string]map[string]interface{}{
+		"gauges":     {},
+		"counters":   {},
+		"histograms": {},
+		"meters":     {},
+		"timers":     {},
+	}
+	r.Snapshot().Each(func(name string, i interface{}) {
+		values := make(map[string]interface{})
+		switch metric := i.(type) {
+		case Counter:
+			values["count"] = metric.Count()
+			sections["counters"][name] = values
+		case Gauge:
+			values["value"] = metric.Value()
+			sections["gauges"][name] = values
+		case GaugeFloat64:
+			values["value"] = metric.Value()
+			sections["gauges"][name] = values
+		case Histogram:
+			dropwizardDistribution(values, metric.Count(), float64(metric.Min()), float64(metric.Max()), metric.Mean(), metric.StdDev(), metric.Percentiles(dropwizardPercentiles), 1)
+			sections["histograms"][name] = values
+		case Meter:
+			dropwizardRates(values, metric)
+			values["units"] = "events/second"
+			sections["meters"][name] = values
+		case Timer:
+			dropwizardDistribution(values, metric.Count(), float64(metric.Min()), float64(metric.Max()), metric.Mean(), metric.StdDev(), metric.Percentiles(dropwizardPercentiles), scale)
+			dropwizardRates(values, metric)
+			values["duration_units"] = durationUnits
+			values["rate_units"] = "calls/second"
+			sections["timers"][name] = values
+		}
+	})
+	out := make(map[string]interface{}, len(sections)+1)
+	for section, m := range sections {
+		out[section] = m
+	}
+	out["version"] = dropwizardVersion
+	return json.Marshal(out)
+}
+
+// dropwizardDistribution sets the values of a histogram or timer, each but
+// the count divided by scale.
+func dropwizardDistribution(values map[string]interface{}, count int64, min, max, mean, stddev float64, ps []float64, scale float64) {
+	values["count"] = count
+	values["min"] = min / scale
+	values["max"] = max / scale
+	values["mean"] = mean / scale
+	values["stddev"] = stddev / scale
+	for i, p := range ps {
+		values[dropwizardPercentileKeys[i]] = p / scale
+	}
+}
+
+// dropwizardRates sets the count and rates of a meter or timer.
+func dropwizardRates(values map[string]interface{}, m interface {
+	Count() int64
+	Rate1() float64
+	Rate5() float64
+	Rate15() float64
+	RateMean() float64
+}) {
+	values["count"] = m.Count()
+	values["m1_rate"] = m.Rate1()
+	values["m5_rate"] = m.Rate5()
+	values["m15_rate"] = m.Rate15()
+	values["mean_rate"] = m.RateMean()
+}
+
+// DropwizardJSONHandler returns an http.Handler which serves every metric in
+// r as JSON in the Dropwizard format, as MetricsServlet would.
+func DropwizardJSONHandler(r Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, err := MarshalDropwizardJSON(r)
+		if nil != err {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(append(b, '\n'))
+	})
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMarshalDropwizardJSON(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r).Inc(47)
+	GetOrRegisterGauge("g", r).Update(47)
+	GetOrRegisterGaugeFloat64("gf", r).Update(4.7)
+	GetOrRegisterHistogram("h", r, NewUniformSample(100)).Update(47)
+	GetOrRegisterMeter("m", r).Mark(47)
+	GetOrRegisterTimer("t", r).Update(2 * time.Second)
+	GetOrRegisterHealthcheck("hc", r, func(Healthcheck) {})
+	b, err := MarshalDropwizardJSON(r)
+	if nil != err {
+		t.Fatal(err)
+	}
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(b, &sections); nil != err {
+		t.Fatal(err)
+	}
+	if v := string(sections["version"]); `"4.0.0"` != v {
+		t.Errorf("version: %s", v)
+	}
+	delete(sections, "version")
+	out := make(map[string]map[string]map[string]interface{})
+	for k, v := range sections {
+		var section map[string]map[string]interface{}
+		if err := json.Unmarshal(v, &section); nil != err {
+			t.Fatal(err)
+		}
+		out[k] = section
+	}
+	if v := out["counters"]["c"]; !reflect.DeepEqual(map[string]interface{}{"count": 47.0}, v) {
+		t.Errorf("counter: %v", v)
+	}
+	if v := out["gauges"]["g"]["value"]; 47.0 != v {
+		t.Errorf("gauge: %v", v)
+	}
+	if v := out["gauges"]["gf"]["value"]; 4.7 != v {
+		t.Errorf("gauge float64: %v", v)
+	}
+	if v := out["histograms"]["h"]; 47.0 != v["p98"] || 47.0 != v["max"] || 1.0 != v["count"] {
+		t.Errorf("histogram: %v", v)
+	}
+	if v := out["meters"]["m"]; 47.0 != v["count"] || "events/second" != v["units"] || nil == v["m1_rate"] {
+		t.Errorf("meter: %v", v)
+	}
+	if v := out["timers"]["t"]; 2.0 != v["p50"] || 2.0 != v["mean"] || "seconds" != v["duration_units"] || "calls/second" != v["rate_units"] {
+		t.Errorf("timer: %v", v)
+	}
+	if 5 != len(out) {
+		t.Errorf("sections: %v", out)
+	}
+}
+
+func TestDropwizardJSONDurationUnit(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterTimer("t", r).Update(2 * time.Second)
+	b, err := json.Marshal(DropwizardJSON{Registry: r, DurationUnit: time.Millisecond})
+	if nil != err {
+		t.Fatal(err)
+	}
+	var out struct {
+		Timers map[string]struct {
+			Max           float64
+			DurationUnits string `json:"duration_units"`
+		}
+	}
+	if err := json.Unmarshal(b, &out); nil != err {
+		t.Fatal(err)
+	}
+	if tm := out.Timers["t"]; 2000 != tm.Max || "milliseconds" != tm.DurationUnits {
+		t.Errorf("timer: %+v", tm)
+	}
+}
+
+func TestDropwizardJSONHandler(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r).Inc(1)
+	w := httptest.NewRecorder()
+	DropwizardJSONHandler(r).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if "application/json; charset=utf-8" != w.Header().Get("Content-Type") {
+		t.Errorf("Content-Type: %q", w.Header().Get("Content-Type"))
+	}
+	var out struct{ Counters map[string]map[string]int64 }
+	if err := json.Unmarshal(w.Body.Bytes(), &out); nil != err || 1 != out.Counters["c"]["count"] {
+		t.Errorf("%s %v", w.Body.Bytes(), err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"math"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // EWMAs continuously calculate an exponentially-weighted moving average
@@ -38,6 +39,16 @@ func NewEWMA15() EWMA {
 	return NewEWMA(1 - math.Exp(-5.0/60.0/15))
 }
 
+// NewEWMAWithWindow constructs a new EWMA for a moving average over the given
+// window, such as ten seconds, or for the rate over the latest tick alone if
+// the window is zero.  EWMAs are ticked every five seconds.
+func NewEWMAWithWindow(window time.Duration) EWMA {
+	if window <= 0 {
+		return NewEWMA(1)
+	}
+	return NewEWMA(1 - math.Exp(-5.0/window.Seconds()))
+}
+
 // EWMASnapshot is a read-only copy of another EWMA.
 type EWMASnapshot float64
 
@@ -1,6 +1,9 @@
 package metrics
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func BenchmarkEWMA(b *testing.B) {
 	a := NewEWMA1()
@@ -223,3 +226,16 @@ func elapseMinute(a EWMA) {
 		a.Tick()
 	}
 }
+
+func TestEWMAWithWindow(t *testing.T) {
+	a, b := NewEWMAWithWindow(time.Minute), NewEWMA1()
+	a.Update(3)
+	b.Update(3)
+	a.Tick()
+	b.Tick()
+	elapseMinute(a)
+	elapseMinute(b)
+	if a.Rate() != b.Rate() {
+		t.Errorf("a.Rate(): %v != %v\n", b.Rate(), a.Rate())
+	}
+}
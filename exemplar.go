@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// Exemplar is a value recorded by a histogram or timer along with the ID of
+// the trace which recorded it, so that a latency spike seen in a dashboard
+// can be followed to the trace behind it.
+type Exemplar struct {
+	Value   int64 // Value recorded, in nanoseconds for timers
+	TraceID string
+	Time    time.Time // When the value was recorded
+}
+
+// maxExemplars is the number of the most recent exemplars kept by each
+// histogram and timer.
+const maxExemplars = 10
+
+// exemplarRing holds the most recent exemplars.
+type exemplarRing struct {
+	mutex     sync.Mutex
+	exemplars []Exemplar
+	next      int // Index of the oldest exemplar once the ring is full
+}
+
+// add records e in place of the oldest exemplar if the ring is full.
+func (r *exemplarRing) add(e Exemplar) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.exemplars) < maxExemplars {
+		r.exemplars = append(r.exemplars, e)
+		return
+	}
+	r.exemplars[r.next] = e
+	r.next = (r.next + 1) % maxExemplars
+}
+
+// clear forgets every exemplar.
+func (r *exemplarRing) clear() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.exemplars, r.next = nil, 0
+}
+
+// list returns a copy of the exemplars, oldest first.
+func (r *exemplarRing) list() []Exemplar {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.copy()
+}
+
+// listAndClear returns a copy of the exemplars, oldest first, and forgets
+// them as one operation.
+func (r *exemplarRing) listAndClear() []Exemplar {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	exemplars := r.copy()
+	r.exemplars, r.next = nil, 0
+	return exemplars
+}
+
+// copy returns a copy of the exemplars, oldest first.  It should run with
+// r.mutex held.
+func (r *exemplarRing) copy() []Exemplar {
+	exemplars := make([]Exemplar, 0, len(r.exemplars))
+	exemplars = append(exemplars, r.exemplars[r.next:]...)
+	return append(exemplars, r.exemplars[:r.next]...)
+}
+
+// exemplarsByTime sorts exemplars, oldest first.
+type exemplarsByTime []Exemplar
+
+func (e exemplarsByTime) Len() int           { return len(e) }
+func (e exemplarsByTime) Less(i, j int) bool { return e[i].Time.Before(e[j].Time) }
+func (e exemplarsByTime) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+// latestExemplar returns the most recent of exemplars, oldest first, whose
+// value lies in (min, max], and whether there is one.
+func latestExemplar(exemplars []Exemplar, min, max int64) (Exemplar, bool) {
+	for i := len(exemplars) - 1; 0 <= i; i-- {
+		if e := exemplars[i]; min < e.Value && e.Value <= max {
+			return e, true
+		}
+	}
+	return Exemplar{}, false
+}
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHistogramExemplars(t *testing.T) {
+	h := NewHistogram(NewUniformSample(100))
+	for i := 0; i < maxExemplars+2; i++ {
+		h.UpdateWithExemplar(int64(i), fmt.Sprintf("trace%d", i))
+	}
+	h.Update(1000)
+	exemplars := h.Exemplars()
+	if maxExemplars != len(exemplars) || "trace2" != exemplars[0].TraceID || "trace11" != exemplars[maxExemplars-1].TraceID {
+		t.Fatalf("h.Exemplars(): %v", exemplars)
+	}
+	if 13 != h.Count() {
+		t.Errorf("h.Count(): 13 != %v", h.Count())
+	}
+	s := h.Snapshot()
+	h.Clear()
+	if 0 != len(h.Exemplars()) {
+		t.Errorf("h.Exemplars() after Clear(): %v", h.Exemplars())
+	}
+	if exemplars := s.Exemplars(); maxExemplars != len(exemplars) || 11 != exemplars[maxExemplars-1].Value {
+		t.Errorf("s.Exemplars(): %v", exemplars)
+	}
+}
+
+func TestBucketedHistogramExemplars(t *testing.T) {
+	h := NewBucketedHistogram(NewUniformSample(100), []int64{100})
+	h.UpdateWithExemplar(47, "abc")
+	if counts := h.BucketCounts(); 1 != counts[0] {
+		t.Errorf("h.BucketCounts(): %v", counts)
+	}
+	if exemplars := h.Snapshot().Exemplars(); 1 != len(exemplars) || "abc" != exemplars[0].TraceID {
+		t.Errorf("h.Snapshot().Exemplars(): %v", exemplars)
+	}
+}
+
+func TestTimerExemplars(t *testing.T) {
+	tm := NewTimer()
+	tm.UpdateWithExemplar(time.Second, "abc")
+	if 1 != tm.Count() || int64(time.Second) != tm.Max() {
+		t.Errorf("tm.Count(), tm.Max(): %v, %v", tm.Count(), tm.Max())
+	}
+	if exemplars := tm.Snapshot().Exemplars(); 1 != len(exemplars) || int64(time.Second) != exemplars[0].Value || "abc" != exemplars[0].TraceID {
+		t.Errorf("tm.Snapshot().Exemplars(): %v", exemplars)
+	}
+}
@@ -50,6 +50,20 @@ func ExpHandler(r metrics.Registry) http.Handler {
 	return http.HandlerFunc(e.expHandler)
 }
 
+// Publish publishes every metric in r as a single expvar of the given name,
+// an object of each metric's values as encoded by metrics.MarshalJSON, read
+// whenever expvars are served.  Unlike ExpHandler it neither publishes a var
+// per value nor needs a handler of its own.  Like expvar.Publish it panics
+// if the name is already in use.
+func Publish(name string, r metrics.Registry) {
+	if nil == r {
+		r = metrics.DefaultRegistry
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return metrics.GetAll(r)
+	}))
+}
+
 func (exp *exp) getInt(name string) *expvar.Int {
 	var v *expvar.Int
 	exp.expvarLock.Lock()
@@ -135,7 +149,7 @@ func (exp *exp) publishTimer(name string, metric metrics.Timer) {
 }
 
 func (exp *exp) syncToExpvar() {
-	exp.registry.Each(func(name string, i interface{}) {
+	exp.registry.Snapshot().Each(func(name string, i interface{}) {
 		switch i.(type) {
 		case metrics.Counter:
 			exp.publishCounter(name, i.(metrics.Counter))
@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// Exporter sends the metrics in a registry to a backend, so that backends
+// can share the scheduling of FlushLoop.  Exporters which also implement
+// ContextExporter are given the loop's context.
+type Exporter interface {
+	Export(Registry) error
+}
+
+// ContextExporter is an Exporter whose exports can be cancelled.
+type ContextExporter interface {
+	Exporter
+	ExportContext(context.Context, Registry) error
+}
+
+// ExporterFunc adapts a function to Exporter.
+type ExporterFunc func(Registry) error
+
+// Export calls f(r).
+func (f ExporterFunc) Export(r Registry) error { return f(r) }
+
+// defaultPercentiles are the percentiles of histograms and timers which
+// exporters report unless they're configured with others.
+var defaultPercentiles = []float64{0.5, 0.75, 0.95, 0.99, 0.999}
+
+// percentilesOrDefault returns ps, or defaultPercentiles if ps is nil, so
+// that an empty slice configures no percentiles.
+func percentilesOrDefault(ps []float64) []float64 {
+	if nil == ps {
+		return defaultPercentiles
+	}
+	return ps
+}
+
+// FlushLoopConfig provides a container with configuration parameters for
+// FlushLoopWithConfig
+type FlushLoopConfig struct {
+	Registry      Registry      // Registry to be exported
+	FlushInterval time.Duration // Flush interval
+	Exporter      Exporter      // Exporter called each flush
+	Jitter        time.Duration // Each flush is delayed by a random duration up to this long, so that hosts spread out
+	ErrorHandler  func(error)   // Called with each flush error, defaults to log.Println
+	SelfMetrics   bool          // Record exports in the Registry, see FlushLoopWithConfig
+	Clock         Clock         // Source of ticks and jitter delays, defaults to the system clock
+
+	failures Counter // Failed exports, if SelfMetrics
+	duration Timer   // Duration of exports, if SelfMetrics
+}
+
+// FlushLoop calls e.Export(r) every d until ctx is done, then once more so
+// that nothing recorded since the previous flush is lost, returning the
+// error of that final export.  Errors from the other exports are logged.
+func FlushLoop(ctx context.Context, r Registry, d time.Duration, e Exporter) error {
+	return FlushLoopWithConfig(ctx, FlushLoopConfig{
+		Registry:      r,
+		FlushInterval: d,
+		Exporter:      e,
+	})
+}
+
+// FlushLoopWithConfig is like FlushLoop but takes a FlushLoopConfig.  Errors
+// from exports other than the final one are passed to the ErrorHandler, as
+// are panics of the Exporter, which are recovered so that one bad flush
+// doesn't stop the loop.  The final export is given one FlushInterval to
+// complete.
+//
+// If SelfMetrics is set the loop records each export in the Registry it
+// exports, as the Timer metrics.exporter.flush_duration, and each one which
+// fails as the Counter metrics.exporter.flush_failures, so that an exporter
+// which loses data is seen to.  Loops exporting the same Registry share
+// them.
+func FlushLoopWithConfig(ctx context.Context, c FlushLoopConfig) error {
+	if nil == c.Registry {
+		return errors.New("metrics: flush loop has no Registry to export")
+	}
+	if nil == c.Exporter {
+		return errors.New("metrics: flush loop has no Exporter")
+	}
+	if c.FlushInterval <= 0 {
+		return errors.New("metrics: flush loop has no positive FlushInterval")
+	}
+	if nil == c.Clock {
+		c.Clock = systemClock{}
+	}
+	if c.SelfMetrics {
+		c.failures = GetOrRegisterCounter("metrics.exporter.flush_failures", c.Registry)
+		c.duration = GetOrRegisterTimer("metrics.exporter.flush_duration", c.Registry)
+	}
+	ticks, stop := c.Clock.Tick(c.FlushInterval)
+	defer stop()
+	for {
+		select {
+		case <-ticks:
+			if 0 < c.Jitter {
+				jittered, stop := after(c.Clock, time.Duration(rand.Int63n(int64(c.Jitter))))
+				select {
+				case <-jittered:
+					stop()
+				case <-ctx.Done():
+					stop()
+					return c.finalExport()
+				}
+			}
+			if err := c.export(ctx); nil != err {
+				c.handleError(err)
+			}
+		case <-ctx.Done():
+			return c.finalExport()
+		}
+	}
+}
+
+// handleError passes err to the ErrorHandler, or logs it if there is none.
+func (c *FlushLoopConfig) handleError(err error) {
+	if nil != c.ErrorHandler {
+		c.ErrorHandler(err)
+	} else {
+		log.Println(err)
+	}
+}
+
+// export calls the Exporter, returning a panic as an error.
+func (c *FlushLoopConfig) export(ctx context.Context) (err error) {
+	start := c.Clock.Now()
+	defer func() {
+		if p := recover(); nil != p {
+			err = fmt.Errorf("metrics: exporter panicked: %v", p)
+		}
+		if c.SelfMetrics {
+			c.duration.Update(c.Clock.Now().Sub(start))
+			if nil != err {
+				c.failures.Inc(1)
+			}
+		}
+	}()
+	if e, ok := c.Exporter.(ContextExporter); ok {
+		return e.ExportContext(ctx, c.Registry)
+	}
+	return c.Exporter.Export(c.Registry)
+}
+
+// finalExport exports once more when the loop is stopped, with a context of
+// its own since the loop's is already done.
+func (c *FlushLoopConfig) finalExport() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.FlushInterval)
+	defer cancel()
+	return c.export(ctx)
+}
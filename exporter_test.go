@@ -0,0 +1,188 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlushLoop(t *testing.T) {
+	r := NewRegistry()
+	clk := &fakeClock{ticks: make(chan time.Time)}
+	exported := make(chan Registry)
+	errs := make(chan error, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- FlushLoopWithConfig(ctx, FlushLoopConfig{
+			Registry:      r,
+			FlushInterval: time.Hour,
+			Exporter: ExporterFunc(func(r Registry) error {
+				exported <- r
+				return errors.New("down")
+			}),
+			ErrorHandler: func(err error) { errs <- err },
+			Clock:        clk,
+		})
+	}()
+	for i := 0; i < 2; i++ {
+		clk.ticks <- time.Time{}
+		if got := <-exported; r != got {
+			t.Fatalf("exported %v, not %v", got, r)
+		}
+		if err := <-errs; "down" != err.Error() {
+			t.Fatal(err)
+		}
+	}
+	cancel()
+	<-exported
+	if err := <-done; nil == err || "down" != err.Error() {
+		t.Fatal(err)
+	}
+	if 0 != len(errs) {
+		t.Fatal("final error passed to the ErrorHandler")
+	}
+}
+
+func TestFlushLoopPanic(t *testing.T) {
+	clk := &fakeClock{ticks: make(chan time.Time)}
+	errs := make(chan error, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	calls := 0
+	go func() {
+		done <- FlushLoopWithConfig(ctx, FlushLoopConfig{
+			Registry:      NewRegistry(),
+			FlushInterval: time.Hour,
+			Exporter: ExporterFunc(func(Registry) error {
+				calls++
+				clk.now = clk.now.Add(time.Second)
+				if 1 == calls {
+					panic("boom")
+				}
+				return nil
+			}),
+			ErrorHandler: func(err error) { errs <- err },
+			Clock:        clk,
+		})
+	}()
+	clk.ticks <- time.Time{}
+	if err := <-errs; !strings.Contains(err.Error(), "boom") {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := <-done; nil != err {
+		t.Fatal(err)
+	}
+	if 2 != calls {
+		t.Fatalf("%d calls", calls)
+	}
+}
+
+// contextExporter records whether each export's context had a deadline and
+// was live.
+type contextExporter struct {
+	live chan bool
+}
+
+func (e *contextExporter) Export(r Registry) error {
+	return e.ExportContext(context.Background(), r)
+}
+
+func (e *contextExporter) ExportContext(ctx context.Context, r Registry) error {
+	_, ok := ctx.Deadline()
+	e.live <- ok && nil == ctx.Err()
+	return nil
+}
+
+func TestFlushLoopContextExporter(t *testing.T) {
+	e := &contextExporter{make(chan bool, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := FlushLoop(ctx, NewRegistry(), time.Hour, e); nil != err {
+		t.Fatal(err)
+	}
+	if !<-e.live {
+		t.Fatal("final export not given a live context with a deadline")
+	}
+}
+
+func TestFlushLoopJitter(t *testing.T) {
+	clk := NewMockClock(time.Unix(1433160000, 0))
+	exported := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go FlushLoopWithConfig(ctx, FlushLoopConfig{
+		Registry:      NewRegistry(),
+		FlushInterval: time.Minute,
+		Exporter: ExporterFunc(func(Registry) error {
+			exported <- struct{}{}
+			return nil
+		}),
+		Jitter: 10 * time.Second,
+		Clock:  clk,
+	})
+	waitForTickers(t, clk, 1)
+	clk.Add(time.Minute)
+	waitForTickers(t, clk, 2)
+	select {
+	case <-exported:
+		t.Fatal("exported without jitter")
+	default:
+	}
+	clk.Add(10 * time.Second)
+	<-exported
+}
+
+func TestFlushLoopSelfMetrics(t *testing.T) {
+	r := NewRegistry()
+	clk := &fakeClock{ticks: make(chan time.Time)}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	done := make(chan error)
+	go func() {
+		done <- FlushLoopWithConfig(ctx, FlushLoopConfig{
+			Registry:      r,
+			FlushInterval: time.Hour,
+			Exporter: ExporterFunc(func(Registry) error {
+				calls++
+				clk.now = clk.now.Add(time.Second)
+				if 1 == calls {
+					return errors.New("unavailable")
+				}
+				return nil
+			}),
+			ErrorHandler: func(error) {},
+			SelfMetrics:  true,
+			Clock:        clk,
+		})
+	}()
+	clk.ticks <- time.Now()
+	clk.ticks <- time.Now()
+	cancel()
+	if err := <-done; nil != err {
+		t.Fatal(err)
+	}
+	if c := GetOrRegisterCounter("metrics.exporter.flush_failures", r).Count(); 1 != c {
+		t.Errorf("flush_failures: %d", c)
+	}
+	if tm := GetOrRegisterTimer("metrics.exporter.flush_duration", r); 3 != tm.Count() || int64(3*time.Second) != tm.Sum() {
+		t.Errorf("flush_duration: %d, %v", tm.Count(), time.Duration(tm.Sum()))
+	}
+}
+
+func TestFlushLoopConfigErrors(t *testing.T) {
+	if err := FlushLoop(context.Background(), nil, time.Second, ExporterFunc(func(Registry) error { return nil })); nil == err {
+		t.Error("no error without a Registry")
+	}
+	if err := FlushLoop(context.Background(), NewRegistry(), time.Second, nil); nil == err {
+		t.Error("no error without an Exporter")
+	}
+	for _, d := range []time.Duration{0, -time.Second} {
+		if err := FlushLoop(context.Background(), NewRegistry(), d, ExporterFunc(func(Registry) error { return nil })); nil == err {
+			t.Errorf("no error with a FlushInterval of %v", d)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FanoutSink is one of the Exporters of a FanoutExporter.
+type FanoutSink struct {
+	Name     string            // Name of the sink in errors, defaults to its index
+	Exporter Exporter          // Exporter given the snapshot
+	Filter   func(string) bool // Metrics whose names it returns false for aren't given to the Exporter, all are if nil
+}
+
+// FanoutExporter is an Exporter which snapshots the registry once and
+// exports the snapshot with each of its sinks in turn, so that metrics can
+// be shipped to several backends, as during a migration from one to
+// another, behind a single FlushLoop.  The Exporters of the backends are
+// constructed by NewOpenTSDBExporter, NewStatsDExporter and the like.  A
+// sink which fails or panics doesn't stop the others.  Sinks are given
+// read-only snapshots, so options which clear metrics as they're exported,
+// such as OpenTSDB's ResetCounters, make them fail.
+type FanoutExporter struct {
+	Sinks []FanoutSink
+}
+
+// NewFanoutExporter constructs a new FanoutExporter exporting every metric
+// with each of the given exporters.
+func NewFanoutExporter(exporters ...Exporter) *FanoutExporter {
+	f := &FanoutExporter{Sinks: make([]FanoutSink, len(exporters))}
+	for i, e := range exporters {
+		f.Sinks[i].Exporter = e
+	}
+	return f
+}
+
+// FanoutError is the error of an export by a FanoutExporter in which sinks
+// failed, holding the error of each, prefixed by the sink's name.
+type FanoutError struct {
+	Errors []error
+}
+
+func (err FanoutError) Error() string {
+	msgs := make([]string, len(err.Errors))
+	for i, e := range err.Errors {
+		msgs[i] = e.Error()
+	}
+	return "fanout: " + strings.Join(msgs, "; ")
+}
+
+// Export exports a snapshot of r with each sink.
+func (f *FanoutExporter) Export(r Registry) error {
+	return f.ExportContext(context.Background(), r)
+}
+
+// ExportContext exports a snapshot of r with each sink, giving those which
+// are ContextExporters ctx.  A FanoutError is returned if any sink fails.
+func (f *FanoutExporter) ExportContext(ctx context.Context, r Registry) error {
+	snapshot := taggedSnapshot(r)
+	var errs []error
+	for i, sink := range f.Sinks {
+		name := sink.Name
+		if "" == name {
+			name = strconv.Itoa(i)
+		}
+		s := snapshot
+		if nil != sink.Filter {
+			s = filteredSnapshot(snapshot, sink.Filter)
+		}
+		if err := exportSink(ctx, sink.Exporter, s); nil != err {
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+		}
+	}
+	if 0 < len(errs) {
+		return FanoutError{errs}
+	}
+	return nil
+}
+
+// exportSink exports r with e, returning a panic as an error.
+func exportSink(ctx context.Context, e Exporter, r Registry) (err error) {
+	defer func() {
+		if p := recover(); nil != p {
+			err = fmt.Errorf("exporter panicked: %v", p)
+		}
+	}()
+	if ce, ok := e.(ContextExporter); ok {
+		return ce.ExportContext(ctx, r)
+	}
+	return e.Export(r)
+}
+
+// taggedSnapshot snapshots every metric in r into a new registry, with its
+// metadata, keeping the tags and export names of Tagged metrics which
+// Registry.Snapshot drops, so that exporters of the snapshot export them as
+// they would the live metrics.
+func taggedSnapshot(r Registry) *StandardRegistry {
+	snapshot := NewRegistry().(*StandardRegistry)
+	mr, _ := r.(MetadataRegistry)
+	r.Each(func(name string, i interface{}) {
+		s := snapshotMetric(i)
+		if t, ok := i.(Tagged); ok {
+			s = tag(exportName(name, i), s, t.Tags())
+		}
+		snapshot.metrics[name] = s
+		if nil != mr {
+			if md, ok := mr.Metadata(name); ok {
+				snapshot.metadata[name] = md
+			}
+		}
+	})
+	return snapshot
+}
+
+// filteredSnapshot returns a registry of the metrics in snapshot whose
+// export names filter returns true for.
+func filteredSnapshot(snapshot *StandardRegistry, filter func(string) bool) *StandardRegistry {
+	filtered := NewRegistry().(*StandardRegistry)
+	for name, i := range snapshot.metrics {
+		if filter(exportName(name, i)) {
+			filtered.metrics[name] = i
+			if md, ok := snapshot.metadata[name]; ok {
+				filtered.metadata[name] = md
+			}
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFanoutExporter(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredCounter("foo.count", r).Inc(3)
+	NewRegisteredGauge("bar.value", r).Update(7)
+	GetOrRegisterTaggedCounter("requests", map[string]string{"host": "a"}, r).Inc(1)
+
+	var all, foo map[string]interface{}
+	collect := func(m *map[string]interface{}) Exporter {
+		return ExporterFunc(func(r Registry) error {
+			*m = make(map[string]interface{})
+			r.Each(func(name string, i interface{}) { (*m)[name] = i })
+			return nil
+		})
+	}
+	f := NewFanoutExporter(collect(&all))
+	f.Sinks = append(f.Sinks, FanoutSink{
+		Exporter: collect(&foo),
+		Filter:   func(name string) bool { return strings.HasPrefix(name, "foo.") },
+	})
+	if err := f.Export(r); nil != err {
+		t.Fatal(err)
+	}
+	if 3 != len(all) {
+		t.Fatalf("all: %v", all)
+	}
+	if 1 != len(foo) {
+		t.Fatalf("foo: %v", foo)
+	}
+	c, ok := foo["foo.count"].(CounterSnapshot)
+	if !ok || 3 != c.Count() {
+		t.Fatalf("foo.count: %#v", foo["foo.count"])
+	}
+	r.Get("foo.count").(Counter).Inc(1)
+	if 3 != c.Count() {
+		t.Fatal("sink was given the live counter")
+	}
+	tagged := 0
+	for name, i := range all {
+		if tg, ok := i.(Tagged); ok {
+			tagged++
+			if "a" != tg.Tags()["host"] || "requests" != exportName(name, i) {
+				t.Fatalf("%s: %v", name, tg.Tags())
+			}
+			if _, ok := i.(Counter); !ok {
+				t.Fatalf("%s: %T", name, i)
+			}
+		}
+	}
+	if 1 != tagged {
+		t.Fatalf("%d tagged metrics", tagged)
+	}
+}
+
+func TestFanoutExporterBackends(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if nil != err {
+		t.Skip(err)
+	}
+	defer conn.Close()
+	r := NewRegistry()
+	NewRegisteredCounter("foo.count", r).Inc(3)
+	NewRegisteredGauge("bar.value", r).Update(7)
+
+	var puts bytes.Buffer
+	opentsdb, err := NewOpenTSDBExporter(OpenTSDBConfig{Transport: &WriterTransport{W: &puts}})
+	if nil != err {
+		t.Fatal(err)
+	}
+	statsd, err := NewStatsDExporter(StatsDConfig{Addr: conn.LocalAddr().(*net.UDPAddr)})
+	if nil != err {
+		t.Fatal(err)
+	}
+	var logged []string
+	log := ExporterFunc(func(r Registry) error {
+		LogStructuredOnce(r, time.Millisecond, StructuredLoggerFunc(func(msg string, keyvals ...interface{}) {
+			logged = append(logged, keyvals[1].(string))
+		}))
+		return nil
+	})
+	f := NewFanoutExporter(opentsdb, log)
+	f.Sinks = append(f.Sinks, FanoutSink{
+		Name:     "statsd",
+		Exporter: statsd,
+		Filter:   func(name string) bool { return strings.HasPrefix(name, "foo.") },
+	})
+	if err := f.Export(r); nil != err {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(puts.String(), "put foo.count.count ") || !strings.Contains(puts.String(), "put bar.value.value ") {
+		t.Errorf("opentsdb: %q", puts.String())
+	}
+	if 2 != len(logged) {
+		t.Errorf("log: %v", logged)
+	}
+	buf := make([]byte, defaultMaxDatagram)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if "foo.count:3|c" != string(buf[:n]) {
+		t.Errorf("statsd: %q", buf[:n])
+	}
+}
+
+func TestFanoutExporterIsolation(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredCounter("foo", r).Inc(1)
+	calls := 0
+	ok := ExporterFunc(func(Registry) error {
+		calls++
+		return nil
+	})
+	f := &FanoutExporter{Sinks: []FanoutSink{
+		{Name: "down", Exporter: ExporterFunc(func(Registry) error { return errors.New("refused") })},
+		{Exporter: ExporterFunc(func(r Registry) error {
+			r.Get("foo").(Counter).Clear()
+			return nil
+		})},
+		{Exporter: ok},
+	}}
+	err := f.Export(r)
+	if 1 != calls {
+		t.Fatalf("%d calls", calls)
+	}
+	ferr, isFanout := err.(FanoutError)
+	if !isFanout || 2 != len(ferr.Errors) {
+		t.Fatalf("%#v", err)
+	}
+	if "down: refused" != ferr.Errors[0].Error() {
+		t.Fatal(ferr.Errors[0])
+	}
+	if !strings.HasPrefix(ferr.Errors[1].Error(), "1: exporter panicked: ") {
+		t.Fatal(ferr.Errors[1])
+	}
+	if !strings.HasPrefix(err.Error(), "fanout: down: refused; 1: ") {
+		t.Fatal(err)
+	}
+	if 1 != r.Get("foo").(Counter).Count() {
+		t.Fatal("live counter cleared")
+	}
+}
@@ -1,6 +1,10 @@
 package metrics
 
-import "sync/atomic"
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 // Gauges hold an int64 value that can be set arbitrarily.
 type Gauge interface {
@@ -9,6 +13,14 @@ type Gauge interface {
 	Value() int64
 }
 
+// ReadOnlyGauge is implemented by Gauges and GaugeFloat64s, such as
+// FunctionalGauge and CachedGauge, whose values are computed rather than set
+// and whose Update panics, so that callers such as LoadRegistry can leave
+// them alone.
+type ReadOnlyGauge interface {
+	ReadOnly()
+}
+
 // GetOrRegisterGauge returns an existing Gauge or constructs and registers a
 // new StandardGauge.
 func GetOrRegisterGauge(name string, r Registry) Gauge {
@@ -26,14 +38,60 @@ func NewGauge() Gauge {
 	return &StandardGauge{0}
 }
 
-// NewRegisteredGauge constructs and registers a new StandardGauge.
+// NewRegisteredGauge constructs and registers a new StandardGauge, or returns
+// the Gauge already registered under the given name.
 func NewRegisteredGauge(name string, r Registry) Gauge {
-	c := NewGauge()
+	return GetOrRegisterGauge(name, r)
+}
+
+// GetOrRegisterFunctionalGauge returns an existing Gauge or constructs and
+// registers a new FunctionalGauge which reads its value from f.
+func GetOrRegisterFunctionalGauge(name string, r Registry, f func() int64) Gauge {
 	if nil == r {
 		r = DefaultRegistry
 	}
-	r.Register(name, c)
-	return c
+	return r.GetOrRegister(name, func() Gauge { return NewFunctionalGauge(f) }).(Gauge)
+}
+
+// NewFunctionalGauge constructs a new FunctionalGauge which reads its value
+// from f.
+func NewFunctionalGauge(f func() int64) Gauge {
+	if UseNilMetrics {
+		return NilGauge{}
+	}
+	return &FunctionalGauge{value: f}
+}
+
+// NewRegisteredFunctionalGauge constructs and registers a new
+// FunctionalGauge, or returns the Gauge already registered under the given
+// name.
+func NewRegisteredFunctionalGauge(name string, r Registry, f func() int64) Gauge {
+	return GetOrRegisterFunctionalGauge(name, r, f)
+}
+
+// GetOrRegisterCachedGauge returns an existing Gauge or constructs and
+// registers a new CachedGauge which reads its value from f at most once
+// per ttl.
+func GetOrRegisterCachedGauge(name string, r Registry, ttl time.Duration, f func() int64) Gauge {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return r.GetOrRegister(name, func() Gauge { return NewCachedGauge(ttl, f) }).(Gauge)
+}
+
+// NewCachedGauge constructs a new CachedGauge which reads its value from f at
+// most once per ttl.
+func NewCachedGauge(ttl time.Duration, f func() int64) Gauge {
+	if UseNilMetrics {
+		return NilGauge{}
+	}
+	return &CachedGauge{load: f, ttl: ttl}
+}
+
+// NewRegisteredCachedGauge constructs and registers a new CachedGauge, or
+// returns the Gauge already registered under the given name.
+func NewRegisteredCachedGauge(name string, r Registry, ttl time.Duration, f func() int64) Gauge {
+	return GetOrRegisterCachedGauge(name, r, ttl, f)
 }
 
 // GaugeSnapshot is a read-only copy of another Gauge.
@@ -82,3 +140,68 @@ func (g *StandardGauge) Update(v int64) {
 func (g *StandardGauge) Value() int64 {
 	return atomic.LoadInt64(&g.value)
 }
+
+// FunctionalGauge is a Gauge whose value is computed by calling a function
+// each time it's read, for values which are cheaper to compute on demand
+// than to keep up to date.
+type FunctionalGauge struct {
+	value func() int64
+}
+
+// ReadOnly marks the gauge as a ReadOnlyGauge.
+func (*FunctionalGauge) ReadOnly() {}
+
+// Snapshot returns a read-only copy of the gauge's current value.
+func (g *FunctionalGauge) Snapshot() Gauge {
+	return GaugeSnapshot(g.Value())
+}
+
+// Update panics.
+func (*FunctionalGauge) Update(int64) {
+	panic("Update called on a FunctionalGauge")
+}
+
+// Value calls the gauge's function and returns the result.
+func (g *FunctionalGauge) Value() int64 {
+	return g.value()
+}
+
+// CachedGauge is a Gauge whose value is computed by calling a function, like
+// a FunctionalGauge's, but which keeps the value for a time to live before
+// calling it again, for values such as disk usage or the rows in a table
+// which are expensive to compute and read by more than one exporter.
+// Readers while the function is being called wait for its value rather than
+// calling it too.
+type CachedGauge struct {
+	load func() int64
+	ttl  time.Duration
+
+	mutex   sync.Mutex
+	value   int64
+	expires time.Time
+}
+
+// ReadOnly marks the gauge as a ReadOnlyGauge.
+func (*CachedGauge) ReadOnly() {}
+
+// Snapshot returns a read-only copy of the gauge's current value.
+func (g *CachedGauge) Snapshot() Gauge {
+	return GaugeSnapshot(g.Value())
+}
+
+// Update panics.
+func (*CachedGauge) Update(int64) {
+	panic("Update called on a CachedGauge")
+}
+
+// Value returns the value last returned by the gauge's function, calling it
+// anew if that was longer ago than the time to live.
+func (g *CachedGauge) Value() int64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if now := time.Now(); !now.Before(g.expires) {
+		g.value = g.load()
+		g.expires = now.Add(g.ttl)
+	}
+	return g.value
+}
@@ -1,6 +1,11 @@
 package metrics
 
-import "sync"
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
 
 // GaugeFloat64s hold a float64 value that can be set arbitrarily.
 type GaugeFloat64 interface {
@@ -15,7 +20,7 @@ func GetOrRegisterGaugeFloat64(name string, r Registry) GaugeFloat64 {
 	if nil == r {
 		r = DefaultRegistry
 	}
-	return r.GetOrRegister(name, NewGaugeFloat64()).(GaugeFloat64)
+	return r.GetOrRegister(name, NewGaugeFloat64).(GaugeFloat64)
 }
 
 // NewGaugeFloat64 constructs a new StandardGaugeFloat64.
@@ -28,14 +33,113 @@ func NewGaugeFloat64() GaugeFloat64 {
 	}
 }
 
-// NewRegisteredGaugeFloat64 constructs and registers a new StandardGaugeFloat64.
+// NewRegisteredGaugeFloat64 constructs and registers a new
+// StandardGaugeFloat64, or returns the GaugeFloat64 already registered under
+// the given name.
 func NewRegisteredGaugeFloat64(name string, r Registry) GaugeFloat64 {
-	c := NewGaugeFloat64()
+	return GetOrRegisterGaugeFloat64(name, r)
+}
+
+// SampleGaugeIntoHistogram updates h with the value of g every interval so
+// that the distribution of an instantaneous signal can be summarized by
+// percentiles alongside its current value.  Values are multiplied by scale,
+// such as 1e3 to keep three decimal places of a load between 0 and 1, and
+// rounded to the nearest int64.  Sampling happens in a goroutine until the
+// returned function is called, which waits for the goroutine to exit.  An
+// error is returned if interval or scale isn't positive.
+func SampleGaugeIntoHistogram(g GaugeFloat64, h Histogram, interval time.Duration, scale float64) (stop func(), err error) {
+	if interval <= 0 {
+		return nil, errors.New("metrics: gauge sampling interval isn't positive")
+	}
+	if !(0 < scale) {
+		return nil, errors.New("metrics: gauge sampling scale isn't positive")
+	}
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.Update(int64(math.Floor(g.Value()*scale + 0.5)))
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-stopped
+	}, nil
+}
+
+// NewRegisteredSampledGaugeFloat64 registers g under the given name and h
+// under the name followed by ".histogram", and samples g into h as
+// SampleGaugeIntoHistogram does.  Nothing is sampled if either can't be
+// registered.
+func NewRegisteredSampledGaugeFloat64(name string, r Registry, g GaugeFloat64, h Histogram, interval time.Duration, scale float64) (stop func(), err error) {
 	if nil == r {
 		r = DefaultRegistry
 	}
-	r.Register(name, c)
-	return c
+	if err := r.RegisterBatch(map[string]interface{}{name: g, name + ".histogram": h}); nil != err {
+		return nil, err
+	}
+	return SampleGaugeIntoHistogram(g, h, interval, scale)
+}
+
+// GetOrRegisterFunctionalGaugeFloat64 returns an existing GaugeFloat64 or
+// constructs and registers a new FunctionalGaugeFloat64 which reads its value
+// from f.
+func GetOrRegisterFunctionalGaugeFloat64(name string, r Registry, f func() float64) GaugeFloat64 {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return r.GetOrRegister(name, func() GaugeFloat64 { return NewFunctionalGaugeFloat64(f) }).(GaugeFloat64)
+}
+
+// NewFunctionalGaugeFloat64 constructs a new FunctionalGaugeFloat64 which
+// reads its value from f.
+func NewFunctionalGaugeFloat64(f func() float64) GaugeFloat64 {
+	if UseNilMetrics {
+		return NilGaugeFloat64{}
+	}
+	return &FunctionalGaugeFloat64{value: f}
+}
+
+// NewRegisteredFunctionalGaugeFloat64 constructs and registers a new
+// FunctionalGaugeFloat64, or returns the GaugeFloat64 already registered
+// under the given name.
+func NewRegisteredFunctionalGaugeFloat64(name string, r Registry, f func() float64) GaugeFloat64 {
+	return GetOrRegisterFunctionalGaugeFloat64(name, r, f)
+}
+
+// GetOrRegisterCachedGaugeFloat64 returns an existing GaugeFloat64 or
+// constructs and registers a new CachedGaugeFloat64 which reads its value
+// from f at most once per ttl.
+func GetOrRegisterCachedGaugeFloat64(name string, r Registry, ttl time.Duration, f func() float64) GaugeFloat64 {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return r.GetOrRegister(name, func() GaugeFloat64 { return NewCachedGaugeFloat64(ttl, f) }).(GaugeFloat64)
+}
+
+// NewCachedGaugeFloat64 constructs a new CachedGaugeFloat64 which reads its
+// value from f at most once per ttl.
+func NewCachedGaugeFloat64(ttl time.Duration, f func() float64) GaugeFloat64 {
+	if UseNilMetrics {
+		return NilGaugeFloat64{}
+	}
+	return &CachedGaugeFloat64{load: f, ttl: ttl}
+}
+
+// NewRegisteredCachedGaugeFloat64 constructs and registers a new
+// CachedGaugeFloat64, or returns the GaugeFloat64 already registered under
+// the given name.
+func NewRegisteredCachedGaugeFloat64(name string, r Registry, ttl time.Duration, f func() float64) GaugeFloat64 {
+	return GetOrRegisterCachedGaugeFloat64(name, r, ttl, f)
 }
 
 // GaugeFloat64Snapshot is a read-only copy of another GaugeFloat64.
@@ -89,3 +193,67 @@ func (g *StandardGaugeFloat64) Value() float64 {
 	defer g.mutex.Unlock()
 	return g.value
 }
+
+// FunctionalGaugeFloat64 is a GaugeFloat64 whose value is computed by calling
+// a function each time it's read.
+type FunctionalGaugeFloat64 struct {
+	value func() float64
+}
+
+// ReadOnly marks the gauge as a ReadOnlyGauge.
+func (*FunctionalGaugeFloat64) ReadOnly() {}
+
+// Snapshot returns a read-only copy of the gauge's current value.
+func (g *FunctionalGaugeFloat64) Snapshot() GaugeFloat64 {
+	return GaugeFloat64Snapshot(g.Value())
+}
+
+// Update panics.
+func (*FunctionalGaugeFloat64) Update(float64) {
+	panic("Update called on a FunctionalGaugeFloat64")
+}
+
+// Value calls the gauge's function and returns the result.
+func (g *FunctionalGaugeFloat64) Value() float64 {
+	return g.value()
+}
+
+// CachedGaugeFloat64 is a GaugeFloat64 whose value is computed by calling a
+// function, like a FunctionalGaugeFloat64's, but which keeps the value for
+// a time to live before calling it again, for values such as disk usage or
+// the rows in a table which are expensive to compute and read by more than
+// one exporter.  Readers while the function is being called wait for its
+// value rather than calling it too.
+type CachedGaugeFloat64 struct {
+	load func() float64
+	ttl  time.Duration
+
+	mutex   sync.Mutex
+	value   float64
+	expires time.Time
+}
+
+// ReadOnly marks the gauge as a ReadOnlyGauge.
+func (*CachedGaugeFloat64) ReadOnly() {}
+
+// Snapshot returns a read-only copy of the gauge's current value.
+func (g *CachedGaugeFloat64) Snapshot() GaugeFloat64 {
+	return GaugeFloat64Snapshot(g.Value())
+}
+
+// Update panics.
+func (*CachedGaugeFloat64) Update(float64) {
+	panic("Update called on a CachedGaugeFloat64")
+}
+
+// Value returns the value last returned by the gauge's function, calling it
+// anew if that was longer ago than the time to live.
+func (g *CachedGaugeFloat64) Value() float64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if now := time.Now(); !now.Before(g.expires) {
+		g.value = g.load()
+		g.expires = now.Add(g.ttl)
+	}
+	return g.value
+}
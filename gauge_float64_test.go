@@ -1,6 +1,9 @@
 package metrics
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func BenchmarkGuageFloat64(b *testing.B) {
 	g := NewGaugeFloat64()
@@ -36,3 +39,94 @@ func TestGetOrRegisterGaugeFloat64(t *testing.T) {
 		t.Fatal(g)
 	}
 }
+
+func TestSampleGaugeIntoHistogram(t *testing.T) {
+	g := NewGaugeFloat64()
+	g.Update(0.4666)
+	h := NewHistogram(NewUniformSample(100))
+	stop, err := SampleGaugeIntoHistogram(g, h, time.Millisecond, 1e3)
+	if nil != err {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	stop()
+	count := h.Count()
+	if 0 == count {
+		t.Fatal(count)
+	}
+	if max := h.Max(); 467 != max {
+		t.Errorf("h.Max(): 467 != %v\n", max)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if c := h.Count(); count != c {
+		t.Errorf("h.Count(): %v != %v\n", count, c)
+	}
+	stop()
+}
+
+func TestSampleGaugeIntoHistogramInvalid(t *testing.T) {
+	g, h := NewGaugeFloat64(), NewHistogram(NewUniformSample(100))
+	if _, err := SampleGaugeIntoHistogram(g, h, 0, 1); nil == err {
+		t.Error("no error for a zero interval")
+	}
+	if _, err := SampleGaugeIntoHistogram(g, h, time.Millisecond, 0); nil == err {
+		t.Error("no error for a zero scale")
+	}
+}
+
+func TestNewRegisteredSampledGaugeFloat64(t *testing.T) {
+	r := NewRegistry()
+	g, h := NewGaugeFloat64(), NewHistogram(NewUniformSample(100))
+	stop, err := NewRegisteredSampledGaugeFloat64("load", r, g, h, time.Millisecond, 1e3)
+	if nil != err {
+		t.Fatal(err)
+	}
+	stop()
+	if g != r.Get("load") || h != r.Get("load.histogram") {
+		t.Errorf("load: %v, load.histogram: %v", r.Get("load"), r.Get("load.histogram"))
+	}
+	if _, err := NewRegisteredSampledGaugeFloat64("load", r, NewGaugeFloat64(), NewHistogram(NewUniformSample(100)), time.Millisecond, 1e3); nil == err {
+		t.Error("no error for a registered name")
+	}
+}
+
+func TestFunctionalGaugeFloat64(t *testing.T) {
+	var counter float64
+	fg := NewFunctionalGaugeFloat64(func() float64 {
+		counter++
+		return counter
+	})
+	fg.Value()
+	fg.Value()
+	if 2 != counter {
+		t.Error("counter != 2")
+	}
+}
+
+func TestGetOrRegisterFunctionalGaugeFloat64(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredFunctionalGaugeFloat64("foo", r, func() float64 { return 47 })
+	if g := GetOrRegisterGaugeFloat64("foo", r); 47 != g.Value() {
+		t.Fatal(g)
+	}
+}
+
+func TestCachedGaugeFloat64(t *testing.T) {
+	var calls float64
+	g := NewCachedGaugeFloat64(time.Hour, func() float64 {
+		calls++
+		return calls
+	})
+	g.Value()
+	if v := g.Value(); 1 != v {
+		t.Errorf("g.Value(): 1 != %v\n", v)
+	}
+}
+
+func TestGetOrRegisterCachedGaugeFloat64(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredCachedGaugeFloat64("foo", r, time.Hour, func() float64 { return 47.0 })
+	if g := GetOrRegisterCachedGaugeFloat64("foo", r, time.Hour, func() float64 { return 0 }); 47.0 != g.Value() {
+		t.Fatal(g)
+	}
+}
@@ -1,6 +1,9 @@
 package metrics
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func BenchmarkGuage(b *testing.B) {
 	g := NewGauge()
@@ -35,3 +38,75 @@ func TestGetOrRegisterGauge(t *testing.T) {
 		t.Fatal(g)
 	}
 }
+
+func TestFunctionalGauge(t *testing.T) {
+	var counter int64
+	fg := NewFunctionalGauge(func() int64 {
+		counter++
+		return counter
+	})
+	fg.Value()
+	fg.Value()
+	if 2 != counter {
+		t.Error("counter != 2")
+	}
+}
+
+func TestFunctionalGaugeSnapshot(t *testing.T) {
+	v := int64(47)
+	g := NewFunctionalGauge(func() int64 { return v })
+	snapshot := g.Snapshot()
+	v = 0
+	if v := snapshot.Value(); 47 != v {
+		t.Errorf("snapshot.Value(): 47 != %v\n", v)
+	}
+	if v := g.Value(); 0 != v {
+		t.Errorf("g.Value(): 0 != %v\n", v)
+	}
+}
+
+func TestGetOrRegisterFunctionalGauge(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredFunctionalGauge("foo", r, func() int64 { return 47 })
+	if g := GetOrRegisterGauge("foo", r); 47 != g.Value() {
+		t.Fatal(g)
+	}
+}
+
+func TestCachedGauge(t *testing.T) {
+	var calls int64
+	g := NewCachedGauge(time.Hour, func() int64 {
+		calls++
+		return calls
+	})
+	if v := g.Value(); 1 != v {
+		t.Errorf("g.Value(): 1 != %v\n", v)
+	}
+	if v := g.Snapshot().Value(); 1 != v {
+		t.Errorf("g.Snapshot().Value(): 1 != %v\n", v)
+	}
+	if 1 != calls {
+		t.Errorf("calls: 1 != %v\n", calls)
+	}
+}
+
+func TestCachedGaugeExpires(t *testing.T) {
+	var calls int64
+	g := NewCachedGauge(time.Millisecond, func() int64 {
+		calls++
+		return calls
+	})
+	g.Value()
+	time.Sleep(2 * time.Millisecond)
+	if v := g.Value(); 2 != v {
+		t.Errorf("g.Value(): 2 != %v\n", v)
+	}
+}
+
+func TestGetOrRegisterCachedGauge(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredCachedGauge("foo", r, time.Hour, func() int64 { return 47 })
+	if g := GetOrRegisterCachedGauge("foo", r, time.Hour, func() int64 { return 0 }); 47 != g.Value() {
+		t.Fatal(g)
+	}
+}
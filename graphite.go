@@ -2,23 +2,46 @@ package metrics
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// GraphiteProtocol is the protocol in which the Graphite exporter sends
+// datapoints to carbon.
+type GraphiteProtocol int
+
+const (
+	// GraphitePlaintext sends a line of "path value timestamp" for each
+	// datapoint, to carbon's line receiver on port 2003.
+	GraphitePlaintext GraphiteProtocol = iota
+
+	// GraphitePickle sends each flush's datapoints as one pickled list, to
+	// carbon's pickle receiver on port 2004, which costs carbon less to
+	// parse.
+	GraphitePickle
+)
+
 // GraphiteConfig provides a container with configuration parameters for
 // the Graphite exporter
 type GraphiteConfig struct {
-	Addr          *net.TCPAddr  // Network address to connect to
-	Registry      Registry      // Registry to be exported
-	FlushInterval time.Duration // Flush interval
-	DurationUnit  time.Duration // Time conversion unit for durations
-	Prefix        string        // Prefix to be prepended to metric names
-	Percentiles   []float64     // Percentiles to export from timers and histograms
+	Addr          *net.TCPAddr     // Network address to connect to
+	Registry      Registry         // Registry to be exported
+	FlushInterval time.Duration    // Flush interval
+	DurationUnit  time.Duration    // Time conversion unit for durations
+	Prefix        string           // Prefix to be prepended to metric names
+	Percentiles   []float64        // Percentiles to export from timers and histograms
+	Protocol      GraphiteProtocol // Protocol to send datapoints in, defaults to GraphitePlaintext
+	ErrorHandler  func(error)      // Called with each flush error, defaults to log.Println
+	Jitter        time.Duration    // Each flush is delayed by a random duration up to this long, see FlushLoopConfig
 }
 
 // Graphite is a blocking exporter function which reports metrics in r
@@ -31,83 +54,202 @@ func Graphite(r Registry, d time.Duration, prefix string, addr *net.TCPAddr) {
 		FlushInterval: d,
 		DurationUnit:  time.Nanosecond,
 		Prefix:        prefix,
-		Percentiles:   []float64{0.5, 0.75, 0.95, 0.99, 0.999},
+		Percentiles:   defaultPercentiles,
 	})
 }
 
 // GraphiteWithConfig is a blocking exporter function just like Graphite,
 // but it takes a GraphiteConfig instead.
 func GraphiteWithConfig(c GraphiteConfig) {
-	log.Printf("WARNING: This go-metrics client has been DEPRECATED! It has been moved to https://github.com/cyberdelia/go-metrics-graphite and will be removed from rcrowley/go-metrics on August 12th 2015")
-	for _ = range time.Tick(c.FlushInterval) {
-		if err := graphite(&c); nil != err {
-			log.Println(err)
-		}
+	if err := GraphiteWithConfigContext(context.Background(), c); nil != err {
+		log.Println(err)
+	}
+}
+
+// GraphiteWithConfigContext is like GraphiteWithConfig but returns once the
+// context is done, after a final flush which is given one FlushInterval to
+// complete and whose error it returns.  Errors from flushes other than the
+// final one are passed to the ErrorHandler.
+func GraphiteWithConfigContext(ctx context.Context, c GraphiteConfig) error {
+	e, err := newGraphiteExporter(c)
+	if nil != err {
+		return err
 	}
+	return FlushLoopWithConfig(ctx, FlushLoopConfig{
+		Registry:      c.Registry,
+		FlushInterval: c.FlushInterval,
+		Exporter:      e,
+		Jitter:        c.Jitter,
+		ErrorHandler:  c.ErrorHandler,
+	})
+}
+
+// NewGraphiteExporter constructs a new Exporter which flushes the registry
+// it's given to Graphite as configured by c, so that it can be run by
+// FlushLoop or be a sink of a FanoutExporter.  c.Registry, which it ignores,
+// needn't be set.
+func NewGraphiteExporter(c GraphiteConfig) (Exporter, error) {
+	if nil == c.Registry {
+		c.Registry = DefaultRegistry
+	}
+	return newGraphiteExporter(c)
 }
 
 // GraphiteOnce performs a single submission to Graphite, returning a
 // non-nil error on failed connections. This can be used in a loop
 // similar to GraphiteWithConfig for custom error handling.
 func GraphiteOnce(c GraphiteConfig) error {
-	log.Printf("WARNING: This go-metrics client has been DEPRECATED! It has been moved to https://github.com/cyberdelia/go-metrics-graphite and will be removed from rcrowley/go-metrics on August 12th 2015")
 	return graphite(&c)
 }
 
+// graphiteExporter holds the configuration of a Graphite exporter.
+type graphiteExporter struct {
+	config GraphiteConfig
+}
+
+func newGraphiteExporter(c GraphiteConfig) (*graphiteExporter, error) {
+	if nil == c.Registry {
+		return nil, errors.New("graphite: config has no Registry to export")
+	}
+	if nil == c.Addr {
+		return nil, errors.New("graphite: config has no Addr to export to")
+	}
+	return &graphiteExporter{config: c}, nil
+}
+
+// Export flushes r, for FlushLoop.
+func (e *graphiteExporter) Export(r Registry) error {
+	return e.ExportContext(context.Background(), r)
+}
+
+// ExportContext flushes r, for FlushLoop.
+func (e *graphiteExporter) ExportContext(ctx context.Context, r Registry) error {
+	e.config.Registry = r
+	return graphiteContext(ctx, &e.config)
+}
+
+// graphiteDatapoint is a value of a series, formatted as the plaintext
+// protocol sends it.
+type graphiteDatapoint struct {
+	path  string
+	text  string
+	value float64
+}
+
 func graphite(c *GraphiteConfig) error {
+	return graphiteContext(context.Background(), c)
+}
+
+// graphiteContext sends every metric in the registry over a new connection,
+// which ctx cancels the dial of.
+func graphiteContext(ctx context.Context, c *GraphiteConfig) error {
 	now := time.Now().Unix()
-	du := float64(c.DurationUnit)
-	conn, err := net.DialTCP("tcp", nil, c.Addr)
+	datapoints := graphiteDatapoints(c)
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.Addr.String())
 	if nil != err {
 		return err
 	}
 	defer conn.Close()
 	w := bufio.NewWriter(conn)
-	c.Registry.Each(func(name string, i interface{}) {
+	if GraphitePickle == c.Protocol {
+		payload := graphitePickle(datapoints, now)
+		var header [4]byte
+		binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+		w.Write(header[:])
+		w.Write(payload)
+	} else {
+		for _, dp := range datapoints {
+			fmt.Fprintf(w, "%s %s %d\n", dp.path, dp.text, now)
+		}
+	}
+	return w.Flush()
+}
+
+// graphiteDatapoints returns the datapoints of every metric in the registry.
+func graphiteDatapoints(c *GraphiteConfig) []graphiteDatapoint {
+	du := float64(c.DurationUnit)
+	var datapoints []graphiteDatapoint
+	add := func(name, key string, v float64, format string, arg interface{}) {
+		datapoints = append(datapoints, graphiteDatapoint{
+			path:  fmt.Sprintf("%s.%s.%s", c.Prefix, name, key),
+			text:  fmt.Sprintf(format, arg),
+			value: v,
+		})
+	}
+	c.Registry.Snapshot().Each(func(name string, i interface{}) {
 		switch metric := i.(type) {
 		case Counter:
-			fmt.Fprintf(w, "%s.%s.count %d %d\n", c.Prefix, name, metric.Count(), now)
+			add(name, "count", float64(metric.Count()), "%d", metric.Count())
 		case Gauge:
-			fmt.Fprintf(w, "%s.%s.value %d %d\n", c.Prefix, name, metric.Value(), now)
+			add(name, "value", float64(metric.Value()), "%d", metric.Value())
 		case GaugeFloat64:
-			fmt.Fprintf(w, "%s.%s.value %f %d\n", c.Prefix, name, metric.Value(), now)
+			add(name, "value", metric.Value(), "%f", metric.Value())
 		case Histogram:
 			h := metric.Snapshot()
 			ps := h.Percentiles(c.Percentiles)
-			fmt.Fprintf(w, "%s.%s.count %d %d\n", c.Prefix, name, h.Count(), now)
-			fmt.Fprintf(w, "%s.%s.min %d %d\n", c.Prefix, name, h.Min(), now)
-			fmt.Fprintf(w, "%s.%s.max %d %d\n", c.Prefix, name, h.Max(), now)
-			fmt.Fprintf(w, "%s.%s.mean %.2f %d\n", c.Prefix, name, h.Mean(), now)
-			fmt.Fprintf(w, "%s.%s.std-dev %.2f %d\n", c.Prefix, name, h.StdDev(), now)
+			add(name, "count", float64(h.Count()), "%d", h.Count())
+			add(name, "min", float64(h.Min()), "%d", h.Min())
+			add(name, "max", float64(h.Max()), "%d", h.Max())
+			add(name, "mean", h.Mean(), "%.2f", h.Mean())
+			add(name, "std-dev", h.StdDev(), "%.2f", h.StdDev())
 			for psIdx, psKey := range c.Percentiles {
 				key := strings.Replace(strconv.FormatFloat(psKey*100.0, 'f', -1, 64), ".", "", 1)
-				fmt.Fprintf(w, "%s.%s.%s-percentile %.2f %d\n", c.Prefix, name, key, ps[psIdx], now)
+				add(name, key+"-percentile", ps[psIdx], "%.2f", ps[psIdx])
 			}
 		case Meter:
 			m := metric.Snapshot()
-			fmt.Fprintf(w, "%s.%s.count %d %d\n", c.Prefix, name, m.Count(), now)
-			fmt.Fprintf(w, "%s.%s.one-minute %.2f %d\n", c.Prefix, name, m.Rate1(), now)
-			fmt.Fprintf(w, "%s.%s.five-minute %.2f %d\n", c.Prefix, name, m.Rate5(), now)
-			fmt.Fprintf(w, "%s.%s.fifteen-minute %.2f %d\n", c.Prefix, name, m.Rate15(), now)
-			fmt.Fprintf(w, "%s.%s.mean %.2f %d\n", c.Prefix, name, m.RateMean(), now)
+			add(name, "count", float64(m.Count()), "%d", m.Count())
+			add(name, "one-minute", m.Rate1(), "%.2f", m.Rate1())
+			add(name, "five-minute", m.Rate5(), "%.2f", m.Rate5())
+			add(name, "fifteen-minute", m.Rate15(), "%.2f", m.Rate15())
+			add(name, "mean", m.RateMean(), "%.2f", m.RateMean())
 		case Timer:
 			t := metric.Snapshot()
 			ps := t.Percentiles(c.Percentiles)
-			fmt.Fprintf(w, "%s.%s.count %d %d\n", c.Prefix, name, t.Count(), now)
-			fmt.Fprintf(w, "%s.%s.min %d %d\n", c.Prefix, name, t.Min()/int64(du), now)
-			fmt.Fprintf(w, "%s.%s.max %d %d\n", c.Prefix, name, t.Max()/int64(du), now)
-			fmt.Fprintf(w, "%s.%s.mean %.2f %d\n", c.Prefix, name, t.Mean()/du, now)
-			fmt.Fprintf(w, "%s.%s.std-dev %.2f %d\n", c.Prefix, name, t.StdDev()/du, now)
+			add(name, "count", float64(t.Count()), "%d", t.Count())
+			add(name, "min", float64(t.Min()/int64(du)), "%d", t.Min()/int64(du))
+			add(name, "max", float64(t.Max()/int64(du)), "%d", t.Max()/int64(du))
+			add(name, "mean", t.Mean()/du, "%.2f", t.Mean()/du)
+			add(name, "std-dev", t.StdDev()/du, "%.2f", t.StdDev()/du)
 			for psIdx, psKey := range c.Percentiles {
 				key := strings.Replace(strconv.FormatFloat(psKey*100.0, 'f', -1, 64), ".", "", 1)
-				fmt.Fprintf(w, "%s.%s.%s-percentile %.2f %d\n", c.Prefix, name, key, ps[psIdx], now)
+				add(name, key+"-percentile", ps[psIdx], "%.2f", ps[psIdx])
 			}
-			fmt.Fprintf(w, "%s.%s.one-minute %.2f %d\n", c.Prefix, name, t.Rate1(), now)
-			fmt.Fprintf(w, "%s.%s.five-minute %.2f %d\n", c.Prefix, name, t.Rate5(), now)
-			fmt.Fprintf(w, "%s.%s.fifteen-minute %.2f %d\n", c.Prefix, name, t.Rate15(), now)
-			fmt.Fprintf(w, "%s.%s.mean-rate %.2f %d\n", c.Prefix, name, t.RateMean(), now)
+			add(name, "one-minute", t.Rate1(), "%.2f", t.Rate1())
+			add(name, "five-minute", t.Rate5(), "%.2f", t.Rate5())
+			add(name, "fifteen-minute", t.Rate15(), "%.2f", t.Rate15())
+			add(name, "mean-rate", t.RateMean(), "%.2f", t.RateMean())
 		}
-		w.Flush()
 	})
-	return nil
+	return datapoints
+}
+
+// graphitePickle encodes the datapoints as carbon's pickle receiver expects
+// them, a list of (path, (timestamp, value)) tuples, in pickle protocol 2.
+func graphitePickle(datapoints []graphiteDatapoint, now int64) []byte {
+	var b bytes.Buffer
+	b.WriteString("\x80\x02](") // PROTO 2, EMPTY_LIST, MARK
+	for _, dp := range datapoints {
+		var n [8]byte
+		b.WriteByte('X') // BINUNICODE
+		binary.LittleEndian.PutUint32(n[:4], uint32(len(dp.path)))
+		b.Write(n[:4])
+		b.WriteString(dp.path)
+		if math.MinInt32 <= now && now <= math.MaxInt32 {
+			b.WriteByte('J') // BININT
+			binary.LittleEndian.PutUint32(n[:4], uint32(now))
+			b.Write(n[:4])
+		} else {
+			b.WriteString("\x8a\x08") // LONG1 of 8 bytes
+			binary.LittleEndian.PutUint64(n[:], uint64(now))
+			b.Write(n[:])
+		}
+		b.WriteByte('G') // BINFLOAT
+		binary.BigEndian.PutUint64(n[:], math.Float64bits(dp.value))
+		b.Write(n[:])
+		b.WriteString("\x86\x86") // TUPLE2 of timestamp and value, TUPLE2 of path and those
+	}
+	b.WriteString("e.") // APPENDS, STOP
+	return b.Bytes()
 }
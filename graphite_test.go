@@ -1,7 +1,14 @@
 package metrics
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
 	"net"
+	"strings"
+	"testing"
 	"time"
 )
 
@@ -20,3 +27,111 @@ func ExampleGraphiteWithConfig() {
 		Percentiles:   []float64{0.5, 0.75, 0.99, 0.999},
 	})
 }
+
+// graphiteOnce runs GraphiteOnce against a listener and returns what it
+// received.
+func graphiteOnce(t *testing.T, c GraphiteConfig) []byte {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan []byte)
+	go func() {
+		conn, err := ln.Accept()
+		if nil != err {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		b, _ := ioutil.ReadAll(conn)
+		received <- b
+	}()
+	c.Addr = ln.Addr().(*net.TCPAddr)
+	if err := GraphiteOnce(c); nil != err {
+		t.Fatal(err)
+	}
+	return <-received
+}
+
+func TestGraphitePlaintext(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredCounter("foo", r).Inc(47)
+	NewRegisteredGaugeFloat64("bar", r).Update(1.5)
+	b := graphiteOnce(t, GraphiteConfig{Registry: r, DurationUnit: time.Nanosecond, Prefix: "p"})
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if 2 != len(lines) {
+		t.Fatalf("lines: %q", lines)
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if 3 != len(fields) {
+			t.Fatalf("line: %q", line)
+		}
+		if s := fields[0] + " " + fields[1]; "p.foo.count 47" != s && "p.bar.value 1.500000" != s {
+			t.Errorf("line: %q", line)
+		}
+	}
+}
+
+func TestGraphitePickle(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredCounter("foo", r).Inc(47)
+	b := graphiteOnce(t, GraphiteConfig{Registry: r, DurationUnit: time.Nanosecond, Prefix: "p", Protocol: GraphitePickle})
+	if len(b) < 4 || int(binary.BigEndian.Uint32(b)) != len(b)-4 {
+		t.Fatalf("header of %d bytes: % x", len(b), b)
+	}
+	payload := b[4:]
+	var want bytes.Buffer
+	want.WriteString("\x80\x02](X\x0b\x00\x00\x00p.foo.countJ")
+	want.Write(payload[len(want.Bytes()) : len(want.Bytes())+4]) // The timestamp
+	want.WriteByte('G')
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], math.Float64bits(47))
+	want.Write(v[:])
+	want.WriteString("\x86\x86e.")
+	if !bytes.Equal(want.Bytes(), payload) {
+		t.Errorf("payload:\n% x\n% x", want.Bytes(), payload)
+	}
+}
+
+func TestGraphitePickleLongTimestamp(t *testing.T) {
+	b := graphitePickle([]graphiteDatapoint{{path: "a", value: 1}}, 1<<32)
+	if !bytes.Contains(b, []byte("\x8a\x08\x00\x00\x00\x00\x01\x00\x00\x00")) {
+		t.Errorf("% x", b)
+	}
+}
+
+func TestGraphiteWithConfigContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan []byte)
+	go func() {
+		conn, err := ln.Accept()
+		if nil != err {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		b, _ := ioutil.ReadAll(conn)
+		received <- b
+	}()
+	r := NewRegistry()
+	NewRegisteredCounter("foo", r).Inc(47)
+	c := GraphiteConfig{Addr: ln.Addr().(*net.TCPAddr), Registry: r, DurationUnit: time.Nanosecond, Prefix: "p"}
+	if err := GraphiteWithConfigContext(context.Background(), c); nil == err {
+		t.Error("no error for a zero FlushInterval")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.FlushInterval = time.Hour
+	if err := GraphiteWithConfigContext(ctx, c); nil != err {
+		t.Fatal(err)
+	}
+	if b := <-received; !bytes.HasPrefix(b, []byte("p.foo.count 47 ")) {
+		t.Errorf("final flush: %q", b)
+	}
+}
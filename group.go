@@ -0,0 +1,86 @@
+package metrics
+
+import "time"
+
+// Group registers metrics in a Registry under a common base name, which is
+// joined to the name of each metric with a dot.  Groups nest, so
+//
+//	g := NewGroup(r, "http")
+//	g.Group("client").Counter("requests")
+//
+// returns the Counter registered in r as "http.client.requests".  Each method
+// returns the metric already registered under the full name if there is one.
+type Group struct {
+	registry Registry
+	name     string
+}
+
+// NewGroup constructs a new Group registering into r, or DefaultRegistry if
+// r is nil.
+func NewGroup(r Registry, name string) *Group {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return &Group{registry: r, name: name}
+}
+
+// Group returns a Group nested within this one.
+func (g *Group) Group(name string) *Group {
+	return &Group{registry: g.registry, name: g.Name(name)}
+}
+
+// Name returns the full name of the metric by the given name in this group.
+func (g *Group) Name(name string) string {
+	return g.name + "." + name
+}
+
+// BucketedHistogram returns the BucketedHistogram by the given name in this
+// group.
+func (g *Group) BucketedHistogram(name string, s Sample, bounds []int64) BucketedHistogram {
+	return GetOrRegisterBucketedHistogram(g.Name(name), g.registry, s, bounds)
+}
+
+// Counter returns the Counter by the given name in this group.
+func (g *Group) Counter(name string) Counter {
+	return GetOrRegisterCounter(g.Name(name), g.registry)
+}
+
+// Gauge returns the Gauge by the given name in this group.
+func (g *Group) Gauge(name string) Gauge {
+	return GetOrRegisterGauge(g.Name(name), g.registry)
+}
+
+// GaugeFloat64 returns the GaugeFloat64 by the given name in this group.
+func (g *Group) GaugeFloat64(name string) GaugeFloat64 {
+	return GetOrRegisterGaugeFloat64(g.Name(name), g.registry)
+}
+
+// Healthcheck returns the Healthcheck by the given name in this group.
+func (g *Group) Healthcheck(name string, f func(Healthcheck)) Healthcheck {
+	return GetOrRegisterHealthcheck(g.Name(name), g.registry, f)
+}
+
+// Histogram returns the Histogram by the given name in this group.
+func (g *Group) Histogram(name string, s Sample) Histogram {
+	return GetOrRegisterHistogram(g.Name(name), g.registry, s)
+}
+
+// Meter returns the Meter by the given name in this group.
+func (g *Group) Meter(name string) Meter {
+	return GetOrRegisterMeter(g.Name(name), g.registry)
+}
+
+// Timer returns the Timer by the given name in this group.
+func (g *Group) Timer(name string) Timer {
+	return GetOrRegisterTimer(g.Name(name), g.registry)
+}
+
+// TopNHistogram returns the TopNHistogram by the given name in this group.
+func (g *Group) TopNHistogram(name string, s Sample, n int) TopNHistogram {
+	return GetOrRegisterTopNHistogram(g.Name(name), g.registry, s, n)
+}
+
+// TopK returns the TopK by the given name in this group.
+func (g *Group) TopK(name string, k int, window time.Duration) TopK {
+	return GetOrRegisterTopK(g.Name(name), g.registry, k, window)
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import "testing"
+
+func TestGroup(t *testing.T) {
+	r := NewRegistry()
+	g := NewGroup(r, "http")
+	g.Counter("requests").Inc(47)
+	if c, ok := r.Get("http.requests").(Counter); !ok || 47 != c.Count() {
+		t.Fatal(r.Get("http.requests"))
+	}
+	if c := g.Counter("requests"); 47 != c.Count() {
+		t.Fatal(c)
+	}
+}
+
+func TestGroupNested(t *testing.T) {
+	r := NewRegistry()
+	g := NewGroup(r, "http").Group("client")
+	g.Timer("latency")
+	if _, ok := r.Get("http.client.latency").(Timer); !ok {
+		t.Fatal(r.Get("http.client.latency"))
+	}
+	if name := g.Name("latency"); "http.client.latency" != name {
+		t.Fatal(name)
+	}
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"sort"
+	"time"
+)
+
+// GRPCClient delivers a Batch message, encoded as described by
+// grpc/metrics.proto, to a collector.  The grpc subpackage implements it over
+// a gRPC connection and tests may substitute their own.
+type GRPCClient interface {
+	Put(ctx context.Context, batch []byte) error
+}
+
+// GRPCTransport is a Transport which marshals the datapoints of each flush
+// into a single protobuf Batch message and sends it using Client.
+type GRPCTransport struct {
+	Client  GRPCClient    // Client used to reach the collector
+	Timeout time.Duration // Deadline for each flush, no deadline if zero
+}
+
+// Send marshals and sends points as one Batch.
+func (t *GRPCTransport) Send(ctx context.Context, points []Datapoint) error {
+	if 0 < t.Timeout {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+	return t.Client.Put(ctx, MarshalDatapoints(points))
+}
+
+// MarshalDatapoints encodes points as a protobuf Batch message.  Tags are
+// encoded in key order so that the encoding is deterministic.
+func MarshalDatapoints(points []Datapoint) []byte {
+	var b, p []byte
+	for _, point := range points {
+		p = marshalDatapoint(p[:0], point)
+		b = appendBytesField(b, 1, p)
+	}
+	return b
+}
+
+func marshalDatapoint(b []byte, p Datapoint) []byte {
+	b = appendBytesField(b, 1, []byte(p.Metric))
+	b = appendVarintField(b, 2, uint64(p.Timestamp))
+	b = appendTag(b, 3, 1)
+	b = appendFixed64(b, math.Float64bits(p.Value))
+	keys := make([]string, 0, len(p.Tags))
+	for k := range p.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var entry []byte
+	for _, k := range keys {
+		entry = appendBytesField(entry[:0], 1, []byte(k))
+		entry = appendBytesField(entry, 2, []byte(p.Tags[k]))
+		b = appendBytesField(b, 4, entry)
+	}
+	if KindUnknown != p.Kind {
+		b = appendVarintField(b, 5, uint64(p.Kind))
+	}
+	return b
+}
+
+// appendTag appends the key of field number n with the given wire type.
+func appendTag(b []byte, n int, wireType uint64) []byte {
+	return appendVarint(b, uint64(n)<<3|wireType)
+}
+
+func appendBytesField(b []byte, n int, v []byte) []byte {
+	b = appendTag(b, n, 2)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendVarintField(b []byte, n int, v uint64) []byte {
+	return appendVarint(appendTag(b, n, 0), v)
+}
+
+func appendFixed64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
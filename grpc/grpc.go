@@ -0,0 +1,67 @@
+// Package grpc implements metrics.GRPCClient over a gRPC connection to a
+// collector serving the Collector service in metrics.proto.
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/rcrowley/go-metrics"
+	grpcgo "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Method is the full name of the RPC which receives each Batch.
+const Method = "/metrics.Collector/Put"
+
+// Config provides a container with configuration parameters for the
+// connection to the collector.
+type Config struct {
+	Endpoint  string      // Address of the collector
+	TLSConfig *tls.Config // TLS configuration, plaintext if nil
+}
+
+// Client is a metrics.GRPCClient backed by a gRPC connection.
+type Client struct {
+	conn *grpcgo.ClientConn
+}
+
+var _ metrics.GRPCClient = (*Client)(nil)
+
+// Dial connects to the collector described by c.
+func Dial(c Config) (*Client, error) {
+	opt := grpcgo.WithInsecure()
+	if nil != c.TLSConfig {
+		opt = grpcgo.WithTransportCredentials(credentials.NewTLS(c.TLSConfig))
+	}
+	conn, err := grpcgo.Dial(c.Endpoint, opt)
+	if nil != err {
+		return nil, err
+	}
+	return &Client{conn: conn}, nil
+}
+
+// Close closes the connection to the collector.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Put sends a Batch which has already been marshaled by the metrics package.
+func (c *Client) Put(ctx context.Context, batch []byte) error {
+	var reply []byte
+	return c.conn.Invoke(ctx, Method, batch, &reply, grpcgo.ForceCodec(rawCodec{}))
+}
+
+// rawCodec passes already-marshaled messages through unchanged.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	return v.([]byte), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*[]byte) = append((*v.(*[]byte))[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string { return "proto" }
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+type fakeGRPCClient struct {
+	batch    []byte
+	deadline bool
+}
+
+func (c *fakeGRPCClient) Put(ctx context.Context, batch []byte) error {
+	c.batch = batch
+	_, c.deadline = ctx.Deadline()
+	return nil
+}
+
+func TestMarshalDatapoints(t *testing.T) {
+	b := MarshalDatapoints([]Datapoint{{
+		Metric:    "a",
+		Timestamp: 1,
+		Tags:      map[string]string{"k": "v"},
+		Metadata:  Metadata{Kind: KindGauge},
+	}})
+	want := []byte{
+		0x0a, 0x18, // Batch.datapoints
+		0x0a, 0x01, 'a', // metric
+		0x10, 0x01, // timestamp
+		0x19, 0, 0, 0, 0, 0, 0, 0, 0, // value
+		0x22, 0x06, 0x0a, 0x01, 'k', 0x12, 0x01, 'v', // tags
+		0x28, 0x02, // kind
+	}
+	if !bytes.Equal(want, b) {
+		t.Fatalf("% x != % x", want, b)
+	}
+}
+
+func TestGRPCTransport(t *testing.T) {
+	client := &fakeGRPCClient{}
+	points := []Datapoint{{Metric: "foo", Timestamp: 47, Value: 1.5}}
+	tr := &GRPCTransport{Client: client, Timeout: time.Second}
+	if err := tr.Send(context.Background(), points); nil != err {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(MarshalDatapoints(points), client.batch) {
+		t.Fatal(client.batch)
+	}
+	if !client.deadline {
+		t.Fatal("no deadline")
+	}
+}
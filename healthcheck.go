@@ -1,5 +1,11 @@
 package metrics
 
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
 // Healthchecks hold an error value describing an arbitrary up/down status.
 type Healthcheck interface {
 	Check()
@@ -8,13 +14,56 @@ type Healthcheck interface {
 	Unhealthy(error)
 }
 
+// GetOrRegisterHealthcheck returns an existing Healthcheck or constructs and
+// registers a new StandardHealthcheck.
+func GetOrRegisterHealthcheck(name string, r Registry, f func(Healthcheck)) Healthcheck {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return r.GetOrRegister(name, func() Healthcheck { return NewHealthcheck(f) }).(Healthcheck)
+}
+
+// GetOrRegisterFunctionalHealthcheck returns an existing Healthcheck or
+// constructs and registers a new StandardHealthcheck which checks f.
+func GetOrRegisterFunctionalHealthcheck(name string, r Registry, f func() error) Healthcheck {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return r.GetOrRegister(name, func() Healthcheck { return NewFunctionalHealthcheck(f) }).(Healthcheck)
+}
+
+// NewFunctionalHealthcheck constructs a new Healthcheck which is healthy
+// when f returns nil and unhealthy with its error otherwise.
+func NewFunctionalHealthcheck(f func() error) Healthcheck {
+	return NewHealthcheck(func(h Healthcheck) {
+		if err := f(); nil != err {
+			h.Unhealthy(err)
+		} else {
+			h.Healthy()
+		}
+	})
+}
+
+// NewRegisteredFunctionalHealthcheck constructs and registers a new
+// StandardHealthcheck which checks f, or returns the Healthcheck already
+// registered under the given name.
+func NewRegisteredFunctionalHealthcheck(name string, r Registry, f func() error) Healthcheck {
+	return GetOrRegisterFunctionalHealthcheck(name, r, f)
+}
+
 // NewHealthcheck constructs a new Healthcheck which will use the given
 // function to update its status.
 func NewHealthcheck(f func(Healthcheck)) Healthcheck {
 	if UseNilMetrics {
 		return NilHealthcheck{}
 	}
-	return &StandardHealthcheck{nil, f}
+	return &StandardHealthcheck{f: f}
+}
+
+// NewRegisteredHealthcheck constructs and registers a new StandardHealthcheck,
+// or returns the Healthcheck already registered under the given name.
+func NewRegisteredHealthcheck(name string, r Registry, f func(Healthcheck)) Healthcheck {
+	return GetOrRegisterHealthcheck(name, r, f)
 }
 
 // NilHealthcheck is a no-op.
@@ -35,8 +84,9 @@ func (NilHealthcheck) Unhealthy(error) {}
 // StandardHealthcheck is the standard implementation of a Healthcheck and
 // stores the status and a function to call to update the status.
 type StandardHealthcheck struct {
-	err error
-	f   func(Healthcheck)
+	err   error
+	f     func(Healthcheck)
+	mutex sync.Mutex
 }
 
 // Check runs the healthcheck function to update the healthcheck's status.
@@ -46,16 +96,51 @@ func (h *StandardHealthcheck) Check() {
 
 // Error returns the healthcheck's status, which will be nil if it is healthy.
 func (h *StandardHealthcheck) Error() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
 	return h.err
 }
 
 // Healthy marks the healthcheck as healthy.
 func (h *StandardHealthcheck) Healthy() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
 	h.err = nil
 }
 
 // Unhealthy marks the healthcheck as unhealthy.  The error is stored and
 // may be retrieved by the Error method.
 func (h *StandardHealthcheck) Unhealthy(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
 	h.err = err
 }
+
+// HealthcheckHandler returns an http.Handler which runs every healthcheck in
+// r and serves a JSON object mapping each one's name to its error, or null if
+// it is healthy, with status 200 if all are healthy and 503 otherwise, for
+// load balancers and orchestrators to probe.
+func HealthcheckHandler(r Registry) http.Handler {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.RunHealthchecks()
+		status := http.StatusOK
+		errs := make(map[string]interface{})
+		r.Each(func(name string, i interface{}) {
+			h, ok := i.(Healthcheck)
+			if !ok {
+				return
+			}
+			errs[name] = nil
+			if err := h.Error(); nil != err {
+				errs[name] = err.Error()
+				status = http.StatusServiceUnavailable
+			}
+		})
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(errs)
+	})
+}
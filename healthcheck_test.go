@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFunctionalHealthcheck(t *testing.T) {
+	var err error
+	h := NewFunctionalHealthcheck(func() error { return err })
+	h.Check()
+	if nil != h.Error() {
+		t.Error(h.Error())
+	}
+	err = errors.New("down")
+	h.Check()
+	if err != h.Error() {
+		t.Errorf("h.Error(): %v", h.Error())
+	}
+}
+
+func TestHealthcheckHandler(t *testing.T) {
+	r := NewRegistry()
+	var err error
+	NewRegisteredFunctionalHealthcheck("db", r, func() error { return err })
+	NewRegisteredFunctionalHealthcheck("cache", r, func() error { return nil })
+	NewRegisteredCounter("requests", r)
+	for _, e := range []error{nil, errors.New("down")} {
+		err = e
+		w := httptest.NewRecorder()
+		HealthcheckHandler(r).ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+		var errs map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &errs); nil != err {
+			t.Fatal(err)
+		}
+		if 2 != len(errs) || nil != errs["cache"] {
+			t.Errorf("errs: %v", errs)
+		}
+		if nil == e && (200 != w.Code || nil != errs["db"]) {
+			t.Errorf("%v %v", w.Code, errs)
+		}
+		if nil != e && (503 != w.Code || "down" != errs["db"]) {
+			t.Errorf("%v %v", w.Code, errs)
+		}
+	}
+}
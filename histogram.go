@@ -1,11 +1,18 @@
 package metrics
 
+import (
+	"sort"
+	"time"
+)
+
 // Histograms calculate distribution statistics from a series of int64 values.
 type Histogram interface {
 	Clear()
 	Count() int64
+	Exemplars() []Exemplar
 	Max() int64
 	Mean() float64
+	Merge(Histogram)
 	Min() int64
 	Percentile(float64) float64
 	Percentiles([]float64) []float64
@@ -14,6 +21,7 @@ type Histogram interface {
 	StdDev() float64
 	Sum() int64
 	Update(int64)
+	UpdateWithExemplar(int64, string)
 	Variance() float64
 }
 
@@ -35,19 +43,35 @@ func NewHistogram(s Sample) Histogram {
 }
 
 // NewRegisteredHistogram constructs and registers a new StandardHistogram from
-// a Sample.
+// a Sample, or returns the Histogram already registered under the given name.
 func NewRegisteredHistogram(name string, r Registry, s Sample) Histogram {
-	c := NewHistogram(s)
-	if nil == r {
-		r = DefaultRegistry
+	return GetOrRegisterHistogram(name, r, s)
+}
+
+// MergeHistograms returns a read-only Histogram of the values of every given
+// histogram, as though they'd been recorded by one, so that an aggregator
+// can export accurate percentiles of several shards or instances rather than
+// averages of theirs.  Their samples are merged by MergeSamples, and the most
+// recent of their exemplars kept.
+func MergeHistograms(hs ...Histogram) Histogram {
+	samples := make([]Sample, len(hs))
+	var exemplars []Exemplar
+	for i, h := range hs {
+		h = readSnapshot(h)
+		samples[i] = h.Sample()
+		exemplars = append(exemplars, h.Exemplars()...)
 	}
-	r.Register(name, c)
-	return c
+	sort.Stable(exemplarsByTime(exemplars))
+	if maxExemplars < len(exemplars) {
+		exemplars = exemplars[len(exemplars)-maxExemplars:]
+	}
+	return &HistogramSnapshot{sample: MergeSamples(samples...), exemplars: exemplars}
 }
 
 // HistogramSnapshot is a read-only copy of another Histogram.
 type HistogramSnapshot struct {
-	sample *SampleSnapshot
+	sample    Sample
+	exemplars []Exemplar
 }
 
 // Clear panics.
@@ -59,6 +83,14 @@ func (*HistogramSnapshot) Clear() {
 // taken.
 func (h *HistogramSnapshot) Count() int64 { return h.sample.Count() }
 
+// Exemplars returns the most recent exemplars, oldest first, at the time the
+// snapshot was taken.
+func (h *HistogramSnapshot) Exemplars() []Exemplar {
+	exemplars := make([]Exemplar, len(h.exemplars))
+	copy(exemplars, h.exemplars)
+	return exemplars
+}
+
 // Max returns the maximum value in the sample at the time the snapshot was
 // taken.
 func (h *HistogramSnapshot) Max() int64 { return h.sample.Max() }
@@ -67,6 +99,11 @@ func (h *HistogramSnapshot) Max() int64 { return h.sample.Max() }
 // was taken.
 func (h *HistogramSnapshot) Mean() float64 { return h.sample.Mean() }
 
+// Merge panics.
+func (*HistogramSnapshot) Merge(Histogram) {
+	panic("Merge called on a HistogramSnapshot")
+}
+
 // Min returns the minimum value in the sample at the time the snapshot was
 // taken.
 func (h *HistogramSnapshot) Min() int64 { return h.sample.Min() }
@@ -101,6 +138,11 @@ func (*HistogramSnapshot) Update(int64) {
 	panic("Update called on a HistogramSnapshot")
 }
 
+// UpdateWithExemplar panics.
+func (*HistogramSnapshot) UpdateWithExemplar(int64, string) {
+	panic("UpdateWithExemplar called on a HistogramSnapshot")
+}
+
 // Variance returns the variance of inputs at the time the snapshot was taken.
 func (h *HistogramSnapshot) Variance() float64 { return h.sample.Variance() }
 
@@ -113,12 +155,18 @@ func (NilHistogram) Clear() {}
 // Count is a no-op.
 func (NilHistogram) Count() int64 { return 0 }
 
+// Exemplars is a no-op.
+func (NilHistogram) Exemplars() []Exemplar { return []Exemplar{} }
+
 // Max is a no-op.
 func (NilHistogram) Max() int64 { return 0 }
 
 // Mean is a no-op.
 func (NilHistogram) Mean() float64 { return 0.0 }
 
+// Merge is a no-op.
+func (NilHistogram) Merge(Histogram) {}
+
 // Min is a no-op.
 func (NilHistogram) Min() int64 { return 0 }
 
@@ -145,28 +193,57 @@ func (NilHistogram) Sum() int64 { return 0 }
 // Update is a no-op.
 func (NilHistogram) Update(v int64) {}
 
+// UpdateWithExemplar is a no-op.
+func (NilHistogram) UpdateWithExemplar(int64, string) {}
+
 // Variance is a no-op.
 func (NilHistogram) Variance() float64 { return 0.0 }
 
 // StandardHistogram is the standard implementation of a Histogram and uses a
 // Sample to bound its memory use.
 type StandardHistogram struct {
-	sample Sample
+	sample    Sample
+	exemplars exemplarRing
 }
 
-// Clear clears the histogram and its sample.
-func (h *StandardHistogram) Clear() { h.sample.Clear() }
+// Clear clears the histogram, its sample and its exemplars.
+func (h *StandardHistogram) Clear() {
+	h.sample.Clear()
+	h.exemplars.clear()
+}
+
+// SnapshotAndClear returns a read-only copy of the histogram and clears it,
+// as one operation if its Sample has a SnapshotAndClear method, as
+// UniformSample and ExpDecaySample do.  Otherwise values recorded between
+// reading and clearing the sample are lost.
+func (h *StandardHistogram) SnapshotAndClear() Histogram {
+	return &HistogramSnapshot{sample: sampleSnapshotAndClear(h.sample), exemplars: h.exemplars.listAndClear()}
+}
 
 // Count returns the number of samples recorded since the histogram was last
 // cleared.
 func (h *StandardHistogram) Count() int64 { return h.sample.Count() }
 
+// Exemplars returns the most recent exemplars, oldest first.
+func (h *StandardHistogram) Exemplars() []Exemplar { return h.exemplars.list() }
+
 // Max returns the maximum value in the sample.
 func (h *StandardHistogram) Max() int64 { return h.sample.Max() }
 
 // Mean returns the mean of the values in the sample.
 func (h *StandardHistogram) Mean() float64 { return h.sample.Mean() }
 
+// Merge absorbs the values and exemplars of another histogram, merging its
+// sample into this one's if it's a MergeableSample and updating it with each
+// of their values otherwise.
+func (h *StandardHistogram) Merge(other Histogram) {
+	other = readSnapshot(other)
+	mergeSample(h.sample, other.Sample())
+	for _, e := range other.Exemplars() {
+		h.exemplars.add(e)
+	}
+}
+
 // Min returns the minimum value in the sample.
 func (h *StandardHistogram) Min() int64 { return h.sample.Min() }
 
@@ -186,7 +263,7 @@ func (h *StandardHistogram) Sample() Sample { return h.sample }
 
 // Snapshot returns a read-only copy of the histogram.
 func (h *StandardHistogram) Snapshot() Histogram {
-	return &HistogramSnapshot{sample: h.sample.Snapshot().(*SampleSnapshot)}
+	return &HistogramSnapshot{sample: h.sample.Snapshot(), exemplars: h.exemplars.list()}
 }
 
 // StdDev returns the standard deviation of the values in the sample.
@@ -198,5 +275,51 @@ func (h *StandardHistogram) Sum() int64 { return h.sample.Sum() }
 // Update samples a new value.
 func (h *StandardHistogram) Update(v int64) { h.sample.Update(v) }
 
+// UpdateWithExemplar samples a new value and keeps it as an exemplar of the
+// trace by the given ID, in place of the oldest if there are already
+// maxExemplars.
+func (h *StandardHistogram) UpdateWithExemplar(v int64, traceID string) {
+	h.sample.Update(v)
+	h.exemplars.add(Exemplar{Value: v, TraceID: traceID, Time: time.Now()})
+}
+
 // Variance returns the variance of the values in the sample.
 func (h *StandardHistogram) Variance() float64 { return h.sample.Variance() }
+
+// snapshotAndClear returns a read-only copy of h and clears it, as one
+// operation if h has a SnapshotAndClear method.
+func snapshotAndClear(h Histogram) Histogram {
+	if sc, ok := h.(interface {
+		SnapshotAndClear() Histogram
+	}); ok {
+		return sc.SnapshotAndClear()
+	}
+	snapshot := h.Snapshot()
+	h.Clear()
+	return snapshot
+}
+
+// readSnapshot returns a read-only copy of h without the side effects of its
+// Snapshot, such as a TopNHistogram's starting to retain its largest values
+// afresh, if h can be read without them.
+func readSnapshot(h Histogram) Histogram {
+	if p, ok := h.(interface {
+		peek() Histogram
+	}); ok {
+		return p.peek()
+	}
+	return h.Snapshot()
+}
+
+// sampleSnapshotAndClear returns a read-only copy of s and clears it, as one
+// operation if s has a SnapshotAndClear method.
+func sampleSnapshotAndClear(s Sample) Sample {
+	if sc, ok := s.(interface {
+		SnapshotAndClear() Sample
+	}); ok {
+		return sc.SnapshotAndClear()
+	}
+	snapshot := s.Snapshot()
+	s.Clear()
+	return snapshot
+}
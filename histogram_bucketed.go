@@ -0,0 +1,216 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// BucketedHistograms are Histograms which additionally count the values
+// recorded at or below each of a set of bounds, as Prometheus histograms do.
+// Unlike percentiles, bucket counts can be summed across hosts, and are
+// exported as a series per bound, such as name.le_100.
+type BucketedHistogram interface {
+	Histogram
+	Bounds() []int64
+	BucketCounts() []int64
+}
+
+// GetOrRegisterBucketedHistogram returns an existing BucketedHistogram or
+// constructs and registers a new StandardBucketedHistogram.
+func GetOrRegisterBucketedHistogram(name string, r Registry, s Sample, bounds []int64) BucketedHistogram {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return r.GetOrRegister(name, func() BucketedHistogram { return NewBucketedHistogram(s, bounds) }).(BucketedHistogram)
+}
+
+// NewBucketedHistogram constructs a new StandardBucketedHistogram from a
+// Sample which counts the values at or below each of the given bounds.
+func NewBucketedHistogram(s Sample, bounds []int64) BucketedHistogram {
+	if UseNilMetrics {
+		return NilBucketedHistogram{}
+	}
+	sorted := make([]int64, len(bounds))
+	copy(sorted, bounds)
+	sort.Sort(int64Slice(sorted))
+	return &StandardBucketedHistogram{
+		StandardHistogram: StandardHistogram{sample: s},
+		bounds:            sorted,
+		counts:            make([]int64, len(sorted)),
+	}
+}
+
+// NewRegisteredBucketedHistogram constructs and registers a new
+// StandardBucketedHistogram, or returns the BucketedHistogram already
+// registered under the given name.
+func NewRegisteredBucketedHistogram(name string, r Registry, s Sample, bounds []int64) BucketedHistogram {
+	return GetOrRegisterBucketedHistogram(name, r, s, bounds)
+}
+
+// BucketedHistogramSnapshot is a read-only copy of another BucketedHistogram.
+type BucketedHistogramSnapshot struct {
+	*HistogramSnapshot
+	bounds []int64
+	counts []int64
+}
+
+// Snapshot returns the snapshot.
+func (h *BucketedHistogramSnapshot) Snapshot() Histogram { return h }
+
+// Bounds returns the upper bounds of the buckets, in ascending order.
+func (h *BucketedHistogramSnapshot) Bounds() []int64 {
+	bounds := make([]int64, len(h.bounds))
+	copy(bounds, h.bounds)
+	return bounds
+}
+
+// BucketCounts returns the number of values at or below each bound at the
+// time the snapshot was taken.
+func (h *BucketedHistogramSnapshot) BucketCounts() []int64 {
+	counts := make([]int64, len(h.counts))
+	copy(counts, h.counts)
+	return counts
+}
+
+// NilBucketedHistogram is a no-op BucketedHistogram.
+type NilBucketedHistogram struct {
+	NilHistogram
+}
+
+// Snapshot is a no-op.
+func (NilBucketedHistogram) Snapshot() Histogram { return NilBucketedHistogram{} }
+
+// Bounds is a no-op.
+func (NilBucketedHistogram) Bounds() []int64 { return []int64{} }
+
+// BucketCounts is a no-op.
+func (NilBucketedHistogram) BucketCounts() []int64 { return []int64{} }
+
+// StandardBucketedHistogram is the standard implementation of a
+// BucketedHistogram.  Its buckets count every value recorded since it was
+// last cleared, however few of them its Sample retains.
+type StandardBucketedHistogram struct {
+	StandardHistogram
+	mutex  sync.Mutex
+	bounds []int64
+	counts []int64 // Values in each bucket alone, not those below it
+}
+
+// Clear clears the histogram, its sample and its buckets.
+func (h *StandardBucketedHistogram) Clear() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.StandardHistogram.Clear()
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+}
+
+// SnapshotAndClear returns a read-only copy of the histogram and clears it
+// as one operation, as StandardHistogram.SnapshotAndClear does.
+func (h *StandardBucketedHistogram) SnapshotAndClear() Histogram {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	snapshot := &BucketedHistogramSnapshot{
+		HistogramSnapshot: h.StandardHistogram.SnapshotAndClear().(*HistogramSnapshot),
+		bounds:            h.bounds,
+		counts:            h.cumulativeCounts(),
+	}
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	return snapshot
+}
+
+// Bounds returns the upper bounds of the buckets, in ascending order.
+func (h *StandardBucketedHistogram) Bounds() []int64 {
+	bounds := make([]int64, len(h.bounds))
+	copy(bounds, h.bounds)
+	return bounds
+}
+
+// BucketCounts returns the number of values at or below each bound.
+func (h *StandardBucketedHistogram) BucketCounts() []int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.cumulativeCounts()
+}
+
+// Merge absorbs the values, buckets and exemplars of another histogram.  The
+// bucket counts of a BucketedHistogram with the same bounds are added, and
+// otherwise those of its sample's values, in proportion to its count.
+func (h *StandardBucketedHistogram) Merge(other Histogram) {
+	other = readSnapshot(other)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.StandardHistogram.Merge(other)
+	if b, ok := other.(BucketedHistogram); ok && equalBounds(h.bounds, b.Bounds()) {
+		var below int64
+		for i, c := range b.BucketCounts() {
+			h.counts[i] += c - below
+			below = c
+		}
+		return
+	}
+	values, count := other.Sample().Values(), other.Count()
+	for i, v := range values {
+		if j := sort.Search(len(h.bounds), func(j int) bool { return v <= h.bounds[j] }); j < len(h.bounds) {
+			h.counts[j] += weight(count, len(values), i)
+		}
+	}
+}
+
+// Snapshot returns a read-only copy of the histogram.
+func (h *StandardBucketedHistogram) Snapshot() Histogram {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return &BucketedHistogramSnapshot{
+		HistogramSnapshot: h.StandardHistogram.Snapshot().(*HistogramSnapshot),
+		bounds:            h.bounds,
+		counts:            h.cumulativeCounts(),
+	}
+}
+
+// Update samples a new value and counts it in the first bucket whose bound
+// it doesn't exceed, if any.
+func (h *StandardBucketedHistogram) Update(v int64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.StandardHistogram.Update(v)
+	if i := sort.Search(len(h.bounds), func(i int) bool { return v <= h.bounds[i] }); i < len(h.bounds) {
+		h.counts[i]++
+	}
+}
+
+// UpdateWithExemplar counts a new value as Update does and keeps it as an
+// exemplar of the trace by the given ID.
+func (h *StandardBucketedHistogram) UpdateWithExemplar(v int64, traceID string) {
+	h.Update(v)
+	h.exemplars.add(Exemplar{Value: v, TraceID: traceID, Time: time.Now()})
+}
+
+// equalBounds reports whether a and b are the same bounds.
+func equalBounds(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// cumulativeCounts returns the number of values at or below each bound.  It
+// should run with h.mutex held.
+func (h *StandardBucketedHistogram) cumulativeCounts() []int64 {
+	counts := make([]int64, len(h.counts))
+	var n int64
+	for i, c := range h.counts {
+		n += c
+		counts[i] = n
+	}
+	return counts
+}
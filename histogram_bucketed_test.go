@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetOrRegisterBucketedHistogram(t *testing.T) {
+	r := NewRegistry()
+	s := NewUniformSample(100)
+	NewRegisteredBucketedHistogram("foo", r, s, []int64{10}).Update(47)
+	if h := GetOrRegisterBucketedHistogram("foo", r, s, []int64{10}); 1 != h.Count() {
+		t.Fatal(h)
+	}
+}
+
+func TestBucketedHistogram(t *testing.T) {
+	h := NewBucketedHistogram(NewUniformSample(2), []int64{500, 100})
+	for _, v := range []int64{1, 100, 101, 500, 1000} {
+		h.Update(v)
+	}
+	if bounds := h.Bounds(); !reflect.DeepEqual([]int64{100, 500}, bounds) {
+		t.Errorf("bounds: %v", bounds)
+	}
+	if counts := h.BucketCounts(); !reflect.DeepEqual([]int64{2, 4}, counts) {
+		t.Errorf("counts: %v", counts)
+	}
+	snapshot := h.Snapshot().(BucketedHistogram)
+	h.Clear()
+	if counts := h.BucketCounts(); !reflect.DeepEqual([]int64{0, 0}, counts) {
+		t.Errorf("counts after clearing: %v", counts)
+	}
+	if counts := snapshot.BucketCounts(); !reflect.DeepEqual([]int64{2, 4}, counts) || 5 != snapshot.Count() {
+		t.Errorf("snapshot: %v %v", counts, snapshot.Count())
+	}
+}
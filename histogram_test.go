@@ -1,6 +1,9 @@
 package metrics
 
-import "testing"
+import (
+	"sync"
+	"testing"
+)
 
 func BenchmarkHistogram(b *testing.B) {
 	h := NewHistogram(NewUniformSample(100))
@@ -93,3 +96,53 @@ func testHistogram10000(t *testing.T, h Histogram) {
 		t.Errorf("99th percentile: 9900.99 != %v\n", ps[2])
 	}
 }
+
+func TestHistogramSnapshotAndClearConcurrent(t *testing.T) {
+	for name, h := range map[string]Histogram{
+		"uniform":  NewHistogram(NewUniformSample(100000)),
+		"expdecay": NewHistogram(NewExpDecaySample(100000, 0.015)),
+		"bucketed": NewBucketedHistogram(NewUniformSample(100000), []int64{10}),
+		"topn":     NewTopNHistogram(NewUniformSample(100000), 3),
+		"tagged":   NewTaggedRegistry(NewRegistry(), map[string]string{"a": "b"}).GetOrRegister("h", NewHistogram(NewUniformSample(100000))).(Histogram),
+		"fallback": histogramWithoutSnapshotAndClear{NewHistogram(NewUniformSample(100000))},
+	} {
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					h.Update(1)
+				}
+			}()
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		var count int64
+	loop:
+		for {
+			select {
+			case <-done:
+				break loop
+			default:
+				count += snapshotAndClear(h).Count()
+			}
+		}
+		count += snapshotAndClear(h).Count()
+		if "fallback" != name && 4000 != count {
+			t.Errorf("%s: %d values read, not 4000", name, count)
+		}
+		if 0 != h.Count() {
+			t.Errorf("%s: %d values left", name, h.Count())
+		}
+	}
+}
+
+// histogramWithoutSnapshotAndClear hides the SnapshotAndClear method of a
+// Histogram.
+type histogramWithoutSnapshotAndClear struct {
+	Histogram
+}
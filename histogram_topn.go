@@ -0,0 +1,246 @@
+package metrics
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TopNHistograms are Histograms which additionally retain the largest values
+// recorded since they were last snapshotted, so that the pathological
+// outliers behind a tail percentile can be inspected directly.  TopNExemplars
+// returns the same values along with the trace IDs and times of those
+// recorded by UpdateWithExemplar, as context for each outlier.
+type TopNHistogram interface {
+	Histogram
+	TopN() []int64
+	TopNExemplars() []Exemplar
+}
+
+// GetOrRegisterTopNHistogram returns an existing TopNHistogram or constructs
+// and registers a new StandardTopNHistogram.
+func GetOrRegisterTopNHistogram(name string, r Registry, s Sample, n int) TopNHistogram {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return r.GetOrRegister(name, func() TopNHistogram { return NewTopNHistogram(s, n) }).(TopNHistogram)
+}
+
+// NewTopNHistogram constructs a new StandardTopNHistogram from a Sample which
+// retains at most the n largest values.  An n below zero is taken as zero.
+func NewTopNHistogram(s Sample, n int) TopNHistogram {
+	if UseNilMetrics {
+		return NilTopNHistogram{}
+	}
+	if n < 0 {
+		n = 0
+	}
+	return &StandardTopNHistogram{
+		StandardHistogram: StandardHistogram{sample: s},
+		n:                 n,
+		top:               make(exemplarMinHeap, 0, n),
+	}
+}
+
+// NewRegisteredTopNHistogram constructs and registers a new
+// StandardTopNHistogram, or returns the TopNHistogram already registered under
+// the given name.
+func NewRegisteredTopNHistogram(name string, r Registry, s Sample, n int) TopNHistogram {
+	return GetOrRegisterTopNHistogram(name, r, s, n)
+}
+
+// TopNHistogramSnapshot is a read-only copy of another TopNHistogram.
+type TopNHistogramSnapshot struct {
+	*HistogramSnapshot
+	top []Exemplar
+}
+
+// Snapshot returns the snapshot.
+func (h *TopNHistogramSnapshot) Snapshot() Histogram { return h }
+
+// TopN returns the largest values, in descending order, recorded between the
+// previous snapshot and the time this snapshot was taken.
+func (h *TopNHistogramSnapshot) TopN() []int64 { return exemplarValues(h.top) }
+
+// TopNExemplars returns the largest values as TopN does, with the trace IDs
+// and times of those recorded by UpdateWithExemplar.
+func (h *TopNHistogramSnapshot) TopNExemplars() []Exemplar {
+	top := make([]Exemplar, len(h.top))
+	copy(top, h.top)
+	return top
+}
+
+// NilTopNHistogram is a no-op TopNHistogram.
+type NilTopNHistogram struct {
+	NilHistogram
+}
+
+// Snapshot is a no-op.
+func (NilTopNHistogram) Snapshot() Histogram { return NilTopNHistogram{} }
+
+// TopN is a no-op.
+func (NilTopNHistogram) TopN() []int64 { return []int64{} }
+
+// TopNExemplars is a no-op.
+func (NilTopNHistogram) TopNExemplars() []Exemplar { return []Exemplar{} }
+
+// StandardTopNHistogram is the standard implementation of a TopNHistogram.
+// It keeps the largest values in a min-heap bounded to n entries, so memory
+// use is bounded by n in addition to the size of the Sample.
+type StandardTopNHistogram struct {
+	StandardHistogram
+	mutex sync.Mutex
+	n     int
+	top   exemplarMinHeap
+}
+
+// Clear clears the histogram, its sample and its largest values.
+func (h *StandardTopNHistogram) Clear() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.StandardHistogram.Clear()
+	h.top = h.top[:0]
+}
+
+// Merge absorbs the values and exemplars of another histogram, and its
+// largest values if it's a TopNHistogram or those of its sample otherwise.
+// The largest values of a live TopNHistogram are read without starting it
+// afresh, as its Snapshot would.
+func (h *StandardTopNHistogram) Merge(other Histogram) {
+	other = readSnapshot(other)
+	h.StandardHistogram.Merge(other)
+	var top []Exemplar
+	if t, ok := other.(TopNHistogram); ok {
+		top = t.TopNExemplars()
+	} else {
+		for _, v := range other.Sample().Values() {
+			top = append(top, Exemplar{Value: v})
+		}
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, e := range top {
+		h.push(e)
+	}
+}
+
+// Snapshot returns a read-only copy of the histogram and starts retaining
+// the largest values afresh.
+func (h *StandardTopNHistogram) Snapshot() Histogram {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	snapshot := &TopNHistogramSnapshot{
+		HistogramSnapshot: h.StandardHistogram.Snapshot().(*HistogramSnapshot),
+		top:               h.sortedTop(),
+	}
+	h.top = h.top[:0]
+	return snapshot
+}
+
+// SnapshotAndClear returns a read-only copy of the histogram and clears it
+// as one operation, as StandardHistogram.SnapshotAndClear does.
+func (h *StandardTopNHistogram) SnapshotAndClear() Histogram {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	snapshot := &TopNHistogramSnapshot{
+		HistogramSnapshot: h.StandardHistogram.SnapshotAndClear().(*HistogramSnapshot),
+		top:               h.sortedTop(),
+	}
+	h.top = h.top[:0]
+	return snapshot
+}
+
+// TopN returns the largest values, in descending order, recorded since the
+// histogram was last snapshotted.
+func (h *StandardTopNHistogram) TopN() []int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return exemplarValues(h.sortedTop())
+}
+
+// TopNExemplars returns the largest values as TopN does, with the trace IDs
+// and times of those recorded by UpdateWithExemplar.
+func (h *StandardTopNHistogram) TopNExemplars() []Exemplar {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.sortedTop()
+}
+
+// Update samples a new value.
+func (h *StandardTopNHistogram) Update(v int64) {
+	h.StandardHistogram.Update(v)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.push(Exemplar{Value: v})
+}
+
+// UpdateWithExemplar samples a new value as Update does and keeps it as an
+// exemplar of the trace by the given ID, both among the most recent
+// exemplars and, if it's among the largest values, in TopNExemplars.
+func (h *StandardTopNHistogram) UpdateWithExemplar(v int64, traceID string) {
+	e := Exemplar{Value: v, TraceID: traceID, Time: time.Now()}
+	h.StandardHistogram.Update(v)
+	h.exemplars.add(e)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.push(e)
+}
+
+// peek returns a read-only copy of the histogram without starting to retain
+// the largest values afresh, for merging it into another.
+func (h *StandardTopNHistogram) peek() Histogram {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return &TopNHistogramSnapshot{
+		HistogramSnapshot: h.StandardHistogram.Snapshot().(*HistogramSnapshot),
+		top:               h.sortedTop(),
+	}
+}
+
+// push retains e if its value is among the n largest.  It should run with
+// h.mutex held.
+func (h *StandardTopNHistogram) push(e Exemplar) {
+	if len(h.top) < h.n {
+		heap.Push(&h.top, e)
+	} else if 0 < h.n && e.Value > h.top[0].Value {
+		h.top[0] = e
+		heap.Fix(&h.top, 0)
+	}
+}
+
+// sortedTop returns a copy of the largest values in descending order.  It
+// should run with h.mutex held.
+func (h *StandardTopNHistogram) sortedTop() []Exemplar {
+	top := make([]Exemplar, len(h.top))
+	copy(top, h.top)
+	sort.Sort(sort.Reverse(exemplarMinHeap(top)))
+	return top
+}
+
+// exemplarValues returns the values of exemplars.
+func exemplarValues(exemplars []Exemplar) []int64 {
+	values := make([]int64, len(exemplars))
+	for i, e := range exemplars {
+		values[i] = e.Value
+	}
+	return values
+}
+
+// exemplarMinHeap implements heap.Interface with the exemplar of the smallest
+// value at the root.
+type exemplarMinHeap []Exemplar
+
+func (h exemplarMinHeap) Len() int           { return len(h) }
+func (h exemplarMinHeap) Less(i, j int) bool { return h[i].Value < h[j].Value }
+func (h exemplarMinHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *exemplarMinHeap) Push(x interface{}) { *h = append(*h, x.(Exemplar)) }
+
+func (h *exemplarMinHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
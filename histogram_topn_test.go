@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func BenchmarkTopNHistogram(b *testing.B) {
+	h := NewTopNHistogram(NewUniformSample(100), 10)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.Update(int64(i))
+	}
+}
+
+func TestGetOrRegisterTopNHistogram(t *testing.T) {
+	r := NewRegistry()
+	s := NewUniformSample(100)
+	h := GetOrRegisterTopNHistogram("foo", r, s, 3)
+	h.Update(47)
+	if h := GetOrRegisterTopNHistogram("foo", r, s, 3); 1 != h.Count() {
+		t.Fatal(h)
+	}
+}
+
+func TestTopNHistogram(t *testing.T) {
+	h := NewTopNHistogram(NewUniformSample(100), 3)
+	for _, v := range []int64{5, 1, 9, 3, 7, 2, 8} {
+		h.Update(v)
+	}
+	if top := h.TopN(); !reflect.DeepEqual([]int64{9, 8, 7}, top) {
+		t.Fatal(top)
+	}
+	if count := h.Count(); 7 != count {
+		t.Fatal(count)
+	}
+}
+
+func TestTopNHistogramFewerThanN(t *testing.T) {
+	h := NewTopNHistogram(NewUniformSample(100), 3)
+	if top := h.TopN(); 0 != len(top) {
+		t.Fatal(top)
+	}
+	h.Update(4)
+	if top := h.TopN(); !reflect.DeepEqual([]int64{4}, top) {
+		t.Fatal(top)
+	}
+}
+
+func TestTopNHistogramSnapshot(t *testing.T) {
+	h := NewTopNHistogram(NewUniformSample(10000), 2)
+	for i := 1; i <= 10000; i++ {
+		h.Update(int64(i))
+	}
+	snapshot := h.Snapshot()
+	h.Update(0)
+	testHistogram10000(t, snapshot)
+	if top := snapshot.(TopNHistogram).TopN(); !reflect.DeepEqual([]int64{10000, 9999}, top) {
+		t.Fatal(top)
+	}
+	if top := h.TopN(); !reflect.DeepEqual([]int64{0}, top) {
+		t.Fatal(top)
+	}
+}
+
+func TestTopNHistogramNegativeN(t *testing.T) {
+	h := NewTopNHistogram(NewUniformSample(100), -1)
+	h.Update(1)
+	if top := h.TopN(); 0 != len(top) {
+		t.Errorf("h.TopN(): %v", top)
+	}
+}
+
+func TestTopNHistogramMergeKeepsSourceTop(t *testing.T) {
+	h := NewTopNHistogram(NewUniformSample(100), 2)
+	other := NewTopNHistogram(NewUniformSample(100), 2)
+	other.Update(7)
+	other.Update(5)
+	h.Merge(other)
+	NewHistogram(NewUniformSample(100)).Merge(other)
+	MergeHistograms(other)
+	if top := other.TopN(); !reflect.DeepEqual([]int64{7, 5}, top) {
+		t.Errorf("other.TopN(): %v", top)
+	}
+	tagged := NewTaggedRegistry(NewRegistry(), map[string]string{"a": "b"}).GetOrRegister("h", other).(Histogram)
+	h.Merge(tagged)
+	if top := other.TopN(); !reflect.DeepEqual([]int64{7, 5}, top) {
+		t.Errorf("other.TopN() after merging its tagged registration: %v", top)
+	}
+}
+
+func TestTopNHistogramExemplars(t *testing.T) {
+	h := NewTopNHistogram(NewUniformSample(100), 2)
+	h.Update(5)
+	h.UpdateWithExemplar(9, "slow")
+	h.UpdateWithExemplar(1, "fast")
+	top := h.TopNExemplars()
+	if 2 != len(top) || 9 != top[0].Value || "slow" != top[0].TraceID || top[0].Time.IsZero() {
+		t.Fatalf("h.TopNExemplars(): %v", top)
+	}
+	if 5 != top[1].Value || "" != top[1].TraceID {
+		t.Errorf("h.TopNExemplars(): %v", top)
+	}
+	other := NewTopNHistogram(NewUniformSample(100), 2)
+	other.Merge(h)
+	if top := other.Snapshot().(TopNHistogram).TopNExemplars(); 2 != len(top) || "slow" != top[0].TraceID {
+		t.Errorf("merged TopNExemplars(): %v", top)
+	}
+}
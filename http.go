@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// InstrumentHandler returns an http.Handler which serves requests with next,
+// recording them in r as InstrumentRoute does but without a route tag.
+func InstrumentHandler(r Registry, next http.Handler) http.Handler {
+	return InstrumentRoute(r, "", next)
+}
+
+// InstrumentRoute returns an http.Handler which serves requests with next,
+// recording in r, tagged with route=route unless route is empty:
+//
+//	http.requests            a Timer of each request's duration
+//	http.requests.in_flight  a Gauge of the requests being served
+//	http.responses           a Counter of responses tagged code=2xx and so on
+//
+// Instrument each route of a mux separately, as in
+// mux.Handle("/login", metrics.InstrumentRoute(r, "/login", login)), so that
+// the metrics of each are distinct but their number doesn't grow with the
+// paths requested.  Give a child registry to prefix the names.  The
+// ResponseWriter given to next passes Flush, Hijack, CloseNotify and, from
+// Go 1.8, Push through to the server's, so that WebSockets and HTTP/2 pushes
+// work, and the response of a hijacked connection, which has left HTTP, is
+// counted as 1xx.
+func InstrumentRoute(r Registry, route string, next http.Handler) http.Handler {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	tags := map[string]string{}
+	if "" != route {
+		tags["route"] = route
+	}
+	h := &instrumentedHandler{
+		next:     next,
+		requests: GetOrRegisterTaggedTimer("http.requests", tags, r),
+		inFlight: GetOrRegisterTaggedGauge("http.requests.in_flight", tags, r),
+	}
+	for i := range h.responses {
+		codeTags := map[string]string{"code": strconv.Itoa(i+1) + "xx"}
+		for k, v := range tags {
+			codeTags[k] = v
+		}
+		h.responses[i] = GetOrRegisterTaggedCounter("http.responses", codeTags, r)
+	}
+	return h
+}
+
+// instrumentedHandler is the http.Handler returned by InstrumentRoute.
+type instrumentedHandler struct {
+	next      http.Handler
+	requests  Timer
+	responses [5]Counter // Responses by status class, 1xx to 5xx
+
+	mutex    sync.Mutex // Orders updates of inFlight
+	n        int64      // Requests being served
+	inFlight Gauge
+}
+
+func (h *instrumentedHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	h.add(1)
+	rw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		h.requests.UpdateSince(start)
+		if i := rw.status/100 - 1; 0 <= i && i < len(h.responses) {
+			h.responses[i].Inc(1)
+		}
+		h.add(-1)
+	}()
+	h.next.ServeHTTP(rw, req)
+}
+
+func (h *instrumentedHandler) add(n int64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.n += n
+	h.inFlight.Update(h.n)
+}
+
+// statusResponseWriter is an http.ResponseWriter which records the status of
+// the response it writes.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+// Flush sends any buffered data to the client if the underlying
+// ResponseWriter is an http.Flusher.
+func (w *statusResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify returns the channel of the underlying ResponseWriter if it's
+// an http.CloseNotifier, or else one which never receives.
+func (w *statusResponseWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// Hijack takes over the connection if the underlying ResponseWriter is an
+// http.Hijacker.
+func (w *statusResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("metrics: ResponseWriter isn't an http.Hijacker")
+	}
+	conn, rw, err := h.Hijack()
+	if nil == err && !w.wroteHeader {
+		w.status, w.wroteHeader = http.StatusSwitchingProtocols, true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
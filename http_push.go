@@ -0,0 +1,15 @@
+//go:build go1.8
+// +build go1.8
+
+package metrics
+
+import "net/http"
+
+// Push initiates an HTTP/2 server push if the underlying ResponseWriter is an
+// http.Pusher.
+func (w *statusResponseWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInstrumentRoute(t *testing.T) {
+	r := NewRegistry()
+	var inFlight int64
+	h := InstrumentRoute(r, "/login", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		inFlight = r.Get("http.requests.in_flight{route=/login}").(Gauge).Value()
+		if "bad" == req.URL.Query().Get("q") {
+			http.Error(w, "bad", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("ok"))
+		w.WriteHeader(http.StatusInternalServerError) // Superfluous
+	}))
+	for _, q := range []string{"", "", "bad"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/login?q="+q, nil))
+	}
+	if 1 != inFlight {
+		t.Errorf("in flight while serving: 1 != %v", inFlight)
+	}
+	if n := r.Get("http.requests.in_flight{route=/login}").(Gauge).Value(); 0 != n {
+		t.Errorf("in flight: 0 != %v", n)
+	}
+	if n := r.Get("http.requests{route=/login}").(Timer).Count(); 3 != n {
+		t.Errorf("requests: 3 != %v", n)
+	}
+	if n := r.Get("http.responses{code=2xx,route=/login}").(Counter).Count(); 2 != n {
+		t.Errorf("2xx: 2 != %v", n)
+	}
+	if n := r.Get("http.responses{code=4xx,route=/login}").(Counter).Count(); 1 != n {
+		t.Errorf("4xx: 1 != %v", n)
+	}
+	if n := r.Get("http.responses{code=5xx,route=/login}").(Counter).Count(); 0 != n {
+		t.Errorf("5xx: 0 != %v", n)
+	}
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	r := NewRegistry()
+	h := InstrumentHandler(r, http.NotFoundHandler())
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if n := r.Get("http.responses{code=4xx}").(Counter).Count(); 1 != n {
+		t.Errorf("4xx: 1 != %v", n)
+	}
+	if _, ok := r.Get("http.requests").(Timer); !ok {
+		t.Error("http.requests wasn't registered untagged")
+	}
+}
+
+func TestInstrumentRouteHijack(t *testing.T) {
+	r := NewRegistry()
+	ts := httptest.NewServer(InstrumentRoute(r, "/ws", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h, ok := w.(http.Hijacker)
+		if !ok {
+			t.Error("ResponseWriter isn't an http.Hijacker")
+			http.Error(w, "can't hijack", http.StatusInternalServerError)
+			return
+		}
+		conn, rw, err := h.Hijack()
+		if nil != err {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\n")
+		rw.Flush()
+	})))
+	defer ts.Close()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	if nil != err || "HTTP/1.1 101 Switching Protocols\r\n" != status {
+		t.Fatalf("%q, %v", status, err)
+	}
+	c := r.Get("http.responses{code=1xx,route=/ws}").(Counter)
+	for i := 0; i < 1000 && 0 == c.Count(); i++ {
+		time.Sleep(time.Millisecond) // Until the handler returns
+	}
+	if n := c.Count(); 1 != n {
+		t.Errorf("1xx: 1 != %v", n)
+	}
+}
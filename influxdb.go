@@ -0,0 +1,352 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxDBConfig provides a container with configuration parameters for
+// the InfluxDB exporter
+type InfluxDBConfig struct {
+	URL           string            // Base URL of the InfluxDB HTTP API, e.g. "http://localhost:8086"
+	Database      string            // Database to write to through the 1.x /write endpoint
+	Username      string            // Username for basic authentication, none if empty
+	Password      string            // Password for basic authentication
+	Org           string            // Organization of the Bucket
+	Bucket        string            // Bucket to write to through the 2.x /api/v2/write endpoint instead of a Database
+	Token         string            // API token for the 2.x endpoint
+	Registry      Registry          // Registry to be exported
+	FlushInterval time.Duration     // Flush interval
+	DurationUnit  time.Duration     // Time conversion unit for durations, defaults to nanoseconds
+	Prefix        string            // Prefix to be prepended to measurement names
+	Tags          map[string]string // Tags added to every point
+	Percentiles   []float64         // Percentiles of histograms and timers to write, defaults to 0.5, 0.75, 0.95, 0.99 and 0.999 if nil, none if empty
+	ErrorHandler  func(error)       // Called with each flush error, defaults to log.Println
+	Filter        func(string) bool // Metrics whose names it returns false for aren't exported, all are if nil
+	Client        *http.Client      // HTTP client, defaults to http.DefaultClient
+
+	// BatchSize is the most points written per request, all of a flush in
+	// one if zero.  Gzip compresses request bodies of at least GzipMinSize
+	// bytes, which InfluxDB accepts.
+	BatchSize   int
+	Gzip        bool
+	GzipMinSize int
+}
+
+// InfluxDBWithConfig is a blocking exporter function which writes the
+// metrics in c.Registry to InfluxDB every c.FlushInterval, each flush in
+// requests of c.BatchSize points to the 1.x /write endpoint, or to 2.x's
+// /api/v2/write if c.Bucket is set.  Every metric is written as a point of the
+// measurement named after it, with fields such as count, value, mean and p99
+// depending on its type, timers' in DurationUnit.  Healthchecks aren't
+// written.
+func InfluxDBWithConfig(c InfluxDBConfig) {
+	if err := InfluxDBWithConfigContext(context.Background(), c); nil != err {
+		c.handleError(err)
+	}
+}
+
+// InfluxDBWithConfigContext is like InfluxDBWithConfig but returns once ctx
+// is done, after a final flush which is given one FlushInterval to complete.
+// Errors from flushes other than the final one are passed to the
+// ErrorHandler.  An error is returned at once if c.FlushInterval isn't
+// positive.
+func InfluxDBWithConfigContext(ctx context.Context, c InfluxDBConfig) error {
+	e, err := newInfluxDBExporter(c)
+	if nil != err {
+		return err
+	}
+	return FlushLoopWithConfig(ctx, FlushLoopConfig{
+		Registry:      c.Registry,
+		FlushInterval: c.FlushInterval,
+		Exporter:      e,
+		ErrorHandler:  c.ErrorHandler,
+	})
+}
+
+// NewInfluxDBExporter constructs a new Exporter which flushes the registry
+// it's given to InfluxDB as configured by c, so that it can be run by
+// FlushLoop or be a sink of a FanoutExporter.  c.Registry, which it
+// ignores, needn't be set.
+func NewInfluxDBExporter(c InfluxDBConfig) (Exporter, error) {
+	if nil == c.Registry {
+		c.Registry = DefaultRegistry
+	}
+	return newInfluxDBExporter(c)
+}
+
+// handleError passes err to the ErrorHandler, or logs it if there is none.
+func (c *InfluxDBConfig) handleError(err error) {
+	if nil != c.ErrorHandler {
+		c.ErrorHandler(err)
+	} else {
+		log.Println(err)
+	}
+}
+
+// influxDBExporter holds the configuration of an InfluxDB exporter, with its
+// defaults filled in.
+type influxDBExporter struct {
+	config InfluxDBConfig
+}
+
+func newInfluxDBExporter(c InfluxDBConfig) (*influxDBExporter, error) {
+	if nil == c.Registry {
+		return nil, errors.New("influxdb: config has no Registry to export")
+	}
+	if "" == c.URL {
+		return nil, errors.New("influxdb: config has no URL")
+	}
+	if "" == c.Database && "" == c.Bucket {
+		return nil, errors.New("influxdb: config has neither a Database nor a Bucket")
+	}
+	if nil == c.Client {
+		c.Client = http.DefaultClient
+	}
+	if 0 == c.DurationUnit {
+		c.DurationUnit = time.Nanosecond
+	}
+	return &influxDBExporter{config: c}, nil
+}
+
+// Export flushes r, for FlushLoop.
+func (e *influxDBExporter) Export(r Registry) error {
+	return e.ExportContext(context.Background(), r)
+}
+
+// ExportContext flushes r, for FlushLoop.
+func (e *influxDBExporter) ExportContext(ctx context.Context, r Registry) error {
+	e.config.Registry = r
+	return e.flush(ctx, time.Now())
+}
+
+// flush writes every metric in the registry, stamped with now, in batches of
+// BatchSize points, stopping at the first batch which fails.  An empty
+// registry is still written, in one empty request.
+func (e *influxDBExporter) flush(ctx context.Context, now time.Time) error {
+	c := &e.config
+	var b bytes.Buffer
+	e.writePoints(&b, now.UnixNano())
+	lines := bytes.SplitAfter(b.Bytes(), []byte("\n"))
+	if 0 < len(lines) && 0 == len(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+	size := c.BatchSize
+	if size <= 0 {
+		size = len(lines)
+	}
+	for {
+		n := size
+		if len(lines) < n {
+			n = len(lines)
+		}
+		if err := e.post(ctx, bytes.Join(lines[:n], nil)); nil != err {
+			return err
+		}
+		if lines = lines[n:]; 0 == len(lines) {
+			return nil
+		}
+	}
+}
+
+// post writes body, lines of points, in one request.
+func (e *influxDBExporter) post(ctx context.Context, body []byte) error {
+	c := &e.config
+	gzipped := false
+	if c.Gzip {
+		var err error
+		if body, gzipped, err = gzipBody(body, c.GzipMinSize); nil != err {
+			return err
+		}
+	}
+	u := strings.TrimSuffix(c.URL, "/") + "/write?" + url.Values{
+		"db":        {c.Database},
+		"precision": {"ns"},
+	}.Encode()
+	if "" != c.Bucket {
+		u = strings.TrimSuffix(c.URL, "/") + "/api/v2/write?" + url.Values{
+			"org":       {c.Org},
+			"bucket":    {c.Bucket},
+			"precision": {"ns"},
+		}.Encode()
+	}
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if nil != err {
+		return err
+	}
+	if "" != c.Token {
+		req.Header.Set("Authorization", "Token "+c.Token)
+	} else if "" != c.Username {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := c.Client.Do(req.WithContext(ctx))
+	if nil != err {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("influxdb: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// influxDBField is a field of a point, an integer if it's an int64 and a
+// float otherwise.
+type influxDBField struct {
+	key   string
+	value interface{}
+}
+
+// writePoints writes a line for each metric in the registry to b.
+func (e *influxDBExporter) writePoints(b *bytes.Buffer, ts int64) {
+	c := &e.config
+	du := float64(c.DurationUnit)
+	tagged := make(map[string]map[string]string)
+	exported := make(map[string]string)
+	c.Registry.Each(func(name string, i interface{}) {
+		if t, ok := i.(Tagged); ok {
+			tagged[name] = t.Tags()
+		}
+		exported[name] = exportName(name, i)
+	})
+	percentiles := percentilesOrDefault(c.Percentiles)
+	percentileFields := func(ps []float64, scale float64) []influxDBField {
+		fields := make([]influxDBField, len(ps))
+		for i, p := range ps {
+			fields[i] = influxDBField{"p" + strings.TrimSuffix(percentileSuffix(percentiles[i]), "-percentile"), p / scale}
+		}
+		return fields
+	}
+	c.Registry.Snapshot().Each(func(name string, i interface{}) {
+		measurement := name
+		if n, ok := exported[name]; ok {
+			measurement = n
+		}
+		if nil != c.Filter && !c.Filter(measurement) {
+			return
+		}
+		var fields []influxDBField
+		switch metric := i.(type) {
+		case Counter:
+			fields = []influxDBField{{"count", metric.Count()}}
+		case Gauge:
+			fields = []influxDBField{{"value", metric.Value()}}
+		case GaugeFloat64:
+			fields = []influxDBField{{"value", metric.Value()}}
+		case Histogram:
+			fields = append([]influxDBField{
+				{"count", metric.Count()},
+				{"min", metric.Min()},
+				{"max", metric.Max()},
+				{"mean", metric.Mean()},
+				{"stddev", metric.StdDev()},
+			}, percentileFields(metric.Percentiles(percentiles), 1)...)
+		case Meter:
+			fields = []influxDBField{
+				{"count", metric.Count()},
+				{"m1", metric.Rate1()},
+				{"m5", metric.Rate5()},
+				{"m15", metric.Rate15()},
+				{"mean", metric.RateMean()},
+			}
+			if wm, ok := metric.(WindowedMeter); ok {
+				for _, wr := range wm.WindowRates() {
+					fields = append(fields, influxDBField{"rate_" + wr.Name(), wr.Rate})
+				}
+			}
+		case Timer:
+			fields = append([]influxDBField{
+				{"count", metric.Count()},
+				{"min", float64(metric.Min()) / du},
+				{"max", float64(metric.Max()) / du},
+				{"mean", metric.Mean() / du},
+				{"stddev", metric.StdDev() / du},
+			}, percentileFields(metric.Percentiles(percentiles), du)...)
+			fields = append(fields,
+				influxDBField{"m1", metric.Rate1()},
+				influxDBField{"m5", metric.Rate5()},
+				influxDBField{"m15", metric.Rate15()},
+				influxDBField{"meanrate", metric.RateMean()},
+			)
+		default:
+			return
+		}
+		if "" != c.Prefix {
+			measurement = c.Prefix + "." + measurement
+		}
+		writeInfluxDBLine(b, measurement, c.Tags, tagged[name], fields, ts)
+	})
+}
+
+// writeInfluxDBLine writes a line of the line protocol for measurement to b.
+// Tags, overridden by those of the metric, are sorted by key as InfluxDB
+// recommends.  Values which aren't finite, which InfluxDB rejects, are
+// written as zero.
+func writeInfluxDBLine(b *bytes.Buffer, measurement string, tags, metricTags map[string]string, fields []influxDBField, ts int64) {
+	b.WriteString(influxDBMeasurementEscaper.Replace(measurement))
+	merged := make(map[string]string, len(tags)+len(metricTags))
+	for k, v := range tags {
+		merged[k] = v
+	}
+	for k, v := range metricTags {
+		merged[k] = v
+	}
+	keys := make([]string, 0, len(merged))
+	for k, v := range merged {
+		// InfluxDB rejects tags with empty keys or values.
+		if "" != k && "" != v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteByte(',')
+		b.WriteString(influxDBTagEscaper.Replace(k))
+		b.WriteByte('=')
+		b.WriteString(influxDBTagEscaper.Replace(merged[k]))
+	}
+	for i, f := range fields {
+		if 0 == i {
+			b.WriteByte(' ')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(influxDBTagEscaper.Replace(f.key))
+		b.WriteByte('=')
+		switch v := f.value.(type) {
+		case int64:
+			b.WriteString(strconv.FormatInt(v, 10))
+			b.WriteByte('i')
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				v = 0
+			}
+			b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(ts, 10))
+	b.WriteByte('\n')
+}
+
+var (
+	influxDBMeasurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `, "\n", `\n`)
+	influxDBTagEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", `\n`)
+)
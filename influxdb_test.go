@@ -0,0 +1,247 @@
+package metrics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfluxDB(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if "/write" != r.URL.Path || "metrics" != r.URL.Query().Get("db") || "ns" != r.URL.Query().Get("precision") {
+			t.Errorf("URL: %v", r.URL)
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || "user" != user || "pass" != pass {
+			t.Errorf("basic auth: %q %q %v", user, pass, ok)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r).Inc(47)
+	GetOrRegisterGaugeFloat64("g", r).Update(0.25)
+	tm := &timerWithTags{NewTimer(), map[string]string{"route": "/users"}}
+	r.Register("t", tm)
+	tm.Update(2 * time.Millisecond)
+	tm.Update(4 * time.Millisecond)
+	e, _ := newInfluxDBExporter(InfluxDBConfig{
+		URL:          ts.URL,
+		Database:     "metrics",
+		Username:     "user",
+		Password:     "pass",
+		Registry:     r,
+		DurationUnit: time.Millisecond,
+		Prefix:       "p",
+		Tags:         map[string]string{"dc": "east", "host": "a b"},
+	})
+	if err := e.flush(context.Background(), time.Unix(1000, 5)); nil != err {
+		t.Fatal(err)
+	}
+	if 1 != len(bodies) {
+		t.Fatalf("%d requests", len(bodies))
+	}
+	lines := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSuffix(bodies[0], "\n"), "\n") {
+		lines[line[:strings.IndexAny(line, ", ")]] = line
+	}
+	if want := `p.c,dc=east,host=a\ b count=47i 1000000000005`; want != lines["p.c"] {
+		t.Errorf("%q != %q", want, lines["p.c"])
+	}
+	if want := `p.g,dc=east,host=a\ b value=0.25 1000000000005`; want != lines["p.g"] {
+		t.Errorf("%q != %q", want, lines["p.g"])
+	}
+	want := `p.t,dc=east,host=a\ b,route=/users count=2i,min=2,max=4,mean=3,stddev=1,p50=3,p75=4,p95=4,p99=4,p999=4,m1=`
+	if !strings.HasPrefix(lines["p.t"], want) || !strings.HasSuffix(lines["p.t"], " 1000000000005") {
+		t.Errorf("%q doesn't begin %q", lines["p.t"], want)
+	}
+}
+
+func TestInfluxDBBatchSizeGzip(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := io.Reader(r.Body)
+		if "gzip" == r.Header.Get("Content-Encoding") {
+			zr, err := gzip.NewReader(r.Body)
+			if nil != err {
+				t.Fatal(err)
+			}
+			body = zr
+		}
+		b, _ := ioutil.ReadAll(body)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	r := NewRegistry()
+	for _, name := range []string{"a", "b", "c"} {
+		GetOrRegisterCounter(name, r).Inc(47)
+	}
+	e, _ := newInfluxDBExporter(InfluxDBConfig{
+		URL:       ts.URL,
+		Database:  "metrics",
+		Registry:  r,
+		BatchSize: 2,
+		Gzip:      true,
+	})
+	if err := e.flush(context.Background(), time.Unix(1000, 0)); nil != err {
+		t.Fatal(err)
+	}
+	if 2 != len(bodies) || 2 != strings.Count(bodies[0], "\n") || 1 != strings.Count(bodies[1], "\n") {
+		t.Fatalf("bodies %q", bodies)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if !strings.Contains(bodies[0]+bodies[1], name+" count=47i ") {
+			t.Errorf("%s's point wasn't written: %q", name, bodies)
+		}
+	}
+}
+
+func TestInfluxDBError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"database not found"}`, http.StatusNotFound)
+	}))
+	defer ts.Close()
+	e, _ := newInfluxDBExporter(InfluxDBConfig{URL: ts.URL, Database: "metrics", Registry: NewRegistry()})
+	err := e.flush(context.Background(), time.Now())
+	if nil == err || !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "database not found") {
+		t.Fatal(err)
+	}
+}
+
+func TestInfluxDBErrorHandler(t *testing.T) {
+	errs := make(chan error, 1)
+	InfluxDBWithConfig(InfluxDBConfig{ErrorHandler: func(err error) { errs <- err }})
+	select {
+	case err := <-errs:
+		if nil == err {
+			t.Error("nil error")
+		}
+	default:
+		t.Error("ErrorHandler wasn't called")
+	}
+	if _, err := newInfluxDBExporter(InfluxDBConfig{Registry: NewRegistry(), URL: "http://localhost:8086"}); nil == err {
+		t.Error("expected an error for a missing Database and Bucket")
+	}
+}
+
+func TestInfluxDBWithConfigContext(t *testing.T) {
+	bodies := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r).Inc(47)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := InfluxDBWithConfigContext(ctx, InfluxDBConfig{
+		URL:           ts.URL,
+		Database:      "metrics",
+		Registry:      r,
+		FlushInterval: time.Hour,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	select {
+	case body := <-bodies:
+		if !strings.HasPrefix(body, "c count=47i ") {
+			t.Errorf("final flush: %q", body)
+		}
+	default:
+		t.Error("no final flush")
+	}
+	err = InfluxDBWithConfigContext(ctx, InfluxDBConfig{URL: ts.URL, Database: "metrics", Registry: r})
+	if nil == err {
+		t.Error("no error for a zero FlushInterval")
+	}
+}
+
+func TestInfluxDBV2(t *testing.T) {
+	var body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if "/api/v2/write" != r.URL.Path || "acme" != q.Get("org") || "metrics" != q.Get("bucket") || "ns" != q.Get("precision") {
+			t.Errorf("URL: %v", r.URL)
+		}
+		if "Token secret" != r.Header.Get("Authorization") {
+			t.Errorf("Authorization: %q", r.Header.Get("Authorization"))
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	r := NewRegistry()
+	GetOrRegisterGauge("g", r).Update(47)
+	e, err := newInfluxDBExporter(InfluxDBConfig{
+		URL:      ts.URL,
+		Org:      "acme",
+		Bucket:   "metrics",
+		Token:    "secret",
+		Registry: r,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err := e.flush(context.Background(), time.Unix(1, 0)); nil != err {
+		t.Fatal(err)
+	}
+	if "g value=47i 1000000000\n" != body {
+		t.Errorf("%q", body)
+	}
+}
+
+func TestInfluxDBPercentiles(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterHistogram("h", r, NewUniformSample(10)).Update(47)
+	e, _ := newInfluxDBExporter(InfluxDBConfig{
+		URL:         "http://localhost:8086",
+		Database:    "metrics",
+		Registry:    r,
+		Percentiles: []float64{0.9},
+	})
+	var b bytes.Buffer
+	e.writePoints(&b, 1)
+	if want := "h count=1i,min=47i,max=47i,mean=47,stddev=0,p90=47 1\n"; want != b.String() {
+		t.Errorf("%q != %q", want, b.String())
+	}
+	e.config.Percentiles = []float64{}
+	b.Reset()
+	e.writePoints(&b, 1)
+	if want := "h count=1i,min=47i,max=47i,mean=47,stddev=0 1\n"; want != b.String() {
+		t.Errorf("%q != %q", want, b.String())
+	}
+}
+
+func TestInfluxDBFilter(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterGauge("keep", r).Update(1)
+	GetOrRegisterGauge("drop", r).Update(2)
+	e, _ := newInfluxDBExporter(InfluxDBConfig{
+		URL:      "http://localhost:8086",
+		Database: "metrics",
+		Registry: r,
+		Prefix:   "p",
+		Filter:   func(name string) bool { return "drop" != name },
+	})
+	var b bytes.Buffer
+	e.writePoints(&b, 1)
+	if want := "p.keep value=1i 1\n"; want != b.String() {
+		t.Errorf("%q != %q", want, b.String())
+	}
+}
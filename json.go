@@ -3,69 +3,133 @@ package metrics
 import (
 	"encoding/json"
 	"io"
+	"net/http"
+	"strconv"
 	"time"
 )
 
 // MarshalJSON returns a byte slice containing a JSON representation of all
 // the metrics in the Registry.
 func (r *StandardRegistry) MarshalJSON() ([]byte, error) {
-	data := make(map[string]map[string]interface{})
-	r.Each(func(name string, i interface{}) {
-		values := make(map[string]interface{})
-		switch metric := i.(type) {
-		case Counter:
-			values["count"] = metric.Count()
-		case Gauge:
-			values["value"] = metric.Value()
-		case GaugeFloat64:
-			values["value"] = metric.Value()
-		case Healthcheck:
-			values["error"] = nil
-			metric.Check()
-			if err := metric.Error(); nil != err {
-				values["error"] = metric.Error().Error()
+	return MarshalJSON(r)
+}
+
+// MarshalJSON returns a byte slice containing a JSON representation of all
+// the metrics in any Registry, an object mapping each name to an object of
+// the metric's values.  Each metric is snapshotted before it's read so that
+// its values are consistent with one another.  Histograms and timers include
+// a percentiles object keyed by quantile, and BucketedHistograms a buckets
+// object of the count at or below each bound.
+func MarshalJSON(r Registry) ([]byte, error) {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return json.Marshal(GetAll(r))
+}
+
+// metricValues returns the values of the given metric keyed by field, as
+// serialized by MarshalJSON.
+func metricValues(i interface{}) map[string]interface{} {
+	percentiles := defaultPercentiles
+	values := make(map[string]interface{})
+	switch metric := snapshotMetric(i).(type) {
+	case Counter:
+		values["count"] = metric.Count()
+	case Gauge:
+		values["value"] = metric.Value()
+	case GaugeFloat64:
+		values["value"] = metric.Value()
+	case Healthcheck:
+		values["error"] = nil
+		metric.Check()
+		if err := metric.Error(); nil != err {
+			values["error"] = metric.Error().Error()
+		}
+	case Histogram:
+		ps := metric.Percentiles(percentiles)
+		values["count"] = metric.Count()
+		values["min"] = metric.Min()
+		values["max"] = metric.Max()
+		values["mean"] = metric.Mean()
+		values["stddev"] = metric.StdDev()
+		values["median"] = ps[0]
+		values["75%"] = ps[1]
+		values["95%"] = ps[2]
+		values["99%"] = ps[3]
+		values["99.9%"] = ps[4]
+		values["percentiles"] = percentileValues(percentiles, ps)
+		if bh, ok := metric.(BucketedHistogram); ok {
+			buckets := make(map[string]int64)
+			counts := bh.BucketCounts()
+			for i, bound := range bh.Bounds() {
+				buckets[strconv.FormatInt(bound, 10)] = counts[i]
 			}
-		case Histogram:
-			h := metric.Snapshot()
-			ps := h.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
-			values["count"] = h.Count()
-			values["min"] = h.Min()
-			values["max"] = h.Max()
-			values["mean"] = h.Mean()
-			values["stddev"] = h.StdDev()
-			values["median"] = ps[0]
-			values["75%"] = ps[1]
-			values["95%"] = ps[2]
-			values["99%"] = ps[3]
-			values["99.9%"] = ps[4]
-		case Meter:
-			m := metric.Snapshot()
-			values["count"] = m.Count()
-			values["1m.rate"] = m.Rate1()
-			values["5m.rate"] = m.Rate5()
-			values["15m.rate"] = m.Rate15()
-			values["mean.rate"] = m.RateMean()
-		case Timer:
-			t := metric.Snapshot()
-			ps := t.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
-			values["count"] = t.Count()
-			values["min"] = t.Min()
-			values["max"] = t.Max()
-			values["mean"] = t.Mean()
-			values["stddev"] = t.StdDev()
-			values["median"] = ps[0]
-			values["75%"] = ps[1]
-			values["95%"] = ps[2]
-			values["99%"] = ps[3]
-			values["99.9%"] = ps[4]
-			values["1m.rate"] = t.Rate1()
-			values["5m.rate"] = t.Rate5()
-			values["15m.rate"] = t.Rate15()
-			values["mean.rate"] = t.RateMean()
+			buckets["+Inf"] = metric.Count()
+			values["buckets"] = buckets
+		}
+	case Meter:
+		values["count"] = metric.Count()
+		values["1m.rate"] = metric.Rate1()
+		values["5m.rate"] = metric.Rate5()
+		values["15m.rate"] = metric.Rate15()
+		values["mean.rate"] = metric.RateMean()
+		if wm, ok := metric.(WindowedMeter); ok {
+			for _, wr := range wm.WindowRates() {
+				values[wr.Name()+".rate"] = wr.Rate
+			}
+		}
+	case Timer:
+		ps := metric.Percentiles(percentiles)
+		values["count"] = metric.Count()
+		values["min"] = metric.Min()
+		values["max"] = metric.Max()
+		values["mean"] = metric.Mean()
+		values["stddev"] = metric.StdDev()
+		values["median"] = ps[0]
+		values["75%"] = ps[1]
+		values["95%"] = ps[2]
+		values["99%"] = ps[3]
+		values["99.9%"] = ps[4]
+		values["percentiles"] = percentileValues(percentiles, ps)
+		values["1m.rate"] = metric.Rate1()
+		values["5m.rate"] = metric.Rate5()
+		values["15m.rate"] = metric.Rate15()
+		values["mean.rate"] = metric.RateMean()
+	case TopK:
+		top := make(map[string]int64)
+		for _, tc := range metric.Top() {
+			top[tc.Key] = tc.Count
 		}
-		data[name] = values
+		values["top"] = top
+	}
+	return values
+}
+
+// percentileValues maps each quantile, formatted as in "0.99", to its value.
+func percentileValues(percentiles, ps []float64) map[string]float64 {
+	values := make(map[string]float64, len(ps))
+	for i, p := range ps {
+		values[strconv.FormatFloat(percentiles[i], 'g', -1, 64)] = p
+	}
+	return values
+}
+
+// JSONHandler returns an http.Handler which serves every metric in r as JSON,
+// as encoded by MarshalJSON, so that its values can be inspected with curl
+// between flushes.
+func JSONHandler(r Registry) http.Handler {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, err := MarshalJSON(r)
+		if nil != err {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(append(b, '\n'))
 	})
-	return json.Marshal(data)
 }
 
 // WriteJSON writes metrics from the given registry  periodically to the
@@ -79,5 +143,9 @@ func WriteJSON(r Registry, d time.Duration, w io.Writer) {
 // WriteJSONOnce writes metrics from the given registry to the specified
 // io.Writer as JSON.
 func WriteJSONOnce(r Registry, w io.Writer) {
-	json.NewEncoder(w).Encode(r)
+	b, err := MarshalJSON(r)
+	if nil != err {
+		return
+	}
+	w.Write(append(b, '\n'))
 }
@@ -3,7 +3,10 @@ package metrics
 import (
 	"bytes"
 	"encoding/json"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestRegistryMarshallJSON(t *testing.T) {
@@ -26,3 +29,85 @@ func TestRegistryWriteJSONOnce(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestMarshalJSONRoundTrip(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterCounter("counter", r).Inc(47)
+	GetOrRegisterGauge("gauge", r).Update(-3)
+	h := GetOrRegisterHistogram("histogram", r, NewUniformSample(100))
+	tm := GetOrRegisterTimer("timer", r)
+	for i := 1; i <= 4; i++ {
+		h.Update(int64(i))
+		tm.Update(time.Duration(i))
+	}
+	// Any Registry has the same representation as the StandardRegistry.
+	b, err := MarshalJSON(NewPrefixedChildRegistry(r, ""))
+	if nil != err {
+		t.Fatal(err)
+	}
+	var data map[string]map[string]interface{}
+	if err := json.Unmarshal(b, &data); nil != err {
+		t.Fatal(err)
+	}
+	if v := data["counter"]["count"]; 47.0 != v {
+		t.Errorf(`counter count: 47 != %v`, v)
+	}
+	if v := data["gauge"]["value"]; -3.0 != v {
+		t.Errorf(`gauge value: -3 != %v`, v)
+	}
+	for _, name := range []string{"histogram", "timer"} {
+		values := data[name]
+		if 4.0 != values["count"] || 1.0 != values["min"] || 4.0 != values["max"] || 2.5 != values["mean"] {
+			t.Errorf("%s: %v", name, values)
+		}
+		ps, _ := values["percentiles"].(map[string]interface{})
+		if 5 != len(ps) || 2.5 != ps["0.5"] || 4.0 != ps["0.999"] {
+			t.Errorf("%s percentiles: %v", name, ps)
+		}
+	}
+	if b2, _ := r.(*StandardRegistry).MarshalJSON(); !bytes.Equal(b, b2) {
+		t.Errorf("%s != %s", b, b2)
+	}
+}
+
+func TestJSONHandler(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredMeter("meter", r).Mark(3)
+	NewRegisteredTimer("timer", r).Update(time.Millisecond)
+	w := httptest.NewRecorder()
+	JSONHandler(r).ServeHTTP(w, httptest.NewRequest("GET", "/debug/metrics.json", nil))
+	if ct := w.Header().Get("Content-Type"); "application/json; charset=utf-8" != ct {
+		t.Error(ct)
+	}
+	var data map[string]map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); nil != err {
+		t.Fatal(err)
+	}
+	if count := data["meter"]["count"]; 3.0 != count {
+		t.Errorf("meter count: 3 != %v", count)
+	}
+	if _, ok := data["meter"]["1m.rate"]; !ok {
+		t.Error("meter rate missing")
+	}
+	if p99 := data["timer"]["99%"]; 1e6 != p99 {
+		t.Errorf("timer 99%%: 1e6 != %v", p99)
+	}
+}
+
+func TestMarshalJSONMeterWithWindows(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredMeterWithWindows("m", r, 10*time.Second)
+	if _, ok := GetAll(r)["m"]["10s.rate"]; !ok {
+		t.Error(GetAll(r))
+	}
+}
+
+func TestMarshalJSONBucketedHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := GetOrRegisterBucketedHistogram("latency", r, NewUniformSample(100), []int64{100})
+	h.Update(47)
+	h.Update(1000)
+	if b, _ := MarshalJSON(r); !strings.Contains(string(b), `"buckets":{"+Inf":2,"100":1}`) {
+		t.Errorf("%s", b)
+	}
+}
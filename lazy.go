@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"reflect"
+)
+
+// LazyRegistry is a Registry which can get or register a metric given a
+// function constructing it, without the reflection GetOrRegister uses to
+// call a constructor and without constructing a metric only to discard it.
+// GetOrRegisterLazy falls back to GetOrRegister for other Registries.
+type LazyRegistry interface {
+	Registry
+
+	// Gets an existing metric or registers the one returned by the given
+	// function, which is only called if none is registered under the name.
+	GetOrRegisterLazy(string, func() interface{}) interface{}
+}
+
+// GetOrRegisterLazy returns the metric registered in r under the given name,
+// or else registers and returns the one f returns, calling f only then.
+func GetOrRegisterLazy(name string, r Registry, f func() interface{}) interface{} {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	if lr, ok := r.(LazyRegistry); ok {
+		return lr.GetOrRegisterLazy(name, f)
+	}
+	return r.GetOrRegister(name, f)
+}
+
+// GetOrRegisterCounterLazy returns an existing Counter or registers the one f
+// returns.
+func GetOrRegisterCounterLazy(name string, r Registry, f func() Counter) Counter {
+	return getOrRegisterTyped(name, r, reflect.TypeOf((*Counter)(nil)).Elem(), func() interface{} { return f() }).(Counter)
+}
+
+// GetOrRegisterGaugeLazy returns an existing Gauge or registers the one f
+// returns.
+func GetOrRegisterGaugeLazy(name string, r Registry, f func() Gauge) Gauge {
+	return getOrRegisterTyped(name, r, reflect.TypeOf((*Gauge)(nil)).Elem(), func() interface{} { return f() }).(Gauge)
+}
+
+// GetOrRegisterGaugeFloat64Lazy returns an existing GaugeFloat64 or registers
+// the one f returns.
+func GetOrRegisterGaugeFloat64Lazy(name string, r Registry, f func() GaugeFloat64) GaugeFloat64 {
+	return getOrRegisterTyped(name, r, reflect.TypeOf((*GaugeFloat64)(nil)).Elem(), func() interface{} { return f() }).(GaugeFloat64)
+}
+
+// GetOrRegisterHistogramLazy returns an existing Histogram or registers the
+// one f returns, so that its Sample is only allocated when it's new.
+func GetOrRegisterHistogramLazy(name string, r Registry, f func() Histogram) Histogram {
+	return getOrRegisterTyped(name, r, reflect.TypeOf((*Histogram)(nil)).Elem(), func() interface{} { return f() }).(Histogram)
+}
+
+// GetOrRegisterMeterLazy returns an existing Meter or registers the one f
+// returns, so that no Meter is started with the arbiter only to be dropped.
+func GetOrRegisterMeterLazy(name string, r Registry, f func() Meter) Meter {
+	return getOrRegisterTyped(name, r, reflect.TypeOf((*Meter)(nil)).Elem(), func() interface{} { return f() }).(Meter)
+}
+
+// GetOrRegisterTimerLazy returns an existing Timer or registers the one f
+// returns.
+func GetOrRegisterTimerLazy(name string, r Registry, f func() Timer) Timer {
+	return getOrRegisterTyped(name, r, reflect.TypeOf((*Timer)(nil)).Elem(), func() interface{} { return f() }).(Timer)
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import "testing"
+
+func BenchmarkGetOrRegisterLazy(b *testing.B) {
+	r := NewRegistry()
+	f := func() Timer { return NewTimer() }
+	GetOrRegisterTimerLazy("foo", r, f)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		GetOrRegisterTimerLazy("foo", r, f)
+	}
+}
+
+func TestGetOrRegisterLazy(t *testing.T) {
+	r := NewRegistry()
+	calls := 0
+	f := func() Counter { calls++; return NewCounter() }
+	c := GetOrRegisterCounterLazy("foo", r, f)
+	c.Inc(47)
+	if c2 := GetOrRegisterCounterLazy("foo", r, f); c != c2 || 47 != c2.Count() {
+		t.Fatalf("GetOrRegisterCounterLazy returned %v, not the registered %v", c2, c)
+	}
+	if 1 != calls {
+		t.Fatalf("constructor called %d times, not once", calls)
+	}
+}
+
+func TestGetOrRegisterLazyChildRegistries(t *testing.T) {
+	r := NewRegistry()
+	calls := 0
+	f := func() Meter { calls++; return NewMeter() }
+	for _, child := range []Registry{
+		NewPrefixedChildRegistry(r, "prefix."),
+		NewTaggedRegistry(r, map[string]string{"k": "v"}),
+		NewMergedRegistry(r),
+	} {
+		m := GetOrRegisterMeterLazy("foo", child, f)
+		if m2 := GetOrRegisterMeterLazy("foo", child, f); m != m2 {
+			t.Errorf("%T: GetOrRegisterMeterLazy returned %v, not the registered %v", child, m2, m)
+		}
+	}
+	if 3 != calls {
+		t.Errorf("constructor called %d times, not once per child", calls)
+	}
+	if nil == r.Get("prefix.foo") || nil == r.Get(TaggedName("foo", map[string]string{"k": "v"})) || nil == r.Get("foo") {
+		t.Errorf("metrics not registered in the parent under the child names")
+	}
+	r.UnregisterAll()
+}
+
+func TestGetOrRegisterLazyUnlazyRegistry(t *testing.T) {
+	r := struct{ Registry }{NewRegistry()}
+	calls := 0
+	f := func() Gauge { calls++; return NewGauge() }
+	GetOrRegisterGaugeLazy("foo", r, f)
+	GetOrRegisterGaugeLazy("foo", r, f)
+	if 1 != calls {
+		t.Fatalf("constructor called %d times, not once", calls)
+	}
+}
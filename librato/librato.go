@@ -88,7 +88,7 @@ func (self *Reporter) BuildRequest(now time.Time, r metrics.Registry) (snapshot
 	snapshot.Gauges = make([]Measurement, 0)
 	snapshot.Counters = make([]Measurement, 0)
 	histogramGaugeCount := 1 + len(self.Percentiles)
-	r.Each(func(name string, metric interface{}) {
+	r.Snapshot().Each(func(name string, metric interface{}) {
 		if self.Namespace != "" {
 			name = fmt.Sprintf("%s.%s", self.Namespace, name)
 		}
@@ -16,7 +16,7 @@ func LogScaled(r Registry, freq time.Duration, scale time.Duration, l *log.Logge
 	duSuffix := scale.String()[1:]
 
 	for _ = range time.Tick(freq) {
-		r.Each(func(name string, i interface{}) {
+		r.Snapshot().Each(func(name string, i interface{}) {
 			switch metric := i.(type) {
 			case Counter:
 				l.Printf("counter %s\n", name)
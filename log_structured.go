@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"strings"
+	"time"
+)
+
+// StructuredLogger is a logger of messages with key/value fields, such as
+// *slog.Logger.  Loggers with methods of other names, such as the Infow of
+// zap's SugaredLogger, can be given as a StructuredLoggerFunc.
+type StructuredLogger interface {
+	Info(msg string, keyvals ...interface{})
+}
+
+// StructuredLoggerFunc is an adapter to allow the use of ordinary functions
+// as StructuredLoggers.
+type StructuredLoggerFunc func(msg string, keyvals ...interface{})
+
+// Info calls f(msg, keyvals...).
+func (f StructuredLoggerFunc) Info(msg string, keyvals ...interface{}) {
+	f(msg, keyvals...)
+}
+
+// LogStructuredConfig provides a container with configuration parameters
+// for LogStructuredWithConfig
+type LogStructuredConfig struct {
+	Registry      Registry         // Registry to be logged
+	FlushInterval time.Duration    // Flush interval
+	DurationUnit  time.Duration    // Time conversion unit for durations, defaults to nanoseconds
+	Logger        StructuredLogger // Logger to log each metric to
+	Percentiles   []float64        // Percentiles of histograms and timers to log, defaults to 0.5, 0.75, 0.95, 0.99 and 0.999 if nil, none if empty
+}
+
+// LogStructured logs each metric in the given registry periodically to the
+// given structured logger.  See LogStructuredOnce.
+func LogStructured(r Registry, freq time.Duration, scale time.Duration, l StructuredLogger) {
+	LogStructuredWithConfig(LogStructuredConfig{
+		Registry:      r,
+		FlushInterval: freq,
+		DurationUnit:  scale,
+		Logger:        l,
+	})
+}
+
+// LogStructuredWithConfig is a blocking exporter function just like
+// LogStructured, but it takes a LogStructuredConfig instead.
+func LogStructuredWithConfig(c LogStructuredConfig) {
+	for _ = range time.Tick(c.FlushInterval) {
+		LogStructuredOnceWithConfig(c)
+	}
+}
+
+// LogStructuredOnce logs each metric in the given registry to the given
+// structured logger, one "metric" message per metric with its name, type
+// and values as fields, such as name=requests type=counter count=47.
+// Timings are in scale units (eg time.Millisecond) rather than nanos.
+func LogStructuredOnce(r Registry, scale time.Duration, l StructuredLogger) {
+	LogStructuredOnceWithConfig(LogStructuredConfig{
+		Registry:     r,
+		DurationUnit: scale,
+		Logger:       l,
+	})
+}
+
+// LogStructuredOnceWithConfig is like LogStructuredOnce but takes a
+// LogStructuredConfig.  The median of histograms and timers is logged as
+// median and the other percentiles by their digits, as p99 for 0.99.
+func LogStructuredOnceWithConfig(c LogStructuredConfig) {
+	scale := c.DurationUnit
+	if 0 == scale {
+		scale = time.Nanosecond
+	}
+	du := float64(scale)
+	percentiles := percentilesOrDefault(c.Percentiles)
+	keys := make([]string, len(percentiles))
+	for i, p := range percentiles {
+		if 0.5 == p {
+			keys[i] = "median"
+		} else {
+			keys[i] = "p" + strings.TrimSuffix(percentileSuffix(p), "-percentile")
+		}
+	}
+	l := c.Logger
+	c.Registry.Snapshot().Each(func(name string, i interface{}) {
+		switch metric := i.(type) {
+		case Counter:
+			l.Info("metric", "name", name, "type", "counter", "count", metric.Count())
+		case Gauge:
+			l.Info("metric", "name", name, "type", "gauge", "value", metric.Value())
+		case GaugeFloat64:
+			l.Info("metric", "name", name, "type", "gauge", "value", metric.Value())
+		case Healthcheck:
+			metric.Check()
+			var err interface{}
+			if nil != metric.Error() {
+				err = metric.Error().Error()
+			}
+			l.Info("metric", "name", name, "type", "healthcheck", "error", err)
+		case Histogram:
+			h := metric.Snapshot()
+			ps := h.Percentiles(percentiles)
+			keyvals := []interface{}{"name", name, "type", "histogram",
+				"count", h.Count(),
+				"min", h.Min(),
+				"max", h.Max(),
+				"mean", h.Mean(),
+				"stddev", h.StdDev(),
+			}
+			for i, p := range ps {
+				keyvals = append(keyvals, keys[i], p)
+			}
+			l.Info("metric", keyvals...)
+		case Meter:
+			m := metric.Snapshot()
+			l.Info("metric", "name", name, "type", "meter",
+				"count", m.Count(),
+				"rate1", m.Rate1(),
+				"rate5", m.Rate5(),
+				"rate15", m.Rate15(),
+				"rate_mean", m.RateMean(),
+			)
+		case Timer:
+			t := metric.Snapshot()
+			ps := t.Percentiles(percentiles)
+			keyvals := []interface{}{"name", name, "type", "timer",
+				"count", t.Count(),
+				"min", float64(t.Min()) / du,
+				"max", float64(t.Max()) / du,
+				"mean", t.Mean() / du,
+				"stddev", t.StdDev() / du,
+			}
+			for i, p := range ps {
+				keyvals = append(keyvals, keys[i], p/du)
+			}
+			l.Info("metric", append(keyvals,
+				"rate1", t.Rate1(),
+				"rate5", t.Rate5(),
+				"rate15", t.Rate15(),
+				"rate_mean", t.RateMean(),
+				"unit", scale.String()[1:],
+			)...)
+		}
+	})
+}
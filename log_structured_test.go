@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLogStructuredOnce(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredCounter("requests", r).Inc(47)
+	r.Register("db", NewHealthcheck(func(h Healthcheck) { h.Unhealthy(errors.New("down")) }))
+	NewRegisteredTimer("latency", r).Update(3 * time.Millisecond)
+	records := make(map[string][]interface{})
+	LogStructuredOnce(r, time.Millisecond, StructuredLoggerFunc(func(msg string, keyvals ...interface{}) {
+		if "metric" != msg || len(keyvals) < 2 || "name" != keyvals[0] {
+			t.Fatal(msg, keyvals)
+		}
+		if 0 != len(keyvals)%2 {
+			t.Errorf("odd keyvals: %v", keyvals)
+		}
+		records[keyvals[1].(string)] = keyvals
+	}))
+	if kv := records["requests"]; !reflect.DeepEqual([]interface{}{"name", "requests", "type", "counter", "count", int64(47)}, kv) {
+		t.Error(kv)
+	}
+	if kv := records["db"]; !reflect.DeepEqual([]interface{}{"name", "db", "type", "healthcheck", "error", "down"}, kv) {
+		t.Error(kv)
+	}
+	if kv := records["latency"]; "max" != kv[8] || 3.0 != kv[9] || "ms" != kv[len(kv)-1] {
+		t.Error(kv)
+	}
+}
+
+func TestLogStructuredOnceWithConfigPercentiles(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredTimer("latency", r).Update(3 * time.Millisecond)
+	h := NewRegisteredHistogram("sizes", r, NewUniformSample(10))
+	h.Update(7)
+	records := make(map[string][]interface{})
+	logger := StructuredLoggerFunc(func(msg string, keyvals ...interface{}) {
+		records[keyvals[1].(string)] = keyvals
+	})
+	LogStructuredOnceWithConfig(LogStructuredConfig{
+		Registry:     r,
+		DurationUnit: time.Millisecond,
+		Logger:       logger,
+		Percentiles:  []float64{0.5, 0.9},
+	})
+	if kv := records["sizes"]; !reflect.DeepEqual([]interface{}{"median", 7.0, "p90", 7.0}, kv[14:]) {
+		t.Error(kv)
+	}
+	if kv := records["latency"]; !reflect.DeepEqual([]interface{}{"median", 3.0, "p90", 3.0, "rate1"}, kv[14:19]) {
+		t.Error(kv)
+	}
+	LogStructuredOnceWithConfig(LogStructuredConfig{
+		Registry:    r,
+		Logger:      logger,
+		Percentiles: []float64{},
+	})
+	if kv := records["sizes"]; 14 != len(kv) {
+		t.Error(kv)
+	}
+	if kv := records["latency"]; "rate1" != kv[14] || "ns" != kv[len(kv)-1] {
+		t.Error(kv)
+	}
+}
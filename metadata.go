@@ -0,0 +1,188 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// MetricKinds tell backends how the values of a metric should be aggregated.
+type MetricKind int
+
+const (
+	KindUnknown      MetricKind = iota // Inferred from the type of the metric
+	KindMonotonic                      // Accumulates over time, like a Counter
+	KindGauge                          // Instantaneous level, like a Gauge
+	KindDistribution                   // Summary of observations, like a Histogram
+)
+
+// String returns the name of the kind as used by exporters.
+func (k MetricKind) String() string {
+	switch k {
+	case KindMonotonic:
+		return "monotonic"
+	case KindGauge:
+		return "gauge"
+	case KindDistribution:
+		return "distribution"
+	}
+	return "unknown"
+}
+
+// InferKind returns the MetricKind implied by the type of i.
+func InferKind(i interface{}) MetricKind {
+	switch i.(type) {
+	case Counter, Meter:
+		return KindMonotonic
+	case Gauge, GaugeFloat64, Healthcheck, TopK:
+		return KindGauge
+	case Histogram, Timer:
+		return KindDistribution
+	}
+	return KindUnknown
+}
+
+// Metadata describes a registered metric so that exporters can choose the
+// right encoding for it without per-backend configuration.
+type Metadata struct {
+	Kind        MetricKind // Defaults to the kind inferred from the metric's type
+	Unit        string     // Unit of the metric's values, e.g. "ms" or "bytes"
+	Description string     // Human-readable description of what is measured
+	Aggregation string     // Preferred rollup of the metric's series, e.g. "sum", defaults to one inferred from its kind
+
+	// SampleRate is the fraction, between 0 and 1, of events which are
+	// actually recorded by the metric, for backends such as StatsD which
+	// scale counts accordingly.  Zero means every event is recorded.  The
+	// metric itself always holds the raw count of recorded events; it is
+	// never scaled up to estimate the unsampled total.  Backends without a
+	// notion of sampling ignore it.
+	SampleRate float64
+}
+
+// Tagged is implemented by metrics which carry tags of their own, such as
+// endpoint=/login on a timer of requests to one endpoint.  Exporters merge
+// them with their global tags, the metric's tags winning where both have the
+// same key.
+type Tagged interface {
+	Tags() map[string]string
+}
+
+// MetadataRegistry is implemented by Registries which can store Metadata
+// alongside their metrics, as StandardRegistry and PrefixedRegistry do.
+type MetadataRegistry interface {
+	Registry
+
+	// Get the metadata attached to the metric by the given name.
+	Metadata(string) (Metadata, bool)
+
+	// Attach metadata to the metric by the given name.
+	SetMetadata(string, Metadata)
+}
+
+// GetMetadata returns the metadata attached to the metric by the given name,
+// with its Kind inferred from the type of the metric if none was attached.
+func GetMetadata(r Registry, name string) Metadata {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return metadata(r, name, r.Get(name))
+}
+
+// RegisterWithMetadata registers the given metric under the given name and
+// attaches md to it.  The metadata is discarded if r is not a
+// MetadataRegistry.
+func RegisterWithMetadata(r Registry, name string, i interface{}, md Metadata) error {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	if md.SampleRate < 0 || 1 < md.SampleRate {
+		return fmt.Errorf("sample rate of %s out of range: %v", name, md.SampleRate)
+	}
+	if err := r.Register(name, i); nil != err {
+		return err
+	}
+	if mr, ok := r.(MetadataRegistry); ok {
+		mr.SetMetadata(name, md)
+	}
+	return nil
+}
+
+// MetadataHandler returns an http.HandlerFunc which describes every metric
+// in r, with its tags if it's Tagged, as a JSON array sorted by name.
+// Unlike the registry's own JSON encoding it reports the schema of each
+// metric rather than its values, for tools which generate documentation and
+// dashboards.
+func MetadataHandler(r Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		type description struct {
+			Name        string            `json:"name"`
+			Type        string            `json:"type"`
+			Kind        string            `json:"kind"`
+			Unit        string            `json:"unit,omitempty"`
+			Description string            `json:"description,omitempty"`
+			SampleRate  float64           `json:"sample_rate,omitempty"`
+			Aggregation string            `json:"aggregation,omitempty"`
+			Tags        map[string]string `json:"tags,omitempty"`
+		}
+		var namedMetrics namedMetricSlice
+		r.Each(func(name string, i interface{}) {
+			namedMetrics = append(namedMetrics, namedMetric{name, i})
+		})
+		sort.Sort(namedMetrics)
+		descriptions := make([]description, 0, len(namedMetrics))
+		for _, nm := range namedMetrics {
+			md := metadata(r, nm.name, nm.m)
+			var tags map[string]string
+			if t, ok := nm.m.(Tagged); ok {
+				tags = t.Tags()
+			}
+			descriptions = append(descriptions, description{
+				Name:        nm.name,
+				Type:        typeName(nm.m),
+				Kind:        md.Kind.String(),
+				Unit:        md.Unit,
+				Description: md.Description,
+				SampleRate:  md.SampleRate,
+				Aggregation: md.Aggregation,
+				Tags:        tags,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(descriptions)
+	}
+}
+
+// typeName returns the name of the type of metric i as used in the output
+// of WriteOnce.
+func typeName(i interface{}) string {
+	switch i.(type) {
+	case Counter:
+		return "counter"
+	case Gauge, GaugeFloat64:
+		return "gauge"
+	case Healthcheck:
+		return "healthcheck"
+	case Histogram:
+		return "histogram"
+	case Meter:
+		return "meter"
+	case Timer:
+		return "timer"
+	case TopK:
+		return "topk"
+	}
+	return "unknown"
+}
+
+// metadata returns the metadata for the metric i registered under name.
+func metadata(r Registry, name string, i interface{}) Metadata {
+	var md Metadata
+	if mr, ok := r.(MetadataRegistry); ok {
+		md, _ = mr.Metadata(name)
+	}
+	if KindUnknown == md.Kind {
+		md.Kind = InferKind(i)
+	}
+	return md
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetMetadataInfersKind(t *testing.T) {
+	r := NewRegistry()
+	r.Register("counter", NewCounter())
+	r.Register("gauge", NewGauge())
+	r.Register("histogram", NewHistogram(NewUniformSample(100)))
+	r.Register("timer", NewTimer())
+	for name, kind := range map[string]MetricKind{
+		"counter":   KindMonotonic,
+		"gauge":     KindGauge,
+		"histogram": KindDistribution,
+		"timer":     KindDistribution,
+		"missing":   KindUnknown,
+	} {
+		if md := GetMetadata(r, name); kind != md.Kind {
+			t.Errorf("%s: %v != %v", name, kind, md.Kind)
+		}
+	}
+}
+
+func TestRegisterWithMetadata(t *testing.T) {
+	r := NewRegistry()
+	if err := RegisterWithMetadata(r, "foo", NewGauge(), Metadata{Kind: KindMonotonic}); nil != err {
+		t.Fatal(err)
+	}
+	if md := GetMetadata(r, "foo"); KindMonotonic != md.Kind {
+		t.Fatal(md)
+	}
+	if err := RegisterWithMetadata(r, "foo", NewGauge(), Metadata{}); nil == err {
+		t.Fatal(err)
+	}
+	r.Unregister("foo")
+	if _, ok := r.(MetadataRegistry).Metadata("foo"); ok {
+		t.Fatal("metadata survived Unregister")
+	}
+}
+
+func TestPrefixedRegistryMetadata(t *testing.T) {
+	r := NewRegistry()
+	pr := NewPrefixedChildRegistry(r, "prefix.")
+	RegisterWithMetadata(pr, "foo", NewGauge(), Metadata{Kind: KindMonotonic})
+	if md := GetMetadata(r, "prefix.foo"); KindMonotonic != md.Kind {
+		t.Fatal(md)
+	}
+	if md := GetMetadata(pr, "foo"); KindMonotonic != md.Kind {
+		t.Fatal(md)
+	}
+}
+
+func TestOpenTSDBDatapointKind(t *testing.T) {
+	r := NewRegistry()
+	r.Register("counter", NewCounter())
+	RegisterWithMetadata(r, "gauge", NewGauge(), Metadata{Kind: KindMonotonic})
+	ct := NewChannelTransport(1)
+	openTSDB(context.Background(), &OpenTSDBConfig{Registry: r, Transport: ct})
+	for _, p := range <-ct.C {
+		if KindMonotonic != p.Kind {
+			t.Errorf("%s: %v", p.Metric, p.Kind)
+		}
+	}
+}
+
+func TestRegisterWithMetadataSampleRate(t *testing.T) {
+	r := NewRegistry()
+	if err := RegisterWithMetadata(r, "foo", NewCounter(), Metadata{SampleRate: 1.5}); nil == err {
+		t.Fatal(err)
+	}
+	if nil != r.Get("foo") {
+		t.Fatal(r.Get("foo"))
+	}
+	RegisterWithMetadata(r, "foo", NewCounter(), Metadata{SampleRate: 0.1})
+	ct := NewChannelTransport(1)
+	openTSDB(context.Background(), &OpenTSDBConfig{Registry: r, Transport: ct})
+	if p := (<-ct.C)[0]; 0.1 != p.SampleRate {
+		t.Fatal(p)
+	}
+}
+
+func TestMetadataHandler(t *testing.T) {
+	r := NewRegistry()
+	RegisterWithMetadata(r, "requests", NewTimer(), Metadata{Unit: "ns", Description: "Request latency"})
+	r.Register("errors", NewCounter())
+	GetOrRegisterTopK("clients", r, 10, time.Minute)
+	GetOrRegisterTaggedCounter("hits", map[string]string{"route": "/"}, r)
+	w := httptest.NewRecorder()
+	MetadataHandler(r)(w, httptest.NewRequest("GET", "/metadata", nil))
+	want := `[{"name":"clients","type":"topk","kind":"gauge"},` +
+		`{"name":"errors","type":"counter","kind":"monotonic"},` +
+		`{"name":"hits{route=/}","type":"counter","kind":"monotonic","tags":{"route":"/"}},` +
+		`{"name":"requests","type":"timer","kind":"distribution","unit":"ns","description":"Request latency"}]` + "\n"
+	if s := w.Body.String(); want != s {
+		t.Fatal(s)
+	}
+}
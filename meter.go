@@ -1,12 +1,19 @@
 package metrics
 
 import (
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Meters count events to produce exponentially-weighted moving average rates
 // at one-, five-, and fifteen-minutes and a mean rate.
+//
+// A stopped Meter silently ignores Mark, so that the arbiter can let go of
+// it.  Registries stop the meters unregistered or replaced in them, so a
+// reference kept across Unregister stops counting; get the Meter from the
+// registry anew instead.
 type Meter interface {
 	Count() int64
 	Mark(int64)
@@ -14,7 +21,9 @@ type Meter interface {
 	Rate5() float64
 	Rate15() float64
 	RateMean() float64
+	Reset()
 	Snapshot() Meter
+	Stop()
 }
 
 // GetOrRegisterMeter returns an existing Meter or constructs and registers a
@@ -32,31 +41,101 @@ func NewMeter() Meter {
 		return NilMeter{}
 	}
 	m := newStandardMeter()
-	arbiter.Lock()
-	defer arbiter.Unlock()
-	arbiter.meters = append(arbiter.meters, m)
-	if !arbiter.started {
-		arbiter.started = true
-		go arbiter.tick()
+	arbiter.add(m)
+	return m
+}
+
+// NewMeterWithClock constructs a new StandardMeter which reads the time from
+// c.  Rather than being ticked by a goroutine, its moving averages catch up
+// on the ticks due whenever it's marked or read, so that a MockClock decays
+// them deterministically.
+func NewMeterWithClock(c Clock) Meter {
+	if UseNilMetrics {
+		return NilMeter{}
 	}
+	m := newStandardMeter()
+	m.clock = c
+	m.startTime = c.Now()
+	m.ticked, m.lazy = m.startTime, true
 	return m
 }
 
-// NewMeter constructs and registers a new StandardMeter and launches a
-// goroutine.
+// NewRegisteredMeter constructs and registers a new StandardMeter and launches
+// a goroutine, or returns the Meter already registered under the given name.
 func NewRegisteredMeter(name string, r Registry) Meter {
-	c := NewMeter()
+	return GetOrRegisterMeter(name, r)
+}
+
+// GetOrRegisterMeterWithWindows returns an existing Meter or constructs and
+// registers a new StandardMeter with moving averages over the given windows.
+func GetOrRegisterMeterWithWindows(name string, r Registry, windows ...time.Duration) Meter {
 	if nil == r {
 		r = DefaultRegistry
 	}
-	r.Register(name, c)
-	return c
+	return r.GetOrRegister(name, func() Meter { return NewMeterWithWindows(windows...) }).(Meter)
+}
+
+// NewMeterWithWindows constructs a new StandardMeter which, besides the one-,
+// five- and fifteen-minute moving averages, keeps one over each of the given
+// windows, such as ten seconds for autoscaling decisions, and launches a
+// goroutine.  A window of zero keeps the rate over the latest five-second
+// tick alone.  The rates are reported by WindowRates.
+func NewMeterWithWindows(windows ...time.Duration) Meter {
+	if UseNilMetrics {
+		return NilMeter{}
+	}
+	m := newStandardMeter()
+	m.windows = windows
+	m.ewmas = newWindowEWMAs(windows)
+	m.snapshot.windows = windows
+	m.snapshot.rates = make([]float64, len(windows))
+	arbiter.add(m)
+	return m
+}
+
+// NewRegisteredMeterWithWindows constructs and registers a new StandardMeter
+// with moving averages over the given windows and launches a goroutine, or
+// returns the Meter already registered under the given name.
+func NewRegisteredMeterWithWindows(name string, r Registry, windows ...time.Duration) Meter {
+	return GetOrRegisterMeterWithWindows(name, r, windows...)
+}
+
+// WindowedMeter is a Meter with moving averages over windows of the user's
+// choosing, which exporters report with series named by their windows, such
+// as rate-10s.
+type WindowedMeter interface {
+	Meter
+	WindowRates() []WindowRate
+}
+
+// WindowRate is a moving average rate of events per second over a window.
+type WindowRate struct {
+	Window time.Duration
+	Rate   float64
+}
+
+// Name returns the name of the window in series, such as 10s, 2m or 1h, or
+// instant for the rate over the latest tick.
+func (wr WindowRate) Name() string {
+	if wr.Window <= 0 {
+		return "instant"
+	}
+	s := wr.Window.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
 }
 
 // MeterSnapshot is a read-only copy of another Meter.
 type MeterSnapshot struct {
 	count                          int64
 	rate1, rate5, rate15, rateMean float64
+	windows                        []time.Duration
+	rates                          []float64 // Over each of windows
 }
 
 // Count returns the count of events at the time the snapshot was taken.
@@ -83,9 +162,30 @@ func (m *MeterSnapshot) Rate15() float64 { return m.rate15 }
 // snapshot was taken.
 func (m *MeterSnapshot) RateMean() float64 { return m.rateMean }
 
+// Reset panics.
+func (*MeterSnapshot) Reset() {
+	panic("Reset called on a MeterSnapshot")
+}
+
 // Snapshot returns the snapshot.
 func (m *MeterSnapshot) Snapshot() Meter { return m }
 
+// Stop is a no-op.
+func (*MeterSnapshot) Stop() {}
+
+// WindowRates returns the moving average rates over the meter's windows at
+// the time the snapshot was taken.
+func (m *MeterSnapshot) WindowRates() []WindowRate {
+	if 0 == len(m.windows) {
+		return nil
+	}
+	wrs := make([]WindowRate, len(m.windows))
+	for i, w := range m.windows {
+		wrs[i] = WindowRate{Window: w, Rate: m.rates[i]}
+	}
+	return wrs
+}
+
 // NilMeter is a no-op Meter.
 type NilMeter struct{}
 
@@ -107,17 +207,32 @@ func (NilMeter) Rate15() float64 { return 0.0 }
 // RateMean is a no-op.
 func (NilMeter) RateMean() float64 { return 0.0 }
 
+// Reset is a no-op.
+func (NilMeter) Reset() {}
+
 // Snapshot is a no-op.
 func (NilMeter) Snapshot() Meter { return NilMeter{} }
 
+// Stop is a no-op.
+func (NilMeter) Stop() {}
+
 // StandardMeter is the standard implementation of a Meter.
 type StandardMeter struct {
 	lock        sync.RWMutex
 	snapshot    *MeterSnapshot
 	a1, a5, a15 EWMA
+	windows     []time.Duration
+	ewmas       []EWMA // Over each of windows
 	startTime   time.Time
+	stopped     uint32    // Set atomically by Stop
+	clock       Clock     // Source of the time
+	ticked      time.Time // Time of the latest tick caught up on, if lazy
+	lazy        bool      // Catches up on ticks when marked or read rather than ticked by the arbiter
 }
 
+// meterTickInterval is the interval at which meters' moving averages tick.
+const meterTickInterval = 5 * time.Second
+
 func newStandardMeter() *StandardMeter {
 	return &StandardMeter{
 		snapshot:  &MeterSnapshot{},
@@ -125,30 +240,40 @@ func newStandardMeter() *StandardMeter {
 		a5:        NewEWMA5(),
 		a15:       NewEWMA15(),
 		startTime: time.Now(),
+		clock:     systemClock{},
 	}
 }
 
 // Count returns the number of events recorded.
 func (m *StandardMeter) Count() int64 {
+	m.catchUp()
 	m.lock.RLock()
 	count := m.snapshot.count
 	m.lock.RUnlock()
 	return count
 }
 
-// Mark records the occurance of n events.
+// Mark records the occurance of n events, unless the meter is stopped.
 func (m *StandardMeter) Mark(n int64) {
+	if 1 == atomic.LoadUint32(&m.stopped) {
+		return
+	}
+	m.catchUp()
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	m.snapshot.count += n
 	m.a1.Update(n)
 	m.a5.Update(n)
 	m.a15.Update(n)
+	for _, a := range m.ewmas {
+		a.Update(n)
+	}
 	m.updateSnapshot()
 }
 
 // Rate1 returns the one-minute moving average rate of events per second.
 func (m *StandardMeter) Rate1() float64 {
+	m.catchUp()
 	m.lock.RLock()
 	rate1 := m.snapshot.rate1
 	m.lock.RUnlock()
@@ -157,6 +282,7 @@ func (m *StandardMeter) Rate1() float64 {
 
 // Rate5 returns the five-minute moving average rate of events per second.
 func (m *StandardMeter) Rate5() float64 {
+	m.catchUp()
 	m.lock.RLock()
 	rate5 := m.snapshot.rate5
 	m.lock.RUnlock()
@@ -165,6 +291,7 @@ func (m *StandardMeter) Rate5() float64 {
 
 // Rate15 returns the fifteen-minute moving average rate of events per second.
 func (m *StandardMeter) Rate15() float64 {
+	m.catchUp()
 	m.lock.RLock()
 	rate15 := m.snapshot.rate15
 	m.lock.RUnlock()
@@ -173,46 +300,148 @@ func (m *StandardMeter) Rate15() float64 {
 
 // RateMean returns the meter's mean rate of events per second.
 func (m *StandardMeter) RateMean() float64 {
+	m.catchUp()
 	m.lock.RLock()
 	rateMean := m.snapshot.rateMean
 	m.lock.RUnlock()
 	return rateMean
 }
 
+// Reset zeroes the count and restarts the moving averages and mean rate as
+// if the meter had just been constructed.
+func (m *StandardMeter) Reset() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.snapshot = &MeterSnapshot{windows: m.windows, rates: make([]float64, len(m.windows))}
+	m.a1 = NewEWMA1()
+	m.a5 = NewEWMA5()
+	m.a15 = NewEWMA15()
+	m.ewmas = newWindowEWMAs(m.windows)
+	m.startTime = m.clock.Now()
+	m.ticked = m.startTime
+}
+
 // Snapshot returns a read-only copy of the meter.
 func (m *StandardMeter) Snapshot() Meter {
+	m.catchUp()
 	m.lock.RLock()
 	snapshot := *m.snapshot
+	if 0 < len(snapshot.rates) {
+		snapshot.rates = append([]float64(nil), snapshot.rates...)
+	}
 	m.lock.RUnlock()
 	return &snapshot
 }
 
+// Stop stops the meter's moving averages ticking, so that the arbiter lets
+// go of it and it can be garbage collected, and makes it silently ignore
+// later marks, which are lost.  Registries stop the meters unregistered or
+// replaced in them.
+func (m *StandardMeter) Stop() {
+	if atomic.CompareAndSwapUint32(&m.stopped, 0, 1) {
+		arbiter.remove(m)
+	}
+}
+
+// WindowRates returns the moving average rates over the meter's windows.
+func (m *StandardMeter) WindowRates() []WindowRate {
+	return m.Snapshot().(*MeterSnapshot).WindowRates()
+}
+
 func (m *StandardMeter) updateSnapshot() {
 	// should run with write lock held on m.lock
 	snapshot := m.snapshot
 	snapshot.rate1 = m.a1.Rate()
 	snapshot.rate5 = m.a5.Rate()
 	snapshot.rate15 = m.a15.Rate()
-	snapshot.rateMean = float64(snapshot.count) / time.Since(m.startTime).Seconds()
+	for i, a := range m.ewmas {
+		snapshot.rates[i] = a.Rate()
+	}
+	snapshot.rateMean = float64(snapshot.count) / m.clock.Now().Sub(m.startTime).Seconds()
 }
 
 func (m *StandardMeter) tick() {
 	m.lock.Lock()
 	defer m.lock.Unlock()
+	m.tickEWMAs()
+	m.updateSnapshot()
+}
+
+// catchUp ticks the moving averages of a meter built with a Clock once for
+// each tick due since the latest.
+func (m *StandardMeter) catchUp() {
+	if !m.lazy {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := m.clock.Now()
+	if now.Sub(m.ticked) < meterTickInterval {
+		return
+	}
+	for meterTickInterval <= now.Sub(m.ticked) {
+		m.tickEWMAs()
+		m.ticked = m.ticked.Add(meterTickInterval)
+	}
+	m.updateSnapshot()
+}
+
+// tickEWMAs ticks the moving averages.  It should run with m.lock held.
+func (m *StandardMeter) tickEWMAs() {
 	m.a1.Tick()
 	m.a5.Tick()
 	m.a15.Tick()
-	m.updateSnapshot()
+	for _, a := range m.ewmas {
+		a.Tick()
+	}
+}
+
+func newWindowEWMAs(windows []time.Duration) []EWMA {
+	if 0 == len(windows) {
+		return nil
+	}
+	ewmas := make([]EWMA, len(windows))
+	for i, w := range windows {
+		ewmas[i] = NewEWMAWithWindow(w)
+	}
+	return ewmas
+}
+
+// meterTicker is a meter which the arbiter ticks.
+type meterTicker interface {
+	tick()
 }
 
 type meterArbiter struct {
 	sync.RWMutex
 	started bool
-	meters  []*StandardMeter
+	meters  map[meterTicker]struct{}
 	ticker  *time.Ticker
 }
 
-var arbiter = meterArbiter{ticker: time.NewTicker(5e9)}
+var arbiter = meterArbiter{
+	meters: make(map[meterTicker]struct{}),
+	ticker: time.NewTicker(meterTickInterval),
+}
+
+// add ticks m along with the other meters, starting the arbiter's goroutine
+// if it isn't running yet.
+func (ma *meterArbiter) add(m meterTicker) {
+	ma.Lock()
+	defer ma.Unlock()
+	ma.meters[m] = struct{}{}
+	if !ma.started {
+		ma.started = true
+		go ma.tick()
+	}
+}
+
+// remove stops ticking m.
+func (ma *meterArbiter) remove(m meterTicker) {
+	ma.Lock()
+	defer ma.Unlock()
+	delete(ma.meters, m)
+}
 
 // Ticks meters on the scheduled interval
 func (ma *meterArbiter) tick() {
@@ -227,7 +456,7 @@ func (ma *meterArbiter) tick() {
 func (ma *meterArbiter) tickMeters() {
 	ma.RLock()
 	defer ma.RUnlock()
-	for _, meter := range ma.meters {
+	for meter := range ma.meters {
 		meter.tick()
 	}
 }
@@ -0,0 +1,106 @@
+package metrics
+
+import "sync/atomic"
+
+// GetOrRegisterShardedMeter returns an existing Meter or constructs and
+// registers a new ShardedMeter.
+func GetOrRegisterShardedMeter(name string, r Registry) Meter {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return r.GetOrRegister(name, NewShardedMeter).(Meter)
+}
+
+// NewShardedMeter constructs a new ShardedMeter and launches a goroutine.
+func NewShardedMeter() Meter {
+	if UseNilMetrics {
+		return NilMeter{}
+	}
+	m := &ShardedMeter{meter: newStandardMeter(), pending: newShardedCounter()}
+	arbiter.add(m)
+	return m
+}
+
+// NewRegisteredShardedMeter constructs and registers a new ShardedMeter and
+// launches a goroutine, or returns the Meter already registered under the
+// given name.
+func NewRegisteredShardedMeter(name string, r Registry) Meter {
+	return GetOrRegisterShardedMeter(name, r)
+}
+
+// ShardedMeter is a Meter for meters marked by many goroutines at once.
+// Marks are added to a ShardedCounter rather than taking the lock of a
+// StandardMeter, which is given them all at once when the meter is read or
+// ticked.
+type ShardedMeter struct {
+	meter   *StandardMeter
+	pending *ShardedCounter // Events marked since they were last given to meter
+}
+
+// Count returns the number of events recorded.
+func (m *ShardedMeter) Count() int64 {
+	m.flush()
+	return m.meter.Count()
+}
+
+// Mark records the occurance of n events.
+func (m *ShardedMeter) Mark(n int64) {
+	m.pending.Inc(n)
+}
+
+// Rate1 returns the one-minute moving average rate of events per second.
+func (m *ShardedMeter) Rate1() float64 {
+	m.flush()
+	return m.meter.Rate1()
+}
+
+// Rate5 returns the five-minute moving average rate of events per second.
+func (m *ShardedMeter) Rate5() float64 {
+	m.flush()
+	return m.meter.Rate5()
+}
+
+// Rate15 returns the fifteen-minute moving average rate of events per second.
+func (m *ShardedMeter) Rate15() float64 {
+	m.flush()
+	return m.meter.Rate15()
+}
+
+// RateMean returns the meter's mean rate of events per second.
+func (m *ShardedMeter) RateMean() float64 {
+	m.flush()
+	return m.meter.RateMean()
+}
+
+// Reset zeroes the count and restarts the moving averages and mean rate as
+// if the meter had just been constructed.
+func (m *ShardedMeter) Reset() {
+	m.pending.Clear()
+	m.meter.Reset()
+}
+
+// Stop stops the meter's moving averages ticking and makes it ignore later
+// marks, as StandardMeter.Stop does.
+func (m *ShardedMeter) Stop() {
+	if atomic.CompareAndSwapUint32(&m.meter.stopped, 0, 1) {
+		arbiter.remove(m)
+	}
+}
+
+// Snapshot returns a read-only copy of the meter.
+func (m *ShardedMeter) Snapshot() Meter {
+	m.flush()
+	return m.meter.Snapshot()
+}
+
+// flush gives the events marked since the last flush to the meter.
+func (m *ShardedMeter) flush() {
+	if n := m.pending.CountAndClear(); 0 != n {
+		m.meter.Mark(n)
+	}
+}
+
+func (m *ShardedMeter) tick() {
+	m.flush()
+	m.meter.tick()
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"sync"
+	"testing"
+)
+
+// BenchmarkMeterParallel and BenchmarkShardedMeterParallel compare meters
+// marked by every processor at once.
+func BenchmarkMeterParallel(b *testing.B) {
+	benchmarkMeterParallel(b, NewMeter())
+}
+
+func BenchmarkShardedMeterParallel(b *testing.B) {
+	benchmarkMeterParallel(b, NewShardedMeter())
+}
+
+func benchmarkMeterParallel(b *testing.B, m Meter) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.Mark(1)
+		}
+	})
+}
+
+func TestShardedMeter(t *testing.T) {
+	m := NewShardedMeter()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				m.Mark(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if count := m.Count(); 8000 != count {
+		t.Errorf("m.Count(): 8000 != %v\n", count)
+	}
+	m.Mark(2)
+	if snapshot := m.Snapshot(); 8002 != snapshot.Count() || 0 == snapshot.RateMean() {
+		t.Errorf("snapshot: %v %v\n", snapshot.Count(), snapshot.RateMean())
+	}
+	m.Mark(3)
+	m.Reset()
+	if count := m.Count(); 0 != count {
+		t.Errorf("m.Count(): 0 != %v\n", count)
+	}
+}
+
+func TestShardedMeterDecay(t *testing.T) {
+	m := &ShardedMeter{meter: newStandardMeter(), pending: newShardedCounter()}
+	m.Mark(1)
+	m.tick()
+	rateMean := m.RateMean()
+	if rate1 := m.Rate1(); 0.2 != rate1 {
+		t.Errorf("m.Rate1(): 0.2 != %v\n", rate1)
+	}
+	m.tick()
+	if m.RateMean() >= rateMean {
+		t.Errorf("m.RateMean(): %v >= %v\n", m.RateMean(), rateMean)
+	}
+}
+
+func TestGetOrRegisterShardedMeter(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredShardedMeter("foo", r).Mark(47)
+	if m := GetOrRegisterShardedMeter("foo", r); 47 != m.Count() {
+		t.Fatal(m)
+	}
+}
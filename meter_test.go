@@ -22,11 +22,11 @@ func TestGetOrRegisterMeter(t *testing.T) {
 }
 
 func TestMeterDecay(t *testing.T) {
+	m := newStandardMeter()
 	ma := meterArbiter{
+		meters: map[meterTicker]struct{}{m: {}},
 		ticker: time.NewTicker(time.Millisecond),
 	}
-	m := newStandardMeter()
-	ma.meters = append(ma.meters, m)
 	go ma.tick()
 	m.Mark(1)
 	rateMean := m.RateMean()
@@ -36,6 +36,38 @@ func TestMeterDecay(t *testing.T) {
 	}
 }
 
+func TestMeterStop(t *testing.T) {
+	m := NewMeter().(*StandardMeter)
+	m.Mark(1)
+	m.Stop()
+	arbiter.RLock()
+	_, ticked := arbiter.meters[m]
+	arbiter.RUnlock()
+	if ticked {
+		t.Error("arbiter still ticks the stopped meter")
+	}
+	m.Mark(1)
+	if count := m.Count(); 1 != count {
+		t.Errorf("m.Count(): 1 != %v\n", count)
+	}
+	m.Stop()
+}
+
+func TestMeterMarkAfterUnregister(t *testing.T) {
+	r := NewRegistry()
+	m := GetOrRegisterMeter("foo", r)
+	m.Mark(1)
+	r.Unregister("foo")
+	m.Mark(1)
+	if count := m.Count(); 1 != count {
+		t.Errorf("m.Count(): 1 != %v\n", count)
+	}
+	if m := GetOrRegisterMeter("foo", r); 0 != m.Count() {
+		t.Errorf("new m.Count(): 0 != %v\n", m.Count())
+	}
+	r.UnregisterAll()
+}
+
 func TestMeterNonzero(t *testing.T) {
 	m := NewMeter()
 	m.Mark(3)
@@ -58,3 +90,65 @@ func TestMeterZero(t *testing.T) {
 		t.Errorf("m.Count(): 0 != %v\n", count)
 	}
 }
+
+func TestMeterReset(t *testing.T) {
+	m := newStandardMeter()
+	m.Mark(10)
+	m.tick()
+	m.Reset()
+	if count := m.Count(); 0 != count {
+		t.Errorf("m.Count(): 0 != %v\n", count)
+	}
+	if rate1 := m.Rate1(); 0.0 != rate1 {
+		t.Errorf("m.Rate1(): 0.0 != %v\n", rate1)
+	}
+	if rateMean := m.RateMean(); 0.0 != rateMean {
+		t.Errorf("m.RateMean(): 0.0 != %v\n", rateMean)
+	}
+	m.Mark(5)
+	m.tick()
+	if count := m.Count(); 5 != count {
+		t.Errorf("m.Count(): 5 != %v\n", count)
+	}
+	if rate1 := m.Rate1(); 1.0 != rate1 {
+		t.Errorf("m.Rate1(): 1.0 != %v\n", rate1)
+	}
+}
+
+func TestMeterWithWindows(t *testing.T) {
+	m := NewMeterWithWindows(10*time.Second, 0).(*StandardMeter)
+	m.Mark(10)
+	m.tick()
+	snapshot := m.Snapshot().(WindowedMeter)
+	wrs := snapshot.WindowRates()
+	if 2 != len(wrs) || 10*time.Second != wrs[0].Window || "10s" != wrs[0].Name() || "instant" != wrs[1].Name() {
+		t.Fatalf("wrs: %v", wrs)
+	}
+	if 2 != wrs[1].Rate || 2 != wrs[0].Rate {
+		t.Errorf("rates: %v %v", wrs[0].Rate, wrs[1].Rate)
+	}
+	m.tick()
+	if wrs := m.WindowRates(); 0 != wrs[1].Rate || wrs[0].Rate <= 0 || 2 <= wrs[0].Rate {
+		t.Errorf("rates after an idle tick: %v", wrs)
+	}
+	if 2 != snapshot.WindowRates()[1].Rate {
+		t.Error("snapshot changed")
+	}
+	m.Reset()
+	if wrs := m.WindowRates(); 2 != len(wrs) || 0 != wrs[0].Rate {
+		t.Errorf("rates after reset: %v", wrs)
+	}
+}
+
+func TestWindowRateName(t *testing.T) {
+	for window, name := range map[time.Duration]string{
+		1500 * time.Millisecond: "1.5s",
+		90 * time.Second:        "1m30s",
+		2 * time.Minute:         "2m",
+		time.Hour:               "1h",
+	} {
+		if n := (WindowRate{Window: window}).Name(); name != n {
+			t.Errorf("%v: %q != %q", window, name, n)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"time"
+)
+
+// NewRelicEndpoint is the default URL of New Relic's Metric API.
+const NewRelicEndpoint = "https://metric-api.newrelic.com/metric/v1"
+
+// NewRelicConfig provides a container with configuration parameters for
+// the New Relic exporter.
+type NewRelicConfig struct {
+	Registry      Registry          // Registry to be exported
+	FlushInterval time.Duration     // Flush interval
+	DurationUnit  time.Duration     // Time conversion unit for durations, defaults to nanoseconds
+	Prefix        string            // Prefix to be prepended to metric names
+	Tags          map[string]string // Attributes common to every metric
+	APIKey        string            // Insert or license key of the New Relic account
+	Endpoint      string            // Metric API URL, defaults to NewRelicEndpoint
+	Client        *http.Client      // HTTP client, defaults to http.DefaultClient
+	Filter        func(string) bool // Metrics whose names it returns false for aren't exported, all are if nil
+}
+
+// NewRelic is a blocking exporter function which reports the metrics in
+// c.Registry to New Relic's Metric API every c.FlushInterval.  Counters and
+// meters are reported as count metrics of their change since the previous
+// flush, gauges as gauge metrics and histograms and timers as summary
+// metrics.  A summary's count and sum are the changes in the histogram's
+// count and sum since the previous flush, but its min and max are those of
+// the histogram's whole sample.  The tags of Tagged metrics become their
+// attributes.
+// Healthchecks aren't reported.
+func NewRelic(c NewRelicConfig) {
+	if err := NewRelicContext(context.Background(), c); nil != err {
+		log.Println(err)
+	}
+}
+
+// NewRelicContext is like NewRelic but returns once ctx is done, after a
+// final flush which is given one FlushInterval to complete.  Errors from
+// flushes other than the final one are logged.  An error is returned at once
+// if c.FlushInterval isn't positive.
+func NewRelicContext(ctx context.Context, c NewRelicConfig) error {
+	e, err := newNewRelicExporter(c)
+	if nil != err {
+		return err
+	}
+	return FlushLoopWithConfig(ctx, FlushLoopConfig{
+		Registry:      c.Registry,
+		FlushInterval: c.FlushInterval,
+		Exporter:      e,
+	})
+}
+
+// NewNewRelicExporter constructs a new Exporter which flushes the registry
+// it's given to New Relic's Metric API as configured by c, so that it can
+// be run by FlushLoop or be a sink of a FanoutExporter.  c.Registry, which
+// it ignores, needn't be set.
+func NewNewRelicExporter(c NewRelicConfig) (Exporter, error) {
+	if nil == c.Registry {
+		c.Registry = DefaultRegistry
+	}
+	return newNewRelicExporter(c)
+}
+
+// newRelicExporter holds the state of a New Relic exporter between flushes.
+type newRelicExporter struct {
+	config NewRelicConfig
+	counts map[string]int64 // Counts at the last successful flush
+	sums   map[string]int64 // Sums of histograms and timers at the last successful flush
+	last   time.Time        // Time of the last successful flush
+}
+
+func newNewRelicExporter(c NewRelicConfig) (*newRelicExporter, error) {
+	if nil == c.Registry {
+		return nil, errors.New("newrelic: config has no Registry to export")
+	}
+	if "" == c.APIKey {
+		return nil, errors.New("newrelic: config has no APIKey")
+	}
+	if "" == c.Endpoint {
+		c.Endpoint = NewRelicEndpoint
+	}
+	if nil == c.Client {
+		c.Client = http.DefaultClient
+	}
+	if 0 == c.DurationUnit {
+		c.DurationUnit = time.Nanosecond
+	}
+	return &newRelicExporter{config: c, counts: make(map[string]int64), sums: make(map[string]int64)}, nil
+}
+
+// newRelicMetric is a single metric in a Metric API request.
+type newRelicMetric struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	Value      interface{}       `json:"value"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// newRelicSummary is the value of a summary metric.
+type newRelicSummary struct {
+	Count int64   `json:"count"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+// Export flushes r, for FlushLoop.
+func (e *newRelicExporter) Export(r Registry) error {
+	return e.ExportContext(context.Background(), r)
+}
+
+// ExportContext flushes r, for FlushLoop.
+func (e *newRelicExporter) ExportContext(ctx context.Context, r Registry) error {
+	e.config.Registry = r
+	return e.flush(ctx, time.Now())
+}
+
+// flush sends every metric in the registry as one batch stamped with now.
+// Count and summary metrics need a previous flush to be measured against, so
+// the first flush only reports gauges.
+func (e *newRelicExporter) flush(ctx context.Context, now time.Time) error {
+	metrics, counts, sums := e.collect()
+	common := map[string]interface{}{
+		"timestamp": now.UnixNano() / int64(time.Millisecond),
+	}
+	if 0 < len(e.config.Tags) {
+		common["attributes"] = e.config.Tags
+	}
+	if !e.last.IsZero() {
+		common["interval.ms"] = int64(now.Sub(e.last) / time.Millisecond)
+	}
+	body, err := json.Marshal([]map[string]interface{}{{
+		"common":  common,
+		"metrics": metrics,
+	}})
+	if nil != err {
+		return err
+	}
+	req, err := http.NewRequest("POST", e.config.Endpoint, bytes.NewReader(body))
+	if nil != err {
+		return err
+	}
+	req.Header.Set("Api-Key", e.config.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.config.Client.Do(req.WithContext(ctx))
+	if nil != err {
+		return err
+	}
+	defer resp.Body.Close()
+	if http.StatusAccepted != resp.StatusCode {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("newrelic: %s: %s", resp.Status, msg)
+	}
+	e.counts, e.sums, e.last = counts, sums, now
+	return nil
+}
+
+// collect reads every metric in the registry, returning them along with the
+// counts and sums that count and summary metrics were measured from.
+func (e *newRelicExporter) collect() ([]newRelicMetric, map[string]int64, map[string]int64) {
+	du := float64(e.config.DurationUnit)
+	tagged := make(map[string]map[string]string)
+	exported := make(map[string]string)
+	e.config.Registry.Each(func(name string, i interface{}) {
+		if t, ok := i.(Tagged); ok {
+			tagged[name] = t.Tags()
+		}
+		exported[name] = exportName(name, i)
+	})
+	var metrics []newRelicMetric
+	counts, sums := make(map[string]int64), make(map[string]int64)
+	var key string // Registry name of the metric being collected
+	put := func(typ string, value interface{}) {
+		name, ok := exported[key]
+		if !ok {
+			name = key
+		}
+		if "" != e.config.Prefix {
+			name = e.config.Prefix + "." + name
+		}
+		metrics = append(metrics, newRelicMetric{Name: name, Type: typ, Value: value, Attributes: tagged[key]})
+	}
+	count := func(n int64) {
+		counts[key] = n
+		if prev, ok := e.counts[key]; ok && !e.last.IsZero() && n >= prev {
+			put("count", n-prev)
+		}
+	}
+	gauge := func(v float64) {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			v = 0
+		}
+		put("gauge", v)
+	}
+	summary := func(h interface {
+		Count() int64
+		Max() int64
+		Mean() float64
+		Min() int64
+		Sum() int64
+	}, scale float64) {
+		n, sum := h.Count()-e.counts[key], h.Sum()-e.sums[key]
+		counts[key], sums[key] = h.Count(), h.Sum()
+		if 0 >= n || e.last.IsZero() {
+			return
+		}
+		put("summary", newRelicSummary{
+			Count: n,
+			Sum:   float64(sum) / scale,
+			Min:   float64(h.Min()) / scale,
+			Max:   float64(h.Max()) / scale,
+		})
+	}
+	e.config.Registry.Snapshot().Each(func(name string, i interface{}) {
+		key = name
+		if n, ok := exported[key]; ok {
+			name = n
+		}
+		if nil != e.config.Filter && !e.config.Filter(name) {
+			return
+		}
+		switch metric := i.(type) {
+		case Counter:
+			count(metric.Count())
+		case Gauge:
+			gauge(float64(metric.Value()))
+		case GaugeFloat64:
+			gauge(metric.Value())
+		case Histogram:
+			summary(metric, 1)
+		case Meter:
+			count(metric.Count())
+		case Timer:
+			summary(metric, du)
+		}
+	})
+	return metrics, counts, sums
+}
@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewRelic(t *testing.T) {
+	var batches []map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if "secret" != r.Header.Get("Api-Key") {
+			t.Errorf("Api-Key: %q", r.Header.Get("Api-Key"))
+		}
+		var batch []map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&batch); nil != err {
+			t.Fatal(err)
+		}
+		batches = append(batches, batch[0])
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	r := NewRegistry()
+	c := NewCounter()
+	r.Register("c", c)
+	r.Register("g", NewGauge())
+	tm := NewTimer()
+	r.Register("t", tm)
+	e, _ := newNewRelicExporter(NewRelicConfig{
+		Registry:     r,
+		DurationUnit: time.Millisecond,
+		Prefix:       "p",
+		Tags:         map[string]string{"dc": "east"},
+		APIKey:       "secret",
+		Endpoint:     ts.URL,
+	})
+	now := time.Unix(1000, 0)
+	c.Inc(40)
+	tm.Update(10 * time.Millisecond)
+	if err := e.flush(context.Background(), now); nil != err {
+		t.Fatal(err)
+	}
+	c.Inc(7)
+	tm.Update(2 * time.Millisecond)
+	tm.Update(4 * time.Millisecond)
+	if err := e.flush(context.Background(), now.Add(10*time.Second)); nil != err {
+		t.Fatal(err)
+	}
+	if 2 != len(batches) {
+		t.Fatalf("batches: %v", batches)
+	}
+
+	if metrics := batches[0]["metrics"].([]interface{}); 1 != len(metrics) {
+		t.Errorf("first flush: %v", metrics)
+	}
+	common := batches[1]["common"].(map[string]interface{})
+	if 10000.0 != common["interval.ms"] || 1010000.0 != common["timestamp"] {
+		t.Errorf("common: %v", common)
+	}
+	if "east" != common["attributes"].(map[string]interface{})["dc"] {
+		t.Errorf("attributes: %v", common["attributes"])
+	}
+	metrics := make(map[string]map[string]interface{})
+	for _, m := range batches[1]["metrics"].([]interface{}) {
+		m := m.(map[string]interface{})
+		metrics[m["name"].(string)] = m
+	}
+	if m := metrics["p.c"]; "count" != m["type"] || 7.0 != m["value"] {
+		t.Errorf("p.c: %v", m)
+	}
+	if m := metrics["p.g"]; "gauge" != m["type"] {
+		t.Errorf("p.g: %v", m)
+	}
+	m := metrics["p.t"]
+	if "summary" != m["type"] {
+		t.Fatalf("p.t: %v", m)
+	}
+	summary := m["value"].(map[string]interface{})
+	if 2.0 != summary["count"] || 6.0 != summary["sum"] || 2.0 != summary["min"] || 10.0 != summary["max"] {
+		t.Errorf("p.t: %v", summary)
+	}
+}
+
+func TestNewRelicRejected(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("bad key"))
+	}))
+	defer ts.Close()
+
+	r := NewRegistry()
+	c := NewCounter()
+	r.Register("c", c)
+	e, _ := newNewRelicExporter(NewRelicConfig{Registry: r, APIKey: "wrong", Endpoint: ts.URL})
+	err := e.flush(context.Background(), time.Now())
+	if nil == err || "newrelic: 403 Forbidden: bad key" != err.Error() {
+		t.Errorf("err: %v", err)
+	}
+	if !e.last.IsZero() {
+		t.Error("rejected flush was recorded")
+	}
+}
+
+func TestNewRelicNoAPIKey(t *testing.T) {
+	if _, err := newNewRelicExporter(NewRelicConfig{Registry: NewRegistry()}); nil == err {
+		t.Error("expected an error")
+	}
+}
+
+func TestNewRelicContext(t *testing.T) {
+	requests := make(chan struct{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+	r := NewRegistry()
+	GetOrRegisterGauge("g", r).Update(47)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := NewRelicContext(ctx, NewRelicConfig{
+		Registry:      r,
+		FlushInterval: time.Hour,
+		APIKey:        "secret",
+		Endpoint:      ts.URL,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	select {
+	case <-requests:
+	default:
+		t.Error("no final flush")
+	}
+	if err := NewRelicContext(ctx, NewRelicConfig{Registry: r, APIKey: "secret", Endpoint: ts.URL}); nil == err {
+		t.Error("no error for a zero FlushInterval")
+	}
+}
+
+func TestNewRelicFilter(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterGauge("keep", r).Update(1)
+	GetOrRegisterGauge("drop", r).Update(2)
+	GetOrRegisterTaggedGauge("drop", map[string]string{"k": "v"}, r).Update(3)
+	e, _ := newNewRelicExporter(NewRelicConfig{
+		Registry: r,
+		APIKey:   "secret",
+		Filter:   func(name string) bool { return "drop" != name },
+	})
+	if metrics, _, _ := e.collect(); 1 != len(metrics) || "keep" != metrics[0].Name {
+		t.Errorf("%+v", metrics)
+	}
+}
@@ -2,11 +2,22 @@ package metrics
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net"
+	"net/http"
 	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,13 +27,184 @@ var shortHostName string = ""
 // the OpenTSDB exporter
 type OpenTSDBConfig struct {
 	Addr          *net.TCPAddr      // Network address to connect to
+	TLSConfig     *tls.Config       // TLS configuration for connections to Addr and MetaURL, plaintext if nil
+	DialTimeout   time.Duration     // Limit on connecting to Addr, unlimited if zero
+	WriteTimeout  time.Duration     // Limit on writing each flush to Addr, unlimited if zero
+	BufferSize    int               // Size of the write buffer, defaults to that of bufio; one holding a whole flush sends it in one write
 	Registry      Registry          // Registry to be exported
 	FlushInterval time.Duration     // Flush interval
-	DurationUnit  time.Duration     // Time conversion unit for durations
+	DurationUnit  time.Duration     // Time conversion unit for durations, defaults to nanoseconds
 	Prefix        string            // Prefix to be prepended to metric names
 	Tags          map[string]string // Allows tags to be added in form of key=value
+	Transport     Transport         // Destination for each flush, defaults to a TelnetTransport to Addr, or a UDPTransport if Network is udp
+	RateGauges    []string          // Gauges which also report their change per second as a rate series
+	Percentiles   []float64         // Percentiles of histograms and timers to report, defaults to 0.5, 0.75, 0.95, 0.99 and 0.999 if nil, none if empty
+	ErrorHandler  func(error)       // Called with each flush error, defaults to log.Println
+	Filter        func(string) bool // Metrics whose names it returns false for aren't exported, all are if nil
+	SanitizeNames bool              // Replace characters OpenTSDB rejects in names and tags with underscores
+
+	// HealthcheckErrorTags tags the healthy series of each unhealthy
+	// Healthcheck with its error, with the characters OpenTSDB rejects
+	// replaced by underscores, as in error=connection_refused, so that
+	// alerts can say why.  Healthchecks aren't checked by the exporter;
+	// run them with Registry.RunHealthchecks.
+	HealthcheckErrorTags bool
+
+	// Sanitizer maps each metric name, tag key and tag value, including
+	// the host tag and HealthcheckErrorTags' errors, before it is written,
+	// as SanitizeNames does with underscores, for those who would rather
+	// map colons to dots, say.  It must return only characters OpenTSDB
+	// allows.  SanitizeNames is ignored if it's set.
+	Sanitizer func(string) string
+
+	// MaxBatchSize, InterBatchDelay and ReadErrors configure the default
+	// TelnetTransport to split each flush into batches and read the errors
+	// the server reports between them.  See TelnetTransport.
+	MaxBatchSize    int
+	InterBatchDelay time.Duration
+	ReadErrors      bool
+
+	// Network is "tcp", the default, or "udp" to send the default
+	// transport's puts as datagrams of at most MaxPacketSize bytes to a
+	// UDPTransport at Addr, for relays which accept them.  TLSConfig and
+	// the batching and timeout options don't apply to UDP.
+	Network       string
+	MaxPacketSize int
+
+	// MetaURL is the base URL of the OpenTSDB HTTP API, e.g.
+	// "http://localhost:4242".  If set, the Description of each metric is
+	// pushed to the API as time series metadata the first time the metric
+	// is exported, so that it appears in the OpenTSDB UI.  Requests are
+	// authenticated with MetaAuth, and made over connections configured by
+	// TLSConfig if it is set.  The put protocol has no authentication of
+	// its own; use a client certificate in TLSConfig to authenticate.
+	MetaURL  string
+	MetaAuth HTTPAuth
+
+	// SampleMatch and SampleSize cap the volume of high-cardinality
+	// metrics.  Of the metrics whose names match SampleMatch, only
+	// SampleSize are exported each flush, rotating through them in name
+	// order so that every one is exported within a few flushes.  The
+	// fraction exported is reported as the opentsdb.sample.fraction series
+	// so that consumers can scale by it.
+	SampleMatch *regexp.Regexp
+	SampleSize  int
+
+	// AggregationTags adds an agg tag to every datapoint naming the
+	// aggregation, such as sum or avg, with which its series should be
+	// rolled up.  It is taken from the metric's Metadata or else inferred
+	// from its kind and the series.
+	AggregationTags bool
+
+	// MillisecondTimestamps stamps datapoints with the Unix time in
+	// milliseconds, which OpenTSDB 2.x accepts, rather than in seconds, so
+	// that sub-second flush intervals don't lose resolution.
+	MillisecondTimestamps bool
+
+	// ResetCounters takes the count of each Counter off it as it is
+	// exported, so that each flush reports the increments since the
+	// previous one.  Other metrics are unaffected.  The count is read from
+	// the live counter rather than the flush's snapshot and taken off with
+	// StandardCounter.CountAndClear, or by decrementing other Counters by
+	// the count read rather than clearing them, so that no increment made
+	// between reading and resetting a counter is lost.
+	ResetCounters bool
+
+	// ResetOnFlush clears each histogram and timer as it is exported, so
+	// that its count, min, max, mean and percentiles describe only the
+	// values recorded since the previous flush rather than the whole
+	// history its Sample retains.  As with ResetCounters, the live metric
+	// is read rather than the flush's snapshot, and it's read and cleared
+	// as one operation by the SnapshotAndClear methods of the standard
+	// histograms and timers when their Samples are UniformSamples or
+	// ExpDecaySamples.  Values recorded between reading and clearing other
+	// histograms and timers are lost.  Only timers' histograms are cleared;
+	// their rates carry on.
+	ResetOnFlush bool
+
+	// StartupDelay suppresses flushes for this long after the exporter
+	// starts, while meters are warming up and histograms are empty, so
+	// that cold-start values don't trigger alerts.  Zero flushes from the
+	// start.
+	StartupDelay time.Duration
+
+	// AlignFlush delays the first flush until the next multiple of
+	// FlushInterval on the wall clock, so that hosts flushing every 10s all
+	// stamp their datapoints at :00, :10, :20 and so on.  Each flush is
+	// stamped with the multiple it began after, so that the latency of the
+	// flush doesn't show in MillisecondTimestamps, unless a flush was
+	// already stamped with it.  The cost is that every aligned host
+	// connects to the server in the same instant.
+	AlignFlush bool
+
+	// Jitter delays each flush by a random duration up to this long, so
+	// that hosts started together spread out their connections to the
+	// server rather than aligning them.  It can't be combined with
+	// AlignFlush.  Zero flushes as soon as each FlushInterval ends.
+	Jitter time.Duration
+
+	// MaxRetries is how many times a flush which the transport fails to
+	// send is retried, after sleeping RetryBackoff and then twice as long
+	// before each further retry.  Sleeps are cut short so that the retries
+	// of a flush end before the next flush is due, and when the context of
+	// OpenTSDBWithConfigContext is done.  The datapoints collected for the
+	// flush are sent again rather than collected anew.  Zero doesn't retry.
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	// RetryQueueSize keeps up to that many of the datapoints of flushes
+	// which failed even after their retries, dropping the oldest beyond
+	// it, and sends them again ahead of the next flush's so that an
+	// outage of a few flushes loses nothing.  Zero keeps none.
+	RetryQueueSize int
+
+	// SelfMetrics records the exporter's flushes in the Registry, as with
+	// FlushLoopConfig.SelfMetrics.
+	SelfMetrics bool
+
+	// PrefixIntervals exports the metrics whose names begin with each
+	// prefix every that long rather than every FlushInterval, so that
+	// slow-moving gauges, say, can be written every 5m while latency
+	// timers are every 10s.  The longest matching prefix applies.  A
+	// metric is exported by the first flush and then by the first flush
+	// at least its interval, less half a FlushInterval, after the last
+	// which exported it.  Counters and histograms reset by ResetCounters or
+	// ResetOnFlush are reset only when they're exported.
+	PrefixIntervals map[string]time.Duration
+
+	// DeltaCounters reports the count of each Counter and Meter as its
+	// increase since the flush which last exported it, without resetting
+	// it as ResetCounters does, so that rates computed by OpenTSDB don't
+	// break when a process restarts.  The first flush, and any after a
+	// count falls, reports the whole count.  ResetCounters takes
+	// precedence for Counters.
+	DeltaCounters bool
+
+	// SkipUnchanged doesn't report the series of a Counter, Gauge or
+	// GaugeFloat64 whose value is the one last reported, until MaxStaleness
+	// has passed since, so that registries of static or slow-moving values
+	// don't rewrite them every flush.  Counts reported as increases, by
+	// ResetCounters or DeltaCounters, are skipped only while they stay zero.
+	// Zero MaxStaleness reports a series again only once it changes.
+	SkipUnchanged bool
+	MaxStaleness  time.Duration
+
+	// ExportRawSamples also reports the values in the Sample of each
+	// histogram and timer as the sample series, timers' in DurationUnit,
+	// for consumers computing quantiles of their own.  Each value is tagged
+	// with its index in the sample, since OpenTSDB keeps only one value per
+	// series and timestamp.  At most MaxRawSamples values of each sample
+	// are reported, defaulting to defaultMaxRawSamples if zero.
+	ExportRawSamples bool
+	MaxRawSamples    int
+
+	Clock Clock // Source of flush times, ticks and retry backoffs, defaults to the system clock
 }
 
+// defaultMaxRawSamples is the number of values of each Sample reported if
+// ExportRawSamples is set without a MaxRawSamples.
+const defaultMaxRawSamples = 100
+
 // OpenTSDB is a blocking exporter function which reports metrics in r
 // to a TSDB server located at addr, flushing them every d duration
 // and prepending metric names with prefix.
@@ -38,13 +220,358 @@ func OpenTSDB(r Registry, d time.Duration, prefix string, addr *net.TCPAddr, tag
 }
 
 // OpenTSDBWithConfig is a blocking exporter function just like OpenTSDB,
-// but it takes a OpenTSDBConfig instead.
+// but it takes a OpenTSDBConfig instead.  If the config is missing its
+// Registry, or both its Addr and Transport, the error is passed to the
+// ErrorHandler and OpenTSDBWithConfig returns immediately.
 func OpenTSDBWithConfig(c OpenTSDBConfig) {
-	for _ = range time.Tick(c.FlushInterval) {
-		if err := openTSDB(&c); nil != err {
-			log.Println(err)
+	if err := OpenTSDBWithConfigContext(context.Background(), c); nil != err {
+		c.handleError(err)
+	}
+}
+
+// OpenTSDBWithConfigContext is like OpenTSDBWithConfig but returns once ctx
+// is done, after a final flush so that nothing recorded since the previous
+// flush is lost.  The final flush is given one FlushInterval to complete.
+// Errors from flushes other than the final one are passed to the
+// ErrorHandler.
+func OpenTSDBWithConfigContext(ctx context.Context, c OpenTSDBConfig) error {
+	e, err := newOpenTSDBExporter(c)
+	if nil != err {
+		return err
+	}
+	defer e.close()
+	if c.AlignFlush {
+		aligned, stop := after(e.config.Clock, untilAligned(e.config.Clock.Now(), c.FlushInterval))
+		select {
+		case <-aligned:
+			stop()
+		case <-ctx.Done():
+			stop()
+			return e.finalFlush()
+		}
+		if err := e.flush(ctx); nil != err {
+			c.handleError(err)
+		}
+	}
+	return FlushLoopWithConfig(ctx, FlushLoopConfig{
+		Registry:      c.Registry,
+		FlushInterval: c.FlushInterval,
+		Exporter:      e,
+		Jitter:        c.Jitter,
+		ErrorHandler:  c.handleError,
+		SelfMetrics:   c.SelfMetrics,
+		Clock:         e.config.Clock,
+	})
+}
+
+// NewOpenTSDBExporter constructs a new Exporter which flushes the registry
+// it's given to OpenTSDB as configured by c, so that it can be run by
+// FlushLoop or be a sink of a FanoutExporter.  c.Registry, which it
+// ignores, needn't be set.  Retries of a flush are bounded by
+// c.FlushInterval if it's set.
+func NewOpenTSDBExporter(c OpenTSDBConfig) (Exporter, error) {
+	if nil == c.Registry {
+		c.Registry = DefaultRegistry
+	}
+	return newOpenTSDBExporter(c)
+}
+
+// handleError passes err to the ErrorHandler, or logs it if there is none.
+func (c *OpenTSDBConfig) handleError(err error) {
+	if nil != c.ErrorHandler {
+		c.ErrorHandler(err)
+	} else {
+		log.Println(err)
+	}
+}
+
+// OpenTSDBOnce flushes the registry to the server once, as a single flush of
+// OpenTSDBWithConfig would, and returns any error instead of logging it.  It
+// is meant for cron-style jobs and tests.
+func OpenTSDBOnce(c OpenTSDBConfig) error {
+	return openTSDB(context.Background(), &c)
+}
+
+// WriteOpenTSDB writes a put line for each datapoint of a single flush of
+// c.Registry, stamped with now, to w.  Addr, Transport and the other
+// connection settings of c are ignored.
+func WriteOpenTSDB(w io.Writer, c OpenTSDBConfig, now int64) error {
+	c.Transport = &WriterTransport{W: w}
+	e, err := newOpenTSDBExporter(c)
+	if nil != err {
+		return err
+	}
+	return e.transport.Send(context.Background(), e.collect(now))
+}
+
+// WriterTransport is a Transport which writes put lines, as a TelnetTransport
+// would send them, to W, such as a file.
+type WriterTransport struct {
+	W io.Writer
+}
+
+// Send writes one put line per datapoint to W.
+func (t *WriterTransport) Send(ctx context.Context, points []Datapoint) error {
+	return writePuts(t.W, points, 0)
+}
+
+// TelnetTransport is the default Transport of the OpenTSDB exporter.  It
+// writes datapoints to the server at Addr using the telnet-style put
+// protocol over a connection kept open across flushes, which is encrypted
+// if TLSConfig is set.
+type TelnetTransport struct {
+	Addr         *net.TCPAddr
+	TLSConfig    *tls.Config
+	DialTimeout  time.Duration // Limit on connecting, unlimited if zero
+	WriteTimeout time.Duration // Limit on writing each batch, unlimited if zero
+	BufferSize   int           // Size of the write buffer, defaults to that of bufio
+
+	// MaxBatchSize splits each flush into batches of at most that many
+	// datapoints, separated by InterBatchDelay, so that a server which drops
+	// puts when overloaded isn't sent tens of thousands at once.  A flush
+	// is sent as one batch if it is zero.
+	MaxBatchSize    int
+	InterBatchDelay time.Duration
+
+	// ReadErrors reads what the server sends back after each batch, for
+	// InterBatchDelay or 10ms if it is shorter, which is nothing unless the
+	// server rejects puts.  Send returns the complaints as a *TSDError once every
+	// batch has been sent.
+	ReadErrors bool
+
+	mutex sync.Mutex
+	conn  net.Conn
+}
+
+// TSDError is the error returned by a TelnetTransport which reads errors when
+// the server complains about puts, such as of malformed metric names.
+type TSDError struct {
+	Lines []string // Each complaint, as in "put: illegal argument: ..."
+}
+
+func (err *TSDError) Error() string {
+	return fmt.Sprintf("opentsdb: server rejected %d puts: %s", len(err.Lines), err.Lines[0])
+}
+
+// minTSDErrorWait is the least time a TelnetTransport waits for errors after
+// each batch.
+const minTSDErrorWait = 10 * time.Millisecond
+
+// Send writes one put line per datapoint, connecting to Addr if there is no
+// open connection.  If the connection turns out to have been broken since the
+// previous flush, Send reconnects once and writes the datapoints of the
+// batches not yet written again, unless writing timed out, as a server which
+// stopped reading would only stall the second attempt too.  Cancelling ctx
+// aborts a connection attempt which is still in progress and the batches
+// not yet written.
+func (t *TelnetTransport) Send(ctx context.Context, points []Datapoint) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if nil != t.conn && !connAlive(t.conn) {
+		t.closeConn()
+	}
+	reused := nil != t.conn
+	sent, err := t.send(ctx, points)
+	if ne, ok := err.(net.Error); nil == err || !reused || ok && ne.Timeout() {
+		return err
+	}
+	if _, ok := err.(*TSDError); ok || ctx.Err() == err {
+		return err
+	}
+	_, err = t.send(ctx, points[sent:])
+	return err
+}
+
+// Close closes the connection, if one is open.  A later Send reconnects.
+func (t *TelnetTransport) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.closeConn()
+}
+
+// send writes points in batches, returning how many were in those written.
+func (t *TelnetTransport) send(ctx context.Context, points []Datapoint) (int, error) {
+	if nil == t.conn {
+		conn, err := t.dial(ctx)
+		if nil != err {
+			return 0, err
+		}
+		t.conn = conn
+	}
+	size := t.MaxBatchSize
+	if size <= 0 || len(points) < size {
+		size = len(points)
+	}
+	var complaints []string
+	sent := 0
+	for sent < len(points) {
+		if 0 < sent && 0 < t.InterBatchDelay && !t.ReadErrors {
+			select {
+			case <-ctx.Done():
+				return sent, ctx.Err()
+			case <-time.After(t.InterBatchDelay):
+			}
+		}
+		end := sent + size
+		if len(points) < end {
+			end = len(points)
+		}
+		var deadline time.Time
+		if 0 < t.WriteTimeout {
+			deadline = time.Now().Add(t.WriteTimeout)
+		}
+		t.conn.SetWriteDeadline(deadline)
+		if err := writePuts(t.conn, points[sent:end], t.BufferSize); nil != err {
+			t.closeConn()
+			return sent, err
+		}
+		sent = end
+		if t.ReadErrors {
+			lines, err := t.readErrors()
+			complaints = append(complaints, lines...)
+			if nil != err {
+				t.closeConn()
+				return sent, err
+			}
 		}
 	}
+	if 0 < len(complaints) {
+		return sent, &TSDError{Lines: complaints}
+	}
+	return sent, nil
+}
+
+// readErrors returns the lines the server sends within InterBatchDelay, or
+// minTSDErrorWait if it is shorter.
+func (t *TelnetTransport) readErrors() ([]string, error) {
+	wait := t.InterBatchDelay
+	if wait < minTSDErrorWait {
+		wait = minTSDErrorWait
+	}
+	t.conn.SetReadDeadline(time.Now().Add(wait))
+	defer t.conn.SetReadDeadline(time.Time{})
+	var b []byte
+	var buf [512]byte
+	var err error
+	for {
+		var n int
+		n, err = t.conn.Read(buf[:])
+		b = append(b, buf[:n]...)
+		if nil != err {
+			break
+		}
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		err = nil
+	}
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); "" != line {
+			lines = append(lines, line)
+		}
+	}
+	return lines, err
+}
+
+// dial connects to Addr, completing the TLS handshake before returning if
+// TLSConfig is set.  A connection attempt aborted because ctx is done
+// returns ctx.Err().
+func (t *TelnetTransport) dial(ctx context.Context) (conn net.Conn, err error) {
+	d := net.Dialer{Timeout: t.DialTimeout}
+	if nil == t.TLSConfig {
+		conn, err = d.DialContext(ctx, "tcp", t.Addr.String())
+	} else {
+		d.Deadline, _ = ctx.Deadline()
+		d.Cancel = ctx.Done()
+		conn, err = tls.DialWithDialer(&d, "tcp", t.Addr.String(), t.TLSConfig)
+	}
+	if nil != err && nil != ctx.Err() {
+		return nil, ctx.Err()
+	}
+	return conn, err
+}
+
+func (t *TelnetTransport) closeConn() error {
+	if nil == t.conn {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+// connAlive reports whether the server hasn't closed conn, as a write to a
+// connection closed by its peer often appears to succeed.  Anything the
+// server has sent, such as complaints about malformed puts, is discarded.
+// The deadline is slightly in the future because a read past its deadline
+// times out without looking at the connection.
+func connAlive(conn net.Conn) bool {
+	var buf [512]byte
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		if _, err := conn.Read(buf[:]); nil != err {
+			ne, ok := err.(net.Error)
+			return ok && ne.Timeout()
+		}
+	}
+}
+
+// putWriter holds the buffers used to format put lines, which are pooled to
+// spare frequent flushes of large registries from allocating them each time.
+type putWriter struct {
+	w    *bufio.Writer
+	line []byte
+	tags []byte
+}
+
+var putWriterPool = sync.Pool{
+	New: func() interface{} {
+		return &putWriter{w: bufio.NewWriter(nil)}
+	},
+}
+
+// writePuts writes one put line per datapoint to wr through a buffer of the
+// given size, or the bufio default if zero, which is flushed only when full
+// and once at the end.  Tags are formatted once for each run of datapoints
+// sharing a tags map, as those of a flush do.
+func writePuts(wr io.Writer, points []Datapoint, size int) error {
+	pw := putWriterPool.Get().(*putWriter)
+	if 0 < size && size != pw.w.Size() {
+		pw.w = bufio.NewWriterSize(nil, size)
+	}
+	pw.w.Reset(wr)
+	defer func() {
+		pw.w.Reset(nil)
+		putWriterPool.Put(pw)
+	}()
+	var tagsPtr uintptr
+	for i, p := range points {
+		if ptr := reflect.ValueOf(p.Tags).Pointer(); 0 == i || ptr != tagsPtr {
+			pw.tags = appendTags(pw.tags[:0], p.Tags)
+			tagsPtr = ptr
+		}
+		line := append(pw.line[:0], "put "...)
+		line = append(line, p.Metric...)
+		line = append(line, ' ')
+		line = strconv.AppendInt(line, p.Timestamp, 10)
+		line = append(line, ' ')
+		// No trailing zeros, so that integral values are stored as integers.
+		line = strconv.AppendFloat(line, p.Value, 'f', -1, 64)
+		line = append(line, ' ')
+		line = append(line, pw.tags...)
+		line = append(line, '\n')
+		pw.line = line
+		if _, err := pw.w.Write(line); nil != err {
+			return err
+		}
+	}
+	return pw.w.Flush()
+}
+
+// untilAligned returns the time from now until the next multiple of d.
+func untilAligned(now time.Time, d time.Duration) time.Duration {
+	return now.Truncate(d).Add(d).Sub(now)
 }
 
 func getShortHostname() string {
@@ -59,70 +586,661 @@ func getShortHostname() string {
 	return shortHostName
 }
 
-func openTSDB(c *OpenTSDBConfig) error {
-	shortHostname := getShortHostname()
-	now := time.Now().Unix()
-	du := float64(c.DurationUnit)
-	conn, err := net.DialTCP("tcp", nil, c.Addr)
+// openTSDBExporter holds the state of an OpenTSDB exporter between flushes.
+type openTSDBExporter struct {
+	config     OpenTSDBConfig
+	transport  Transport
+	annotated  map[string]bool           // Series whose metadata has been pushed
+	metaFailed map[string]metaBackoff    // Series whose metadata failed to be pushed
+	metaClient *http.Client              // Client of MetaURL
+	rates      map[string]*gaugePrevious // Previous values of RateGauges
+	sampled    int                       // Offset of the next sample of SampleMatch metrics
+	start      time.Time                 // When the exporter was created, for StartupDelay
+	queue      []Datapoint               // Datapoints of failed flushes, for RetryQueueSize
+	exportedAt map[string]time.Time      // When each metric was last exported, for PrefixIntervals
+	counts     map[string]int64          // Counts last exported, for DeltaCounters
+	stamped    time.Time                 // Time the last flush was stamped with, for AlignFlush
+	reported   map[string]reportedValue  // Values last reported, for SkipUnchanged
+}
+
+// reportedValue is the value last reported of a metric and when.
+type reportedValue struct {
+	value float64
+	at    time.Time
+}
+
+// gaugePrevious is the value of a gauge at the previous flush.
+type gaugePrevious struct {
+	value     float64
+	timestamp int64
+	ok        bool
+}
+
+func newOpenTSDBExporter(c OpenTSDBConfig) (*openTSDBExporter, error) {
+	if nil == c.Registry {
+		return nil, errors.New("opentsdb: config has no Registry to export")
+	}
+	if nil == c.Addr && nil == c.Transport {
+		return nil, errors.New("opentsdb: config has neither an Addr nor a Transport")
+	}
+	if c.AlignFlush && 0 < c.Jitter {
+		return nil, errors.New("opentsdb: config has both AlignFlush and Jitter")
+	}
+	if nil == c.Clock {
+		c.Clock = systemClock{}
+	}
+	e := &openTSDBExporter{
+		config:     c,
+		transport:  c.Transport,
+		annotated:  make(map[string]bool),
+		metaFailed: make(map[string]metaBackoff),
+		exportedAt: make(map[string]time.Time),
+		counts:     make(map[string]int64),
+		reported:   make(map[string]reportedValue),
+		metaClient: newTLSClient(c.TLSConfig),
+		rates:      make(map[string]*gaugePrevious, len(c.RateGauges)),
+		start:      c.Clock.Now(),
+	}
+	for _, name := range c.RateGauges {
+		e.rates[name] = &gaugePrevious{}
+	}
+	switch {
+	case nil != e.transport:
+	case "udp" == c.Network:
+		if nil != c.TLSConfig {
+			return nil, errors.New("opentsdb: config has a TLSConfig for udp")
+		}
+		e.transport = &UDPTransport{
+			Addr:          &net.UDPAddr{IP: c.Addr.IP, Port: c.Addr.Port, Zone: c.Addr.Zone},
+			MaxPacketSize: c.MaxPacketSize,
+		}
+	case "" == c.Network || "tcp" == c.Network:
+		e.transport = &TelnetTransport{
+			Addr:            c.Addr,
+			TLSConfig:       c.TLSConfig,
+			DialTimeout:     c.DialTimeout,
+			WriteTimeout:    c.WriteTimeout,
+			BufferSize:      c.BufferSize,
+			MaxBatchSize:    c.MaxBatchSize,
+			InterBatchDelay: c.InterBatchDelay,
+			ReadErrors:      c.ReadErrors,
+		}
+	default:
+		return nil, fmt.Errorf("opentsdb: config has an unknown Network %q", c.Network)
+	}
+	return e, nil
+}
+
+// flush collects the registry and sends it with the exporter's transport,
+// unless the StartupDelay hasn't yet passed.
+func (e *openTSDBExporter) flush(ctx context.Context) error {
+	now := e.config.Clock.Now()
+	if now.Sub(e.start) < e.config.StartupDelay {
+		return nil
+	}
+	points := e.collect(e.timestamp(now))
+	if err := e.send(ctx, append(e.queue, points...), now); nil != err {
+		e.enqueue(points)
+		return err
+	}
+	e.queue = nil
+	if "" != e.config.MetaURL {
+		return e.annotate(ctx, points)
+	}
+	return nil
+}
+
+// timestamp returns the timestamp in seconds, or milliseconds if
+// MillisecondTimestamps is set, of every datapoint of the flush at now.
+func (e *openTSDBExporter) timestamp(now time.Time) int64 {
+	if e.config.AlignFlush && 0 < e.config.FlushInterval {
+		if aligned := now.Truncate(e.config.FlushInterval); aligned.After(e.stamped) {
+			now = aligned
+		}
+		e.stamped = now
+	}
+	if e.config.MillisecondTimestamps {
+		return now.UnixNano() / int64(time.Millisecond)
+	}
+	return now.Unix()
+}
+
+// Export flushes r, for FlushLoop.
+func (e *openTSDBExporter) Export(r Registry) error {
+	return e.ExportContext(context.Background(), r)
+}
+
+// ExportContext flushes r, for FlushLoop.
+func (e *openTSDBExporter) ExportContext(ctx context.Context, r Registry) error {
+	e.config.Registry = r
+	return e.flush(ctx)
+}
+
+// send sends points with the exporter's transport, retrying failures as
+// configured by MaxRetries and RetryBackoff for the flush begun at start.
+func (e *openTSDBExporter) send(ctx context.Context, points []Datapoint, start time.Time) error {
+	c := &e.config
+	err := e.transport.Send(ctx, points)
+	backoff := c.RetryBackoff
+	for i := 0; nil != err && i < c.MaxRetries; i++ {
+		sleep := backoff
+		if 0 < c.FlushInterval {
+			left := start.Add(c.FlushInterval).Sub(c.Clock.Now())
+			if left <= 0 {
+				break
+			}
+			if left < sleep {
+				sleep = left
+			}
+		}
+		slept, stop := after(c.Clock, sleep)
+		select {
+		case <-slept:
+			stop()
+		case <-ctx.Done():
+			stop()
+			return err
+		}
+		backoff *= 2
+		err = e.transport.Send(ctx, points)
+	}
+	return err
+}
+
+// enqueue adds the points of a failed flush to the retry queue, dropping the
+// oldest beyond RetryQueueSize.
+func (e *openTSDBExporter) enqueue(points []Datapoint) {
+	e.queue = append(e.queue, points...)
+	if n := len(e.queue) - e.config.RetryQueueSize; 0 < n {
+		e.queue = append([]Datapoint(nil), e.queue[n:]...)
+	}
+}
+
+// finalFlush flushes once more when the exporter is stopped, with a context
+// of its own since the exporter's is already done.
+func (e *openTSDBExporter) finalFlush() error {
+	ctx, cancel := context.WithTimeout(context.Background(), e.config.FlushInterval)
+	defer cancel()
+	return e.flush(ctx)
+}
+
+// close closes the connection of the exporter's transport if the exporter
+// created it.
+func (e *openTSDBExporter) close() error {
+	if t, ok := e.transport.(io.Closer); ok && nil == e.config.Transport {
+		return t.Close()
+	}
+	return nil
+}
+
+// openTSDB performs a single flush with a new exporter.
+func openTSDB(ctx context.Context, c *OpenTSDBConfig) error {
+	e, err := newOpenTSDBExporter(*c)
 	if nil != err {
 		return err
 	}
-	defer conn.Close()
+	defer e.close()
+	return e.flush(ctx)
+}
 
-	tagArr := make([]string, len(c.Tags))
+// collect reads every metric in the registry into datapoints stamped with
+// now.  All datapoints share one tags map holding host and c.Tags, or one per
+// aggregation if AggregationTags is set, except those of Tagged metrics whose
+// own tags are merged over them.  Values which aren't finite, which OpenTSDB
+// rejects, are reported as zero.
+func (e *openTSDBExporter) collect(now int64) []Datapoint {
+	c := &e.config
+	du := float64(c.DurationUnit)
+	if 0 == du {
+		du = float64(time.Nanosecond)
+	}
+	percentiles := percentilesOrDefault(c.Percentiles)
+	suffixes := make([]string, len(percentiles))
+	for i, p := range percentiles {
+		suffixes[i] = percentileSuffix(p)
+	}
+	sanitize := func(s string) string { return s }
+	if nil != c.Sanitizer {
+		sanitize = c.Sanitizer
+	} else if c.SanitizeNames {
+		sanitize = sanitizeOpenTSDB
+	}
+	tags := make(map[string]string, len(c.Tags)+1)
+	tags["host"] = sanitize(getShortHostname())
 	for k, v := range c.Tags {
-		tagArr = append(tagArr, fmt.Sprintf("%s=%s", k, v))
+		tags[sanitize(k)] = sanitize(v)
 	}
-	tags := strings.Join(tagArr, " ")
-
-	w := bufio.NewWriter(conn)
+	tagged := make(map[string]map[string]string)
+	exported := make(map[string]string)
+	counters := make(map[string]Counter)
+	histograms := make(map[string]Histogram)
+	timers := make(map[string]Timer)
 	c.Registry.Each(func(name string, i interface{}) {
+		if t, ok := i.(Tagged); ok {
+			tagged[name] = t.Tags()
+		}
+		if n := exportName(name, i); n != name {
+			exported[name] = n
+		}
+		if counter, ok := i.(Counter); ok && c.ResetCounters {
+			counters[name] = counter
+		}
+		if c.ResetOnFlush {
+			switch metric := i.(type) {
+			case Histogram:
+				histograms[name] = metric
+			case Timer:
+				timers[name] = metric
+			}
+		}
+	})
+
+	var (
+		points []Datapoint
+		md     Metadata
+	)
+	sharedAggTags := make(map[string]map[string]string)
+	metricTags, aggTags := tags, sharedAggTags
+	tagsFor := func(suffix string) map[string]string {
+		if !c.AggregationTags {
+			return metricTags
+		}
+		agg := md.Aggregation
+		if "" == agg {
+			agg = inferAggregation(md.Kind, suffix)
+		}
+		if t, ok := aggTags[agg]; ok {
+			return t
+		}
+		t := make(map[string]string, len(metricTags)+1)
+		for k, v := range metricTags {
+			t[k] = v
+		}
+		t["agg"] = agg
+		aggTags[agg] = t
+		return t
+	}
+
+	put := func(name, suffix string, value float64) {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			value = 0
+		}
+		points = append(points, Datapoint{
+			Metric:    sanitize(openTSDBName(c.Prefix, name, suffix)),
+			Timestamp: now,
+			Value:     value,
+			Tags:      tagsFor(suffix),
+			Metadata:  md,
+		})
+	}
+	maxRawSamples := c.MaxRawSamples
+	if 0 == maxRawSamples {
+		maxRawSamples = defaultMaxRawSamples
+	}
+	putSample := func(name string, s Sample, scale float64) {
+		values := s.Values()
+		if len(values) > maxRawSamples {
+			values = values[:maxRawSamples]
+		}
+		base := tagsFor("sample")
+		for i, v := range values {
+			t := make(map[string]string, len(base)+1)
+			for k, v := range base {
+				t[k] = v
+			}
+			t["sample"] = strconv.Itoa(i)
+			points = append(points, Datapoint{
+				Metric:    sanitize(openTSDBName(c.Prefix, name, "sample")),
+				Timestamp: now,
+				Value:     float64(v) / scale,
+				Tags:      t,
+				Metadata:  md,
+			})
+		}
+	}
+	snapshot := c.Registry.Snapshot()
+	skip := e.sample(snapshot)
+	flushed := c.Clock.Now()
+	if nil != skip {
+		md = Metadata{Kind: KindGauge}
+		put("opentsdb", "sample.fraction", float64(len(skip.sampled))/float64(skip.total))
+	}
+	snapshot.Each(func(name string, i interface{}) {
+		series := name
+		if n, ok := exported[name]; ok {
+			series = n
+		}
+		if nil != c.Filter && !c.Filter(series) {
+			return
+		}
+		if nil != skip && c.SampleMatch.MatchString(name) && !skip.sampled[name] {
+			return
+		}
+		if !e.due(name, series, flushed) {
+			return
+		}
+		md = metadata(c.Registry, name, i)
+		metricTags, aggTags = tags, sharedAggTags
+		if t := tagged[name]; 0 < len(t) {
+			metricTags = make(map[string]string, len(tags)+len(t))
+			for k, v := range tags {
+				metricTags[k] = v
+			}
+			for k, v := range t {
+				metricTags[sanitize(k)] = sanitize(v)
+			}
+			aggTags = make(map[string]map[string]string)
+		}
 		switch metric := i.(type) {
 		case Counter:
-			fmt.Fprintf(w, "put %s.%s.count %d %d host=%s %s\n", c.Prefix, name, now, metric.Count(), shortHostname, tags)
+			count, increase := int64(0), c.DeltaCounters
+			if counter, ok := counters[name]; ok {
+				count, increase = countAndClear(counter), true
+			} else {
+				count = e.count(name, metric.Count())
+			}
+			if !e.unchanged(name, float64(count), increase, flushed) {
+				put(series, "count", float64(count))
+			}
 		case Gauge:
-			fmt.Fprintf(w, "put %s.%s.value %d %d host=%s %s\n", c.Prefix, name, now, metric.Value(), shortHostname, tags)
+			rate, ok := e.rate(name, float64(metric.Value()), now)
+			if e.unchanged(name, float64(metric.Value()), false, flushed) {
+				break
+			}
+			put(series, "value", float64(metric.Value()))
+			if ok {
+				put(series, "rate", rate)
+			}
 		case GaugeFloat64:
-			fmt.Fprintf(w, "put %s.%s.value %d %f host=%s %s\n", c.Prefix, name, now, metric.Value(), shortHostname, tags)
+			rate, ok := e.rate(name, metric.Value(), now)
+			if e.unchanged(name, metric.Value(), false, flushed) {
+				break
+			}
+			put(series, "value", metric.Value())
+			if ok {
+				put(series, "rate", rate)
+			}
+		case Healthcheck:
+			healthy := 1.0
+			if err := metric.Error(); nil != err {
+				healthy = 0
+				if msg := c.sanitizeError(err); c.HealthcheckErrorTags && "" != msg {
+					t := make(map[string]string, len(metricTags)+1)
+					for k, v := range metricTags {
+						t[k] = v
+					}
+					t["error"] = msg
+					metricTags, aggTags = t, make(map[string]map[string]string)
+				}
+			}
+			put(series, "healthy", healthy)
 		case Histogram:
 			h := metric.Snapshot()
-			ps := h.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
-			fmt.Fprintf(w, "put %s.%s.count %d %d host=%s %s\n", c.Prefix, name, now, h.Count(), shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.min %d %d host=%s %s\n", c.Prefix, name, now, h.Min(), shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.max %d %d host=%s %s\n", c.Prefix, name, now, h.Max(), shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.mean %d %.2f host=%s %s\n", c.Prefix, name, now, h.Mean(), shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.std-dev %d %.2f host=%s %s\n", c.Prefix, name, now, h.StdDev(), shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.50-percentile %d %.2f host=%s %s\n", c.Prefix, name, now, ps[0], shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.75-percentile %d %.2f host=%s %s\n", c.Prefix, name, now, ps[1], shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.95-percentile %d %.2f host=%s %s\n", c.Prefix, name, now, ps[2], shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.99-percentile %d %.2f host=%s %s\n", c.Prefix, name, now, ps[3], shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.999-percentile %d %.2f host=%s %s\n", c.Prefix, name, now, ps[4], shortHostname, tags)
+			if live, ok := histograms[name]; ok {
+				h = snapshotAndClear(live)
+			}
+			ps := h.Percentiles(percentiles)
+			put(series, "count", float64(h.Count()))
+			put(series, "min", float64(h.Min()))
+			put(series, "max", float64(h.Max()))
+			put(series, "mean", h.Mean())
+			put(series, "std-dev", h.StdDev())
+			for i, p := range ps {
+				put(series, suffixes[i], p)
+			}
+			if bh, ok := h.(BucketedHistogram); ok {
+				counts := bh.BucketCounts()
+				for i, bound := range bh.Bounds() {
+					put(series, "le_"+strconv.FormatInt(bound, 10), float64(counts[i]))
+				}
+				put(series, "le_inf", float64(h.Count()))
+			}
+			if c.ExportRawSamples {
+				putSample(series, h.Sample(), 1)
+			}
 		case Meter:
 			m := metric.Snapshot()
-			fmt.Fprintf(w, "put %s.%s.count %d %d host=%s %s\n", c.Prefix, name, now, m.Count(), shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.one-minute %d %.2f host=%s %s\n", c.Prefix, name, now, m.Rate1(), shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.five-minute %d %.2f host=%s %s\n", c.Prefix, name, now, m.Rate5(), shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.fifteen-minute %d %.2f host=%s %s\n", c.Prefix, name, now, m.Rate15(), shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.mean %d %.2f host=%s %s\n", c.Prefix, name, now, m.RateMean(), shortHostname, tags)
+			put(series, "count", float64(e.count(name, m.Count())))
+			put(series, "one-minute", m.Rate1())
+			put(series, "five-minute", m.Rate5())
+			put(series, "fifteen-minute", m.Rate15())
+			put(series, "mean", m.RateMean())
+			if wm, ok := m.(WindowedMeter); ok {
+				for _, wr := range wm.WindowRates() {
+					put(series, "rate-"+wr.Name(), wr.Rate)
+				}
+			}
 		case Timer:
 			t := metric.Snapshot()
-			ps := t.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
-			fmt.Fprintf(w, "put %s.%s.count %d %d host=%s %s\n", c.Prefix, name, now, t.Count(), shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.min %d %d host=%s %s\n", c.Prefix, name, now, t.Min()/int64(du), shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.max %d %d host=%s %s\n", c.Prefix, name, now, t.Max()/int64(du), shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.mean %d %.2f host=%s %s\n", c.Prefix, name, now, t.Mean()/du, shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.std-dev %d %.2f host=%s %s\n", c.Prefix, name, now, t.StdDev()/du, shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.50-percentile %d %.2f host=%s %s\n", c.Prefix, name, now, ps[0]/du, shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.75-percentile %d %.2f host=%s %s\n", c.Prefix, name, now, ps[1]/du, shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.95-percentile %d %.2f host=%s %s\n", c.Prefix, name, now, ps[2]/du, shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.99-percentile %d %.2f host=%s %s\n", c.Prefix, name, now, ps[3]/du, shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.999-percentile %d %.2f host=%s %s\n", c.Prefix, name, now, ps[4]/du, shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.one-minute %d %.2f host=%s %s\n", c.Prefix, name, now, t.Rate1(), shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.five-minute %d %.2f host=%s %s\n", c.Prefix, name, now, t.Rate5(), shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.fifteen-minute %d %.2f host=%s %s\n", c.Prefix, name, now, t.Rate15(), shortHostname, tags)
-			fmt.Fprintf(w, "put %s.%s.mean-rate %d %.2f host=%s %s\n", c.Prefix, name, now, t.RateMean(), shortHostname, tags)
-		}
-		w.Flush()
+			if live, ok := timers[name]; ok {
+				t = timerSnapshotAndClear(live)
+			}
+			ps := t.Percentiles(percentiles)
+			put(series, "count", float64(t.Count()))
+			put(series, "min", float64(t.Min()/int64(du)))
+			put(series, "max", float64(t.Max()/int64(du)))
+			put(series, "mean", t.Mean()/du)
+			put(series, "std-dev", t.StdDev()/du)
+			for i, p := range ps {
+				put(series, suffixes[i], p/du)
+			}
+			put(series, "one-minute", t.Rate1())
+			put(series, "five-minute", t.Rate5())
+			put(series, "fifteen-minute", t.Rate15())
+			put(series, "mean-rate", t.RateMean())
+			if c.ExportRawSamples {
+				putSample(series, t.Sample(), du)
+			}
+		case TopK:
+			base := metricTags
+			for _, tc := range metric.Top() {
+				t := make(map[string]string, len(base)+1)
+				for k, v := range base {
+					t[k] = v
+				}
+				t["key"] = sanitize(tc.Key)
+				metricTags, aggTags = t, make(map[string]map[string]string)
+				put(series, "count", float64(tc.Count))
+			}
+		}
 	})
-	return nil
+	return points
+}
+
+// openTSDBName joins prefix, name and suffix with dots into a series name,
+// without a leading dot if prefix is empty or a doubled one if it ends in a
+// dot.
+func openTSDBName(prefix, name, suffix string) string {
+	if "" == prefix {
+		return name + "." + suffix
+	}
+	if strings.HasSuffix(prefix, ".") {
+		return prefix + name + "." + suffix
+	}
+	return prefix + "." + name + "." + suffix
+}
+
+// sanitizeOpenTSDB replaces the characters of s which OpenTSDB doesn't allow
+// in metric names and tags, anything but letters, digits, -, _, . and /, with
+// underscores.
+func sanitizeOpenTSDB(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' ||
+			'-' == r || '_' == r || '.' == r || '/' == r {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// sanitizeError returns err's message as the value of an error tag, mapped
+// by the Sanitizer or else by sanitizeOpenTSDB.
+func (c *OpenTSDBConfig) sanitizeError(err error) string {
+	if nil != c.Sanitizer {
+		return c.Sanitizer(err.Error())
+	}
+	return sanitizeOpenTSDB(err.Error())
+}
+
+// percentileSuffix returns the suffix of the series of percentile p, the
+// digits of p as a percentage, such as 95-percentile for 0.95 and
+// 999-percentile for 0.999.
+func percentileSuffix(p float64) string {
+	digits := strconv.FormatFloat(p, 'f', -1, 64)
+	whole, frac := digits, ""
+	if i := strings.Index(digits, "."); -1 != i {
+		whole, frac = digits[:i], digits[i+1:]
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	whole = strings.TrimLeft(whole+frac[:2], "0")
+	if "" == whole {
+		whole = "0"
+	}
+	return whole + frac[2:] + "-percentile"
+}
+
+// inferAggregation returns the aggregation with which the series of a metric
+// of the given kind having the given suffix should be rolled up.
+func inferAggregation(kind MetricKind, suffix string) string {
+	switch {
+	case KindMonotonic == kind, "count" == suffix, "rate" == suffix,
+		strings.HasSuffix(suffix, "-minute"), "mean-rate" == suffix,
+		strings.HasPrefix(suffix, "le_"):
+		return "sum"
+	case "min" == suffix:
+		return "min"
+	case "max" == suffix:
+		return "max"
+	}
+	return "avg"
+}
+
+// due reports whether the metric registered under name and exported as
+// series is due to be exported by the flush at now under PrefixIntervals,
+// and if so notes that it's exported at now.
+func (e *openTSDBExporter) due(name, series string, now time.Time) bool {
+	var interval time.Duration
+	longest := -1
+	for prefix, d := range e.config.PrefixIntervals {
+		if longest < len(prefix) && strings.HasPrefix(series, prefix) {
+			interval, longest = d, len(prefix)
+		}
+	}
+	if interval <= 0 {
+		return true
+	}
+	if last, ok := e.exportedAt[name]; ok && now.Sub(last) < interval-e.config.FlushInterval/2 {
+		return false
+	}
+	e.exportedAt[name] = now
+	return true
+}
+
+// metricSample is the subset of the metrics matching SampleMatch which is
+// exported by a flush.
+type metricSample struct {
+	sampled map[string]bool
+	total   int
+}
+
+// sample chooses the next SampleSize metrics matching SampleMatch, in name
+// order, to be exported.  It returns nil if sampling isn't configured or if no
+// metrics match.
+func (e *openTSDBExporter) sample(r Registry) *metricSample {
+	if nil == e.config.SampleMatch || 0 >= e.config.SampleSize {
+		return nil
+	}
+	var names []string
+	r.Each(func(name string, i interface{}) {
+		if nil != e.config.Filter && !e.config.Filter(name) {
+			return
+		}
+		if e.config.SampleMatch.MatchString(name) {
+			names = append(names, name)
+		}
+	})
+	if 0 == len(names) {
+		return nil
+	}
+	sort.Strings(names)
+	s := &metricSample{sampled: make(map[string]bool), total: len(names)}
+	for i := 0; i < e.config.SampleSize && i < len(names); i++ {
+		s.sampled[names[(e.sampled+i)%len(names)]] = true
+	}
+	e.sampled = (e.sampled + e.config.SampleSize) % len(names)
+	return s
+}
+
+// count returns the count of the named Counter or Meter to be reported: its
+// increase since it was last exported if DeltaCounters is set, and count
+// itself otherwise.
+func (e *openTSDBExporter) count(name string, count int64) int64 {
+	if !e.config.DeltaCounters {
+		return count
+	}
+	last, ok := e.counts[name]
+	e.counts[name] = count
+	if !ok || count < last {
+		return count
+	}
+	return count - last
+}
+
+// unchanged reports whether the named metric's value is the one last
+// reported within MaxStaleness of now, so that SkipUnchanged skips it, and
+// notes that it's reported at now if not.  An increase is only unchanged
+// while it's zero.
+func (e *openTSDBExporter) unchanged(name string, value float64, increase bool, now time.Time) bool {
+	if !e.config.SkipUnchanged {
+		return false
+	}
+	last, ok := e.reported[name]
+	if ok && value == last.value && (!increase || 0 == value) &&
+		(0 == e.config.MaxStaleness || now.Sub(last.at) < e.config.MaxStaleness) {
+		return true
+	}
+	e.reported[name] = reportedValue{value: value, at: now}
+	return false
+}
+
+// rate records the value of the named gauge at now and returns its change
+// per second since the previous flush.  It returns false for gauges not in
+// RateGauges and on the first flush, when there is no previous value.
+func (e *openTSDBExporter) rate(name string, value float64, now int64) (float64, bool) {
+	prev, ok := e.rates[name]
+	if !ok {
+		return 0, false
+	}
+	elapsed := float64(now - prev.timestamp)
+	if e.config.MillisecondTimestamps {
+		elapsed /= 1000
+	}
+	rate, ok := (value-prev.value)/elapsed, prev.ok && now > prev.timestamp
+	*prev = gaugePrevious{value: value, timestamp: now, ok: true}
+	return rate, ok
+}
+
+// formatTags renders tags as space-separated key=value pairs sorted by key.
+func formatTags(tags map[string]string) string {
+	return string(appendTags(nil, tags))
+}
+
+// appendTags appends tags to dst as formatted by formatTags.
+func appendTags(dst []byte, tags map[string]string) []byte {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		if 0 < i {
+			dst = append(dst, ' ')
+		}
+		dst = append(dst, k...)
+		dst = append(dst, '=')
+		dst = append(dst, tags[k]...)
+	}
+	return dst
 }
@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// HTTPTransport is a Transport which POSTs datapoints as JSON to the
+// /api/put endpoint of the OpenTSDB HTTP API, for deployments behind HTTP
+// load balancers.
+type HTTPTransport struct {
+	URL       string       // Base URL of the OpenTSDB HTTP API, e.g. "http://localhost:4242"
+	BatchSize int          // Most datapoints sent per request, all of a flush in one if zero
+	Gzip      bool         // Compress request bodies, which OpenTSDB 2.2 and later accept
+	Client    *http.Client // HTTP client, defaults to http.DefaultClient
+
+	// GzipMinSize is the size in bytes below which bodies are sent
+	// uncompressed even if Gzip is set, as compressing a handful of
+	// datapoints saves less than it costs.  Every body is compressed if
+	// it's zero.
+	GzipMinSize int
+
+	// TLSConfig configures HTTPS connections, such as with a client
+	// certificate for mutual TLS, if Client isn't set.  Auth authenticates
+	// each request, for managed offerings which require it.
+	TLSConfig *tls.Config
+	Auth      HTTPAuth
+
+	once      sync.Once
+	tlsClient *http.Client
+}
+
+// HTTPAuth holds the credentials of requests to an HTTP API: a bearer token
+// sent as the Authorization header if Token is set, or else a username and
+// password sent with basic authentication if Username is.
+type HTTPAuth struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// authorize sets the Authorization header of req from the credentials.
+func (a HTTPAuth) authorize(req *http.Request) {
+	if "" != a.Token {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	} else if "" != a.Username {
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+}
+
+// newTLSClient returns an HTTP client whose connections are configured by
+// tlsConfig, or http.DefaultClient if it is nil.
+func newTLSClient(tlsConfig *tls.Config) *http.Client {
+	if nil == tlsConfig {
+		return http.DefaultClient
+	}
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}}
+}
+
+// gzipBody returns body compressed with gzip if it's at least min bytes,
+// and whether it was.
+func gzipBody(body []byte, min int) ([]byte, bool, error) {
+	if len(body) < min {
+		return body, false, nil
+	}
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := zw.Write(body); nil != err {
+		return nil, false, err
+	}
+	if err := zw.Close(); nil != err {
+		return nil, false, err
+	}
+	return b.Bytes(), true, nil
+}
+
+// openTSDBPut is a datapoint in an /api/put request.
+type openTSDBPut struct {
+	Metric    string            `json:"metric"`
+	Timestamp int64             `json:"timestamp"`
+	Value     float64           `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// Send POSTs points in batches of BatchSize, stopping at the first batch
+// which fails.
+func (t *HTTPTransport) Send(ctx context.Context, points []Datapoint) error {
+	size := t.BatchSize
+	if size <= 0 {
+		size = len(points)
+	}
+	for 0 < len(points) {
+		n := size
+		if len(points) < n {
+			n = len(points)
+		}
+		if err := t.post(ctx, points[:n]); nil != err {
+			return err
+		}
+		points = points[n:]
+	}
+	return nil
+}
+
+func (t *HTTPTransport) post(ctx context.Context, points []Datapoint) error {
+	puts := make([]openTSDBPut, len(points))
+	for i, p := range points {
+		puts[i] = openTSDBPut{p.Metric, p.Timestamp, p.Value, p.Tags}
+	}
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(puts); nil != err {
+		return err
+	}
+	b, gzipped := body.Bytes(), false
+	if t.Gzip {
+		var err error
+		if b, gzipped, err = gzipBody(b, t.GzipMinSize); nil != err {
+			return err
+		}
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(t.URL, "/")+"/api/put", bytes.NewReader(b))
+	if nil != err {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	t.Auth.authorize(req)
+	client := t.Client
+	if nil == client {
+		t.once.Do(func() { t.tlsClient = newTLSClient(t.TLSConfig) })
+		client = t.tlsClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if nil != err {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("opentsdb: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPTransport(t *testing.T) {
+	var batches [][]openTSDBPut
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if "/api/put" != r.URL.Path || "application/json" != r.Header.Get("Content-Type") {
+			t.Errorf("%s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		body := io.Reader(r.Body)
+		if "gzip" == r.Header.Get("Content-Encoding") {
+			zr, err := gzip.NewReader(r.Body)
+			if nil != err {
+				t.Fatal(err)
+			}
+			body = zr
+		}
+		var batch []openTSDBPut
+		if err := json.NewDecoder(body).Decode(&batch); nil != err {
+			t.Fatal(err)
+		}
+		batches = append(batches, batch)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	tags := map[string]string{"host": "h"}
+	points := []Datapoint{
+		{Metric: "p.a.count", Timestamp: 47, Value: 1, Tags: tags},
+		{Metric: "p.b.count", Timestamp: 47, Value: 2, Tags: tags},
+		{Metric: "p.c.count", Timestamp: 47, Value: 3, Tags: tags},
+	}
+	for _, c := range []struct {
+		size    int
+		gzip    bool
+		batches int
+	}{
+		{0, false, 1},
+		{2, false, 2},
+		{2, true, 2},
+	} {
+		batches = nil
+		tr := &HTTPTransport{URL: ts.URL + "/", BatchSize: c.size, Gzip: c.gzip}
+		if err := tr.Send(context.Background(), points); nil != err {
+			t.Fatal(err)
+		}
+		if c.batches != len(batches) {
+			t.Fatalf("BatchSize %d: %d batches", c.size, len(batches))
+		}
+		var got []openTSDBPut
+		for _, batch := range batches {
+			got = append(got, batch...)
+		}
+		if 3 != len(got) || "p.c.count" != got[2].Metric || 3 != got[2].Value || 47 != got[2].Timestamp || "h" != got[2].Tags["host"] {
+			t.Errorf("BatchSize %d, Gzip %v: %v", c.size, c.gzip, got)
+		}
+	}
+}
+
+func TestHTTPTransportGzipMinSize(t *testing.T) {
+	var encodings []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	tr := &HTTPTransport{URL: ts.URL, Gzip: true, GzipMinSize: 200}
+	small := []Datapoint{{Metric: "a", Timestamp: 47, Value: 1}}
+	large := make([]Datapoint, 10)
+	for i := range large {
+		large[i] = Datapoint{Metric: "a", Timestamp: 47, Value: float64(i)}
+	}
+	for _, points := range [][]Datapoint{small, large} {
+		if err := tr.Send(context.Background(), points); nil != err {
+			t.Fatal(err)
+		}
+	}
+	if 2 != len(encodings) || "" != encodings[0] || "gzip" != encodings[1] {
+		t.Errorf("Content-Encodings %q", encodings)
+	}
+}
+
+func TestHTTPTransportError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"code":400,"message":"One or more data points had errors"}}`, http.StatusBadRequest)
+	}))
+	defer ts.Close()
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r)
+	err := OpenTSDBOnce(OpenTSDBConfig{Registry: r, Transport: &HTTPTransport{URL: ts.URL}})
+	if nil == err || !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), "had errors") {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPTransportAuth(t *testing.T) {
+	var auth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	points := []Datapoint{{Metric: "p.a.count", Timestamp: 47, Value: 1}}
+	for _, c := range []struct {
+		auth     HTTPAuth
+		expected string
+	}{
+		{HTTPAuth{}, ""},
+		{HTTPAuth{Username: "u", Password: "p"}, "Basic dTpw"},
+		{HTTPAuth{Username: "u", Password: "p", Token: "t"}, "Bearer t"},
+	} {
+		tr := &HTTPTransport{URL: ts.URL, Auth: c.auth}
+		if err := tr.Send(context.Background(), points); nil != err {
+			t.Fatal(err)
+		}
+		if c.expected != auth {
+			t.Errorf("%+v: %q != %q", c.auth, c.expected, auth)
+		}
+	}
+}
+
+func TestHTTPTransportClientCertificate(t *testing.T) {
+	var peers int
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peers = len(r.TLS.PeerCertificates)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+	tr := &HTTPTransport{
+		URL: ts.URL,
+		TLSConfig: &tls.Config{
+			Certificates: ts.TLS.Certificates,
+			RootCAs:      ts.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs,
+		},
+	}
+	if err := tr.Send(context.Background(), []Datapoint{{Metric: "p.a.count", Timestamp: 47, Value: 1}}); nil != err {
+		t.Fatal(err)
+	}
+	if 1 != peers {
+		t.Errorf("%d client certificates", peers)
+	}
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// annotationsPerFlush bounds the number of calls made to the OpenTSDB HTTP
+// API during a single flush so that a newly started process with many
+// metrics doesn't flood it.  Remaining series are annotated by later flushes.
+const annotationsPerFlush = 10
+
+// maxAnnotationBackoff bounds the number of flushes for which a series whose
+// metadata keeps being rejected is skipped.
+const maxAnnotationBackoff = 64
+
+// annotate pushes the Description of each series in points which hasn't yet
+// been annotated to the OpenTSDB time series metadata API, returning the
+// first error after trying the others.  A series whose call fails is tried
+// again during the next flush, and after further failures is skipped for 1,
+// 3, 7 and so on flushes, up to maxAnnotationBackoff, so that one OpenTSDB
+// rejects doesn't spend every flush's calls.
+func (e *openTSDBExporter) annotate(ctx context.Context, points []Datapoint) error {
+	calls := 0
+	var firstErr error
+	for _, p := range points {
+		if "" == p.Description {
+			continue
+		}
+		series := p.Metric + "{" + strings.Replace(formatTags(p.Tags), " ", ",", -1) + "}"
+		if e.annotated[series] {
+			continue
+		}
+		if b, ok := e.metaFailed[series]; ok && 0 < b.skip {
+			b.skip--
+			e.metaFailed[series] = b
+			continue
+		}
+		if calls == annotationsPerFlush {
+			break
+		}
+		calls++
+		if err := e.putTSMeta(ctx, series, p.Metadata); nil != err {
+			b := e.metaFailed[series]
+			if 1<<uint(b.failures) <= maxAnnotationBackoff {
+				b.failures++
+			}
+			b.skip = 1<<uint(b.failures-1) - 1 // 0, 1, 3, 7...
+			e.metaFailed[series] = b
+			if nil == firstErr {
+				firstErr = err
+			}
+			continue
+		}
+		delete(e.metaFailed, series)
+		e.annotated[series] = true
+	}
+	return firstErr
+}
+
+// metaBackoff is the number of consecutive failures of a series'
+// metadata and the number of flushes for which it's still to be skipped.
+type metaBackoff struct {
+	failures, skip int
+}
+
+// putTSMeta creates or updates the metadata of the time series matching the
+// given query.
+func (e *openTSDBExporter) putTSMeta(ctx context.Context, series string, md Metadata) error {
+	body, err := json.Marshal(map[string]string{
+		"description": md.Description,
+		"units":       md.Unit,
+	})
+	if nil != err {
+		return err
+	}
+	u := strings.TrimRight(e.config.MetaURL, "/") + "/api/uid/tsmeta?create=true&m=" + url.QueryEscape(series)
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if nil != err {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	e.config.MetaAuth.authorize(req)
+	resp, err := e.metaClient.Do(req.WithContext(ctx))
+	if nil != err {
+		return err
+	}
+	resp.Body.Close()
+	if 300 <= resp.StatusCode && http.StatusNotModified != resp.StatusCode {
+		return fmt.Errorf("opentsdb: metadata for %s: %s", series, resp.Status)
+	}
+	return nil
+}
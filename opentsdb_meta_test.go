@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenTSDBAnnotate(t *testing.T) {
+	var series []string
+	var body map[string]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if "/api/uid/tsmeta" != r.URL.Path || "true" != r.URL.Query().Get("create") {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		series = append(series, r.URL.Query().Get("m"))
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer ts.Close()
+
+	r := NewRegistry()
+	RegisterWithMetadata(r, "described", NewCounter(), Metadata{Description: "a counter", Unit: "requests"})
+	r.Register("undescribed", NewCounter())
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:  r,
+		Prefix:    "p",
+		Tags:      map[string]string{"dc": "east"},
+		Transport: NewChannelTransport(2),
+		MetaURL:   ts.URL + "/",
+	})
+	for i := 0; i < 2; i++ {
+		if err := e.flush(context.Background()); nil != err {
+			t.Fatal(err)
+		}
+	}
+	if 1 != len(series) {
+		t.Fatalf("series: %v", series)
+	}
+	if !strings.HasPrefix(series[0], "p.described.count{dc=east,host=") {
+		t.Errorf("series: %s", series[0])
+	}
+	if "a counter" != body["description"] || "requests" != body["units"] {
+		t.Errorf("body: %v", body)
+	}
+}
+
+func TestOpenTSDBAnnotateAuth(t *testing.T) {
+	var auth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+	}))
+	defer ts.Close()
+	r := NewRegistry()
+	RegisterWithMetadata(r, "described", NewCounter(), Metadata{Description: "a counter"})
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:  r,
+		Transport: NewChannelTransport(1),
+		MetaURL:   ts.URL,
+		MetaAuth:  HTTPAuth{Token: "t"},
+	})
+	if err := e.flush(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	if "Bearer t" != auth {
+		t.Errorf("Authorization: %q", auth)
+	}
+}
+
+func TestOpenTSDBAnnotateLimit(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer ts.Close()
+
+	r := NewRegistry()
+	RegisterWithMetadata(r, "t", NewTimer(), Metadata{Description: "a timer"})
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:  r,
+		Transport: NewChannelTransport(2),
+		MetaURL:   ts.URL,
+	})
+	e.flush(context.Background())
+	if annotationsPerFlush != calls {
+		t.Errorf("calls after one flush: %v != %v", annotationsPerFlush, calls)
+	}
+	e.flush(context.Background())
+	if 14 != calls {
+		t.Errorf("calls after two flushes: 14 != %v", calls)
+	}
+}
+
+func TestOpenTSDBAnnotateRetry(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if 1 == calls {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	r := NewRegistry()
+	RegisterWithMetadata(r, "c", NewCounter(), Metadata{Description: "a counter"})
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:  r,
+		Transport: NewChannelTransport(2),
+		MetaURL:   ts.URL,
+	})
+	if err := e.flush(context.Background()); nil == err {
+		t.Error("expected an error")
+	}
+	if err := e.flush(context.Background()); nil != err {
+		t.Error(err)
+	}
+	e.flush(context.Background())
+	if 2 != calls {
+		t.Errorf("calls: 2 != %v", calls)
+	}
+}
+
+func TestOpenTSDBAnnotateRejected(t *testing.T) {
+	var series []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := r.URL.Query().Get("m")
+		series = append(series, m[:1])
+		if strings.HasPrefix(m, "a.") {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	r := NewRegistry()
+	RegisterWithMetadata(r, "a", NewCounter(), Metadata{Description: "rejected"})
+	RegisterWithMetadata(r, "b", NewCounter(), Metadata{Description: "accepted"})
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:  r,
+		Transport: NewChannelTransport(10),
+		MetaURL:   ts.URL,
+	})
+	if err := e.flush(context.Background()); nil == err {
+		t.Error("expected an error")
+	}
+	if !e.annotated["b.count{host="+getShortHostname()+"}"] {
+		t.Errorf("b wasn't annotated after a failed a: %v", series)
+	}
+	for i := 0; i < 6; i++ {
+		e.flush(context.Background())
+	}
+	// a is tried by the 1st, 2nd and 4th flushes, skipped by the 3rd and
+	// by the 5th to 7th.
+	if a, b := strings.Count(strings.Join(series, ""), "a"), strings.Count(strings.Join(series, ""), "b"); 3 != a || 1 != b {
+		t.Errorf("series: %v", series)
+	}
+}
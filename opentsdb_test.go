@@ -1,7 +1,23 @@
 package metrics
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"net"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
 	"time"
 )
 
@@ -20,3 +36,1600 @@ func ExampleOpenTSDBWithConfig() {
 		Tags:          nil,
 	})
 }
+
+func TestUntilAligned(t *testing.T) {
+	base := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		now  time.Time
+		want time.Duration
+	}{
+		{base.Add(3 * time.Second), 7 * time.Second},
+		{base.Add(9*time.Second + 500*time.Millisecond), 500 * time.Millisecond},
+		{base, 10 * time.Second},
+	} {
+		if d := untilAligned(c.now, 10*time.Second); c.want != d {
+			t.Errorf("untilAligned(%v): %v != %v", c.now, c.want, d)
+		}
+	}
+}
+
+func TestOpenTSDBDialCancelled(t *testing.T) {
+	addr, _ := net.ResolveTCPAddr("tcp", "10.255.255.1:4242") // unroutable
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	r := NewRegistry()
+	NewRegisteredCounter("foo", r).Inc(1)
+	start := time.Now()
+	err := openTSDB(ctx, &OpenTSDBConfig{Addr: addr, Registry: r})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatal(elapsed)
+	}
+	if nil == ctx.Err() {
+		t.Skipf("connection attempt finished before it was cancelled: %v", err)
+	}
+	if context.Canceled != err {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestOpenTSDBDialDone(t *testing.T) {
+	addr, _ := net.ResolveTCPAddr("tcp", "10.255.255.1:4242") // unroutable
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	r := NewRegistry()
+	NewRegisteredCounter("foo", r).Inc(1)
+	for ctx, expected := range map[context.Context]error{
+		cancelled: context.Canceled,
+		expired:   context.DeadlineExceeded,
+	} {
+		for _, tlsConfig := range []*tls.Config{nil, {}} {
+			err := openTSDB(ctx, &OpenTSDBConfig{Addr: addr, Registry: r, TLSConfig: tlsConfig})
+			if expected != err {
+				t.Errorf("TLS %v: %v, not %v", nil != tlsConfig, err, expected)
+			}
+		}
+	}
+}
+
+func TestOpenTSDBNoNaN(t *testing.T) {
+	r := NewRegistry()
+	r.Register("histogram", NewHistogram(NewUniformSample(100)))
+	r.Register("timer", NewTimer())
+	NewRegisteredGaugeFloat64("nan", r).Update(math.NaN())
+	ct := NewChannelTransport(1)
+	if err := openTSDB(context.Background(), &OpenTSDBConfig{Registry: r, Transport: ct}); nil != err {
+		t.Fatal(err)
+	}
+	points := <-ct.C
+	if 25 != len(points) {
+		t.Fatal(len(points))
+	}
+	for _, p := range points {
+		if 0 != p.Value {
+			t.Errorf("%s: %v", p.Metric, p.Value)
+		}
+	}
+}
+
+func TestOpenTSDBNilRegistry(t *testing.T) {
+	addr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:4242")
+	err := openTSDB(context.Background(), &OpenTSDBConfig{Addr: addr})
+	if nil == err || "opentsdb: config has no Registry to export" != err.Error() {
+		t.Errorf("err: %v", err)
+	}
+}
+
+func TestOpenTSDBNilAddr(t *testing.T) {
+	err := openTSDB(context.Background(), &OpenTSDBConfig{Registry: NewRegistry()})
+	if nil == err || "opentsdb: config has neither an Addr nor a Transport" != err.Error() {
+		t.Errorf("err: %v", err)
+	}
+}
+
+func TestOpenTSDBGaugeRate(t *testing.T) {
+	r := NewRegistry()
+	g := NewGauge()
+	r.Register("depth", g)
+	r.Register("other", NewGauge())
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:   r,
+		Prefix:     "p",
+		Transport:  NewChannelTransport(1),
+		RateGauges: []string{"depth"},
+	})
+	g.Update(10)
+	if points := e.collect(100); 2 != len(points) {
+		t.Fatalf("first flush: %v", points)
+	}
+	g.Update(40)
+	rates := 0
+	for _, p := range e.collect(110) {
+		if "p.depth.rate" == p.Metric {
+			rates++
+			if 3 != p.Value {
+				t.Errorf("p.depth.rate: 3 != %v", p.Value)
+			}
+		} else if "p.depth.value" != p.Metric && "p.other.value" != p.Metric {
+			t.Errorf("unexpected metric %s", p.Metric)
+		}
+	}
+	if 1 != rates {
+		t.Errorf("rates: 1 != %v", rates)
+	}
+}
+
+func BenchmarkOpenTSDBWritePuts(b *testing.B) {
+	r := NewRegistry()
+	for i := 0; i < 10; i++ {
+		r.Register(fmt.Sprintf("timer%d", i), NewTimer())
+	}
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:  r,
+		Prefix:    "p",
+		Tags:      map[string]string{"dc": "east"},
+		Transport: NewChannelTransport(1),
+	})
+	points := e.collect(0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		writePuts(ioutil.Discard, points, 0)
+	}
+}
+
+func TestOpenTSDBWritePuts(t *testing.T) {
+	tags := map[string]string{"host": "h", "a-b": "2", "a": "1"}
+	var b bytes.Buffer
+	writePuts(&b, []Datapoint{
+		{Metric: "p.c.count", Timestamp: 47, Value: 3, Tags: tags},
+		{Metric: "p.g.value", Timestamp: 47, Value: 0.25, Tags: tags},
+		{Metric: "p.o.value", Timestamp: 47, Value: 1, Tags: map[string]string{"x": "y"}},
+	}, 0)
+	expected := "put p.c.count 47 3 a=1 a-b=2 host=h\n" +
+		"put p.g.value 47 0.25 a=1 a-b=2 host=h\n" +
+		"put p.o.value 47 1 x=y\n"
+	if expected != b.String() {
+		t.Errorf("%q != %q", expected, b.String())
+	}
+}
+
+func TestOpenTSDBSample(t *testing.T) {
+	r := NewRegistry()
+	for _, name := range []string{"user.a", "user.b", "user.c", "total"} {
+		r.Register(name, NewCounter())
+	}
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:    r,
+		Prefix:      "p",
+		Transport:   NewChannelTransport(1),
+		SampleMatch: regexp.MustCompile(`^user\.`),
+		SampleSize:  2,
+	})
+	exported := make(map[string]int)
+	for flush := 0; flush < 3; flush++ {
+		var metrics []string
+		for _, p := range e.collect(0) {
+			if "p.opentsdb.sample.fraction" == p.Metric {
+				if 2.0/3.0 != p.Value {
+					t.Errorf("fraction: %v", p.Value)
+				}
+				continue
+			}
+			metrics = append(metrics, p.Metric)
+			exported[p.Metric]++
+		}
+		if 3 != len(metrics) {
+			t.Errorf("flush %d: %v", flush, metrics)
+		}
+	}
+	expected := map[string]int{"p.total.count": 3, "p.user.a.count": 2, "p.user.b.count": 2, "p.user.c.count": 2}
+	if !reflect.DeepEqual(expected, exported) {
+		t.Errorf("%v != %v", expected, exported)
+	}
+}
+
+func TestOpenTSDBAggregationTags(t *testing.T) {
+	r := NewRegistry()
+	r.Register("c", NewCounter())
+	r.Register("g", NewGauge())
+	r.Register("t", NewTimer())
+	RegisterWithMetadata(r, "peak", NewGauge(), Metadata{Aggregation: "max"})
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:        r,
+		Prefix:          "p",
+		Transport:       NewChannelTransport(1),
+		AggregationTags: true,
+	})
+	aggs := make(map[string]string)
+	for _, p := range e.collect(0) {
+		aggs[p.Metric] = p.Tags["agg"]
+	}
+	for metric, agg := range map[string]string{
+		"p.c.count":          "sum",
+		"p.g.value":          "avg",
+		"p.peak.value":       "max",
+		"p.t.count":          "sum",
+		"p.t.max":            "max",
+		"p.t.95-percentile":  "avg",
+		"p.t.fifteen-minute": "sum",
+	} {
+		if agg != aggs[metric] {
+			t.Errorf("%s: %s != %s", metric, agg, aggs[metric])
+		}
+	}
+
+	e.config.AggregationTags = false
+	for _, p := range e.collect(0) {
+		if _, ok := p.Tags["agg"]; ok {
+			t.Errorf("%s: unexpected agg tag", p.Metric)
+		}
+	}
+}
+
+func TestOpenTSDBStartupDelay(t *testing.T) {
+	r := NewRegistry()
+	r.Register("c", NewCounter())
+	ct := NewChannelTransport(2)
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:     r,
+		Transport:    ct,
+		StartupDelay: time.Hour,
+	})
+	e.flush(context.Background())
+	select {
+	case points := <-ct.C:
+		t.Fatalf("flushed during the startup delay: %v", points)
+	default:
+	}
+	e.start = e.start.Add(-time.Hour)
+	e.flush(context.Background())
+	select {
+	case points := <-ct.C:
+		if 1 != len(points) {
+			t.Errorf("points: %v", points)
+		}
+	default:
+		t.Error("didn't flush after the startup delay")
+	}
+}
+
+func TestTelnetTransportReusesConnection(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Skip(err)
+	}
+	defer l.Close()
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if nil != err {
+				return
+			}
+			conns <- conn
+		}
+	}()
+	tt := &TelnetTransport{Addr: l.Addr().(*net.TCPAddr)}
+	defer tt.Close()
+	points := []Datapoint{{Metric: "p.c.count", Timestamp: 47, Value: 1, Tags: map[string]string{"host": "h"}}}
+	expected := "put p.c.count 47 1 host=h\n"
+	read := func(conn net.Conn, n int) string {
+		buf := make([]byte, n*len(expected))
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.ReadFull(conn, buf); nil != err {
+			t.Fatal(err)
+		}
+		return string(buf)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := tt.Send(context.Background(), points); nil != err {
+			t.Fatal(err)
+		}
+	}
+	conn := <-conns
+	if s := read(conn, 2); expected+expected != s {
+		t.Errorf("%q", s)
+	}
+
+	conn.Close()
+	time.Sleep(10 * time.Millisecond)
+	if err := tt.Send(context.Background(), points); nil != err {
+		t.Fatal(err)
+	}
+	select {
+	case conn = <-conns:
+	case <-time.After(5 * time.Second):
+		t.Fatal("didn't reconnect")
+	}
+	defer conn.Close()
+	if s := read(conn, 1); expected != s {
+		t.Errorf("%q", s)
+	}
+}
+
+func TestOpenTSDBTwoTags(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r).Inc(47)
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:  r,
+		Prefix:    "p",
+		Tags:      map[string]string{"region": "us", "dc": "east"},
+		Transport: NewChannelTransport(1),
+	})
+	var b bytes.Buffer
+	writePuts(&b, e.collect(1000), 0)
+	expected := "put p.c.count 1000 47 dc=east host=" + getShortHostname() + " region=us\n"
+	if expected != b.String() {
+		t.Errorf("%q != %q", expected, b.String())
+	}
+}
+
+func TestOpenTSDBWithConfigContext(t *testing.T) {
+	r := NewRegistry()
+	c := GetOrRegisterCounter("c", r)
+	ct := NewChannelTransport(100)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- OpenTSDBWithConfigContext(ctx, OpenTSDBConfig{
+			Registry:      r,
+			FlushInterval: time.Hour,
+			Transport:     ct,
+		})
+	}()
+	c.Inc(47)
+	cancel()
+	select {
+	case err := <-done:
+		if nil != err {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("didn't return after cancellation")
+	}
+	select {
+	case points := <-ct.C:
+		if 1 != len(points) || 47 != points[0].Value {
+			t.Errorf("final flush: %v", points)
+		}
+	default:
+		t.Error("no final flush")
+	}
+}
+
+func TestPercentileSuffix(t *testing.T) {
+	for p, suffix := range map[float64]string{
+		0.5:    "50-percentile",
+		0.9:    "90-percentile",
+		0.999:  "999-percentile",
+		0.9999: "9999-percentile",
+		0.05:   "5-percentile",
+		1:      "100-percentile",
+	} {
+		if s := percentileSuffix(p); suffix != s {
+			t.Errorf("percentileSuffix(%v): %s != %s", p, suffix, s)
+		}
+	}
+}
+
+func TestOpenTSDBPercentiles(t *testing.T) {
+	r := NewRegistry()
+	r.Register("h", NewHistogram(NewUniformSample(10)))
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:    r,
+		Prefix:      "p",
+		Transport:   NewChannelTransport(1),
+		Percentiles: []float64{0.9, 0.9999},
+	})
+	var metrics []string
+	for _, p := range e.collect(0) {
+		if strings.HasSuffix(p.Metric, "-percentile") {
+			metrics = append(metrics, p.Metric)
+		}
+	}
+	if !reflect.DeepEqual([]string{"p.h.90-percentile", "p.h.9999-percentile"}, metrics) {
+		t.Errorf("metrics: %v", metrics)
+	}
+}
+
+func TestOpenTSDBNoPercentiles(t *testing.T) {
+	r := NewRegistry()
+	r.Register("h", NewHistogram(NewUniformSample(10)))
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:    r,
+		Prefix:      "p",
+		Transport:   NewChannelTransport(1),
+		Percentiles: []float64{},
+	})
+	points := e.collect(0)
+	for _, p := range points {
+		if strings.HasSuffix(p.Metric, "-percentile") {
+			t.Errorf("unexpected metric %s", p.Metric)
+		}
+	}
+	if 5 != len(points) {
+		t.Errorf("points: %v", points)
+	}
+}
+
+func TestTelnetTransportTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	cert := ts.TLS.Certificates[0]
+	ts.Close()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if nil != err {
+		t.Skip(err)
+	}
+	defer l.Close()
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if nil != err {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		lines <- line
+	}()
+	tt := &TelnetTransport{
+		Addr:      l.Addr().(*net.TCPAddr),
+		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	defer tt.Close()
+	points := []Datapoint{{Metric: "p.c.count", Timestamp: 47, Value: 1, Tags: map[string]string{"host": "h"}}}
+	if err := tt.Send(context.Background(), points); nil != err {
+		t.Fatal(err)
+	}
+	select {
+	case line := <-lines:
+		if "put p.c.count 47 1 host=h\n" != line {
+			t.Errorf("%q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing received")
+	}
+}
+
+func TestTelnetTransportWriteTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Skip(err)
+	}
+	defer l.Close()
+	stalled := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if nil != err {
+			return
+		}
+		stalled <- conn // Never read from.
+	}()
+	tt := &TelnetTransport{Addr: l.Addr().(*net.TCPAddr), WriteTimeout: 50 * time.Millisecond}
+	defer tt.Close()
+	points := make([]Datapoint, 1000)
+	for i := range points {
+		points[i] = Datapoint{Metric: "p.c.count", Value: 1, Tags: map[string]string{"host": "h"}}
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for nil == err && time.Now().Before(deadline) {
+		err = tt.Send(context.Background(), points)
+	}
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("err: %v", err)
+	}
+	select {
+	case conn := <-stalled:
+		conn.Close()
+	default:
+	}
+}
+
+func TestOpenTSDBOnce(t *testing.T) {
+	r := NewRegistry()
+	r.Register("c", NewCounter())
+	ct := NewChannelTransport(1)
+	if err := OpenTSDBOnce(OpenTSDBConfig{Registry: r, Transport: ct}); nil != err {
+		t.Fatal(err)
+	}
+	if points := <-ct.C; 1 != len(points) {
+		t.Errorf("points: %v", points)
+	}
+	if err := OpenTSDBOnce(OpenTSDBConfig{Registry: r}); nil == err {
+		t.Error("expected an error for a nil Addr")
+	}
+}
+
+func TestOpenTSDBErrorHandler(t *testing.T) {
+	errs := make(chan error, 1)
+	OpenTSDBWithConfig(OpenTSDBConfig{ErrorHandler: func(err error) { errs <- err }})
+	select {
+	case err := <-errs:
+		if nil == err {
+			t.Error("nil error")
+		}
+	default:
+		t.Error("ErrorHandler wasn't called")
+	}
+}
+
+func TestOpenTSDBErrorHandlerFlush(t *testing.T) {
+	r := NewRegistry()
+	errs := make(chan error, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		OpenTSDBWithConfigContext(ctx, OpenTSDBConfig{
+			Registry:      r,
+			FlushInterval: time.Millisecond,
+			Transport:     errTransport{errors.New("broken")},
+			ErrorHandler: func(err error) {
+				select {
+				case errs <- err:
+				default:
+				}
+			},
+		})
+		close(done)
+	}()
+	select {
+	case err := <-errs:
+		if "broken" != err.Error() {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("ErrorHandler wasn't called")
+	}
+	cancel()
+	<-done
+}
+
+func TestWriteOpenTSDB(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterCounter("counter", r).Inc(47)
+	GetOrRegisterGauge("gauge", r).Update(-3)
+	GetOrRegisterGaugeFloat64("gaugef", r).Update(0.25)
+	h := GetOrRegisterHistogram("histogram", r, NewUniformSample(10))
+	h.Update(1)
+	h.Update(2)
+	h.Update(3)
+	GetOrRegisterMeter("meter", r)
+	tm := GetOrRegisterTimer("timer", r)
+	tm.Update(2 * time.Millisecond)
+	var b bytes.Buffer
+	err := WriteOpenTSDB(&b, OpenTSDBConfig{
+		Registry:     r,
+		DurationUnit: time.Millisecond,
+		Prefix:       "p",
+		Tags:         map[string]string{"dc": "east"},
+	}, 1000)
+	if nil != err {
+		t.Fatal(err)
+	}
+	tags := "dc=east host=" + getShortHostname()
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		lines[line] = true
+	}
+	for _, line := range []string{
+		"put p.counter.count 1000 47 ",
+		"put p.gauge.value 1000 -3 ",
+		"put p.gaugef.value 1000 0.25 ",
+		"put p.histogram.count 1000 3 ",
+		"put p.histogram.min 1000 1 ",
+		"put p.histogram.max 1000 3 ",
+		"put p.histogram.mean 1000 2 ",
+		"put p.histogram.50-percentile 1000 2 ",
+		"put p.histogram.999-percentile 1000 3 ",
+		"put p.meter.count 1000 0 ",
+		"put p.meter.one-minute 1000 0 ",
+		"put p.timer.count 1000 1 ",
+		"put p.timer.max 1000 2 ",
+		"put p.timer.mean 1000 2 ",
+		"put p.timer.99-percentile 1000 2 ",
+	} {
+		if !lines[line+tags] {
+			t.Errorf("missing %q in %q", line+tags, b.String())
+		}
+	}
+	if 1+1+1+10+5+14 != len(lines) {
+		t.Errorf("lines: %v", len(lines))
+	}
+}
+
+type timerWithTags struct {
+	Timer
+	tags map[string]string
+}
+
+func (t timerWithTags) Tags() map[string]string { return t.tags }
+
+func TestOpenTSDBTaggedMetric(t *testing.T) {
+	r := NewRegistry()
+	r.Register("login", timerWithTags{NewTimer(), map[string]string{"endpoint": "/login", "dc": "west"}})
+	r.Register("c", NewCounter())
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:  r,
+		Prefix:    "p",
+		Tags:      map[string]string{"dc": "east", "env": "prod"},
+		Transport: NewChannelTransport(1),
+	})
+	for _, p := range e.collect(0) {
+		expected := map[string]string{"host": getShortHostname(), "dc": "east", "env": "prod"}
+		if strings.HasPrefix(p.Metric, "p.login.") {
+			expected["dc"] = "west"
+			expected["endpoint"] = "/login"
+		}
+		if !reflect.DeepEqual(expected, p.Tags) {
+			t.Errorf("%s: %v != %v", p.Metric, expected, p.Tags)
+		}
+	}
+}
+
+// fakeClock is a clock which stands still at now and ticks only when a time
+// is sent on ticks.
+type fakeClock struct {
+	now   time.Time
+	ticks chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Tick(time.Duration) (<-chan time.Time, func()) {
+	return c.ticks, func() {}
+}
+
+func TestOpenTSDBMillisecondTimestamps(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r).Inc(47)
+	var b bytes.Buffer
+	err := OpenTSDBOnce(OpenTSDBConfig{
+		Registry:              r,
+		Prefix:                "p",
+		Transport:             &WriterTransport{W: &b},
+		MillisecondTimestamps: true,
+		Clock:                 &fakeClock{now: time.Unix(1433160000, 123456789)},
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if want := "put p.c.count 1433160000123 47 host=" + getShortHostname() + "\n"; want != b.String() {
+		t.Errorf("%q != %q", want, b.String())
+	}
+}
+
+func TestOpenTSDBAlignedTimestamps(t *testing.T) {
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:              NewRegistry(),
+		Transport:             NewChannelTransport(1),
+		FlushInterval:         10 * time.Second,
+		AlignFlush:            true,
+		MillisecondTimestamps: true,
+	})
+	if ts := e.timestamp(time.Unix(1433160010, 3e6)); 1433160010000 != ts {
+		t.Errorf("1433160010000 != %v", ts)
+	}
+	if ts := e.timestamp(time.Unix(1433160014, 0)); 1433160014000 != ts {
+		t.Errorf("second flush of an interval: 1433160014000 != %v", ts)
+	}
+	if ts := e.timestamp(time.Unix(1433160020, 250e6)); 1433160020000 != ts {
+		t.Errorf("1433160020000 != %v", ts)
+	}
+	e.config.MillisecondTimestamps = false
+	if ts := e.timestamp(time.Unix(1433160030, 999e6)); 1433160030 != ts {
+		t.Errorf("1433160030 != %v", ts)
+	}
+}
+
+func TestOpenTSDBFlushLoopClock(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r)
+	ct := NewChannelTransport(10)
+	clk := &fakeClock{now: time.Unix(1433160000, 0), ticks: make(chan time.Time)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		OpenTSDBWithConfigContext(ctx, OpenTSDBConfig{
+			Registry:      r,
+			FlushInterval: time.Hour,
+			Transport:     ct,
+			Clock:         clk,
+		})
+		close(done)
+	}()
+	for i := 0; i < 2; i++ {
+		clk.ticks <- time.Time{}
+		if points := <-ct.C; 1433160000 != points[0].Timestamp {
+			t.Errorf("flush %d: 1433160000 != %v", i, points[0].Timestamp)
+		}
+	}
+	cancel()
+	<-done
+}
+
+func TestOpenTSDBGaugeRateMilliseconds(t *testing.T) {
+	r := NewRegistry()
+	g := GetOrRegisterGauge("depth", r)
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:              r,
+		Transport:             NewChannelTransport(1),
+		RateGauges:            []string{"depth"},
+		MillisecondTimestamps: true,
+	})
+	e.collect(100000)
+	g.Update(30)
+	for _, p := range e.collect(110000) {
+		if strings.HasSuffix(p.Metric, ".rate") && 3 != p.Value {
+			t.Errorf("rate: 3 != %v", p.Value)
+		}
+	}
+}
+
+func TestOpenTSDBFilter(t *testing.T) {
+	r := NewRegistry()
+	r.Register("keep", NewTimer())
+	r.Register("drop", NewTimer())
+	calls := make(map[string]int)
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:  r,
+		Prefix:    "p",
+		Transport: NewChannelTransport(1),
+		Filter: func(name string) bool {
+			calls[name]++
+			return "keep" == name
+		},
+	})
+	points := e.collect(0)
+	if 14 != len(points) {
+		t.Errorf("points: %v", len(points))
+	}
+	for _, p := range points {
+		if !strings.HasPrefix(p.Metric, "p.keep.") {
+			t.Errorf("unexpected metric %s", p.Metric)
+		}
+	}
+	if 1 != calls["keep"] || 1 != calls["drop"] {
+		t.Errorf("calls: %v", calls)
+	}
+}
+
+func TestOpenTSDBSanitizeNames(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterCounter("db/query time", r).Inc(1)
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:      r,
+		Prefix:        "my app",
+		Tags:          map[string]string{"zone": "us east=1"},
+		Transport:     NewChannelTransport(1),
+		SanitizeNames: true,
+	})
+	var b bytes.Buffer
+	writePuts(&b, e.collect(1), 0)
+	expected := "put my_app.db/query_time.count 1 1 host=" + sanitizeOpenTSDB(getShortHostname()) + " zone=us_east_1\n"
+	if expected != b.String() {
+		t.Errorf("%q != %q", expected, b.String())
+	}
+	if fields := strings.Fields(b.String()); 6 != len(fields) {
+		t.Errorf("fields: %q", fields)
+	}
+}
+
+func TestOpenTSDBSanitizer(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterCounter("db:query time", r).Inc(1)
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:  r,
+		Prefix:    "app",
+		Tags:      map[string]string{"zone": "us:east"},
+		Transport: NewChannelTransport(1),
+		Sanitizer: func(s string) string {
+			return sanitizeOpenTSDB(strings.Replace(s, ":", ".", -1))
+		},
+		SanitizeNames: true,
+	})
+	var b bytes.Buffer
+	writePuts(&b, e.collect(1), 0)
+	expected := "put app.db.query_time.count 1 1 host=" + sanitizeOpenTSDB(getShortHostname()) + " zone=us.east\n"
+	if expected != b.String() {
+		t.Errorf("%q != %q", expected, b.String())
+	}
+}
+
+func TestOpenTSDBHealthcheck(t *testing.T) {
+	r := NewRegistry()
+	var err error
+	GetOrRegisterHealthcheck("db", r, func(h Healthcheck) {
+		if nil != err {
+			h.Unhealthy(err)
+		} else {
+			h.Healthy()
+		}
+	})
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{Registry: r, Prefix: "p", Transport: NewChannelTransport(1)})
+	for _, healthy := range []bool{true, false, true} {
+		if healthy {
+			err = nil
+		} else {
+			err = errors.New("down")
+		}
+		r.RunHealthchecks()
+		points := e.collect(0)
+		if 1 != len(points) || "p.db.healthy" != points[0].Metric {
+			t.Fatalf("points: %v", points)
+		}
+		if expected := map[bool]float64{true: 1, false: 0}[healthy]; expected != points[0].Value {
+			t.Errorf("healthy %v: %v != %v", healthy, expected, points[0].Value)
+		}
+	}
+}
+
+type countingWriter struct {
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return len(p), nil
+}
+
+func TestOpenTSDBBufferSize(t *testing.T) {
+	r := NewRegistry()
+	for i := 0; i < 50; i++ {
+		r.Register(fmt.Sprintf("timer%d", i), NewTimer())
+	}
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{Registry: r, Prefix: "p", Transport: NewChannelTransport(1)})
+	points := e.collect(0)
+
+	var unbuffered, buffered countingWriter
+	writePuts(&unbuffered, points, 0)
+	writePuts(&buffered, points, 1<<20)
+	if unbuffered.writes < 5 {
+		t.Errorf("writes with the default buffer: %v", unbuffered.writes)
+	}
+	if 1 != buffered.writes {
+		t.Errorf("writes with a 1MiB buffer: 1 != %v", buffered.writes)
+	}
+	t.Logf("writes per flush: %v with the default buffer, %v with a 1MiB buffer", unbuffered.writes, buffered.writes)
+}
+
+type failingWriter struct {
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errors.New("broken pipe")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestWriteOpenTSDBFailingWriter(t *testing.T) {
+	r := NewRegistry()
+	for i := 0; i < 50; i++ {
+		r.Register(fmt.Sprintf("timer%d", i), NewTimer())
+	}
+	for _, n := range []int{0, 100, 5000} {
+		err := WriteOpenTSDB(&failingWriter{n}, OpenTSDBConfig{Registry: r, Prefix: "p"}, 0)
+		if nil == err || "broken pipe" != err.Error() {
+			t.Errorf("failing after %d bytes: %v", n, err)
+		}
+	}
+}
+
+func TestOpenTSDBResetCounters(t *testing.T) {
+	r := NewRegistry()
+	c := GetOrRegisterCounter("c", r)
+	g := GetOrRegisterGauge("g", r)
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:      r,
+		Prefix:        "p",
+		Transport:     NewChannelTransport(1),
+		ResetCounters: true,
+	})
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10000; i++ {
+			c.Inc(1)
+		}
+		close(done)
+	}()
+	g.Update(5)
+	var total float64
+	collect := func() {
+		for _, p := range e.collect(0) {
+			switch p.Metric {
+			case "p.c.count":
+				total += p.Value
+			case "p.g.value":
+				if 5 != p.Value {
+					t.Errorf("p.g.value: 5 != %v", p.Value)
+				}
+			}
+		}
+	}
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		collect()
+	}
+	collect()
+	if 10000 != total {
+		t.Errorf("total: 10000 != %v", total)
+	}
+	if 0 != c.Count() {
+		t.Errorf("c.Count(): 0 != %v", c.Count())
+	}
+}
+
+func TestOpenTSDBResetOnFlush(t *testing.T) {
+	r := NewRegistry()
+	h := GetOrRegisterHistogram("h", r, NewUniformSample(100))
+	tm := GetOrRegisterTaggedTimer("t", map[string]string{"route": "/users"}, r)
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:     r,
+		Prefix:       "p",
+		Transport:    NewChannelTransport(1),
+		ResetOnFlush: true,
+	})
+	values := func() map[string]float64 {
+		values := make(map[string]float64)
+		for _, p := range e.collect(0) {
+			values[p.Metric] = p.Value
+		}
+		return values
+	}
+	h.Update(10)
+	h.Update(30)
+	tm.Update(4)
+	if v := values(); 2 != v["p.h.count"] || 10 != v["p.h.min"] || 30 != v["p.h.max"] || 1 != v["p.t.count"] {
+		t.Errorf("first flush: %v", v)
+	}
+	h.Update(5)
+	if v := values(); 1 != v["p.h.count"] || 5 != v["p.h.max"] || 0 != v["p.t.count"] {
+		t.Errorf("second flush: %v", v)
+	}
+	if v := values(); 0 != v["p.h.count"] || 0 != v["p.h.max"] {
+		t.Errorf("third flush: %v", v)
+	}
+	if 0 == tm.RateMean() {
+		t.Error("timer's meter was reset")
+	}
+}
+
+func TestOpenTSDBFunctionalGauge(t *testing.T) {
+	r := NewRegistry()
+	v := int64(1)
+	GetOrRegisterFunctionalGauge("g", r, func() int64 { return v })
+	GetOrRegisterFunctionalGaugeFloat64("f", r, func() float64 { return float64(v) / 2 })
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:  r,
+		Prefix:    "p",
+		Transport: NewChannelTransport(1),
+	})
+	for _, want := range []int64{1, 3} {
+		v = want
+		values := make(map[string]float64)
+		for _, p := range e.collect(0) {
+			values[p.Metric] = p.Value
+		}
+		if float64(want) != values["p.g.value"] {
+			t.Errorf("p.g.value: %v != %v", want, values["p.g.value"])
+		}
+		if float64(want)/2 != values["p.f.value"] {
+			t.Errorf("p.f.value: %v != %v", float64(want)/2, values["p.f.value"])
+		}
+	}
+}
+
+func TestWriteOpenTSDBMergedRegistry(t *testing.T) {
+	a, b := NewRegistry(), NewRegistry()
+	GetOrRegisterCounter("a", a).Inc(1)
+	GetOrRegisterCounter("b", b).Inc(2)
+	var buf bytes.Buffer
+	err := WriteOpenTSDB(&buf, OpenTSDBConfig{
+		Registry: NewMergedRegistry(a, b),
+		Prefix:   "p",
+	}, 1000)
+	if nil != err {
+		t.Fatal(err)
+	}
+	tags := "host=" + getShortHostname()
+	want := []string{"put p.a.count 1000 1 " + tags, "put p.b.count 1000 2 " + tags}
+	got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	sort.Strings(got)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("%q != %q", want, got)
+	}
+}
+
+// flakyTransport fails the first failures sends.
+type flakyTransport struct {
+	failures, sends int
+}
+
+func (t *flakyTransport) Send(context.Context, []Datapoint) error {
+	t.sends++
+	if t.sends <= t.failures {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func TestOpenTSDBRetries(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r)
+	for _, c := range []struct {
+		failures, retries, sends int
+		ok                       bool
+	}{
+		{0, 2, 1, true},
+		{2, 2, 3, true},
+		{3, 2, 3, false},
+		{1, 0, 1, false},
+	} {
+		ft := &flakyTransport{failures: c.failures}
+		err := OpenTSDBOnce(OpenTSDBConfig{
+			Registry:     r,
+			Transport:    ft,
+			MaxRetries:   c.retries,
+			RetryBackoff: time.Millisecond,
+		})
+		if c.ok != (nil == err) || c.sends != ft.sends {
+			t.Errorf("%d failures, %d retries: %d sends, %v", c.failures, c.retries, ft.sends, err)
+		}
+	}
+}
+
+// recordingTransport is a flakyTransport which records what it sends.
+type recordingTransport struct {
+	flakyTransport
+	sent [][]Datapoint
+}
+
+func (t *recordingTransport) Send(ctx context.Context, points []Datapoint) error {
+	err := t.flakyTransport.Send(ctx, points)
+	if nil == err {
+		t.sent = append(t.sent, points)
+	}
+	return err
+}
+
+func TestOpenTSDBRetryQueue(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterGauge("g", r).Update(47)
+	clk := &fakeClock{}
+	tr := &recordingTransport{flakyTransport: flakyTransport{failures: 2}}
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:       r,
+		Transport:      tr,
+		RetryQueueSize: 1,
+		Clock:          clk,
+	})
+	for i := 1; i <= 4; i++ {
+		clk.now = time.Unix(int64(i), 0)
+		err := e.flush(context.Background())
+		if (i <= 2) != (nil != err) {
+			t.Fatalf("flush %d: %v", i, err)
+		}
+	}
+	if 2 != len(tr.sent) {
+		t.Fatalf("sent: %v", tr.sent)
+	}
+	var timestamps []int64
+	for _, p := range tr.sent[0] {
+		timestamps = append(timestamps, p.Timestamp)
+	}
+	if !reflect.DeepEqual([]int64{2, 3}, timestamps) {
+		t.Errorf("timestamps: %v", timestamps)
+	}
+	if 1 != len(tr.sent[1]) {
+		t.Errorf("queue not emptied: %v", tr.sent[1])
+	}
+}
+
+func TestOpenTSDBRetriesWithinFlushInterval(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r)
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:      r,
+		FlushInterval: 20 * time.Millisecond,
+		Transport:     errTransport{errors.New("unavailable")},
+		MaxRetries:    3,
+		RetryBackoff:  time.Hour,
+	})
+	start := time.Now()
+	if err := e.flush(context.Background()); nil == err {
+		t.Error("expected an error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("retries took %v", elapsed)
+	}
+}
+
+func TestOpenTSDBRetryBackoffMockClock(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r)
+	clk := NewMockClock(time.Unix(1433160000, 0))
+	ft := &flakyTransport{failures: 1}
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:     r,
+		Transport:    ft,
+		MaxRetries:   1,
+		RetryBackoff: time.Minute,
+		Clock:        clk,
+	})
+	done := make(chan error)
+	go func() { done <- e.flush(context.Background()) }()
+	waitForTickers(t, clk, 1)
+	select {
+	case err := <-done:
+		t.Fatalf("retried before the backoff: %v", err)
+	default:
+	}
+	clk.Add(time.Minute)
+	if err := <-done; nil != err || 2 != ft.sends {
+		t.Errorf("%d sends, %v", ft.sends, err)
+	}
+}
+
+func TestOpenTSDBRetriesCancelled(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	err := openTSDB(ctx, &OpenTSDBConfig{
+		Registry:     r,
+		Transport:    errTransport{errors.New("unavailable")},
+		MaxRetries:   3,
+		RetryBackoff: time.Hour,
+	})
+	if nil == err {
+		t.Error("expected an error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("retries took %v", elapsed)
+	}
+}
+
+func TestOpenTSDBExportRawSamples(t *testing.T) {
+	r := NewRegistry()
+	h := GetOrRegisterHistogram("h", r, NewUniformSample(1000))
+	for i := 0; i < 500; i++ {
+		h.Update(int64(i))
+	}
+	tm := GetOrRegisterTimer("t", r)
+	tm.Update(2 * time.Millisecond)
+	tm.Update(4 * time.Millisecond)
+	for _, c := range []struct {
+		max, h int
+	}{
+		{0, defaultMaxRawSamples},
+		{10, 10},
+		{1000, 500},
+	} {
+		e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+			Registry:         r,
+			Transport:        NewChannelTransport(1),
+			DurationUnit:     time.Millisecond,
+			Prefix:           "p",
+			ExportRawSamples: true,
+			MaxRawSamples:    c.max,
+		})
+		values := make(map[string][]float64)
+		for _, p := range e.collect(0) {
+			if !strings.HasSuffix(p.Metric, ".sample") {
+				continue
+			}
+			if strconv.Itoa(len(values[p.Metric])) != p.Tags["sample"] {
+				t.Errorf("%s: sample tag %q at %d", p.Metric, p.Tags["sample"], len(values[p.Metric]))
+			}
+			values[p.Metric] = append(values[p.Metric], p.Value)
+		}
+		if c.h != len(values["p.h.sample"]) {
+			t.Errorf("MaxRawSamples %d: %d values of p.h.sample", c.max, len(values["p.h.sample"]))
+		}
+		ts := values["p.t.sample"]
+		sort.Float64s(ts)
+		if !reflect.DeepEqual([]float64{2, 4}, ts) {
+			t.Errorf("MaxRawSamples %d: p.t.sample %v", c.max, ts)
+		}
+	}
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{Registry: r, Transport: NewChannelTransport(1)})
+	for _, p := range e.collect(0) {
+		if strings.HasSuffix(p.Metric, ".sample") {
+			t.Fatalf("raw samples exported by default: %v", p)
+		}
+	}
+}
+
+func TestOpenTSDBName(t *testing.T) {
+	for _, c := range []struct {
+		prefix, want string
+	}{
+		{"", "http.requests.count"},
+		{"p", "p.http.requests.count"},
+		{"p.", "p.http.requests.count"},
+	} {
+		if name := openTSDBName(c.prefix, "http.requests", "count"); c.want != name {
+			t.Errorf("prefix %q: %q != %q", c.prefix, c.want, name)
+		}
+		var b bytes.Buffer
+		r := NewRegistry()
+		GetOrRegisterCounter("http.requests", r)
+		if err := WriteOpenTSDB(&b, OpenTSDBConfig{Registry: r, Prefix: c.prefix}, 0); nil != err {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(b.String(), "put "+c.want+" ") {
+			t.Errorf("prefix %q: %q", c.prefix, b.String())
+		}
+	}
+}
+
+func TestOpenTSDBTaggedRegistry(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterTaggedCounter("requests", map[string]string{"endpoint": "/login"}, r).Inc(1)
+	GetOrRegisterTaggedCounter("requests", map[string]string{"endpoint": "/logout"}, r).Inc(2)
+	var b bytes.Buffer
+	if err := WriteOpenTSDB(&b, OpenTSDBConfig{Registry: r, Prefix: "p"}, 1000); nil != err {
+		t.Fatal(err)
+	}
+	host := getShortHostname()
+	want := []string{
+		"put p.requests.count 1000 1 endpoint=/login host=" + host,
+		"put p.requests.count 1000 2 endpoint=/logout host=" + host,
+	}
+	got := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	sort.Strings(got)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("%q != %q", want, got)
+	}
+}
+
+func TestOpenTSDBTaggedPrefixedRegistry(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterTaggedCounter("requests", map[string]string{"endpoint": "/login"}, NewPrefixedChildRegistry(r, "http.")).Inc(1)
+	if nil == r.Get("http.requests{endpoint=/login}") {
+		t.Fatal("http.requests{endpoint=/login} wasn't registered")
+	}
+	var b bytes.Buffer
+	if err := WriteOpenTSDB(&b, OpenTSDBConfig{Registry: r}, 1000); nil != err {
+		t.Fatal(err)
+	}
+	if want := "put http.requests.count 1000 1 endpoint=/login host=" + getShortHostname() + "\n"; want != b.String() {
+		t.Errorf("%q != %q", want, b.String())
+	}
+}
+
+func TestOpenTSDBReconnectsWithBackoff(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Skip(err)
+	}
+	addr := l.Addr().(*net.TCPAddr)
+	l.Close() // The TSD is down when the flush begins.
+	lines := make(chan string, 1)
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		l, err := net.ListenTCP("tcp", addr)
+		if nil != err {
+			lines <- err.Error()
+			return
+		}
+		defer l.Close()
+		conn, err := l.Accept()
+		if nil != err {
+			lines <- err.Error()
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		lines <- line
+	}()
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r).Inc(47)
+	err = OpenTSDBOnce(OpenTSDBConfig{
+		Addr:         addr,
+		Registry:     r,
+		Prefix:       "p",
+		DialTimeout:  time.Second,
+		MaxRetries:   8,
+		RetryBackoff: 5 * time.Millisecond,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	select {
+	case line := <-lines:
+		if !strings.HasPrefix(line, "put p.c.count ") {
+			t.Errorf("%q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing received")
+	}
+}
+
+func TestOpenTSDBHealthcheckErrorTags(t *testing.T) {
+	r := NewRegistry()
+	h := NewRegisteredFunctionalHealthcheck("db", r, func() error { return errors.New("connection refused") })
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{Registry: r, Prefix: "p", Tags: map[string]string{"dc": "east"}, HealthcheckErrorTags: true, Transport: NewChannelTransport(1)})
+	h.Check()
+	points := e.collect(0)
+	if 1 != len(points) || 0 != points[0].Value || "connection_refused" != points[0].Tags["error"] || "east" != points[0].Tags["dc"] {
+		t.Fatalf("points: %v", points)
+	}
+	h.Healthy()
+	points = e.collect(0)
+	if _, ok := points[0].Tags["error"]; 1 != points[0].Value || ok {
+		t.Errorf("points: %v", points)
+	}
+}
+
+func TestOpenTSDBMeterWithWindows(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredMeterWithWindows("m", r, 10*time.Second).Mark(1)
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{Registry: r, Prefix: "p", Transport: NewChannelTransport(1)})
+	var found bool
+	for _, p := range e.collect(0) {
+		found = found || "p.m.rate-10s" == p.Metric
+	}
+	if !found {
+		t.Error("p.m.rate-10s wasn't exported")
+	}
+}
+
+func TestTelnetTransportBatches(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Skip(err)
+	}
+	defer l.Close()
+	type read struct {
+		at   time.Time
+		line string
+	}
+	reads := make(chan read, 10)
+	go func() {
+		conn, err := l.Accept()
+		if nil != err {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			reads <- read{time.Now(), scanner.Text()}
+			if strings.Contains(scanner.Text(), "bad name") {
+				conn.Write([]byte("put: illegal argument: Invalid metric name (\"bad name\")\n"))
+			}
+		}
+	}()
+	tt := &TelnetTransport{Addr: l.Addr().(*net.TCPAddr), MaxBatchSize: 2, InterBatchDelay: 50 * time.Millisecond, ReadErrors: true}
+	defer tt.Close()
+	var points []Datapoint
+	for i := 0; i < 5; i++ {
+		points = append(points, Datapoint{Metric: "p.c" + strconv.Itoa(i), Timestamp: 47, Value: 1, Tags: map[string]string{"host": "h"}})
+	}
+	points[2].Metric = "bad name"
+	err = tt.Send(context.Background(), points)
+	if tsdErr, ok := err.(*TSDError); !ok || 1 != len(tsdErr.Lines) || !strings.HasPrefix(tsdErr.Lines[0], "put: illegal argument") {
+		t.Fatalf("err: %v", err)
+	}
+	var times []time.Time
+	for i := 0; i < 5; i++ {
+		r := <-reads
+		times = append(times, r.at)
+	}
+	if times[2].Sub(times[1]) < 40*time.Millisecond || times[4].Sub(times[3]) < 40*time.Millisecond {
+		t.Errorf("batches weren't delayed: %v", times)
+	}
+	if times[1].Sub(times[0]) > 40*time.Millisecond {
+		t.Errorf("batch was split: %v", times)
+	}
+}
+
+func TestOpenTSDBBucketedHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := GetOrRegisterBucketedHistogram("h", r, NewUniformSample(100), []int64{100, 500})
+	h.Update(47)
+	h.Update(1000)
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{Registry: r, Prefix: "p", Transport: NewChannelTransport(1), Percentiles: []float64{}})
+	values := make(map[string]float64)
+	for _, p := range e.collect(1) {
+		values[p.Metric] = p.Value
+	}
+	if 1 != values["p.h.le_100"] || 1 != values["p.h.le_500"] || 2 != values["p.h.le_inf"] {
+		t.Errorf("values: %v", values)
+	}
+}
+
+func TestOpenTSDBTopK(t *testing.T) {
+	r := NewRegistry()
+	tk := GetOrRegisterTopK("clients", r, 2, 0)
+	tk.Add("a", 3)
+	tk.Add("c", 1)
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{Registry: r, Prefix: "p", Transport: NewChannelTransport(1)})
+	values := make(map[string]float64)
+	for _, p := range e.collect(1) {
+		if "p.clients.count" == p.Metric {
+			values[p.Tags["key"]] = p.Value
+		}
+	}
+	if 2 != len(values) || 3 != values["a"] || 1 != values["c"] {
+		t.Errorf("values: %v", values)
+	}
+}
+
+func TestOpenTSDBPrefixIntervals(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterCounter("requests", r)
+	GetOrRegisterGauge("disk.used", r)
+	GetOrRegisterGauge("disk.io.queue", r)
+	clk := &fakeClock{}
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:        r,
+		FlushInterval:   10 * time.Second,
+		Transport:       NewChannelTransport(1),
+		PrefixIntervals: map[string]time.Duration{"disk.": 30 * time.Second, "disk.io.": 10 * time.Second},
+		Clock:           clk,
+	})
+	var flushes []string
+	for i := 0; i < 4; i++ {
+		clk.now = time.Unix(int64(10*i), int64(i)*int64(time.Millisecond)) // Ticks drift a little
+		var names []string
+		for _, p := range e.collect(int64(10 * i)) {
+			names = append(names, p.Metric)
+		}
+		sort.Strings(names)
+		flushes = append(flushes, strings.Join(names, " "))
+	}
+	expected := []string{
+		"disk.io.queue.value disk.used.value requests.count",
+		"disk.io.queue.value requests.count",
+		"disk.io.queue.value requests.count",
+		"disk.io.queue.value disk.used.value requests.count",
+	}
+	if !reflect.DeepEqual(expected, flushes) {
+		t.Errorf("%q", flushes)
+	}
+}
+
+func TestOpenTSDBSkipUnchanged(t *testing.T) {
+	r := NewRegistry()
+	c := GetOrRegisterCounter("c", r)
+	g := GetOrRegisterGaugeFloat64("g", r)
+	clk := &fakeClock{now: time.Unix(1433160000, 0)}
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:      r,
+		Transport:     NewChannelTransport(1),
+		SkipUnchanged: true,
+		MaxStaleness:  time.Minute,
+		Clock:         clk,
+	})
+	metrics := func() string {
+		var names []string
+		for _, p := range e.collect(clk.now.Unix()) {
+			names = append(names, p.Metric)
+		}
+		sort.Strings(names)
+		clk.now = clk.now.Add(10 * time.Second)
+		return strings.Join(names, " ")
+	}
+	c.Inc(1)
+	g.Update(2)
+	if got := metrics(); "c.count g.value" != got {
+		t.Errorf("first flush: %q", got)
+	}
+	if got := metrics(); "" != got {
+		t.Errorf("unchanged: %q", got)
+	}
+	c.Inc(1)
+	if got := metrics(); "c.count" != got {
+		t.Errorf("counter changed: %q", got)
+	}
+	for i := 0; i < 3; i++ {
+		metrics()
+	}
+	if got := metrics(); "g.value" != got {
+		t.Errorf("gauge stale: %q", got)
+	}
+	metrics()
+	if got := metrics(); "c.count" != got {
+		t.Errorf("counter stale: %q", got)
+	}
+}
+
+func TestOpenTSDBSkipUnchangedResetCounters(t *testing.T) {
+	r := NewRegistry()
+	c := GetOrRegisterCounter("c", r)
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:      r,
+		Transport:     NewChannelTransport(1),
+		SkipUnchanged: true,
+		ResetCounters: true,
+	})
+	for i, want := range []int{1, 1, 1, 1, 0} {
+		if i < 3 {
+			c.Inc(2)
+		}
+		if points := e.collect(int64(i)); want != len(points) {
+			t.Errorf("flush %d: %d != %d points", i, want, len(points))
+		}
+	}
+}
+
+func TestOpenTSDBDeltaCounters(t *testing.T) {
+	r := NewRegistry()
+	c := GetOrRegisterCounter("c", r)
+	m := GetOrRegisterMeter("m", r)
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{Registry: r, Transport: NewChannelTransport(1), DeltaCounters: true})
+	counts := func() (int64, int64) {
+		values := make(map[string]float64)
+		for _, p := range e.collect(1) {
+			values[p.Metric] = p.Value
+		}
+		return int64(values["c.count"]), int64(values["m.count"])
+	}
+	c.Inc(5)
+	m.Mark(3)
+	if cc, mc := counts(); 5 != cc || 3 != mc {
+		t.Errorf("first flush: %d %d", cc, mc)
+	}
+	c.Inc(2)
+	m.Mark(1)
+	if cc, mc := counts(); 2 != cc || 1 != mc {
+		t.Errorf("second flush: %d %d", cc, mc)
+	}
+	if 7 != c.Count() {
+		t.Errorf("counter reset: %d", c.Count())
+	}
+	c.Clear()
+	c.Inc(1)
+	if cc, _ := counts(); 1 != cc {
+		t.Errorf("after clearing: %d", cc)
+	}
+}
+
+func TestOpenTSDBAlignFlushMockClock(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r)
+	ct := NewChannelTransport(10)
+	clk := NewMockClock(time.Unix(1433160003, 0))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		OpenTSDBWithConfigContext(ctx, OpenTSDBConfig{
+			Registry:      r,
+			FlushInterval: 10 * time.Second,
+			AlignFlush:    true,
+			Transport:     ct,
+			Clock:         clk,
+		})
+		close(done)
+	}()
+	waitForTickers(t, clk, 1)
+	clk.Add(7 * time.Second)
+	if points := <-ct.C; 1433160010 != points[0].Timestamp {
+		t.Errorf("aligned flush: 1433160010 != %v", points[0].Timestamp)
+	}
+	cancel()
+	<-done
+}
+
+func TestOpenTSDBAlignFlushJitter(t *testing.T) {
+	if _, err := newOpenTSDBExporter(OpenTSDBConfig{
+		Registry:   NewRegistry(),
+		Transport:  NewChannelTransport(1),
+		AlignFlush: true,
+		Jitter:     time.Second,
+	}); nil == err {
+		t.Error("no error for AlignFlush with Jitter")
+	}
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+)
+
+// UDPTransport is a Transport which sends put lines to Addr over UDP, as
+// tcollector-compatible relays accept, for fire-and-forget telemetry.  It
+// packs as many whole lines into each datagram as fit in MaxPacketSize, so
+// that no flush produces fragmented datagrams.  Nothing confirms delivery.
+type UDPTransport struct {
+	Addr          *net.UDPAddr
+	MaxPacketSize int // Largest datagram sent, defaults to 1432 bytes to fit a typical Ethernet MTU
+
+	mutex sync.Mutex
+	conn  net.Conn
+}
+
+// Send writes one put line per datapoint to Addr, opening a socket if there
+// is none.  Lines too long for a datagram of their own are dropped, and
+// reported by the error once the rest have been sent.
+func (t *UDPTransport) Send(ctx context.Context, points []Datapoint) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if nil == t.conn {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "udp", t.Addr.String())
+		if nil != err {
+			return err
+		}
+		t.conn = conn
+	}
+	var b bytes.Buffer
+	if err := writePuts(&b, points, 0); nil != err {
+		return err
+	}
+	lines := strings.SplitAfter(b.String(), "\n")
+	max := t.MaxPacketSize
+	if max <= 0 {
+		max = defaultMaxDatagram
+	}
+	return writeDatagrams(t.conn, lines[:len(lines)-1], "", max)
+}
+
+// Close closes the socket, if one is open.  A later Send opens another.
+func (t *UDPTransport) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if nil == t.conn {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
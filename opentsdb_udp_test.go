@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUDPTransport(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if nil != err {
+		t.Skip(err)
+	}
+	defer conn.Close()
+	r := NewRegistry()
+	for i := 0; i < 100; i++ {
+		GetOrRegisterCounter("c"+strconv.Itoa(i), r).Inc(1)
+	}
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	if err := OpenTSDBOnce(OpenTSDBConfig{
+		Addr:          &net.TCPAddr{IP: addr.IP, Port: addr.Port},
+		Network:       "udp",
+		MaxPacketSize: 512,
+		Registry:      r,
+		Prefix:        "p",
+	}); nil != err {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 2048)
+	lines, packets := 0, 0
+	for lines < 100 {
+		n, err := conn.Read(buf)
+		if nil != err {
+			t.Fatalf("%d lines in %d packets: %v", lines, packets, err)
+		}
+		if 512 < n {
+			t.Errorf("packet of %d bytes", n)
+		}
+		if '\n' != buf[n-1] {
+			t.Errorf("packet splits a line: %q", buf[:n])
+		}
+		packets++
+		lines += strings.Count(string(buf[:n]), "\n")
+	}
+	if packets < 2 {
+		t.Errorf("%d packets", packets)
+	}
+}
+
+func TestUDPTransportDropsLongLines(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if nil != err {
+		t.Skip(err)
+	}
+	defer conn.Close()
+	tr := &UDPTransport{Addr: conn.LocalAddr().(*net.UDPAddr), MaxPacketSize: 64}
+	defer tr.Close()
+	err = tr.Send(context.Background(), []Datapoint{
+		{Metric: strings.Repeat("m", 64), Timestamp: 1, Value: 1},
+		{Metric: "short", Timestamp: 1, Value: 1},
+	})
+	if nil == err {
+		t.Error("no error for the dropped line")
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 128)
+	if n, err := conn.Read(buf); nil != err || "put short 1 1 \n" != string(buf[:n]) {
+		t.Errorf("%q %v", buf[:n], err)
+	}
+}
+
+func TestOpenTSDBUnknownNetwork(t *testing.T) {
+	if _, err := newOpenTSDBExporter(OpenTSDBConfig{Registry: NewRegistry(), Addr: &net.TCPAddr{}, Network: "sctp"}); nil == err {
+		t.Error("no error for an unknown Network")
+	}
+}
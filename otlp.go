@@ -0,0 +1,435 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// OTLPEndpoint is the default URL of an OpenTelemetry collector's OTLP/HTTP
+// metrics receiver.
+const OTLPEndpoint = "http://localhost:4318/v1/metrics"
+
+// OTLPConfig provides a container with configuration parameters for
+// the OTLP exporter
+type OTLPConfig struct {
+	Endpoint      string            // OTLP/HTTP metrics URL, defaults to OTLPEndpoint
+	Headers       map[string]string // Headers added to every request, such as for authentication
+	Registry      Registry          // Registry to be exported
+	FlushInterval time.Duration     // Flush interval
+	DurationUnit  time.Duration     // Time conversion unit for durations, defaults to nanoseconds
+	Prefix        string            // Prefix to be prepended to metric names
+	Tags          map[string]string // Attributes of the resource every metric belongs to, such as service.name
+	Percentiles   []float64         // Quantiles of histogram and timer summaries, defaults to 0.5, 0.75, 0.95, 0.99 and 0.999 if nil, none if empty
+	ErrorHandler  func(error)       // Called with each flush error, defaults to log.Println
+	Filter        func(string) bool // Metrics whose names it returns false for aren't exported, all are if nil
+	Client        *http.Client      // HTTP client, defaults to http.DefaultClient
+}
+
+// OTLPWithConfig is a blocking exporter function which sends the metrics in
+// c.Registry to an OpenTelemetry collector every c.FlushInterval, as OTLP
+// over HTTP in its JSON encoding.  Counters are sent as cumulative sums and
+// meters as monotonic ones of their counts, gauges as gauges and histograms
+// and timers as summaries, timers in DurationUnit.  BucketedHistograms are
+// sent as histograms of their buckets, with their exemplars; OTLP summaries
+// carry none.  The tags of Tagged metrics become their attributes.
+// Healthchecks aren't sent.
+func OTLPWithConfig(c OTLPConfig) {
+	if err := OTLPWithConfigContext(context.Background(), c); nil != err {
+		c.handleError(err)
+	}
+}
+
+// OTLPWithConfigContext is like OTLPWithConfig but returns once ctx is done,
+// after a final flush which is given one FlushInterval to complete.  Errors
+// from flushes other than the final one are passed to the ErrorHandler.  An
+// error is returned at once if c.FlushInterval isn't positive.
+func OTLPWithConfigContext(ctx context.Context, c OTLPConfig) error {
+	e, err := newOTLPExporter(c)
+	if nil != err {
+		return err
+	}
+	return FlushLoopWithConfig(ctx, FlushLoopConfig{
+		Registry:      c.Registry,
+		FlushInterval: c.FlushInterval,
+		Exporter:      e,
+		ErrorHandler:  c.ErrorHandler,
+	})
+}
+
+// NewOTLPExporter constructs a new Exporter which flushes the registry it's
+// given to an OpenTelemetry collector as configured by c, so that it can be
+// run by FlushLoop or be a sink of a FanoutExporter.  c.Registry, which it
+// ignores, needn't be set.
+func NewOTLPExporter(c OTLPConfig) (Exporter, error) {
+	if nil == c.Registry {
+		c.Registry = DefaultRegistry
+	}
+	return newOTLPExporter(c)
+}
+
+// handleError passes err to the ErrorHandler, or logs it if there is none.
+func (c *OTLPConfig) handleError(err error) {
+	if nil != c.ErrorHandler {
+		c.ErrorHandler(err)
+	} else {
+		log.Println(err)
+	}
+}
+
+// otlpExporter holds the state of an OTLP exporter between flushes.
+type otlpExporter struct {
+	config OTLPConfig
+	start  time.Time // Start of every cumulative sum
+}
+
+func newOTLPExporter(c OTLPConfig) (*otlpExporter, error) {
+	if nil == c.Registry {
+		return nil, errors.New("otlp: config has no Registry to export")
+	}
+	if "" == c.Endpoint {
+		c.Endpoint = OTLPEndpoint
+	}
+	if nil == c.Client {
+		c.Client = http.DefaultClient
+	}
+	if 0 == c.DurationUnit {
+		c.DurationUnit = time.Nanosecond
+	}
+	return &otlpExporter{config: c, start: time.Now()}, nil
+}
+
+// The following types are the parts of an ExportMetricsServiceRequest used
+// by the exporter, in the JSON encoding of protobuf, which represents 64-bit
+// integers as strings.
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Unit        string         `json:"unit,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Summary     *otlpSummary   `json:"summary,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsInt             string          `json:"asInt,omitempty"`
+	AsDouble          *float64        `json:"asDouble,omitempty"`
+}
+
+type otlpSummary struct {
+	DataPoints []otlpSummaryDataPoint `json:"dataPoints"`
+}
+
+type otlpSummaryDataPoint struct {
+	Attributes        []otlpAttribute     `json:"attributes,omitempty"`
+	StartTimeUnixNano string              `json:"startTimeUnixNano"`
+	TimeUnixNano      string              `json:"timeUnixNano"`
+	Count             string              `json:"count"`
+	Sum               float64             `json:"sum"`
+	QuantileValues    []otlpQuantileValue `json:"quantileValues"`
+}
+
+type otlpQuantileValue struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                      `json:"aggregationTemporality"`
+}
+
+type otlpHistogramDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []float64       `json:"explicitBounds"`
+	Exemplars         []otlpExemplar  `json:"exemplars,omitempty"`
+}
+
+type otlpExemplar struct {
+	FilteredAttributes []otlpAttribute `json:"filteredAttributes,omitempty"`
+	TimeUnixNano       string          `json:"timeUnixNano"`
+	AsDouble           float64         `json:"asDouble"`
+	TraceID            string          `json:"traceId,omitempty"`
+}
+
+// otlpCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+const otlpCumulative = 2
+
+// Export flushes r, for FlushLoop.
+func (e *otlpExporter) Export(r Registry) error {
+	return e.ExportContext(context.Background(), r)
+}
+
+// ExportContext flushes r, for FlushLoop.
+func (e *otlpExporter) ExportContext(ctx context.Context, r Registry) error {
+	e.config.Registry = r
+	return e.flush(ctx, time.Now())
+}
+
+// flush sends every metric in the registry, stamped with now, in one
+// request.
+func (e *otlpExporter) flush(ctx context.Context, now time.Time) error {
+	c := &e.config
+	body, err := json.Marshal(otlpRequest{[]otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: otlpAttributes(c.Tags)},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "github.com/rcrowley/go-metrics"},
+			Metrics: e.collect(now),
+		}},
+	}}})
+	if nil != err {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.Endpoint, bytes.NewReader(body))
+	if nil != err {
+		return err
+	}
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.Client.Do(req.WithContext(ctx))
+	if nil != err {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("otlp: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// collect converts every metric in the registry to OTLP.
+func (e *otlpExporter) collect(now time.Time) []otlpMetric {
+	c := &e.config
+	du := float64(c.DurationUnit)
+	start := strconv.FormatInt(e.start.UnixNano(), 10)
+	ts := strconv.FormatInt(now.UnixNano(), 10)
+	tagged := make(map[string]map[string]string)
+	exported := make(map[string]string)
+	c.Registry.Each(func(name string, i interface{}) {
+		if t, ok := i.(Tagged); ok {
+			tagged[name] = t.Tags()
+		}
+		exported[name] = exportName(name, i)
+	})
+	percentiles := percentilesOrDefault(c.Percentiles)
+	var metrics []otlpMetric
+	c.Registry.Snapshot().Each(func(key string, i interface{}) {
+		name, ok := exported[key]
+		if !ok {
+			name = key
+		}
+		if nil != c.Filter && !c.Filter(name) {
+			return
+		}
+		md := metadata(c.Registry, key, i)
+		if "" != c.Prefix {
+			name = c.Prefix + "." + name
+		}
+		m := otlpMetric{Name: name, Description: md.Description, Unit: md.Unit}
+		attrs := otlpAttributes(tagged[key])
+		sum := func(n int64, monotonic bool) *otlpSum {
+			return &otlpSum{
+				DataPoints: []otlpNumberDataPoint{{
+					Attributes:        attrs,
+					StartTimeUnixNano: start,
+					TimeUnixNano:      ts,
+					AsInt:             strconv.FormatInt(n, 10),
+				}},
+				AggregationTemporality: otlpCumulative,
+				IsMonotonic:            monotonic,
+			}
+		}
+		summary := func(count int64, total float64, ps []float64, scale float64) *otlpSummary {
+			qs := make([]otlpQuantileValue, len(ps))
+			for i, p := range ps {
+				qs[i] = otlpQuantileValue{percentiles[i], p / scale}
+			}
+			return &otlpSummary{[]otlpSummaryDataPoint{{
+				Attributes:        attrs,
+				StartTimeUnixNano: start,
+				TimeUnixNano:      ts,
+				Count:             strconv.FormatInt(count, 10),
+				Sum:               total / scale,
+				QuantileValues:    qs,
+			}}}
+		}
+		switch metric := i.(type) {
+		case Counter:
+			m.Sum = sum(metric.Count(), false)
+		case Gauge:
+			m.Gauge = &otlpGauge{[]otlpNumberDataPoint{{
+				Attributes:   attrs,
+				TimeUnixNano: ts,
+				AsInt:        strconv.FormatInt(metric.Value(), 10),
+			}}}
+		case GaugeFloat64:
+			v := metric.Value()
+			// JSON has no representation of values which aren't finite.
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				v = 0
+			}
+			m.Gauge = &otlpGauge{[]otlpNumberDataPoint{{
+				Attributes:   attrs,
+				TimeUnixNano: ts,
+				AsDouble:     &v,
+			}}}
+		case BucketedHistogram:
+			m.Histogram = &otlpHistogram{
+				DataPoints: []otlpHistogramDataPoint{{
+					Attributes:        attrs,
+					StartTimeUnixNano: start,
+					TimeUnixNano:      ts,
+					Count:             strconv.FormatInt(metric.Count(), 10),
+					Sum:               float64(metric.Sum()),
+					BucketCounts:      otlpBucketCounts(metric.BucketCounts(), metric.Count()),
+					ExplicitBounds:    otlpBounds(metric.Bounds()),
+					Exemplars:         otlpExemplars(metric.Exemplars()),
+				}},
+				AggregationTemporality: otlpCumulative,
+			}
+		case Histogram:
+			m.Summary = summary(metric.Count(), float64(metric.Sum()), metric.Percentiles(percentiles), 1)
+		case Meter:
+			m.Sum = sum(metric.Count(), true)
+		case Timer:
+			m.Summary = summary(metric.Count(), float64(metric.Sum()), metric.Percentiles(percentiles), du)
+		default:
+			return
+		}
+		metrics = append(metrics, m)
+	})
+	return metrics
+}
+
+// otlpBucketCounts converts the cumulative counts of a BucketedHistogram to
+// the count of each bucket alone, the last counting the values above every
+// bound.
+func otlpBucketCounts(cumulative []int64, count int64) []string {
+	counts := make([]string, len(cumulative)+1)
+	var below int64
+	for i, c := range append(cumulative, count) {
+		counts[i] = strconv.FormatInt(c-below, 10)
+		below = c
+	}
+	return counts
+}
+
+func otlpBounds(bounds []int64) []float64 {
+	fs := make([]float64, len(bounds))
+	for i, b := range bounds {
+		fs[i] = float64(b)
+	}
+	return fs
+}
+
+// otlpExemplars converts exemplars, sending their trace IDs as traceId if
+// they're the 32 hex digits of a W3C trace ID and as a trace_id attribute
+// otherwise.
+func otlpExemplars(exemplars []Exemplar) []otlpExemplar {
+	if 0 == len(exemplars) {
+		return nil
+	}
+	oes := make([]otlpExemplar, len(exemplars))
+	for i, e := range exemplars {
+		oes[i] = otlpExemplar{
+			TimeUnixNano: strconv.FormatInt(e.Time.UnixNano(), 10),
+			AsDouble:     float64(e.Value),
+		}
+		if isTraceID(e.TraceID) {
+			oes[i].TraceID = e.TraceID
+		} else {
+			oes[i].FilteredAttributes = otlpAttributes(map[string]string{"trace_id": e.TraceID})
+		}
+	}
+	return oes
+}
+
+// isTraceID reports whether id is a W3C trace ID of 32 lowercase hex digits.
+func isTraceID(id string) bool {
+	if 32 != len(id) {
+		return false
+	}
+	for _, r := range id {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// otlpAttributes converts tags to attributes sorted by key.
+func otlpAttributes(tags map[string]string) []otlpAttribute {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]otlpAttribute, len(keys))
+	for i, k := range keys {
+		attrs[i].Key = k
+		attrs[i].Value.StringValue = tags[k]
+	}
+	return attrs
+}
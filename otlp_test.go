@@ -0,0 +1,172 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOTLP(t *testing.T) {
+	var reqs []otlpRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if "/v1/metrics" != r.URL.Path || "application/json" != r.Header.Get("Content-Type") {
+			t.Errorf("%v %q", r.URL, r.Header.Get("Content-Type"))
+		}
+		if "secret" != r.Header.Get("Api-Key") {
+			t.Errorf("Api-Key: %q", r.Header.Get("Api-Key"))
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); nil != err {
+			t.Error(err)
+		}
+		reqs = append(reqs, req)
+	}))
+	defer ts.Close()
+
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r).Inc(47)
+	GetOrRegisterGaugeFloat64("g", r).Update(0.25)
+	GetOrRegisterMeter("m", r).Mark(3)
+	tm := GetOrRegisterTaggedTimer("t", map[string]string{"route": "/users"}, r)
+	tm.Update(2 * time.Millisecond)
+	tm.Update(4 * time.Millisecond)
+	r.Register("h", NewHealthcheck(func(Healthcheck) {}))
+	e, _ := newOTLPExporter(OTLPConfig{
+		Endpoint:     ts.URL + "/v1/metrics",
+		Headers:      map[string]string{"Api-Key": "secret"},
+		Registry:     r,
+		DurationUnit: time.Millisecond,
+		Prefix:       "p",
+		Tags:         map[string]string{"service.name": "api"},
+	})
+	e.start = time.Unix(900, 0)
+	if err := e.flush(context.Background(), time.Unix(1000, 5)); nil != err {
+		t.Fatal(err)
+	}
+	if 1 != len(reqs) || 1 != len(reqs[0].ResourceMetrics) {
+		t.Fatalf("%+v", reqs)
+	}
+	rm := reqs[0].ResourceMetrics[0]
+	if attrs := rm.Resource.Attributes; 1 != len(attrs) || "service.name" != attrs[0].Key || "api" != attrs[0].Value.StringValue {
+		t.Errorf("resource attributes: %+v", attrs)
+	}
+	metrics := make(map[string]otlpMetric)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+	if 4 != len(metrics) {
+		t.Fatalf("%+v", metrics)
+	}
+	if s := metrics["p.c"].Sum; nil == s || s.IsMonotonic || otlpCumulative != s.AggregationTemporality ||
+		"47" != s.DataPoints[0].AsInt || "900000000000" != s.DataPoints[0].StartTimeUnixNano || "1000000000005" != s.DataPoints[0].TimeUnixNano {
+		t.Errorf("p.c: %+v", s)
+	}
+	if g := metrics["p.g"].Gauge; nil == g || nil == g.DataPoints[0].AsDouble || 0.25 != *g.DataPoints[0].AsDouble {
+		t.Errorf("p.g: %+v", g)
+	}
+	if s := metrics["p.m"].Sum; nil == s || !s.IsMonotonic || "3" != s.DataPoints[0].AsInt {
+		t.Errorf("p.m: %+v", s)
+	}
+	s := metrics["p.t"].Summary
+	if nil == s {
+		t.Fatalf("p.t: %+v", metrics["p.t"])
+	}
+	dp := s.DataPoints[0]
+	if "2" != dp.Count || 6 != dp.Sum || 5 != len(dp.QuantileValues) || 0.5 != dp.QuantileValues[0].Quantile || 3 != dp.QuantileValues[0].Value {
+		t.Errorf("p.t: %+v", dp)
+	}
+	if 1 != len(dp.Attributes) || "route" != dp.Attributes[0].Key || "/users" != dp.Attributes[0].Value.StringValue {
+		t.Errorf("p.t attributes: %+v", dp.Attributes)
+	}
+}
+
+func TestOTLPError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unsupported", http.StatusUnsupportedMediaType)
+	}))
+	defer ts.Close()
+	e, _ := newOTLPExporter(OTLPConfig{Endpoint: ts.URL, Registry: NewRegistry()})
+	err := e.flush(context.Background(), time.Now())
+	if nil == err || !strings.Contains(err.Error(), "415") || !strings.Contains(err.Error(), "unsupported") {
+		t.Fatal(err)
+	}
+}
+
+func TestOTLPPercentiles(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterHistogram("h", r, NewUniformSample(10)).Update(47)
+	e, _ := newOTLPExporter(OTLPConfig{Registry: r, Percentiles: []float64{0.9}})
+	qs := e.collect(time.Now())[0].Summary.DataPoints[0].QuantileValues
+	if 1 != len(qs) || 0.9 != qs[0].Quantile || 47 != qs[0].Value {
+		t.Errorf("%+v", qs)
+	}
+	e.config.Percentiles = []float64{}
+	if qs := e.collect(time.Now())[0].Summary.DataPoints[0].QuantileValues; 0 != len(qs) {
+		t.Errorf("%+v", qs)
+	}
+}
+
+func TestOTLPBucketedHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := GetOrRegisterBucketedHistogram("h", r, NewUniformSample(10), []int64{100})
+	h.UpdateWithExemplar(47, "4bf92f3577b34da6a3ce929d0e0e4736")
+	h.UpdateWithExemplar(1000, "not-a-trace-id")
+	e, _ := newOTLPExporter(OTLPConfig{Registry: r})
+	m := e.collect(time.Now())[0]
+	if nil == m.Histogram || nil != m.Summary {
+		t.Fatalf("%+v", m)
+	}
+	dp := m.Histogram.DataPoints[0]
+	if "2" != dp.Count || 1047 != dp.Sum || 2 != len(dp.BucketCounts) || "1" != dp.BucketCounts[0] || "1" != dp.BucketCounts[1] || 100 != dp.ExplicitBounds[0] {
+		t.Errorf("%+v", dp)
+	}
+	if 2 != len(dp.Exemplars) || "4bf92f3577b34da6a3ce929d0e0e4736" != dp.Exemplars[0].TraceID || 47 != dp.Exemplars[0].AsDouble {
+		t.Fatalf("%+v", dp.Exemplars)
+	}
+	if ex := dp.Exemplars[1]; "" != ex.TraceID || 1 != len(ex.FilteredAttributes) || "not-a-trace-id" != ex.FilteredAttributes[0].Value.StringValue {
+		t.Errorf("%+v", ex)
+	}
+}
+
+func TestOTLPWithConfigContext(t *testing.T) {
+	flushed := make(chan struct{}, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flushed <- struct{}{}
+	}))
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := OTLPWithConfigContext(ctx, OTLPConfig{
+		Endpoint:      ts.URL,
+		Registry:      NewRegistry(),
+		FlushInterval: time.Hour,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	select {
+	case <-flushed:
+	default:
+		t.Fatal("no final flush")
+	}
+	if err := OTLPWithConfigContext(ctx, OTLPConfig{Endpoint: ts.URL, Registry: NewRegistry()}); nil == err {
+		t.Error("no error for a zero FlushInterval")
+	}
+}
+
+func TestOTLPFilter(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterGauge("keep", r).Update(1)
+	GetOrRegisterGauge("drop", r).Update(2)
+	e, _ := newOTLPExporter(OTLPConfig{
+		Registry: r,
+		Filter:   func(name string) bool { return "drop" != name },
+	})
+	if metrics := e.collect(time.Now()); 1 != len(metrics) || "keep" != metrics[0].Name {
+		t.Errorf("%+v", metrics)
+	}
+}
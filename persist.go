@@ -0,0 +1,206 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// A saved registry begins with persistMagic followed by a version byte.  The
+// rest of the file is a sequence of records, each holding a type tag byte,
+// the uvarint length of its payload and the payload itself.  Length-prefixed
+// records let a loader skip metric types added by later versions of this
+// format; anything else incompatible must bump persistVersion.
+const (
+	persistMagic   = "GOMETRICS"
+	persistVersion = 1
+)
+
+// maxPersistRecord bounds the length of a record's payload, so that a corrupt
+// file can't make LoadRegistry allocate without limit.  SaveRegistry skips
+// metrics whose names are too long to fit.
+const maxPersistRecord = 1 << 20
+
+// Record type tags.  Payloads hold the uvarint length of the metric's name,
+// the name and then its value: a varint for counters and gauges and the
+// IEEE 754 bits, big-endian, for float64 gauges.
+const (
+	persistCounter      = 'c'
+	persistGauge        = 'g'
+	persistGaugeFloat64 = 'f'
+)
+
+// ErrNotSavedRegistry is returned by LoadRegistry when its input doesn't
+// begin with the header written by SaveRegistry.
+var ErrNotSavedRegistry = errors.New("metrics: not a saved registry")
+
+// UnsupportedVersion is the error returned by LoadRegistry when its input was
+// written in a version of the format it doesn't understand.
+type UnsupportedVersion byte
+
+func (err UnsupportedVersion) Error() string {
+	return fmt.Sprintf("metrics: unsupported saved registry version %d", byte(err))
+}
+
+// SaveRegistry writes the counters and gauges in r to w so that they may be
+// restored by LoadRegistry, for instance across a restart.  Other metrics
+// can't be restored faithfully and are not saved.
+func SaveRegistry(w io.Writer, r Registry) error {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(persistMagic)
+	bw.WriteByte(persistVersion)
+	var payload []byte
+	r.Snapshot().Each(func(name string, i interface{}) {
+		var tag byte
+		payload = appendVarint(payload[:0], uint64(len(name)))
+		payload = append(payload, name...)
+		switch metric := i.(type) {
+		case Counter:
+			tag = persistCounter
+			payload = appendSvarint(payload, metric.Count())
+		case Gauge:
+			tag = persistGauge
+			payload = appendSvarint(payload, metric.Value())
+		case GaugeFloat64:
+			tag = persistGaugeFloat64
+			var b [8]byte
+			binary.BigEndian.PutUint64(b[:], math.Float64bits(metric.Value()))
+			payload = append(payload, b[:]...)
+		default:
+			return
+		}
+		if maxPersistRecord < len(payload) {
+			return
+		}
+		bw.WriteByte(tag)
+		bw.Write(appendVarint(nil, uint64(len(payload))))
+		bw.Write(payload)
+	})
+	return bw.Flush()
+}
+
+// LoadRegistry restores the counters and gauges saved by SaveRegistry into
+// r, registering any which don't exist.  It returns ErrNotSavedRegistry or
+// an UnsupportedVersion, leaving r untouched, if rd wasn't written by a
+// compatible SaveRegistry, and an error if a record is truncated or longer
+// than any SaveRegistry writes.  Records of metric types it doesn't know,
+// and of metrics registered in r under the same name as another type, are
+// skipped.
+func LoadRegistry(rd io.Reader, r Registry) error {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	br := bufio.NewReader(rd)
+	header := make([]byte, len(persistMagic)+1)
+	if _, err := io.ReadFull(br, header); nil != err || persistMagic != string(header[:len(persistMagic)]) {
+		return ErrNotSavedRegistry
+	}
+	if version := header[len(persistMagic)]; persistVersion != version {
+		return UnsupportedVersion(version)
+	}
+	for {
+		tag, err := br.ReadByte()
+		if io.EOF == err {
+			return nil
+		}
+		if nil != err {
+			return err
+		}
+		n, err := binary.ReadUvarint(br)
+		if nil != err {
+			return unexpectedEOF(err)
+		}
+		if maxPersistRecord < n {
+			return errCorruptRecord
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(br, payload); nil != err {
+			return unexpectedEOF(err)
+		}
+		if err := loadRecord(tag, payload, r); nil != err {
+			return err
+		}
+	}
+}
+
+func loadRecord(tag byte, payload []byte, r Registry) error {
+	switch tag {
+	case persistCounter, persistGauge, persistGaugeFloat64:
+	default:
+		return nil
+	}
+	n, k := binary.Uvarint(payload)
+	if 0 >= k || uint64(len(payload)-k) < n {
+		return errCorruptRecord
+	}
+	name, value := string(payload[k:k+int(n)]), payload[k+int(n):]
+	if persistGaugeFloat64 == tag {
+		if 8 != len(value) {
+			return errCorruptRecord
+		}
+		// A read-only gauge computes its own value and can't be restored.
+		if g, ok := loadedMetric(name, r, NewGaugeFloat64).(GaugeFloat64); ok && !isReadOnlyGauge(g) {
+			g.Update(math.Float64frombits(binary.BigEndian.Uint64(value)))
+		}
+		return nil
+	}
+	v, k := binary.Varint(value)
+	if 0 >= k {
+		return errCorruptRecord
+	}
+	if persistCounter == tag {
+		if c, ok := loadedMetric(name, r, NewCounter).(Counter); ok {
+			c.Clear()
+			c.Inc(v)
+		}
+	} else if g, ok := loadedMetric(name, r, NewGauge).(Gauge); ok && !isReadOnlyGauge(g) {
+		g.Update(v)
+	}
+	return nil
+}
+
+// loadedMetric returns the metric registered in r under name, whatever its
+// type, or else registers and returns the one f constructs.  Unlike
+// GetOrRegister, it neither panics nor replaces a metric of another type
+// under the registry's ConflictPolicy.
+func loadedMetric(name string, r Registry, f interface{}) interface{} {
+	if i := r.Get(name); nil != i {
+		return i
+	}
+	return r.GetOrRegister(name, f)
+}
+
+// isReadOnlyGauge reports whether the gauge i, unwrapped if it's tagged, is a
+// ReadOnlyGauge, whose value can't be restored.
+func isReadOnlyGauge(i interface{}) bool {
+	switch g := i.(type) {
+	case *taggedGauge:
+		i = g.Gauge
+	case *taggedGaugeFloat64:
+		i = g.GaugeFloat64
+	}
+	_, ok := i.(ReadOnlyGauge)
+	return ok
+}
+
+var errCorruptRecord = errors.New("metrics: corrupt saved registry record")
+
+func unexpectedEOF(err error) error {
+	if io.EOF == err {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// appendSvarint appends the zig-zag varint encoding of v, as appendVarint
+// does for unsigned values.
+func appendSvarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSaveLoadRegistry(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r).Inc(47)
+	GetOrRegisterGauge("g", r).Update(-3)
+	GetOrRegisterGaugeFloat64("f", r).Update(0.25)
+	r.Register("m", NewMeter())
+	var b bytes.Buffer
+	if err := SaveRegistry(&b, r); nil != err {
+		t.Fatal(err)
+	}
+
+	r2 := NewRegistry()
+	GetOrRegisterCounter("c", r2).Inc(1)
+	if err := LoadRegistry(&b, r2); nil != err {
+		t.Fatal(err)
+	}
+	if count := GetOrRegisterCounter("c", r2).Count(); 47 != count {
+		t.Errorf("c: 47 != %v", count)
+	}
+	if value := GetOrRegisterGauge("g", r2).Value(); -3 != value {
+		t.Errorf("g: -3 != %v", value)
+	}
+	if value := GetOrRegisterGaugeFloat64("f", r2).Value(); 0.25 != value {
+		t.Errorf("f: 0.25 != %v", value)
+	}
+	if nil != r2.Get("m") {
+		t.Error("m was restored")
+	}
+}
+
+func TestLoadRegistryVersion1(t *testing.T) {
+	v1 := []byte("GOMETRICS\x01" +
+		"c\x04\x01c\x80\x01" + // counter c = 64
+		"z\x03\x01z\x00" + // a type this loader doesn't know
+		"g\x03\x01g\x05") // gauge g = -3
+	r := NewRegistry()
+	if err := LoadRegistry(bytes.NewReader(v1), r); nil != err {
+		t.Fatal(err)
+	}
+	if count := GetOrRegisterCounter("c", r).Count(); 64 != count {
+		t.Errorf("c: 64 != %v", count)
+	}
+	if value := GetOrRegisterGauge("g", r).Value(); -3 != value {
+		t.Errorf("g: -3 != %v", value)
+	}
+	if nil != r.Get("z") {
+		t.Error("unknown record was loaded")
+	}
+}
+
+func TestLoadRegistryUnsupportedVersion(t *testing.T) {
+	r := NewRegistry()
+	err := LoadRegistry(bytes.NewReader([]byte("GOMETRICS\x02c\x04\x01c\x80\x01")), r)
+	if UnsupportedVersion(2) != err {
+		t.Errorf("err: %v", err)
+	}
+	if nil != r.Get("c") {
+		t.Error("r was modified")
+	}
+	if err := LoadRegistry(bytes.NewReader([]byte("{}")), r); ErrNotSavedRegistry != err {
+		t.Errorf("err: %v", err)
+	}
+}
+
+func TestLoadRegistryTruncated(t *testing.T) {
+	if err := LoadRegistry(bytes.NewReader([]byte("GOMETRICS\x01c\x04\x01c")), NewRegistry()); nil == err {
+		t.Error("expected an error")
+	}
+}
+
+func TestLoadRegistryHugeRecord(t *testing.T) {
+	for _, n := range []uint64{1<<63 - 1, maxPersistRecord + 1} {
+		b := append([]byte("GOMETRICS\x01c"), appendVarint(nil, n)...)
+		if err := LoadRegistry(bytes.NewReader(b), NewRegistry()); errCorruptRecord != err {
+			t.Errorf("length %v: err: %v", n, err)
+		}
+	}
+}
+
+func TestLoadRegistryFunctionalGauge(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterGauge("g", r).Update(3)
+	GetOrRegisterGaugeFloat64("f", r).Update(0.25)
+	GetOrRegisterGauge("cg", r).Update(3)
+	GetOrRegisterGaugeFloat64("cf{k=v}", r).Update(0.25)
+	var b bytes.Buffer
+	if err := SaveRegistry(&b, r); nil != err {
+		t.Fatal(err)
+	}
+
+	r2 := NewRegistry()
+	GetOrRegisterFunctionalGauge("g", r2, func() int64 { return 47 })
+	GetOrRegisterFunctionalGaugeFloat64("f", r2, func() float64 { return 0.5 })
+	GetOrRegisterCachedGauge("cg", r2, time.Minute, func() int64 { return 48 })
+	GetOrRegisterCachedGaugeFloat64("cf", NewTaggedRegistry(r2, map[string]string{"k": "v"}), time.Minute, func() float64 { return 0.75 })
+	if err := LoadRegistry(&b, r2); nil != err {
+		t.Fatal(err)
+	}
+	if value := GetOrRegisterGauge("g", r2).Value(); 47 != value {
+		t.Errorf("g: 47 != %v", value)
+	}
+	if value := GetOrRegisterGaugeFloat64("f", r2).Value(); 0.5 != value {
+		t.Errorf("f: 0.5 != %v", value)
+	}
+	if value := GetOrRegisterGauge("cg", r2).Value(); 48 != value {
+		t.Errorf("cg: 48 != %v", value)
+	}
+	if value := GetOrRegisterGaugeFloat64("cf{k=v}", r2).Value(); 0.75 != value {
+		t.Errorf("cf: 0.75 != %v", value)
+	}
+}
+
+func TestLoadRegistryTypeChanged(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r).Inc(3)
+	GetOrRegisterGauge("g", r).Update(4)
+	var b bytes.Buffer
+	if err := SaveRegistry(&b, r); nil != err {
+		t.Fatal(err)
+	}
+
+	r2 := NewRegistryWithConflictPolicy(ConflictReject)
+	GetOrRegisterGauge("c", r2).Update(47)
+	GetOrRegisterCounter("g", r2).Inc(47)
+	if err := LoadRegistry(&b, r2); nil != err {
+		t.Fatal(err)
+	}
+	if value := r2.Get("c").(Gauge).Value(); 47 != value {
+		t.Errorf("c: 47 != %v", value)
+	}
+	if count := r2.Get("g").(Counter).Count(); 47 != count {
+		t.Errorf("g: 47 != %v", count)
+	}
+}
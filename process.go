@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"context"
+	"time"
+)
+
+var processStart = time.Now()
+
+// ProcessCollector captures statistics of the running process into a
+// registry: its CPU time, resident memory, open file descriptors, thread
+// count and uptime.  All but the uptime are read from /proc and so are only
+// captured on Linux.
+type ProcessCollector struct {
+	cpu     GaugeFloat64
+	fds     Gauge
+	rss     Gauge
+	threads Gauge
+	uptime  GaugeFloat64
+}
+
+// processStats are the statistics of a process read from /proc.
+type processStats struct {
+	cpuSeconds float64 // User and system CPU time
+	fds        int64
+	rssBytes   int64
+	threads    int64
+}
+
+// NewProcessCollector registers gauges in r, named with the given prefix, for
+// the process's statistics: cpu.seconds, fds, rss.bytes, threads and
+// uptime.seconds.  Only uptime.seconds is registered where the others cannot
+// be read.  No statistic is read until CaptureOnce or Capture is called.
+func NewProcessCollector(r Registry, prefix string) *ProcessCollector {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	c := &ProcessCollector{uptime: NewGaugeFloat64()}
+	r.Register(prefix+"uptime.seconds", c.uptime)
+	if processStatsSupported {
+		c.cpu = NewGaugeFloat64()
+		c.fds = NewGauge()
+		c.rss = NewGauge()
+		c.threads = NewGauge()
+		r.Register(prefix+"cpu.seconds", c.cpu)
+		r.Register(prefix+"fds", c.fds)
+		r.Register(prefix+"rss.bytes", c.rss)
+		r.Register(prefix+"threads", c.threads)
+	}
+	return c
+}
+
+// Capture captures the process's statistics every d until the context is
+// done.  This is designed to be called as a goroutine.
+func (c *ProcessCollector) Capture(ctx context.Context, d time.Duration) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.CaptureOnce()
+		}
+	}
+}
+
+// CaptureOnce captures new values for the process's statistics.  Those which
+// cannot be read keep their last values.
+func (c *ProcessCollector) CaptureOnce() {
+	c.uptime.Update(time.Since(processStart).Seconds())
+	if !processStatsSupported {
+		return
+	}
+	var stats processStats
+	if err := readProcessStats(&stats); nil != err {
+		return
+	}
+	c.cpu.Update(stats.cpuSeconds)
+	c.fds.Update(stats.fds)
+	c.rss.Update(stats.rssBytes)
+	c.threads.Update(stats.threads)
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const processStatsSupported = true
+
+// clockTicks is the number of clock ticks per second in which /proc reports
+// CPU time, USER_HZ, which is 100 on every architecture Go supports.
+const clockTicks = 100
+
+// readProcessStats reads the CPU time, resident memory and threads of the
+// process from /proc/self/stat and counts its open file descriptors in
+// /proc/self/fd.
+func readProcessStats(stats *processStats) error {
+	b, err := ioutil.ReadFile("/proc/self/stat")
+	if nil != err {
+		return err
+	}
+	if err := parseProcStat(string(b), os.Getpagesize(), stats); nil != err {
+		return err
+	}
+	d, err := os.Open("/proc/self/fd")
+	if nil != err {
+		return err
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if nil != err {
+		return err
+	}
+	stats.fds = int64(len(names)) - 1 // Less the descriptor reading them
+	return nil
+}
+
+// parseProcStat parses the contents of /proc/[pid]/stat, documented in
+// proc(5).
+func parseProcStat(s string, pageSize int, stats *processStats) error {
+	// The command, the second field, is parenthesized and may contain
+	// spaces and parentheses itself, so fields are counted from its end.
+	i := strings.LastIndex(s, ")")
+	if -1 == i {
+		return errors.New("metrics: malformed /proc stat")
+	}
+	fields := strings.Fields(s[i+1:])
+	if len(fields) < 22 {
+		return errors.New("metrics: malformed /proc stat")
+	}
+	field := func(n int) (int64, error) {
+		return strconv.ParseInt(fields[n-3], 10, 64)
+	}
+	utime, err := field(14)
+	if nil != err {
+		return err
+	}
+	stime, err := field(15)
+	if nil != err {
+		return err
+	}
+	threads, err := field(20)
+	if nil != err {
+		return err
+	}
+	rss, err := field(24)
+	if nil != err {
+		return err
+	}
+	stats.cpuSeconds = float64(utime+stime) / clockTicks
+	stats.rssBytes = rss * int64(pageSize)
+	stats.threads = threads
+	return nil
+}
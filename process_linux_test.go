@@ -0,0 +1,17 @@
+package metrics
+
+import "testing"
+
+func TestParseProcStat(t *testing.T) {
+	s := "1595 (a) b (c)) R 1145 1145 1145 0 -1 4194304 81 0 0 0 250 150 0 0 20 0 7 0 456764 2703360 309 18446744073709551615 0\n"
+	var stats processStats
+	if err := parseProcStat(s, 4096, &stats); nil != err {
+		t.Fatal(err)
+	}
+	if 4.0 != stats.cpuSeconds || 309*4096 != stats.rssBytes || 7 != stats.threads {
+		t.Errorf("stats: %+v", stats)
+	}
+	if err := parseProcStat("1595 (cat) R 1145", 4096, &stats); nil == err {
+		t.Error("short stat was parsed")
+	}
+}
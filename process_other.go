@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package metrics
+
+import "errors"
+
+const processStatsSupported = false
+
+func readProcessStats(stats *processStats) error {
+	return errors.New("metrics: process statistics are only read on Linux")
+}
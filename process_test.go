@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestProcessCollector(t *testing.T) {
+	r := NewRegistry()
+	c := NewProcessCollector(r, "process.")
+	c.CaptureOnce()
+	if g, ok := r.Get("process.uptime.seconds").(GaugeFloat64); !ok || 0 >= g.Value() {
+		t.Errorf("uptime.seconds: %v", r.Get("process.uptime.seconds"))
+	}
+	if "linux" != runtime.GOOS {
+		if nil != r.Get("process.rss.bytes") {
+			t.Error("rss.bytes was registered")
+		}
+		return
+	}
+	for _, name := range []string{"process.fds", "process.rss.bytes", "process.threads"} {
+		if g, ok := r.Get(name).(Gauge); !ok || 0 >= g.Value() {
+			t.Errorf("%s: %v", name, r.Get(name))
+		}
+	}
+	if _, ok := r.Get("process.cpu.seconds").(GaugeFloat64); !ok {
+		t.Errorf("cpu.seconds: %v", r.Get("process.cpu.seconds"))
+	}
+}
@@ -0,0 +1,237 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrometheusHandler returns an http.Handler which renders every metric in r
+// in the Prometheus text exposition format, for scraping by Prometheus.
+// Counters and meters are rendered as counters named with a _count suffix,
+// along with gauges of meters' per-second rates named with _rate1, _rate5,
+// _rate15 and _rate_mean suffixes.  Gauges are rendered as gauges and
+// histograms and timers as summaries, timers in seconds.  TopKs are
+// rendered as a gauge of each top key's count, labeled with the key.  The
+// tags of Tagged metrics become labels and names are sanitized, so that
+// http.requests becomes http_requests.  Healthchecks aren't rendered.
+//
+// Scrapers which accept the OpenMetrics format are served it instead, with
+// the exemplars of histograms and timers attached to the latest samples
+// whose values they fall within: those of summaries to their _count and
+// those of BucketedHistograms to their buckets.
+func PrometheusHandler(r Registry) http.Handler {
+	return PrometheusHandlerWithConfig(PrometheusConfig{Registry: r})
+}
+
+// PrometheusConfig provides a container with configuration parameters for
+// PrometheusHandlerWithConfig
+type PrometheusConfig struct {
+	Registry    Registry  // Registry to be rendered, defaults to DefaultRegistry
+	Percentiles []float64 // Quantiles of histogram and timer summaries, defaults to 0.5, 0.75, 0.95, 0.99 and 0.999 if nil, none if empty
+}
+
+// PrometheusHandlerWithConfig is like PrometheusHandler but takes a
+// PrometheusConfig, so that summaries' quantiles can be chosen.
+func PrometheusHandlerWithConfig(c PrometheusConfig) http.Handler {
+	r := c.Registry
+	if nil == r {
+		r = DefaultRegistry
+	}
+	percentiles := percentilesOrDefault(c.Percentiles)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var b bytes.Buffer
+		if strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text") {
+			writePrometheus(&b, r, percentiles, true)
+			b.WriteString("# EOF\n")
+			w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		} else {
+			writePrometheus(&b, r, percentiles, false)
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		}
+		w.Write(b.Bytes())
+	})
+}
+
+// writePrometheus renders r, sorted by name, to b, with summaries of the
+// given percentiles and with exemplars if openMetrics is set.
+func writePrometheus(b *bytes.Buffer, r Registry, percentiles []float64, openMetrics bool) {
+	tagged := make(map[string]map[string]string)
+	exported := make(map[string]string)
+	r.Each(func(name string, i interface{}) {
+		if t, ok := i.(Tagged); ok {
+			tagged[name] = t.Tags()
+		}
+		exported[name] = exportName(name, i)
+	})
+
+	// Metrics exported under the same name, with different tags, are
+	// grouped into one family as the format requires.
+	families := make(map[string]namedMetricSlice)
+	var names []string
+	r.Snapshot().Each(func(key string, i interface{}) {
+		name, ok := exported[key]
+		if !ok {
+			name = key
+		}
+		if _, ok := families[name]; !ok {
+			names = append(names, name)
+		}
+		families[name] = append(families[name], namedMetric{key, i})
+	})
+	sort.Strings(names)
+	var namedMetrics namedMetricSlice
+	for _, name := range names {
+		sort.Sort(families[name])
+		namedMetrics = append(namedMetrics, families[name]...)
+	}
+	headers := make(map[string]bool)
+	for _, nm := range namedMetrics {
+		name := prometheusName(nm.name)
+		if n, ok := exported[nm.name]; ok {
+			name = prometheusName(n)
+		}
+		labels := prometheusLabels(tagged[nm.name])
+		header := func(name, typ string) {
+			if headers[name] {
+				return
+			}
+			headers[name] = true
+			if md := metadata(r, nm.name, nm.m); "" != md.Description {
+				fmt.Fprintf(b, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(md.Description))
+			}
+			fmt.Fprintf(b, "# TYPE %s %s\n", name, typ)
+		}
+		sample := func(name, labels string, v float64) {
+			fmt.Fprintf(b, "%s%s %s\n", name, labels, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		sampleWithExemplar := func(name, labels string, v float64, e Exemplar, ok bool, scale float64) {
+			if !openMetrics || !ok {
+				sample(name, labels, v)
+				return
+			}
+			fmt.Fprintf(b, "%s%s %s # %s %s %s\n", name, labels, strconv.FormatFloat(v, 'g', -1, 64),
+				prometheusLabels(map[string]string{"trace_id": e.TraceID}),
+				strconv.FormatFloat(float64(e.Value)/scale, 'g', -1, 64),
+				strconv.FormatFloat(float64(e.Time.UnixNano())/1e9, 'f', 3, 64))
+		}
+		summary := func(count int64, sum float64, ps []float64, scale float64, exemplars []Exemplar) {
+			header(name, "summary")
+			for i, p := range ps {
+				quantile := `quantile="` + strconv.FormatFloat(percentiles[i], 'g', -1, 64) + `"`
+				if "" == labels {
+					sample(name, "{"+quantile+"}", p/scale)
+				} else {
+					sample(name, labels[:len(labels)-1]+","+quantile+"}", p/scale)
+				}
+			}
+			sample(name+"_sum", labels, sum/scale)
+			e, ok := latestExemplar(exemplars, math.MinInt64, math.MaxInt64)
+			sampleWithExemplar(name+"_count", labels, float64(count), e, ok, scale)
+		}
+		switch metric := nm.m.(type) {
+		case Counter:
+			header(name+"_count", "counter")
+			sample(name+"_count", labels, float64(metric.Count()))
+		case Gauge:
+			header(name, "gauge")
+			sample(name, labels, float64(metric.Value()))
+		case GaugeFloat64:
+			header(name, "gauge")
+			sample(name, labels, metric.Value())
+		case BucketedHistogram:
+			header(name, "histogram")
+			counts := metric.BucketCounts()
+			exemplars := metric.Exemplars()
+			below := int64(math.MinInt64)
+			bucket := func(le string, count, bound int64) {
+				le = `le="` + le + `"`
+				bucketLabels := "{" + le + "}"
+				if "" != labels {
+					bucketLabels = labels[:len(labels)-1] + "," + le + "}"
+				}
+				e, ok := latestExemplar(exemplars, below, bound)
+				sampleWithExemplar(name+"_bucket", bucketLabels, float64(count), e, ok, 1)
+				below = bound
+			}
+			for i, bound := range metric.Bounds() {
+				bucket(strconv.FormatInt(bound, 10), counts[i], bound)
+			}
+			bucket("+Inf", metric.Count(), math.MaxInt64)
+			sample(name+"_sum", labels, float64(metric.Sum()))
+			sample(name+"_count", labels, float64(metric.Count()))
+		case Histogram:
+			summary(metric.Count(), float64(metric.Sum()), metric.Percentiles(percentiles), 1, metric.Exemplars())
+		case Meter:
+			header(name+"_count", "counter")
+			sample(name+"_count", labels, float64(metric.Count()))
+			for _, rate := range []struct {
+				suffix string
+				value  float64
+			}{
+				{"_rate1", metric.Rate1()},
+				{"_rate5", metric.Rate5()},
+				{"_rate15", metric.Rate15()},
+				{"_rate_mean", metric.RateMean()},
+			} {
+				header(name+rate.suffix, "gauge")
+				sample(name+rate.suffix, labels, rate.value)
+			}
+			if wm, ok := metric.(WindowedMeter); ok {
+				for _, wr := range wm.WindowRates() {
+					suffix := prometheusName("_rate_" + wr.Name())
+					header(name+suffix, "gauge")
+					sample(name+suffix, labels, wr.Rate)
+				}
+			}
+		case Timer:
+			summary(metric.Count(), float64(metric.Sum()), metric.Percentiles(percentiles), float64(time.Second), metric.Exemplars())
+		case TopK:
+			header(name, "gauge")
+			for _, tc := range metric.Top() {
+				t := map[string]string{"key": tc.Key}
+				for k, v := range tagged[nm.name] {
+					t[k] = v
+				}
+				sample(name, prometheusLabels(t), float64(tc.Count))
+			}
+		}
+	}
+}
+
+// prometheusName replaces the characters of name which Prometheus doesn't
+// allow in metric names with underscores.
+func prometheusName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '_' == c || ':' == c || 0 < i && '0' <= c && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// prometheusLabels renders tags as labels sorted by name, or the empty
+// string if there are none.
+func prometheusLabels(tags map[string]string) string {
+	if 0 == len(tags) {
+		return ""
+	}
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	labels := make([]string, len(names))
+	for i, name := range names {
+		// Label names may not contain colons, unlike metric names.
+		labels[i] = strings.Replace(prometheusName(name), ":", "_", -1) + `="` + escape.Replace(tags[name]) + `"`
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	r := NewRegistry()
+	RegisterWithMetadata(r, "http.requests", NewCounter(), Metadata{Description: "Requests served"})
+	GetOrRegisterCounter("http.requests", r).Inc(47)
+	GetOrRegisterGauge("queue.depth", r).Update(3)
+	h := GetOrRegisterHistogram("sizes", r, NewUniformSample(10))
+	h.Update(1)
+	h.Update(3)
+	r.Register("login", timerWithTags{NewTimer(), map[string]string{"endpoint": "/login"}})
+	r.Get("login").(Timer).Update(2 * time.Second)
+
+	w := httptest.NewRecorder()
+	PrometheusHandler(r).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type: %s", ct)
+	}
+	expected := `# HELP http_requests_count Requests served
+# TYPE http_requests_count counter
+http_requests_count 47
+# TYPE login summary
+login{endpoint="/login",quantile="0.5"} 2
+login{endpoint="/login",quantile="0.75"} 2
+login{endpoint="/login",quantile="0.95"} 2
+login{endpoint="/login",quantile="0.99"} 2
+login{endpoint="/login",quantile="0.999"} 2
+login_sum{endpoint="/login"} 2
+login_count{endpoint="/login"} 1
+# TYPE queue_depth gauge
+queue_depth 3
+# TYPE sizes summary
+sizes{quantile="0.5"} 2
+sizes{quantile="0.75"} 3
+sizes{quantile="0.95"} 3
+sizes{quantile="0.99"} 3
+sizes{quantile="0.999"} 3
+sizes_sum 4
+sizes_count 2
+`
+	if expected != w.Body.String() {
+		t.Errorf("%s", w.Body.String())
+	}
+}
+
+func TestPrometheusHandlerWithConfigPercentiles(t *testing.T) {
+	r := NewRegistry()
+	h := GetOrRegisterHistogram("sizes", r, NewUniformSample(10))
+	h.Update(1)
+	h.Update(3)
+
+	w := httptest.NewRecorder()
+	PrometheusHandlerWithConfig(PrometheusConfig{Registry: r, Percentiles: []float64{0.9}}).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	expected := `# TYPE sizes summary
+sizes{quantile="0.9"} 3
+sizes_sum 4
+sizes_count 2
+`
+	if expected != w.Body.String() {
+		t.Errorf("%s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	PrometheusHandlerWithConfig(PrometheusConfig{Registry: r, Percentiles: []float64{}}).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	expected = `# TYPE sizes summary
+sizes_sum 4
+sizes_count 2
+`
+	if expected != w.Body.String() {
+		t.Errorf("%s", w.Body.String())
+	}
+}
+
+func TestPrometheusName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"http.requests": "http_requests",
+		"a:b_c9":        "a:b_c9",
+		"9lives":        "_lives",
+		"p-99 latency":  "p_99_latency",
+	} {
+		if s := prometheusName(name); expected != s {
+			t.Errorf("prometheusName(%q): %q != %q", name, expected, s)
+		}
+	}
+}
+
+func TestPrometheusTaggedRegistry(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterGauge("queue", r).Update(1)
+	GetOrRegisterTaggedGauge("queue.depth", map[string]string{"queue": "b"}, r).Update(2)
+	GetOrRegisterGauge("queue.depth.max", r).Update(3)
+	GetOrRegisterTaggedGauge("queue.depth", map[string]string{"queue": "a"}, r).Update(4)
+	w := httptest.NewRecorder()
+	PrometheusHandler(r).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	expected := `# TYPE queue gauge
+queue 1
+# TYPE queue_depth gauge
+queue_depth{queue="a"} 4
+queue_depth{queue="b"} 2
+# TYPE queue_depth_max gauge
+queue_depth_max 3
+`
+	if expected != w.Body.String() {
+		t.Errorf("%s", w.Body.String())
+	}
+}
+
+func TestPrometheusMeter(t *testing.T) {
+	r := NewRegistry()
+	m := GetOrRegisterTaggedMeter("requests", map[string]string{"code": "200"}, r)
+	m.Mark(47)
+	w := httptest.NewRecorder()
+	PrometheusHandler(r).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	lines := strings.Split(w.Body.String(), "\n")
+	for i, want := range []string{
+		"# TYPE requests_count counter",
+		`requests_count{code="200"} 47`,
+		"# TYPE requests_rate1 gauge",
+		`requests_rate1{code="200"} `,
+		"# TYPE requests_rate5 gauge",
+		`requests_rate5{code="200"} `,
+		"# TYPE requests_rate15 gauge",
+		`requests_rate15{code="200"} `,
+		"# TYPE requests_rate_mean gauge",
+		`requests_rate_mean{code="200"} `,
+	} {
+		if i >= len(lines) || !strings.HasPrefix(lines[i], want) {
+			t.Fatalf("line %d doesn't begin %q:\n%s", i, want, w.Body.String())
+		}
+	}
+}
+
+func TestPrometheusOpenMetricsExemplars(t *testing.T) {
+	r := NewRegistry()
+	h := GetOrRegisterBucketedHistogram("latency", r, NewUniformSample(100), []int64{100})
+	h.UpdateWithExemplar(47, "abc")
+	GetOrRegisterTimer("t", r).UpdateWithExemplar(time.Second, "def")
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	w := httptest.NewRecorder()
+	PrometheusHandler(r).ServeHTTP(w, req)
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Errorf("Content-Type: %q", ct)
+	}
+	body := w.Body.String()
+	for _, prefix := range []string{
+		`latency_bucket{le="100"} 1 # {trace_id="abc"} 47 `,
+		`latency_bucket{le="+Inf"} 1` + "\n",
+		`t_count 1 # {trace_id="def"} 1 `,
+	} {
+		if !strings.Contains(body, "\n"+prefix) {
+			t.Errorf("no line beginning %q in\n%s", prefix, body)
+		}
+	}
+	if !strings.HasSuffix(body, "# EOF\n") {
+		t.Errorf("no # EOF:\n%s", body)
+	}
+	w = httptest.NewRecorder()
+	PrometheusHandler(r).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if strings.Contains(w.Body.String(), "trace_id") {
+		t.Errorf("exemplars in the text format:\n%s", w.Body.String())
+	}
+}
+
+func TestPrometheusTopK(t *testing.T) {
+	r := NewRegistry()
+	tk := GetOrRegisterTaggedTopK("clients", map[string]string{"route": "/"}, r, 2, 0)
+	tk.Add("10.0.0.1", 3)
+	tk.Add("10.0.0.2", 1)
+	w := httptest.NewRecorder()
+	PrometheusHandler(r).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	expected := `# TYPE clients gauge
+clients{key="10.0.0.1",route="/"} 3
+clients{key="10.0.0.2",route="/"} 1
+`
+	if expected != w.Body.String() {
+		t.Errorf("%s", w.Body.String())
+	}
+}
+
+func TestPrometheusBucketedHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := GetOrRegisterTaggedBucketedHistogram("latency", map[string]string{"route": "/"}, r, NewUniformSample(100), []int64{100})
+	h.Update(47)
+	h.Update(1000)
+	w := httptest.NewRecorder()
+	PrometheusHandler(r).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	expected := `# TYPE latency histogram
+latency_bucket{route="/",le="100"} 1
+latency_bucket{route="/",le="+Inf"} 2
+latency_sum{route="/"} 1047
+latency_count{route="/"} 2
+`
+	if expected != w.Body.String() {
+		t.Errorf("%s", w.Body.String())
+	}
+}
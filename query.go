@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// QueryHandler returns an http.Handler which serves queries of the metrics
+// in r, so that tools can inspect a running process without a TSDB:
+//
+//	GET /names?prefix=p          a JSON array of the sorted metric names
+//	GET /metric?name=n           a JSON object of the metric's values
+//	GET /stream?interval=1s      a line of JSON of every metric per interval
+//
+// Values are those serialized by MarshalJSON, and prefix, which may be
+// given to /stream too, restricts the metrics to those whose names begin
+// with it.  Mount it under a path of its own with http.StripPrefix.
+func QueryHandler(r Registry) http.Handler {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	q := &queryHandler{registry: r}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/names", q.names)
+	mux.HandleFunc("/metric", q.metric)
+	mux.HandleFunc("/stream", q.stream)
+	return mux
+}
+
+// queryHandler serves the endpoints of QueryHandler.
+type queryHandler struct {
+	registry Registry
+}
+
+func (q *queryHandler) names(w http.ResponseWriter, req *http.Request) {
+	names := []string{}
+	EachPrefixed(q.registry, req.FormValue("prefix"), func(name string, _ interface{}) {
+		names = append(names, name)
+	})
+	sort.Strings(names)
+	writeQueryJSON(w, names)
+}
+
+func (q *queryHandler) metric(w http.ResponseWriter, req *http.Request) {
+	name := req.FormValue("name")
+	i := q.registry.Get(name)
+	if nil == i {
+		http.Error(w, "no metric named "+name, http.StatusNotFound)
+		return
+	}
+	writeQueryJSON(w, metricValues(i))
+}
+
+// stream writes a snapshot of the metrics at once and then every interval,
+// until the client goes away.
+func (q *queryHandler) stream(w http.ResponseWriter, req *http.Request) {
+	interval := time.Second
+	if s := req.FormValue("interval"); "" != s {
+		d, err := time.ParseDuration(s)
+		if nil != err || d <= 0 {
+			http.Error(w, "invalid interval "+s, http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+	prefix := req.FormValue("prefix")
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	enc := json.NewEncoder(w)
+	for {
+		data := make(map[string]map[string]interface{})
+		q.registry.Snapshot().Each(func(name string, i interface{}) {
+			if strings.HasPrefix(name, prefix) {
+				data[name] = metricValues(i)
+			}
+		})
+		if err := enc.Encode(data); nil != err {
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		select {
+		case <-ticker.C:
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// writeQueryJSON writes v as the JSON response.
+func writeQueryJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if nil != err {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(append(b, '\n'))
+}
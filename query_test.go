@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newQueryServer() *httptest.Server {
+	r := NewRegistry()
+	GetOrRegisterCounter("db.queries", r).Inc(47)
+	GetOrRegisterGauge("db.conns", r).Update(3)
+	GetOrRegisterCounter("http.requests", r).Inc(1)
+	return httptest.NewServer(http.StripPrefix("/debug/metrics", QueryHandler(r)))
+}
+
+func getQueryJSON(t *testing.T, url string, v interface{}) int {
+	resp, err := http.Get(url)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if http.StatusOK == resp.StatusCode {
+		if err := json.NewDecoder(resp.Body).Decode(v); nil != err {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestQueryHandlerNames(t *testing.T) {
+	ts := newQueryServer()
+	defer ts.Close()
+	var names []string
+	getQueryJSON(t, ts.URL+"/debug/metrics/names?prefix=db.", &names)
+	if 2 != len(names) || "db.conns" != names[0] || "db.queries" != names[1] {
+		t.Errorf("names %q", names)
+	}
+}
+
+func TestQueryHandlerMetric(t *testing.T) {
+	ts := newQueryServer()
+	defer ts.Close()
+	var values map[string]interface{}
+	if code := getQueryJSON(t, ts.URL+"/debug/metrics/metric?name=db.queries", &values); http.StatusOK != code {
+		t.Fatal(code)
+	}
+	if 47.0 != values["count"] {
+		t.Errorf("values %v", values)
+	}
+	if code := getQueryJSON(t, ts.URL+"/debug/metrics/metric?name=nope", &values); http.StatusNotFound != code {
+		t.Errorf("missing metric: %d", code)
+	}
+}
+
+func TestQueryHandlerStream(t *testing.T) {
+	ts := newQueryServer()
+	defer ts.Close()
+	if code := getQueryJSON(t, ts.URL+"/debug/metrics/stream?interval=-1s", nil); http.StatusBadRequest != code {
+		t.Errorf("negative interval: %d", code)
+	}
+	resp, err := http.Get(ts.URL + "/debug/metrics/stream?interval=10ms&prefix=http.")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	s := bufio.NewScanner(resp.Body)
+	for i := 0; i < 2; i++ {
+		if !s.Scan() {
+			t.Fatalf("snapshot %d: %v", i, s.Err())
+		}
+		var data map[string]map[string]interface{}
+		if err := json.Unmarshal(s.Bytes(), &data); nil != err {
+			t.Fatal(err)
+		}
+		if 1 != len(data) || 1.0 != data["http.requests"]["count"] {
+			t.Errorf("snapshot %d: %v", i, data)
+		}
+	}
+}
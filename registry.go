@@ -2,19 +2,78 @@ package metrics
 
 import (
 	"fmt"
+	"log"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
 )
 
 // DuplicateMetric is the error returned by Registry.Register when a metric
-// already exists.  If you mean to Register that metric you must first
-// Unregister the existing metric.
-type DuplicateMetric string
+// already exists, naming the type of the existing metric.  If you mean to
+// Register that metric you must first Unregister the existing metric.
+type DuplicateMetric struct {
+	Name     string
+	Existing interface{}
+}
 
 func (err DuplicateMetric) Error() string {
-	return fmt.Sprintf("duplicate metric: %s", string(err))
+	return fmt.Sprintf("duplicate metric: %s is already registered as a %T", err.Name, err.Existing)
+}
+
+// MetricLimitExceeded is the error returned by Registry.Register when
+// registering a metric would take a StandardRegistry past its RegistryLimits.
+type MetricLimitExceeded string
+
+func (err MetricLimitExceeded) Error() string {
+	return fmt.Sprintf("metric limit exceeded: %s", string(err))
+}
+
+// RegistryLimits caps the number of metrics a StandardRegistry holds, so that
+// metric names accidentally built from user input can't grow the number of
+// series without bound.  Registrations past a limit are dropped: Register
+// returns a MetricLimitExceeded and GetOrRegister returns the new metric
+// without registering it.  Either way the dropped metric is stopped, so that
+// meters and timers don't pile up in the meter arbiter, and they then ignore
+// later marks.  Zero means no limit.
+type RegistryLimits struct {
+	MaxMetrics   int            // Cap on the number of metrics
+	MaxPerPrefix map[string]int // Caps on the number of metrics whose names begin with each prefix
+}
+
+// MetricTypeConflict is the error returned by Registry.Register, and the
+// value GetOrRegister panics with, when a registry with the ConflictReject
+// policy is asked to register a metric under a name already taken by a metric
+// of another type.
+type MetricTypeConflict struct {
+	Name          string
+	Existing, New interface{}
+}
+
+func (err MetricTypeConflict) Error() string {
+	return fmt.Sprintf("metric type conflict: %s is a %T, not a %T", err.Name, err.Existing, err.New)
 }
 
+// ConflictPolicy decides what a StandardRegistry does when a metric is
+// registered under a name already taken by a metric of another type, as
+// might happen when a Counter is replaced by a Gauge during a reload.
+type ConflictPolicy int
+
+const (
+	// ConflictKeep keeps the existing metric.  GetOrRegister returns it, so
+	// type assertions on the result fail, and Register returns a
+	// DuplicateMetric.  This is the policy of NewRegistry.
+	ConflictKeep ConflictPolicy = iota
+
+	// ConflictReject keeps the existing metric.  GetOrRegister panics with
+	// a MetricTypeConflict and Register returns one.
+	ConflictReject
+
+	// ConflictReplace unregisters the existing metric, along with its
+	// metadata, registers the new one in its place and logs a warning.
+	ConflictReplace
+)
+
 // A Registry holds references to a set of metrics by name and can iterate
 // over them, calling callback functions provided by the user.
 //
@@ -36,9 +95,17 @@ type Registry interface {
 	// Register the given metric under the given name.
 	Register(string, interface{}) error
 
+	// Register all the given metrics at once, or none of them if any
+	// cannot be registered.
+	RegisterBatch(map[string]interface{}) error
+
 	// Run all registered healthchecks.
 	RunHealthchecks()
 
+	// Snapshot every registered metric exactly once into a new read-only
+	// Registry, which can be shared by all consumers of a single flush.
+	Snapshot() Registry
+
 	// Unregister the metric with the given name.
 	Unregister(string)
 
@@ -49,13 +116,40 @@ type Registry interface {
 // The standard implementation of a Registry is a mutex-protected map
 // of names to metrics.
 type StandardRegistry struct {
-	metrics map[string]interface{}
-	mutex   sync.Mutex
+	metrics  map[string]interface{}
+	metadata map[string]Metadata
+	policy   ConflictPolicy
+	limits   RegistryLimits
+	dropped  Counter
+	mutex    sync.Mutex
 }
 
 // Create a new registry.
 func NewRegistry() Registry {
-	return &StandardRegistry{metrics: make(map[string]interface{})}
+	return NewRegistryWithConflictPolicy(ConflictKeep)
+}
+
+// Create a new registry which handles metrics changing type under the same
+// name according to the given policy.
+func NewRegistryWithConflictPolicy(policy ConflictPolicy) Registry {
+	return &StandardRegistry{
+		metrics:  make(map[string]interface{}),
+		metadata: make(map[string]Metadata),
+		policy:   policy,
+		dropped:  NewCounter(),
+	}
+}
+
+// Child returns a registry of the given prefix and tags whose metrics are
+// registered in this one.  See NewChildRegistry.
+func (r *StandardRegistry) Child(prefix string, tags map[string]string) Registry {
+	return NewChildRegistry(r, prefix, tags)
+}
+
+// Dropped returns the Counter of registrations dropped because of the
+// registry's limits, which may be registered for export like any other.
+func (r *StandardRegistry) Dropped() Counter {
+	return r.dropped
 }
 
 // Call the given function for each registered metric.
@@ -65,6 +159,16 @@ func (r *StandardRegistry) Each(f func(string, interface{})) {
 	}
 }
 
+// Call the given function for each registered metric whose name begins with
+// the given prefix.
+func (r *StandardRegistry) EachPrefixed(prefix string, f func(string, interface{})) {
+	for name, i := range r.registered() {
+		if strings.HasPrefix(name, prefix) {
+			f(name, i)
+		}
+	}
+}
+
 // Get the metric by the given name or nil if none is registered.
 func (r *StandardRegistry) Get(name string) interface{} {
 	r.mutex.Lock()
@@ -72,23 +176,68 @@ func (r *StandardRegistry) Get(name string) interface{} {
 	return r.metrics[name]
 }
 
+// Get the values of every registered metric, keyed by name and then by field,
+// as serialized by MarshalJSON.
+func (r *StandardRegistry) GetAll() map[string]map[string]interface{} {
+	return getAll(r)
+}
+
 // Gets an existing metric or creates and registers a new one. Threadsafe
 // alternative to calling Get and Register on failure.
 // The interface can be the metric to register if not found in registry,
 // or a function returning the metric for lazy instantiation.
 func (r *StandardRegistry) GetOrRegister(name string, i interface{}) interface{} {
+	if f, ok := i.(func() interface{}); ok {
+		return r.GetOrRegisterLazy(name, f)
+	}
+	if v := reflect.ValueOf(i); v.Kind() == reflect.Func {
+		return r.getOrRegisterTyped(name, v.Type().Out(0), func() interface{} { return v.Call(nil)[0].Interface() })
+	}
+	return r.getOrRegisterTyped(name, reflect.TypeOf(i), func() interface{} { return i })
+}
+
+// Gets an existing metric or registers the one returned by the given
+// function, which is only called if none is registered under the name or,
+// unless the policy is ConflictKeep, to learn the type of the new metric.  A
+// metric it returns which isn't registered is stopped.
+func (r *StandardRegistry) GetOrRegisterLazy(name string, f func() interface{}) interface{} {
+	return r.getOrRegisterTyped(name, nil, f)
+}
+
+// getOrRegisterTyped is GetOrRegisterLazy given the type f returns, if known,
+// so that f needn't be called when the metric registered is of that type.
+func (r *StandardRegistry) getOrRegisterTyped(name string, t reflect.Type, f func() interface{}) interface{} {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	if metric, ok := r.metrics[name]; ok {
+	metric, ok := r.metrics[name]
+	if ok && (ConflictKeep == r.policy || isMetricOfType(metric, t)) {
 		return metric
 	}
-	if v := reflect.ValueOf(i); v.Kind() == reflect.Func {
-		i = v.Call(nil)[0].Interface()
+	i := f()
+	if ok {
+		if sameMetricType(metric, i) {
+			stop(i)
+			return metric
+		}
+		if ConflictReject == r.policy {
+			stop(i)
+			panic(MetricTypeConflict{Name: name, Existing: metric, New: i})
+		}
+		r.replace(name, metric, i)
+		return i
 	}
 	r.register(name, i)
 	return i
 }
 
+// Get the metadata attached to the metric by the given name.
+func (r *StandardRegistry) Metadata(name string) (Metadata, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	md, ok := r.metadata[name]
+	return md, ok
+}
+
 // Register the given metric under the given name.  Returns a DuplicateMetric
 // if a metric by the given name is already registered.
 func (r *StandardRegistry) Register(name string, i interface{}) error {
@@ -97,6 +246,54 @@ func (r *StandardRegistry) Register(name string, i interface{}) error {
 	return r.register(name, i)
 }
 
+// Register all the given metrics under a single lock, so that exporters see
+// either all of them or none.  If any cannot be registered, the error that
+// Register would return for the first such name, in sorted order, is returned
+// and none are registered.
+func (r *StandardRegistry) RegisterBatch(metrics map[string]interface{}) error {
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, name := range names {
+		metric, ok := r.metrics[name]
+		if !ok || ConflictReplace == r.policy && !sameMetricType(metric, metrics[name]) {
+			continue
+		}
+		if ConflictReject == r.policy && !sameMetricType(metric, metrics[name]) {
+			return MetricTypeConflict{Name: name, Existing: metric, New: metrics[name]}
+		}
+		return DuplicateMetric{Name: name, Existing: metric}
+	}
+	if r.exceedsLimits(names) {
+		r.dropped.Inc(int64(len(names)))
+		for _, name := range names {
+			stop(metrics[name])
+		}
+		return MetricLimitExceeded(names[0])
+	}
+	for _, name := range names {
+		r.register(name, metrics[name])
+	}
+	return nil
+}
+
+// Unregister every metric whose name begins with the given prefix at once.
+func (r *StandardRegistry) RemovePrefix(prefix string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for name, _ := range r.metrics {
+		if strings.HasPrefix(name, prefix) {
+			stop(r.metrics[name])
+			delete(r.metrics, name)
+			delete(r.metadata, name)
+		}
+	}
+}
+
 // Run all registered healthchecks.
 func (r *StandardRegistry) RunHealthchecks() {
 	r.mutex.Lock()
@@ -108,33 +305,230 @@ func (r *StandardRegistry) RunHealthchecks() {
 	}
 }
 
-// Unregister the metric with the given name.
+// SetLimits sets the limits on the number of metrics in the registry.
+// Metrics already registered past them are kept.
+func (r *StandardRegistry) SetLimits(limits RegistryLimits) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.limits = limits
+}
+
+// Attach metadata to the metric by the given name.
+func (r *StandardRegistry) SetMetadata(name string, md Metadata) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.metadata[name] = md
+}
+
+// Snapshot every registered metric exactly once into a new Registry, along
+// with its metadata.  Healthchecks are shared rather than copied.
+func (r *StandardRegistry) Snapshot() Registry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	snapshot := &StandardRegistry{
+		metrics:  make(map[string]interface{}, len(r.metrics)),
+		metadata: make(map[string]Metadata, len(r.metadata)),
+		dropped:  NilCounter{},
+	}
+	for name, i := range r.metrics {
+		snapshot.metrics[name] = snapshotMetric(i)
+	}
+	for name, md := range r.metadata {
+		snapshot.metadata[name] = md
+	}
+	return snapshot
+}
+
+// Unregister the metric with the given name, stopping it if it's Stoppable.
 func (r *StandardRegistry) Unregister(name string) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
+	stop(r.metrics[name])
 	delete(r.metrics, name)
+	delete(r.metadata, name)
 }
 
 // Unregister all metrics.  (Mostly for testing.)
 func (r *StandardRegistry) UnregisterAll() {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	for name, _ := range r.metrics {
+	for name, i := range r.metrics {
+		stop(i)
 		delete(r.metrics, name)
 	}
+	for name, _ := range r.metadata {
+		delete(r.metadata, name)
+	}
 }
 
 func (r *StandardRegistry) register(name string, i interface{}) error {
-	if _, ok := r.metrics[name]; ok {
-		return DuplicateMetric(name)
+	if metric, ok := r.metrics[name]; ok {
+		if ConflictKeep == r.policy || sameMetricType(metric, i) {
+			return DuplicateMetric{Name: name, Existing: metric}
+		}
+		if ConflictReject == r.policy {
+			return MetricTypeConflict{Name: name, Existing: metric, New: i}
+		}
+		r.replace(name, metric, i)
+		return nil
 	}
 	switch i.(type) {
-	case Counter, Gauge, GaugeFloat64, Healthcheck, Histogram, Meter, Timer:
+	case Counter, Gauge, GaugeFloat64, Healthcheck, Histogram, Meter, Timer, TopK:
+		if r.exceedsLimits([]string{name}) {
+			r.dropped.Inc(1)
+			stop(i)
+			return MetricLimitExceeded(name)
+		}
 		r.metrics[name] = i
 	}
 	return nil
 }
 
+// exceedsLimits reports whether registering metrics under the given names
+// would take the registry past its limits.  It should run with r.mutex held.
+func (r *StandardRegistry) exceedsLimits(names []string) bool {
+	if 0 == r.limits.MaxMetrics && 0 == len(r.limits.MaxPerPrefix) {
+		return false
+	}
+	var added []string
+	for _, name := range names {
+		if _, ok := r.metrics[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	if 0 == len(added) {
+		return false
+	}
+	if 0 < r.limits.MaxMetrics && r.limits.MaxMetrics < len(r.metrics)+len(added) {
+		return true
+	}
+	for prefix, max := range r.limits.MaxPerPrefix {
+		if max <= 0 {
+			continue
+		}
+		n := 0
+		for _, name := range added {
+			if strings.HasPrefix(name, prefix) {
+				n++
+			}
+		}
+		if 0 == n {
+			continue
+		}
+		for name := range r.metrics {
+			if strings.HasPrefix(name, prefix) {
+				n++
+			}
+		}
+		if max < n {
+			return true
+		}
+	}
+	return false
+}
+
+// replace registers i under name in place of the existing metric of another
+// type, per the ConflictReplace policy.
+func (r *StandardRegistry) replace(name string, existing, i interface{}) {
+	log.Printf("WARNING: replacing metric %s, a %T, with a %T", name, existing, i)
+	stop(existing)
+	delete(r.metrics, name)
+	delete(r.metadata, name)
+	r.register(name, i)
+}
+
+// sameMetricType reports whether a and b implement the same metric
+// interfaces, so that either may be used where the other was registered.
+func sameMetricType(a, b interface{}) bool {
+	_, aCounter := a.(Counter)
+	_, bCounter := b.(Counter)
+	_, aGauge := a.(Gauge)
+	_, bGauge := b.(Gauge)
+	_, aGaugeFloat64 := a.(GaugeFloat64)
+	_, bGaugeFloat64 := b.(GaugeFloat64)
+	_, aHealthcheck := a.(Healthcheck)
+	_, bHealthcheck := b.(Healthcheck)
+	_, aHistogram := a.(Histogram)
+	_, bHistogram := b.(Histogram)
+	_, aMeter := a.(Meter)
+	_, bMeter := b.(Meter)
+	_, aTimer := a.(Timer)
+	_, bTimer := b.(Timer)
+	_, aTopK := a.(TopK)
+	_, bTopK := b.(TopK)
+	return aCounter == bCounter && aGauge == bGauge &&
+		aGaugeFloat64 == bGaugeFloat64 && aHealthcheck == bHealthcheck &&
+		aHistogram == bHistogram && aMeter == bMeter && aTimer == bTimer &&
+		aTopK == bTopK
+}
+
+// isMetricOfType reports whether metric may be used where a metric of type t,
+// the type a constructor returns, was asked for.  A nil t, or interface{},
+// says nothing of the type.
+func isMetricOfType(metric interface{}, t reflect.Type) bool {
+	if nil == t {
+		return false
+	}
+	if reflect.Interface == t.Kind() {
+		return 0 < t.NumMethod() && reflect.TypeOf(metric).Implements(t)
+	}
+	return sameMetricType(metric, reflect.Zero(t).Interface())
+}
+
+// typedLazyRegistry is implemented by the registries of this package which
+// can get or register a metric given a constructor and the type it returns,
+// without calling the constructor when the registered metric is of that type.
+type typedLazyRegistry interface {
+	getOrRegisterTyped(name string, t reflect.Type, f func() interface{}) interface{}
+}
+
+// getOrRegisterTyped gets or registers a metric in r given a constructor and
+// the type it returns, falling back to GetOrRegisterLazy for other
+// Registries.
+func getOrRegisterTyped(name string, r Registry, t reflect.Type, f func() interface{}) interface{} {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	if tr, ok := r.(typedLazyRegistry); ok {
+		return tr.getOrRegisterTyped(name, t, f)
+	}
+	return GetOrRegisterLazy(name, r, f)
+}
+
+// Stoppable is implemented by metrics which hold resources until they're
+// stopped, such as meters and timers, which the meter arbiter ticks.
+// Registries stop the metrics unregistered from them.
+type Stoppable interface {
+	Stop()
+}
+
+// stop stops i if it's Stoppable.
+func stop(i interface{}) {
+	if s, ok := i.(Stoppable); ok {
+		s.Stop()
+	}
+}
+
+func snapshotMetric(i interface{}) interface{} {
+	switch m := i.(type) {
+	case Counter:
+		return m.Snapshot()
+	case Gauge:
+		return m.Snapshot()
+	case GaugeFloat64:
+		return m.Snapshot()
+	case Histogram:
+		return m.Snapshot()
+	case Meter:
+		return m.Snapshot()
+	case Timer:
+		return m.Snapshot()
+	case TopK:
+		return m.Snapshot()
+	}
+	return i
+}
+
 func (r *StandardRegistry) registered() map[string]interface{} {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
@@ -164,17 +558,55 @@ func NewPrefixedChildRegistry(parent Registry, prefix string) Registry {
 	}
 }
 
+// NewChildRegistry returns a Registry which registers its metrics in parent
+// under the given prefix and with the given tags, so that subsystems such as
+// http, db and cache can be given registries of their own.  The children of a
+// child inherit its prefix and tags: the prefixes are joined and the tags
+// merged, those given winning, and the metrics registered in the same root.
+func NewChildRegistry(parent Registry, prefix string, tags map[string]string) Registry {
+	if nil == parent {
+		parent = DefaultRegistry
+	}
+	if p, ok := parent.(*PrefixedRegistry); ok {
+		parent, prefix = p.underlying, p.prefix+prefix
+	}
+	if 0 != len(tags) {
+		parent = NewTaggedRegistry(parent, tags)
+	}
+	if "" == prefix {
+		return parent
+	}
+	return &PrefixedRegistry{underlying: parent, prefix: prefix}
+}
+
+// Child returns a registry whose metrics carry this one's prefix followed by
+// the given one and the given tags.  See NewChildRegistry.
+func (r *PrefixedRegistry) Child(prefix string, tags map[string]string) Registry {
+	return NewChildRegistry(r, prefix, tags)
+}
+
 // Call the given function for each registered metric.
 func (r *PrefixedRegistry) Each(fn func(string, interface{})) {
 	r.underlying.Each(fn)
 }
 
+// Call the given function for each metric in the underlying registry whose
+// name begins with the given prefix.  The prefix will be prefixed.
+func (r *PrefixedRegistry) EachPrefixed(prefix string, fn func(string, interface{})) {
+	EachPrefixed(r.underlying, r.prefix+prefix, fn)
+}
+
 // Get the metric by the given name or nil if none is registered.
 func (r *PrefixedRegistry) Get(name string) interface{} {
 	realName := r.prefix + name
 	return r.underlying.Get(realName)
 }
 
+// Get the values of every metric in the underlying registry.
+func (r *PrefixedRegistry) GetAll() map[string]map[string]interface{} {
+	return GetAll(r.underlying)
+}
+
 // Gets an existing metric or registers the given one.
 // The interface can be the metric to register if not found in registry,
 // or a function returning the metric for lazy instantiation.
@@ -183,17 +615,63 @@ func (r *PrefixedRegistry) GetOrRegister(name string, metric interface{}) interf
 	return r.underlying.GetOrRegister(realName, metric)
 }
 
+// Gets an existing metric or registers the one returned by the given
+// function. The name will be prefixed.
+func (r *PrefixedRegistry) GetOrRegisterLazy(name string, f func() interface{}) interface{} {
+	return GetOrRegisterLazy(r.prefix+name, r.underlying, f)
+}
+
+func (r *PrefixedRegistry) getOrRegisterTyped(name string, t reflect.Type, f func() interface{}) interface{} {
+	return getOrRegisterTyped(r.prefix+name, r.underlying, t, f)
+}
+
+// Get the metadata attached to the metric by the given name. The name will be
+// prefixed.
+func (r *PrefixedRegistry) Metadata(name string) (Metadata, bool) {
+	if mr, ok := r.underlying.(MetadataRegistry); ok {
+		return mr.Metadata(r.prefix + name)
+	}
+	return Metadata{}, false
+}
+
 // Register the given metric under the given name. The name will be prefixed.
 func (r *PrefixedRegistry) Register(name string, metric interface{}) error {
 	realName := r.prefix + name
 	return r.underlying.Register(realName, metric)
 }
 
+// Register all the given metrics at once. The names will be prefixed.
+func (r *PrefixedRegistry) RegisterBatch(metrics map[string]interface{}) error {
+	prefixed := make(map[string]interface{}, len(metrics))
+	for name, metric := range metrics {
+		prefixed[r.prefix+name] = metric
+	}
+	return r.underlying.RegisterBatch(prefixed)
+}
+
+// Unregister every metric whose name begins with the given prefix.  The
+// prefix will be prefixed.
+func (r *PrefixedRegistry) RemovePrefix(prefix string) {
+	RemovePrefix(r.underlying, r.prefix+prefix)
+}
+
 // Run all registered healthchecks.
 func (r *PrefixedRegistry) RunHealthchecks() {
 	r.underlying.RunHealthchecks()
 }
 
+// Attach metadata to the metric by the given name. The name will be prefixed.
+func (r *PrefixedRegistry) SetMetadata(name string, md Metadata) {
+	if mr, ok := r.underlying.(MetadataRegistry); ok {
+		mr.SetMetadata(r.prefix+name, md)
+	}
+}
+
+// Snapshot every metric in the underlying registry.
+func (r *PrefixedRegistry) Snapshot() Registry {
+	return r.underlying.Snapshot()
+}
+
 // Unregister the metric with the given name. The name will be prefixed.
 func (r *PrefixedRegistry) Unregister(name string) {
 	realName := r.prefix + name
@@ -205,6 +683,178 @@ func (r *PrefixedRegistry) UnregisterAll() {
 	r.underlying.UnregisterAll()
 }
 
+// MergedRegistry presents several registries as one, so that metrics
+// registered once may be exported and served by consumers of different
+// registries.  Where children hold metrics of the same name, the first
+// child to hold one shadows the others.  New metrics are registered in the
+// first child.
+type MergedRegistry struct {
+	registries []Registry
+	mutex      sync.Mutex // Serializes registrations through the merged registry
+}
+
+// NewMergedRegistry returns a Registry merging the given registries, the
+// first of which receives new metrics.  With no registries it merges a new
+// StandardRegistry.
+func NewMergedRegistry(registries ...Registry) Registry {
+	if 0 == len(registries) {
+		registries = []Registry{NewRegistry()}
+	}
+	return &MergedRegistry{registries: registries}
+}
+
+// Call the given function for each registered metric, skipping those
+// shadowed by a metric of the same name in an earlier child.
+func (r *MergedRegistry) Each(f func(string, interface{})) {
+	seen := make(map[string]bool)
+	for _, child := range r.registries {
+		child.Each(func(name string, i interface{}) {
+			if !seen[name] {
+				seen[name] = true
+				f(name, i)
+			}
+		})
+	}
+}
+
+// Get the metric by the given name from the first child which holds one, or
+// nil if none is registered.
+func (r *MergedRegistry) Get(name string) interface{} {
+	for _, child := range r.registries {
+		if i := child.Get(name); nil != i {
+			return i
+		}
+	}
+	return nil
+}
+
+// Gets an existing metric from any child or registers the given one in the
+// first child.
+func (r *MergedRegistry) GetOrRegister(name string, i interface{}) interface{} {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if metric := r.Get(name); nil != metric {
+		return metric
+	}
+	return r.registries[0].GetOrRegister(name, i)
+}
+
+// Gets an existing metric from any child or registers the one returned by
+// the given function in the first child.
+func (r *MergedRegistry) GetOrRegisterLazy(name string, f func() interface{}) interface{} {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if metric := r.Get(name); nil != metric {
+		return metric
+	}
+	return GetOrRegisterLazy(name, r.registries[0], f)
+}
+
+// Get the metadata attached to the metric by the given name in the first
+// child which holds one.
+func (r *MergedRegistry) Metadata(name string) (Metadata, bool) {
+	if mr, ok := r.holder(name).(MetadataRegistry); ok {
+		return mr.Metadata(name)
+	}
+	return Metadata{}, false
+}
+
+// Register the given metric under the given name in the first child.
+// Returns a DuplicateMetric if any child already holds a metric by the
+// given name.
+func (r *MergedRegistry) Register(name string, i interface{}) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if metric := r.Get(name); nil != metric {
+		return DuplicateMetric{Name: name, Existing: metric}
+	}
+	return r.registries[0].Register(name, i)
+}
+
+// Register all the given metrics at once in the first child, or none of them
+// if any child already holds a metric by one of the given names.
+func (r *MergedRegistry) RegisterBatch(metrics map[string]interface{}) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if metric := r.Get(name); nil != metric {
+			return DuplicateMetric{Name: name, Existing: metric}
+		}
+	}
+	return r.registries[0].RegisterBatch(metrics)
+}
+
+// Run the healthchecks of every child.
+func (r *MergedRegistry) RunHealthchecks() {
+	for _, child := range r.registries {
+		child.RunHealthchecks()
+	}
+}
+
+// Attach metadata to the metric by the given name in the first child which
+// holds one, or in the first child if none does.
+func (r *MergedRegistry) SetMetadata(name string, md Metadata) {
+	if mr, ok := r.holder(name).(MetadataRegistry); ok {
+		mr.SetMetadata(name, md)
+	}
+}
+
+// Snapshot every child into a single read-only Registry, shadowing as Each
+// does.
+func (r *MergedRegistry) Snapshot() Registry {
+	snapshot := &StandardRegistry{
+		metrics:  make(map[string]interface{}),
+		metadata: make(map[string]Metadata),
+		dropped:  NilCounter{},
+	}
+	for _, child := range r.registries {
+		cs := child.Snapshot()
+		cs.Each(func(name string, i interface{}) {
+			if _, ok := snapshot.metrics[name]; ok {
+				return
+			}
+			snapshot.metrics[name] = i
+			if mr, ok := cs.(MetadataRegistry); ok {
+				if md, ok := mr.Metadata(name); ok {
+					snapshot.metadata[name] = md
+				}
+			}
+		})
+	}
+	return snapshot
+}
+
+// Unregister the metric with the given name from every child, so that no
+// shadowed metric takes its place.
+func (r *MergedRegistry) Unregister(name string) {
+	for _, child := range r.registries {
+		child.Unregister(name)
+	}
+}
+
+// Unregister all metrics from every child.  (Mostly for testing.)
+func (r *MergedRegistry) UnregisterAll() {
+	for _, child := range r.registries {
+		child.UnregisterAll()
+	}
+}
+
+// holder returns the first child holding a metric by the given name, or the
+// first child if none does.
+func (r *MergedRegistry) holder(name string) Registry {
+	for _, child := range r.registries {
+		if nil != child.Get(name) {
+			return child
+		}
+	}
+	return r.registries[0]
+}
+
 var DefaultRegistry Registry = NewRegistry()
 
 // Call the given function for each registered metric.
@@ -229,6 +879,12 @@ func Register(name string, i interface{}) error {
 	return DefaultRegistry.Register(name, i)
 }
 
+// Register all the given metrics at once, or none of them if any cannot be
+// registered.
+func RegisterBatch(metrics map[string]interface{}) error {
+	return DefaultRegistry.RegisterBatch(metrics)
+}
+
 // Register the given metric under the given name.  Panics if a metric by the
 // given name is already registered.
 func MustRegister(name string, i interface{}) {
@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"fmt"
 	"testing"
 )
 
@@ -57,6 +58,20 @@ func TestRegistryDuplicate(t *testing.T) {
 	}
 }
 
+func TestMustRegister(t *testing.T) {
+	defer Unregister("foo")
+	MustRegister("foo", NewCounter())
+	defer func() {
+		err, ok := recover().(DuplicateMetric)
+		if !ok || "foo" != err.Name {
+			t.Errorf("MustRegister(): expected a DuplicateMetric panic, not %v", err)
+		} else if "duplicate metric: foo is already registered as a *metrics.StandardCounter" != err.Error() {
+			t.Error(err)
+		}
+	}()
+	MustRegister("foo", NewCounter())
+}
+
 func TestRegistryGet(t *testing.T) {
 	r := NewRegistry()
 	r.Register("foo", NewCounter())
@@ -223,3 +238,348 @@ func TestPrefixedChildRegistryGet(t *testing.T) {
 		t.Fatal(name)
 	}
 }
+
+func TestNewRegisteredReturnsExisting(t *testing.T) {
+	r := NewRegistry()
+	if c := NewRegisteredCounter("counter", r); c != NewRegisteredCounter("counter", r) {
+		t.Fatal(c)
+	}
+	if g := NewRegisteredGauge("gauge", r); g != NewRegisteredGauge("gauge", r) {
+		t.Fatal(g)
+	}
+	if g := NewRegisteredGaugeFloat64("gaugefloat64", r); g != NewRegisteredGaugeFloat64("gaugefloat64", r) {
+		t.Fatal(g)
+	}
+	f := func(h Healthcheck) {}
+	if h := NewRegisteredHealthcheck("healthcheck", r, f); h != NewRegisteredHealthcheck("healthcheck", r, f) {
+		t.Fatal(h)
+	}
+	s := NewUniformSample(100)
+	if h := NewRegisteredHistogram("histogram", r, s); h != NewRegisteredHistogram("histogram", r, s) {
+		t.Fatal(h)
+	}
+	if m := NewRegisteredMeter("meter", r); m != NewRegisteredMeter("meter", r) {
+		t.Fatal(m)
+	}
+	if tm := NewRegisteredTimer("timer", r); tm != NewRegisteredTimer("timer", r) {
+		t.Fatal(tm)
+	}
+	if h := NewRegisteredTopNHistogram("topn", r, s, 3); h != NewRegisteredTopNHistogram("topn", r, s, 3) {
+		t.Fatal(h)
+	}
+}
+
+func TestRegistrySnapshot(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounter()
+	RegisterWithMetadata(r, "foo", c, Metadata{Unit: "requests"})
+	c.Inc(47)
+	s := r.Snapshot()
+	c.Inc(1)
+	snapshot, ok := s.Get("foo").(CounterSnapshot)
+	if !ok {
+		t.Fatalf("s.Get(\"foo\"): %T", s.Get("foo"))
+	}
+	if count := snapshot.Count(); 47 != count {
+		t.Errorf("snapshot.Count(): 47 != %v\n", count)
+	}
+	if md := GetMetadata(s, "foo"); "requests" != md.Unit {
+		t.Errorf("md.Unit: requests != %v\n", md.Unit)
+	}
+}
+
+func TestRegistryConflictKeep(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounter()
+	r.Register("foo", c)
+	if err := r.Register("foo", NewGauge()); err != (DuplicateMetric{Name: "foo", Existing: c}) {
+		t.Errorf("r.Register(): %v", err)
+	}
+	if i := r.GetOrRegister("foo", NewGauge); i != c {
+		t.Errorf("r.GetOrRegister(): %v", i)
+	}
+}
+
+func TestRegistryConflictReject(t *testing.T) {
+	r := NewRegistryWithConflictPolicy(ConflictReject)
+	c := NewCounter()
+	r.Register("foo", c)
+	if err := r.Register("foo", NewCounter()); err != (DuplicateMetric{Name: "foo", Existing: c}) {
+		t.Errorf("r.Register(): %v", err)
+	}
+	if _, ok := r.Register("foo", NewGauge()).(MetricTypeConflict); !ok {
+		t.Error("r.Register(): expected a MetricTypeConflict")
+	}
+	if i := r.GetOrRegister("foo", NewCounter); i != c {
+		t.Errorf("r.GetOrRegister(): %v", i)
+	}
+	defer func() {
+		if _, ok := recover().(MetricTypeConflict); !ok {
+			t.Error("r.GetOrRegister(): expected a MetricTypeConflict panic")
+		}
+		if r.Get("foo") != c {
+			t.Error("existing metric was replaced")
+		}
+	}()
+	r.GetOrRegister("foo", NewGauge)
+}
+
+func TestRegistryConflictReplace(t *testing.T) {
+	r := NewRegistryWithConflictPolicy(ConflictReplace)
+	RegisterWithMetadata(r, "foo", NewCounter(), Metadata{Unit: "requests"})
+	g := NewGauge()
+	if err := r.Register("foo", g); nil != err {
+		t.Fatal(err)
+	}
+	if r.Get("foo") != g {
+		t.Errorf("r.Get(): %v", r.Get("foo"))
+	}
+	if md := GetMetadata(r, "foo"); "" != md.Unit {
+		t.Errorf("metadata survived replacement: %v", md)
+	}
+	if _, ok := r.GetOrRegister("foo", NewGaugeFloat64).(GaugeFloat64); !ok {
+		t.Error("r.GetOrRegister(): expected a GaugeFloat64")
+	}
+}
+
+func TestRegistryGetOrRegisterConstructsOnMiss(t *testing.T) {
+	for _, policy := range []ConflictPolicy{ConflictKeep, ConflictReject, ConflictReplace} {
+		r := NewRegistryWithConflictPolicy(policy)
+		calls := 0
+		f := func() Meter {
+			calls++
+			return NewMeter()
+		}
+		m := r.GetOrRegister("foo", f)
+		arbiter.RLock()
+		n := len(arbiter.meters)
+		arbiter.RUnlock()
+		for i := 0; i < 100; i++ {
+			if r.GetOrRegister("foo", f) != m {
+				t.Fatalf("policy %v: registered meter wasn't returned", policy)
+			}
+			GetOrRegisterMeterLazy("foo", r, f)
+			r.(LazyRegistry).GetOrRegisterLazy("foo", func() interface{} { return NewMeter() })
+		}
+		arbiter.RLock()
+		leaked := len(arbiter.meters) - n
+		arbiter.RUnlock()
+		if 1 != calls || 0 != leaked {
+			t.Errorf("policy %v: constructor called %d times, %d meters leaked", policy, calls, leaked)
+		}
+		r.UnregisterAll()
+	}
+}
+
+func TestRegistryRegisterBatch(t *testing.T) {
+	r := NewRegistry()
+	if err := r.RegisterBatch(map[string]interface{}{"a": NewCounter(), "b": NewGauge()}); nil != err {
+		t.Fatal(err)
+	}
+	if nil == r.Get("a") || nil == r.Get("b") {
+		t.Error("batch wasn't registered")
+	}
+}
+
+func TestRegistryRegisterBatchCollision(t *testing.T) {
+	r := NewRegistry()
+	b := NewCounter()
+	r.Register("b", b)
+	err := r.RegisterBatch(map[string]interface{}{"a": NewCounter(), "b": NewCounter(), "c": NewCounter()})
+	if (DuplicateMetric{Name: "b", Existing: b}) != err {
+		t.Errorf("err: %v", err)
+	}
+	if nil != r.Get("a") || nil != r.Get("c") {
+		t.Error("batch was partially registered")
+	}
+}
+
+func TestPrefixedRegistryRegisterBatch(t *testing.T) {
+	r := NewPrefixedRegistry("prefix.")
+	r.RegisterBatch(map[string]interface{}{"a": NewCounter()})
+	if nil == r.(*PrefixedRegistry).underlying.Get("prefix.a") {
+		t.Error("batch wasn't prefixed")
+	}
+}
+
+func TestMergedRegistryGetOrRegister(t *testing.T) {
+	a, b := NewRegistry(), NewRegistry()
+	r := NewMergedRegistry(a, b)
+	c := GetOrRegisterCounter("foo", r)
+	if a.Get("foo") != c {
+		t.Error("new metric wasn't registered in the first child")
+	}
+	if nil != b.Get("foo") {
+		t.Error("new metric was registered in the second child")
+	}
+	g := GetOrRegisterGauge("bar", b)
+	if GetOrRegisterGauge("bar", r) != g {
+		t.Error("existing metric in the second child wasn't returned")
+	}
+	if nil != a.Get("bar") {
+		t.Error("existing metric was registered again")
+	}
+}
+
+func TestMergedRegistryCollision(t *testing.T) {
+	a, b := NewRegistry(), NewRegistry()
+	r := NewMergedRegistry(a, b)
+	ca := GetOrRegisterCounter("foo", a)
+	GetOrRegisterCounter("foo", b).Inc(1)
+	if r.Get("foo") != ca {
+		t.Error("the first child didn't shadow the second")
+	}
+	i := 0
+	r.Each(func(name string, m interface{}) {
+		i++
+		if "foo" != name || m != ca {
+			t.Error(name, m)
+		}
+	})
+	if 1 != i {
+		t.Fatal(i)
+	}
+	if _, ok := r.Register("foo", NewCounter()).(DuplicateMetric); !ok {
+		t.Error("Register didn't return a DuplicateMetric")
+	}
+	bar := GetOrRegisterGauge("bar", b)
+	if err := r.RegisterBatch(map[string]interface{}{"baz": NewCounter(), "bar": NewGauge()}); (DuplicateMetric{Name: "bar", Existing: bar}) != err {
+		t.Error(err)
+	}
+	if nil != a.Get("baz") {
+		t.Error("batch was partially registered")
+	}
+	if c := r.Snapshot().Get("foo").(Counter); 0 != c.Count() {
+		t.Error("the snapshot didn't shadow the second child")
+	}
+	r.Unregister("foo")
+	if nil != r.Get("foo") {
+		t.Error("a shadowed metric took the place of an unregistered one")
+	}
+}
+
+func TestMergedRegistryMetadata(t *testing.T) {
+	a, b := NewRegistry(), NewRegistry()
+	r := NewMergedRegistry(a, b)
+	RegisterWithMetadata(b, "foo", NewCounter(), Metadata{Unit: "requests"})
+	if md := GetMetadata(r, "foo"); "requests" != md.Unit {
+		t.Error(md)
+	}
+	r.(MetadataRegistry).SetMetadata("foo", Metadata{Unit: "errors"})
+	if md := GetMetadata(b, "foo"); "errors" != md.Unit {
+		t.Error(md)
+	}
+	if md, ok := r.Snapshot().(MetadataRegistry).Metadata("foo"); !ok || "errors" != md.Unit {
+		t.Error(md, ok)
+	}
+}
+
+func TestChildRegistry(t *testing.T) {
+	r := NewRegistry()
+	http := r.(*StandardRegistry).Child("http.", map[string]string{"subsystem": "http", "az": "a"})
+	login := http.(*PrefixedRegistry).Child("login.", map[string]string{"az": "b"})
+	c := GetOrRegisterCounter("requests", login)
+	if r.Get("http.login.requests{az=b,subsystem=http}") != c {
+		t.Fatal("counter wasn't registered under its prefixed, tagged name")
+	}
+	if name := exportName("http.login.requests{az=b,subsystem=http}", c); "http.login.requests" != name {
+		t.Error(name)
+	}
+	if tags := c.(Tagged).Tags(); 2 != len(tags) || "b" != tags["az"] || "http" != tags["subsystem"] {
+		t.Error(tags)
+	}
+	if login.Get("requests") != c {
+		t.Error("counter wasn't found through its registry")
+	}
+}
+
+func TestChildRegistryPrefixOnly(t *testing.T) {
+	r := NewRegistry()
+	db := NewChildRegistry(r, "db.", nil)
+	GetOrRegisterCounter("queries", NewChildRegistry(db, "pool.", nil))
+	if _, ok := r.Get("db.pool.queries").(*StandardCounter); !ok {
+		t.Error("counter wasn't registered untagged under db.pool.queries")
+	}
+}
+
+func TestRegistryUnregisterStopsMeters(t *testing.T) {
+	r := NewRegistry()
+	m := GetOrRegisterMeter("m", r).(*StandardMeter)
+	tm := GetOrRegisterTimer("t", r).(*StandardTimer)
+	r.Unregister("m")
+	r.UnregisterAll()
+	arbiter.RLock()
+	_, mTicked := arbiter.meters[m]
+	_, tTicked := arbiter.meters[tm.meter.(*StandardMeter)]
+	arbiter.RUnlock()
+	if mTicked || tTicked {
+		t.Errorf("arbiter still ticks unregistered meters: %v %v", mTicked, tTicked)
+	}
+}
+
+func TestRegistryLimitsStopDropped(t *testing.T) {
+	r := NewRegistry().(*StandardRegistry)
+	r.SetLimits(RegistryLimits{MaxMetrics: 1})
+	GetOrRegisterMeter("first", r)
+	arbiter.RLock()
+	n := len(arbiter.meters)
+	arbiter.RUnlock()
+	for i := 0; i < 100; i++ {
+		GetOrRegisterMeter(fmt.Sprintf("user.%d", i), r)
+		GetOrRegisterTimer(fmt.Sprintf("user.%d.latency", i), r)
+	}
+	r.Register("meter", NewMeter())
+	r.RegisterBatch(map[string]interface{}{"a": NewMeter(), "b": NewTimer()})
+	arbiter.RLock()
+	leaked := len(arbiter.meters) - n
+	arbiter.RUnlock()
+	if 0 != leaked {
+		t.Errorf("%d dropped meters left in the arbiter", leaked)
+	}
+	r.UnregisterAll()
+}
+
+func TestRegistryLimits(t *testing.T) {
+	r := NewRegistry().(*StandardRegistry)
+	r.SetLimits(RegistryLimits{
+		MaxMetrics:   3,
+		MaxPerPrefix: map[string]int{"user.": 1},
+	})
+	if err := r.Register("user.alice", NewCounter()); nil != err {
+		t.Fatal(err)
+	}
+	if err := r.Register("user.bob", NewCounter()); MetricLimitExceeded("user.bob") != err {
+		t.Fatal(err)
+	}
+	c := GetOrRegisterCounter("user.carol", r)
+	c.Inc(1)
+	if nil != r.Get("user.carol") {
+		t.Fatal("registered past the prefix limit")
+	}
+	GetOrRegisterCounter("user.alice", r).Inc(1)
+	if 1 != r.Get("user.alice").(Counter).Count() {
+		t.Fatal("existing metric not returned")
+	}
+	if err := r.RegisterBatch(map[string]interface{}{
+		"bar": NewGauge(),
+		"baz": NewGauge(),
+		"foo": NewGauge(),
+	}); MetricLimitExceeded("bar") != err {
+		t.Fatal(err)
+	}
+	if nil != r.Get("bar") {
+		t.Fatal("partial batch registered")
+	}
+	NewRegisteredGauge("bar", r)
+	NewRegisteredGauge("baz", r)
+	if err := r.Register("foo", NewGauge()); MetricLimitExceeded("foo") != err {
+		t.Fatal(err)
+	}
+	if count := r.Dropped().Count(); 6 != count {
+		t.Fatalf("r.Dropped().Count(): 6 != %v\n", count)
+	}
+	r.Unregister("baz")
+	if err := r.Register("foo", NewGauge()); nil != err {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,140 @@
+//go:build go1.16
+// +build go1.16
+
+package metrics
+
+import (
+	"context"
+	"math"
+	rtmetrics "runtime/metrics"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runtimeHistogramUpdates is the most observations a RuntimeCollector gives
+// one of its histograms per capture.
+const runtimeHistogramUpdates = 1028
+
+var runtimeMetricNames = strings.NewReplacer("/", ".", ":", ".")
+
+// RuntimeCollector captures the Go runtime's statistics read via
+// runtime/metrics, such as the heap's size, GC pauses, the number of
+// goroutines and scheduler latency, into a registry.
+type RuntimeCollector struct {
+	mutex   sync.Mutex
+	samples []rtmetrics.Sample
+	updates []func(rtmetrics.Value)
+}
+
+// NewRuntimeCollector registers a metric in r for each statistic supported
+// by runtime/metrics, named with the given prefix followed by the
+// statistic's name with its slashes and colon replaced by dots, so that
+// /sched/goroutines:goroutines becomes sched.goroutines.goroutines.  Integer
+// statistics become Gauges, floating-point ones GaugeFloat64s and
+// distributions Histograms, in nanoseconds where they are in seconds.  No
+// statistic is read until CaptureOnce or Capture is called.
+func NewRuntimeCollector(r Registry, prefix string) *RuntimeCollector {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	c := &RuntimeCollector{}
+	for _, d := range rtmetrics.All() {
+		name := prefix + runtimeMetricNames.Replace(strings.TrimPrefix(d.Name, "/"))
+		var update func(rtmetrics.Value)
+		switch d.Kind {
+		case rtmetrics.KindUint64:
+			g := NewGauge()
+			r.Register(name, g)
+			update = func(v rtmetrics.Value) { g.Update(int64(v.Uint64())) }
+		case rtmetrics.KindFloat64:
+			g := NewGaugeFloat64()
+			r.Register(name, g)
+			update = func(v rtmetrics.Value) { g.Update(v.Float64()) }
+		case rtmetrics.KindFloat64Histogram:
+			h := NewHistogram(NewExpDecaySample(1028, 0.015))
+			r.Register(name, h)
+			scale := 1.0
+			if strings.HasSuffix(d.Name, ":seconds") {
+				scale = 1e9
+			}
+			var counts []uint64
+			update = func(v rtmetrics.Value) {
+				counts = updateRuntimeHistogram(h, v.Float64Histogram(), counts, scale)
+			}
+		default:
+			continue
+		}
+		c.samples = append(c.samples, rtmetrics.Sample{Name: d.Name})
+		c.updates = append(c.updates, update)
+	}
+	return c
+}
+
+// Capture captures the runtime's statistics every d until the context is
+// done.  This is designed to be called as a goroutine.
+func (c *RuntimeCollector) Capture(ctx context.Context, d time.Duration) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.CaptureOnce()
+		}
+	}
+}
+
+// CaptureOnce captures new values for the runtime's statistics.  Histograms
+// are given the observations made since the last capture, each at the middle
+// of its bucket; where there are more than 1028 they are given 1028 in
+// proportion to their buckets' counts.
+func (c *RuntimeCollector) CaptureOnce() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	rtmetrics.Read(c.samples)
+	for i, s := range c.samples {
+		if rtmetrics.KindBad != s.Value.Kind() {
+			c.updates[i](s.Value)
+		}
+	}
+}
+
+// updateRuntimeHistogram updates h with the observations counted by rh since
+// it counted last, its values multiplied by scale, and returns a copy of its
+// counts.
+func updateRuntimeHistogram(h Histogram, rh *rtmetrics.Float64Histogram, last []uint64, scale float64) []uint64 {
+	deltas := make([]uint64, len(rh.Counts))
+	var total uint64
+	for i, n := range rh.Counts {
+		deltas[i] = n
+		if i < len(last) {
+			deltas[i] -= last[i]
+		}
+		total += deltas[i]
+	}
+	for i, n := range deltas {
+		if runtimeHistogramUpdates < total {
+			n = n * runtimeHistogramUpdates / total
+		}
+		v := int64(runtimeBucketValue(rh.Buckets, i) * scale)
+		for ; 0 < n; n-- {
+			h.Update(v)
+		}
+	}
+	return append(last[:0], rh.Counts...)
+}
+
+// runtimeBucketValue returns the middle of the ith bucket, or its finite
+// boundary if the other is infinite.
+func runtimeBucketValue(buckets []float64, i int) float64 {
+	lo, hi := buckets[i], buckets[i+1]
+	if math.IsInf(lo, -1) {
+		return hi
+	}
+	if math.IsInf(hi, 1) {
+		return lo
+	}
+	return (lo + hi) / 2
+}
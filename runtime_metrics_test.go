@@ -0,0 +1,47 @@
+//go:build go1.16
+// +build go1.16
+
+package metrics
+
+import (
+	"math"
+	"runtime"
+	rtmetrics "runtime/metrics"
+	"testing"
+)
+
+func TestRuntimeCollector(t *testing.T) {
+	r := NewRegistry()
+	c := NewRuntimeCollector(r, "runtime.")
+	runtime.GC()
+	c.CaptureOnce()
+	if g, ok := r.Get("runtime.sched.goroutines.goroutines").(Gauge); !ok || 0 == g.Value() {
+		t.Errorf("sched.goroutines.goroutines: %v", r.Get("runtime.sched.goroutines.goroutines"))
+	}
+	if g, ok := r.Get("runtime.gc.cycles.total.gc-cycles").(Gauge); !ok || 0 == g.Value() {
+		t.Errorf("gc.cycles.total.gc-cycles: %v", r.Get("runtime.gc.cycles.total.gc-cycles"))
+	}
+	if _, ok := r.Get("runtime.sched.latencies.seconds").(Histogram); !ok {
+		t.Errorf("sched.latencies.seconds: %v", r.Get("runtime.sched.latencies.seconds"))
+	}
+}
+
+func TestUpdateRuntimeHistogram(t *testing.T) {
+	h := NewHistogram(NewUniformSample(10000))
+	rh := &rtmetrics.Float64Histogram{
+		Counts:  []uint64{1, 2, 3},
+		Buckets: []float64{math.Inf(-1), 1e-6, 3e-6, math.Inf(1)},
+	}
+	counts := updateRuntimeHistogram(h, rh, nil, 1e9)
+	if 6 != h.Count() || 1000 != h.Min() || 3000 != h.Max() {
+		t.Errorf("h: %v %v %v", h.Count(), h.Min(), h.Max())
+	}
+	if 2000 != h.Sample().Values()[1] {
+		t.Errorf("h.Sample().Values(): %v", h.Sample().Values())
+	}
+	rh.Counts = []uint64{1, 2, 3 + 2*runtimeHistogramUpdates}
+	updateRuntimeHistogram(h, rh, counts, 1e9)
+	if count := h.Count(); 6+runtimeHistogramUpdates != count {
+		t.Errorf("h.Count(): %v != %v", 6+runtimeHistogramUpdates, count)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !go1.16
+// +build !go1.16
+
+package metrics
+
+import (
+	"context"
+	"time"
+)
+
+// RuntimeCollector captures nothing before Go 1.16, which introduced
+// runtime/metrics.
+type RuntimeCollector struct{}
+
+// NewRuntimeCollector registers nothing before Go 1.16.
+func NewRuntimeCollector(r Registry, prefix string) *RuntimeCollector {
+	return &RuntimeCollector{}
+}
+
+// Capture waits until the context is done.
+func (c *RuntimeCollector) Capture(ctx context.Context, d time.Duration) {
+	<-ctx.Done()
+}
+
+// CaptureOnce is a no-op.
+func (c *RuntimeCollector) CaptureOnce() {}
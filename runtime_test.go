@@ -86,3 +86,32 @@ func testRuntimeMemStatsBlocking(ch chan int) {
 		}
 	}
 }
+
+func TestRegisterRuntimeMemStatsNames(t *testing.T) {
+	r := NewRegistry()
+	RegisterRuntimeMemStats(r)
+	for _, name := range []string{
+		"runtime.MemStats.HeapAlloc",
+		"runtime.MemStats.HeapInuse",
+		"runtime.MemStats.NumGC",
+		"runtime.MemStats.PauseNs",
+		"runtime.MemStats.PauseTotalNs",
+		"runtime.NumGoroutine",
+		"runtime.NumThread",
+	} {
+		if nil == r.Get(name) {
+			t.Errorf("%s wasn't registered", name)
+		}
+	}
+	CaptureRuntimeMemStatsOnce(r)
+	e, _ := newOpenTSDBExporter(OpenTSDBConfig{Registry: r, Prefix: "p", Transport: NewChannelTransport(1)})
+	exported := false
+	for _, p := range e.collect(0) {
+		if "p.runtime.NumGoroutine.value" == p.Metric {
+			exported = 1 <= p.Value
+		}
+	}
+	if !exported {
+		t.Error("p.runtime.NumGoroutine.value wasn't exported")
+	}
+}
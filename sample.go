@@ -63,6 +63,11 @@ func NewExpDecaySample(reservoirSize int, alpha float64) Sample {
 func (s *ExpDecaySample) Clear() {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	s.clear()
+}
+
+// clear clears all samples.  It should run with s.mutex held.
+func (s *ExpDecaySample) clear() {
 	s.count = 0
 	s.t0 = time.Now()
 	s.t1 = s.t0.Add(rescaleThreshold)
@@ -112,10 +117,29 @@ func (s *ExpDecaySample) Size() int {
 	return s.values.Size()
 }
 
-// Snapshot returns a read-only copy of the sample.
+// Snapshot returns a read-only copy of the sample.  Consumers reading the
+// same metrics concurrently are discouraged from each taking their own
+// snapshots, which may disagree; Registry.Snapshot reads every metric once
+// so that one copy can be shared.
 func (s *ExpDecaySample) Snapshot() Sample {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	return s.snapshot()
+}
+
+// SnapshotAndClear returns a read-only copy of the sample and clears it as
+// one operation, so that no value is lost between reading and clearing it.
+func (s *ExpDecaySample) SnapshotAndClear() Sample {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	snapshot := s.snapshot()
+	s.clear()
+	return snapshot
+}
+
+// snapshot returns a read-only copy of the sample.  It should run with
+// s.mutex held.
+func (s *ExpDecaySample) snapshot() Sample {
 	vals := s.values.Values()
 	values := make([]int64, len(vals))
 	for i, v := range vals {
@@ -482,6 +506,20 @@ func (s *UniformSample) Snapshot() Sample {
 	}
 }
 
+// SnapshotAndClear returns a read-only copy of the sample and clears it as
+// one operation, so that no value is lost between reading and clearing it.
+func (s *UniformSample) SnapshotAndClear() Sample {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	snapshot := &SampleSnapshot{
+		count:  s.count,
+		values: s.values,
+	}
+	s.count = 0
+	s.values = make([]int64, 0, s.reservoirSize)
+	return snapshot
+}
+
 // StdDev returns the standard deviation of the values in the sample.
 func (s *UniformSample) StdDev() float64 {
 	s.mutex.Lock()
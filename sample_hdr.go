@@ -0,0 +1,415 @@
+package metrics
+
+import (
+	"math"
+	"sync"
+)
+
+// HdrSample is a Sample which counts every value in buckets of a High
+// Dynamic Range histogram rather than keeping a reservoir of some of them, so
+// that high percentiles stay accurate however many values are recorded.
+// Values are distinguished to the configured number of significant digits
+// across the configured range, in memory fixed by it.  Values outside the
+// range are counted in the bucket nearest to them, but Count, Max, Mean, Min
+// and Sum are exact.
+//
+// <http://hdrhistogram.org/>
+type HdrSample struct {
+	mutex sync.Mutex
+	hdr   *hdrHistogram
+}
+
+// NewHdrSample constructs a new HDR sample distinguishing values from lowest
+// to highest to sigfigs significant digits.  The lowest value is at least 1,
+// the highest at least twice the lowest, and the number of significant
+// digits between 1 and 5.
+func NewHdrSample(lowest, highest int64, sigfigs int) Sample {
+	if UseNilMetrics {
+		return NilSample{}
+	}
+	return &HdrSample{hdr: newHdrHistogram(lowest, highest, sigfigs)}
+}
+
+// Clear clears all samples.
+func (s *HdrSample) Clear() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hdr.clear()
+}
+
+// Count returns the number of samples recorded.
+func (s *HdrSample) Count() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.hdr.count
+}
+
+// Max returns the maximum value recorded.
+func (s *HdrSample) Max() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.hdr.max
+}
+
+// Mean returns the mean of the values recorded.
+func (s *HdrSample) Mean() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.hdr.mean()
+}
+
+// Min returns the minimum value recorded.
+func (s *HdrSample) Min() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.hdr.min
+}
+
+// Percentile returns an arbitrary percentile of the values recorded.
+func (s *HdrSample) Percentile(p float64) float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.hdr.percentile(p)
+}
+
+// Percentiles returns a slice of arbitrary percentiles of the values
+// recorded.
+func (s *HdrSample) Percentiles(ps []float64) []float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.hdr.percentiles(ps)
+}
+
+// Size returns the number of values recorded, as that of Values.
+func (s *HdrSample) Size() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return int(s.hdr.count)
+}
+
+// Snapshot returns a read-only copy of the sample.
+func (s *HdrSample) Snapshot() Sample {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return &hdrSampleSnapshot{s.hdr.copy()}
+}
+
+// StdDev returns the standard deviation of the values recorded.
+func (s *HdrSample) StdDev() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return math.Sqrt(s.hdr.variance())
+}
+
+// Sum returns the sum of the values recorded.
+func (s *HdrSample) Sum() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.hdr.sum
+}
+
+// Update samples a new value.
+func (s *HdrSample) Update(v int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hdr.record(v)
+}
+
+// Values returns every value recorded, each as the middle of its bucket, in
+// ascending order.  There are as many as Count, so Percentiles and the like
+// are much cheaper.
+func (s *HdrSample) Values() []int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.hdr.values()
+}
+
+// Variance returns the variance of the values recorded, each as the middle
+// of its bucket.
+func (s *HdrSample) Variance() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.hdr.variance()
+}
+
+// hdrSampleSnapshot is a read-only copy of an HdrSample.
+type hdrSampleSnapshot struct {
+	hdr *hdrHistogram
+}
+
+// Clear panics.
+func (*hdrSampleSnapshot) Clear() {
+	panic("Clear called on a SampleSnapshot")
+}
+
+// Count returns the count of inputs at the time the snapshot was taken.
+func (s *hdrSampleSnapshot) Count() int64 { return s.hdr.count }
+
+// Max returns the maximal value at the time the snapshot was taken.
+func (s *hdrSampleSnapshot) Max() int64 { return s.hdr.max }
+
+// Mean returns the mean value at the time the snapshot was taken.
+func (s *hdrSampleSnapshot) Mean() float64 { return s.hdr.mean() }
+
+// Min returns the minimal value at the time the snapshot was taken.
+func (s *hdrSampleSnapshot) Min() int64 { return s.hdr.min }
+
+// Percentile returns an arbitrary percentile of values at the time the
+// snapshot was taken.
+func (s *hdrSampleSnapshot) Percentile(p float64) float64 { return s.hdr.percentile(p) }
+
+// Percentiles returns a slice of arbitrary percentiles of values at the time
+// the snapshot was taken.
+func (s *hdrSampleSnapshot) Percentiles(ps []float64) []float64 { return s.hdr.percentiles(ps) }
+
+// Size returns the size of the sample at the time the snapshot was taken.
+func (s *hdrSampleSnapshot) Size() int { return int(s.hdr.count) }
+
+// Snapshot returns the snapshot.
+func (s *hdrSampleSnapshot) Snapshot() Sample { return s }
+
+// StdDev returns the standard deviation of values at the time the snapshot
+// was taken.
+func (s *hdrSampleSnapshot) StdDev() float64 { return math.Sqrt(s.hdr.variance()) }
+
+// Sum returns the sum of values at the time the snapshot was taken.
+func (s *hdrSampleSnapshot) Sum() int64 { return s.hdr.sum }
+
+// Update panics.
+func (*hdrSampleSnapshot) Update(int64) {
+	panic("Update called on a SampleSnapshot")
+}
+
+// Values returns the values recorded at the time the snapshot was taken.
+func (s *hdrSampleSnapshot) Values() []int64 { return s.hdr.values() }
+
+// Variance returns the variance of values at the time the snapshot was taken.
+func (s *hdrSampleSnapshot) Variance() float64 { return s.hdr.variance() }
+
+// hdrHistogram counts values in buckets of doubling width, each divided into
+// enough sub-buckets to distinguish values to the significant digits asked
+// for.  Values below twice the sub-bucket count, scaled by the lowest value,
+// fall in the first bucket at the finest resolution.
+type hdrHistogram struct {
+	highest                     int64
+	unitMagnitude               uint
+	subBucketHalfCountMagnitude uint
+	subBucketHalfCount          int64
+	subBucketMask               int64
+	counts                      []int64
+	count, sum, min, max        int64
+}
+
+func newHdrHistogram(lowest, highest int64, sigfigs int) *hdrHistogram {
+	if lowest < 1 {
+		lowest = 1
+	}
+	if highest < 2*lowest {
+		highest = 2 * lowest
+	}
+	if sigfigs < 1 {
+		sigfigs = 1
+	} else if 5 < sigfigs {
+		sigfigs = 5
+	}
+	largestSingleUnit := 2 * math.Pow10(sigfigs)
+	subBucketCountMagnitude := uint(math.Ceil(math.Log2(largestSingleUnit)))
+	h := &hdrHistogram{
+		highest:                     highest,
+		unitMagnitude:               uint(math.Floor(math.Log2(float64(lowest)))),
+		subBucketHalfCountMagnitude: subBucketCountMagnitude - 1,
+	}
+	subBucketCount := int64(1) << subBucketCountMagnitude
+	h.subBucketHalfCount = subBucketCount / 2
+	h.subBucketMask = (subBucketCount - 1) << h.unitMagnitude
+	buckets := 1
+	for smallestUntrackable := subBucketCount << h.unitMagnitude; smallestUntrackable <= highest; buckets++ {
+		if math.MaxInt64/2 < smallestUntrackable {
+			buckets++
+			break
+		}
+		smallestUntrackable <<= 1
+	}
+	h.counts = make([]int64, (buckets+1)*int(h.subBucketHalfCount))
+	return h
+}
+
+func (h *hdrHistogram) clear() {
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.count, h.sum, h.min, h.max = 0, 0, 0, 0
+}
+
+func (h *hdrHistogram) copy() *hdrHistogram {
+	c := *h
+	c.counts = make([]int64, len(h.counts))
+	copy(c.counts, h.counts)
+	return &c
+}
+
+func (h *hdrHistogram) record(v int64) {
+	h.recordN(v, 1)
+}
+
+// recordN records n occurrences of v.
+func (h *hdrHistogram) recordN(v, n int64) {
+	if n <= 0 {
+		return
+	}
+	if 0 == h.count || v < h.min {
+		h.min = v
+	}
+	if 0 == h.count || h.max < v {
+		h.max = v
+	}
+	h.count += n
+	h.sum += v * n
+	if v < 0 {
+		v = 0
+	} else if h.highest < v {
+		v = h.highest
+	}
+	h.counts[h.index(v)] += n
+}
+
+// merge adds the counts of o, which must have the same layout, to h.
+func (h *hdrHistogram) merge(o *hdrHistogram) {
+	if 0 == o.count {
+		return
+	}
+	if 0 == h.count || o.min < h.min {
+		h.min = o.min
+	}
+	if 0 == h.count || h.max < o.max {
+		h.max = o.max
+	}
+	h.count += o.count
+	h.sum += o.sum
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+}
+
+// sameLayout reports whether o counts values in the same sub-buckets as h.
+func (h *hdrHistogram) sameLayout(o *hdrHistogram) bool {
+	return h.highest == o.highest && h.unitMagnitude == o.unitMagnitude &&
+		h.subBucketHalfCountMagnitude == o.subBucketHalfCountMagnitude &&
+		len(h.counts) == len(o.counts)
+}
+
+// index returns the index in counts of the sub-bucket counting v.
+func (h *hdrHistogram) index(v int64) int {
+	bucket := h.bucket(v)
+	subBucket := v >> (uint(bucket) + h.unitMagnitude)
+	return int((int64(bucket+1) << h.subBucketHalfCountMagnitude) + subBucket - h.subBucketHalfCount)
+}
+
+func (h *hdrHistogram) bucket(v int64) int {
+	return int(bitLen(v|h.subBucketMask)) - int(h.unitMagnitude+h.subBucketHalfCountMagnitude+1)
+}
+
+// lowest returns the lowest value counted at index i, along with the width of
+// its sub-bucket.
+func (h *hdrHistogram) lowest(i int) (int64, int64) {
+	bucket := i>>h.subBucketHalfCountMagnitude - 1
+	subBucket := int64(i)&(h.subBucketHalfCount-1) + h.subBucketHalfCount
+	if bucket < 0 {
+		subBucket -= h.subBucketHalfCount
+		bucket = 0
+	}
+	shift := uint(bucket) + h.unitMagnitude
+	return subBucket << shift, int64(1) << shift
+}
+
+// clamp limits v, a value standing for some of those in its sub-bucket, to
+// the exact extremes.
+func (h *hdrHistogram) clamp(v int64) int64 {
+	if v < h.min {
+		return h.min
+	}
+	if h.max < v {
+		return h.max
+	}
+	return v
+}
+
+// middle returns the value in the middle of the sub-bucket at index i.
+func (h *hdrHistogram) middle(i int) int64 {
+	v, width := h.lowest(i)
+	return h.clamp(v + width/2)
+}
+
+func (h *hdrHistogram) mean() float64 {
+	if 0 == h.count {
+		return 0.0
+	}
+	return float64(h.sum) / float64(h.count)
+}
+
+// percentile returns the highest value of the sub-bucket holding the value at
+// or below which the fraction p of the values fall.
+func (h *hdrHistogram) percentile(p float64) float64 {
+	if 0 == h.count {
+		return 0.0
+	}
+	target := int64(math.Ceil(p * float64(h.count)))
+	if target < 1 {
+		target = 1
+	}
+	var seen int64
+	for i, n := range h.counts {
+		seen += n
+		if target <= seen {
+			v, width := h.lowest(i)
+			return float64(h.clamp(v + width - 1))
+		}
+	}
+	return float64(h.max)
+}
+
+func (h *hdrHistogram) percentiles(ps []float64) []float64 {
+	scores := make([]float64, len(ps))
+	for i, p := range ps {
+		scores[i] = h.percentile(p)
+	}
+	return scores
+}
+
+func (h *hdrHistogram) values() []int64 {
+	values := make([]int64, 0, h.count)
+	for i, n := range h.counts {
+		if 0 == n {
+			continue
+		}
+		v := h.middle(i)
+		for ; 0 < n; n-- {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func (h *hdrHistogram) variance() float64 {
+	if 0 == h.count {
+		return 0.0
+	}
+	m := h.mean()
+	var sum float64
+	for i, n := range h.counts {
+		if 0 != n {
+			d := float64(h.middle(i)) - m
+			sum += float64(n) * d * d
+		}
+	}
+	return sum / float64(h.count)
+}
+
+// bitLen returns the number of bits needed to represent x.
+func bitLen(x int64) uint {
+	var n uint
+	for ; 0 != x; x >>= 1 {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func BenchmarkHdrSample(b *testing.B) {
+	benchmarkSample(b, NewHdrSample(1, 3600e9, 3))
+}
+
+func TestHdrSample(t *testing.T) {
+	s := NewHdrSample(1, 3600e9, 3)
+	for i := 1; i <= 10000; i++ {
+		s.Update(int64(i))
+	}
+	testHdrSampleStatistics(t, s)
+}
+
+func TestHdrSampleSnapshot(t *testing.T) {
+	s := NewHdrSample(1, 3600e9, 3)
+	for i := 1; i <= 10000; i++ {
+		s.Update(int64(i))
+	}
+	snapshot := s.Snapshot()
+	s.Update(1e6)
+	testHdrSampleStatistics(t, snapshot)
+	defer func() {
+		if nil == recover() {
+			t.Error("Update of a snapshot didn't panic")
+		}
+	}()
+	snapshot.Update(1)
+}
+
+func testHdrSampleStatistics(t *testing.T, s Sample) {
+	if count := s.Count(); 10000 != count {
+		t.Errorf("s.Count(): 10000 != %v\n", count)
+	}
+	if min := s.Min(); 1 != min {
+		t.Errorf("s.Min(): 1 != %v\n", min)
+	}
+	if max := s.Max(); 10000 != max {
+		t.Errorf("s.Max(): 10000 != %v\n", max)
+	}
+	if mean := s.Mean(); 5000.5 != mean {
+		t.Errorf("s.Mean(): 5000.5 != %v\n", mean)
+	}
+	if sum := s.Sum(); 50005000 != sum {
+		t.Errorf("s.Sum(): 50005000 != %v\n", sum)
+	}
+	if stdDev := s.StdDev(); math.Abs(stdDev-2886.75) > 3 {
+		t.Errorf("s.StdDev(): 2886.75 != %v\n", stdDev)
+	}
+	ps := s.Percentiles([]float64{0.001, 0.5, 0.99, 1})
+	if 10 != ps[0] {
+		t.Errorf("0.1th percentile: 10 != %v\n", ps[0])
+	}
+	if 5003 != ps[1] {
+		t.Errorf("median: 5003 != %v\n", ps[1])
+	}
+	if 9903 != ps[2] {
+		t.Errorf("99th percentile: 9903 != %v\n", ps[2])
+	}
+	if 10000 != ps[3] {
+		t.Errorf("maximum: 10000 != %v\n", ps[3])
+	}
+}
+
+func TestHdrSampleAccuracy(t *testing.T) {
+	rand.Seed(1)
+	s := NewHdrSample(1000, 3600e9, 3)
+	values := make([]int64, 100000)
+	for i := range values {
+		values[i] = int64(math.Exp(rand.NormFloat64()*2 + 15))
+		s.Update(values[i])
+	}
+	sort.Sort(int64Slice(values))
+	for _, p := range []float64{0.5, 0.9, 0.99, 0.999, 0.9999} {
+		exact := float64(values[int(math.Ceil(p*float64(len(values))))-1])
+		if got := s.Percentile(p); math.Abs(got-exact)/exact > 1e-3 {
+			t.Errorf("%v percentile: %v not within 0.1%% of %v", p, got, exact)
+		}
+	}
+}
+
+func TestHdrSampleOutOfRange(t *testing.T) {
+	s := NewHdrSample(1, 1000, 2)
+	s.Update(-5)
+	s.Update(1e6)
+	if 2 != s.Count() || -5 != s.Min() || 1e6 != s.Max() || 999995 != s.Sum() {
+		t.Errorf("%v %v %v %v", s.Count(), s.Min(), s.Max(), s.Sum())
+	}
+	if p := s.Percentile(1); p < 1000 || 1e6 < p {
+		t.Errorf("maximum: %v", p)
+	}
+}
+
+func TestHdrSampleValues(t *testing.T) {
+	s := NewHdrSample(1, 1e6, 3)
+	for _, v := range []int64{3, 100000, 3, 7} {
+		s.Update(v)
+	}
+	values := s.Values()
+	if 4 != len(values) || 4 != s.Size() {
+		t.Fatalf("%v, size %d", values, s.Size())
+	}
+	if 3 != values[0] || 3 != values[1] || 7 != values[2] || math.Abs(float64(values[3])-100000) > 100 {
+		t.Errorf("%v", values)
+	}
+	s.Clear()
+	if 0 != s.Count() || 0 != s.Max() || 0 != len(s.Values()) || 0 != s.Percentile(0.5) {
+		t.Errorf("not cleared: %v", s.Values())
+	}
+}
+
+func TestHdrSampleHistogramAndTimer(t *testing.T) {
+	h := NewHistogram(NewHdrSample(1, 1e6, 3))
+	h.Update(47)
+	if snapshot := h.Snapshot(); 1 != snapshot.Count() || 47 != snapshot.Percentile(0.99) {
+		t.Errorf("histogram: %v %v", snapshot.Count(), snapshot.Percentile(0.99))
+	}
+	tm := NewCustomTimer(NewHistogram(NewHdrSample(1, 3600e9, 3)), NewMeter())
+	tm.Update(2000)
+	if snapshot := tm.Snapshot(); 1 != snapshot.Count() || 2000 != snapshot.Max() {
+		t.Errorf("timer: %v %v", snapshot.Count(), snapshot.Max())
+	}
+}
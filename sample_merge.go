@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// MergeableSamples are Samples which can absorb the values of another, such
+// as those of a shard or of a goroutine-local sample, so that percentiles are
+// computed over the values of both rather than averaged.
+type MergeableSample interface {
+	Sample
+	Merge(Sample)
+}
+
+// MergeSamples returns a read-only Sample of the values of every given
+// sample, as though they'd been recorded by one.  Each sample's values are
+// weighted by the number it stands for, its Count, so that a reservoir of
+// many values isn't outweighed by a complete sample of a few.
+func MergeSamples(samples ...Sample) Sample {
+	var count int64
+	values := []int64{}
+	for _, s := range samples {
+		s = s.Snapshot()
+		values = mergeValues(values, count, s.Values(), s.Count(), 0)
+		count += s.Count()
+	}
+	return &SampleSnapshot{count: count, values: values}
+}
+
+// Merge absorbs the values of another sample, keeping at most the reservoir
+// size of the values of both in proportion to their counts.
+func (s *UniformSample) Merge(other Sample) {
+	other = other.Snapshot()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.values = mergeValues(s.values, s.count, other.Values(), other.Count(), s.reservoirSize)
+	s.count += other.Count()
+}
+
+// Merge absorbs the values of another sample, keeping at most the reservoir
+// size of the values of both in proportion to their counts.  Those kept are
+// weighted afresh as though they were recorded now.
+func (s *ExpDecaySample) Merge(other Sample) {
+	other = other.Snapshot()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	vals := s.values.Values()
+	values := make([]int64, len(vals))
+	for i, v := range vals {
+		values[i] = v.v
+	}
+	merged := mergeValues(values, s.count, other.Values(), other.Count(), s.reservoirSize)
+	s.count += other.Count()
+	s.values.Clear()
+	s.t0 = time.Now()
+	s.t1 = s.t0.Add(rescaleThreshold)
+	for _, v := range merged {
+		s.values.Push(expDecaySample{k: 1 / rand.Float64(), v: v})
+	}
+}
+
+// Merge absorbs the values of another sample.  Those of another HdrSample of
+// the same range and precision are merged exactly; the values of any other
+// are recorded in proportion to its count.
+func (s *HdrSample) Merge(other Sample) {
+	other = other.Snapshot()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if o, ok := other.(*hdrSampleSnapshot); ok && s.hdr.sameLayout(o.hdr) {
+		s.hdr.merge(o.hdr)
+		return
+	}
+	values, count := other.Values(), other.Count()
+	for i, v := range values {
+		s.hdr.recordN(v, weight(count, len(values), i))
+	}
+}
+
+// Merge absorbs the values of another sample as though they were recorded
+// now, so that they leave the window together.
+func (s *SlidingTimeWindowSample) Merge(other Sample) {
+	other = other.Snapshot()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	for _, v := range other.Values() {
+		s.times = append(s.times, now)
+		s.values = append(s.values, v)
+	}
+	s.count += other.Count()
+	s.trim()
+}
+
+// mergeSample merges src into dst, updating it with each of the values of
+// src if it isn't a MergeableSample.
+func mergeSample(dst, src Sample) {
+	if m, ok := dst.(MergeableSample); ok {
+		m.Merge(src)
+		return
+	}
+	for _, v := range src.Values() {
+		dst.Update(v)
+	}
+}
+
+// mergeValues returns the values of two samples, standing for aCount and
+// bCount values, in proportion to those counts: as many of each are kept as
+// the sample which stands for the most values per value allows, and no more
+// than max in all unless it's zero.
+func mergeValues(a []int64, aCount int64, b []int64, bCount int64, max int) []int64 {
+	if 0 == len(a) {
+		aCount = 0
+	} else if aCount < int64(len(a)) {
+		aCount = int64(len(a))
+	}
+	if 0 == len(b) {
+		bCount = 0
+	} else if bCount < int64(len(b)) {
+		bCount = int64(len(b))
+	}
+	total := aCount + bCount
+	if 0 == total {
+		return []int64{}
+	}
+	n := int64(len(a) + len(b))
+	if 0 < aCount && int64(len(a))*total/aCount < n {
+		n = int64(len(a)) * total / aCount
+	}
+	if 0 < bCount && int64(len(b))*total/bCount < n {
+		n = int64(len(b)) * total / bCount
+	}
+	if 0 < max && int64(max) < n {
+		n = int64(max)
+	}
+	na := (n*aCount + total/2) / total
+	if int64(len(a)) < na {
+		na = int64(len(a))
+	}
+	nb := n - na
+	if int64(len(b)) < nb {
+		nb = int64(len(b))
+	}
+	return append(spread(a, int(na)), spread(b, int(nb))...)
+}
+
+// spread returns n of values, evenly spaced through them in ascending order
+// so that their distribution is kept.
+func spread(values []int64, n int) []int64 {
+	sorted := make(int64Slice, len(values))
+	copy(sorted, values)
+	if len(values) <= n {
+		return sorted
+	}
+	sort.Sort(sorted)
+	spread := make([]int64, n)
+	for i := range spread {
+		spread[i] = sorted[(2*i+1)*len(sorted)/(2*n)]
+	}
+	return spread
+}
+
+// weight returns the number of the count values the ith of n values stands
+// for, dividing count between them as evenly as possible.
+func weight(count int64, n, i int) int64 {
+	return count*int64(i+1)/int64(n) - count*int64(i)/int64(n)
+}
@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"testing"
+)
+
+func TestMergeSamples(t *testing.T) {
+	a, b := NewUniformSample(100), NewUniformSample(100)
+	for i := 1; i <= 50; i++ {
+		a.Update(int64(i))
+		b.Update(int64(100 + i))
+	}
+	s := MergeSamples(a, b)
+	if count, size := s.Count(), s.Size(); 100 != count || 100 != size {
+		t.Fatalf("s.Count(), s.Size(): %v, %v", count, size)
+	}
+	if min, max := s.Min(), s.Max(); 1 != min || 150 != max {
+		t.Errorf("s.Min(), s.Max(): %v, %v", min, max)
+	}
+	if p := s.Percentile(0.5); p <= 50 || 101 <= p {
+		t.Errorf("s.Percentile(0.5): %v", p)
+	}
+}
+
+func TestMergeSamplesWeighted(t *testing.T) {
+	big, small := NewUniformSample(10), NewUniformSample(10)
+	for i := 0; i < 1000; i++ {
+		big.Update(1)
+	}
+	for i := 0; i < 10; i++ {
+		small.Update(2)
+	}
+	s := MergeSamples(big, small)
+	if count := s.Count(); 1010 != count {
+		t.Fatalf("s.Count(): 1010 != %v\n", count)
+	}
+	if p := s.Percentile(0.99); 1 != p {
+		t.Errorf("s.Percentile(0.99): 1 != %v\n", p)
+	}
+}
+
+func TestUniformSampleMerge(t *testing.T) {
+	s, other := NewUniformSample(10), NewUniformSample(10)
+	for i := 0; i < 10; i++ {
+		s.Update(1)
+	}
+	for i := 0; i < 30; i++ {
+		other.Update(3)
+	}
+	s.(MergeableSample).Merge(other)
+	if count, size := s.Count(), s.Size(); 40 != count || 10 != size {
+		t.Fatalf("s.Count(), s.Size(): %v, %v", count, size)
+	}
+	if sum := s.Sum(); 3+7*3 != sum {
+		t.Errorf("s.Sum(): 24 != %v\n", sum)
+	}
+}
+
+func TestExpDecaySampleMerge(t *testing.T) {
+	s, other := NewExpDecaySample(10, 0.015), NewExpDecaySample(10, 0.015)
+	for i := 0; i < 5; i++ {
+		s.Update(1)
+		other.Update(2)
+	}
+	s.(MergeableSample).Merge(other)
+	if count, size, sum := s.Count(), s.Size(), s.Sum(); 10 != count || 10 != size || 15 != sum {
+		t.Errorf("s.Count(), s.Size(), s.Sum(): %v, %v, %v", count, size, sum)
+	}
+}
+
+func TestHdrSampleMerge(t *testing.T) {
+	s, other := NewHdrSample(1, 1000, 3), NewHdrSample(1, 1000, 3)
+	for i := 1; i <= 100; i++ {
+		s.Update(int64(i))
+		other.Update(int64(100 + i))
+	}
+	s.(MergeableSample).Merge(other)
+	if count, min, max := s.Count(), s.Min(), s.Max(); 200 != count || 1 != min || 200 != max {
+		t.Fatalf("s.Count(), s.Min(), s.Max(): %v, %v, %v", count, min, max)
+	}
+	if p := s.Percentile(0.5); p < 99 || 102 < p {
+		t.Errorf("s.Percentile(0.5): %v", p)
+	}
+	u := NewUniformSample(2)
+	for i := 0; i < 10; i++ {
+		u.Update(500)
+	}
+	s.(MergeableSample).Merge(u)
+	if count, max := s.Count(), s.Max(); 210 != count || 500 != max {
+		t.Errorf("s.Count(), s.Max(): %v, %v", count, max)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	h, other := NewHistogram(NewUniformSample(100)), NewHistogram(NewUniformSample(100))
+	h.Update(1)
+	other.UpdateWithExemplar(9, "trace")
+	h.Merge(other)
+	if count, max := h.Count(), h.Max(); 2 != count || 9 != max {
+		t.Errorf("h.Count(), h.Max(): %v, %v", count, max)
+	}
+	if exs := h.Exemplars(); 1 != len(exs) || "trace" != exs[0].TraceID {
+		t.Errorf("h.Exemplars(): %v", exs)
+	}
+	m := MergeHistograms(h, other)
+	if count, sum := m.Count(), m.Sum(); 3 != count || 19 != sum {
+		t.Errorf("m.Count(), m.Sum(): %v, %v", count, sum)
+	}
+}
+
+func TestBucketedHistogramMerge(t *testing.T) {
+	h := NewBucketedHistogram(NewUniformSample(100), []int64{10, 100})
+	other := NewBucketedHistogram(NewUniformSample(100), []int64{10, 100})
+	h.Update(5)
+	other.Update(50)
+	other.Update(500)
+	h.Merge(other)
+	if counts := h.BucketCounts(); 1 != counts[0] || 2 != counts[1] {
+		t.Errorf("h.BucketCounts(): %v", counts)
+	}
+	plain := NewHistogram(NewUniformSample(100))
+	plain.Update(7)
+	h.Merge(plain)
+	if counts := h.BucketCounts(); 2 != counts[0] || 3 != counts[1] {
+		t.Errorf("h.BucketCounts(): %v", counts)
+	}
+}
+
+func TestTopNHistogramMerge(t *testing.T) {
+	h := NewTopNHistogram(NewUniformSample(100), 2)
+	other := NewTopNHistogram(NewUniformSample(100), 2)
+	h.Update(3)
+	other.Update(7)
+	other.Update(5)
+	h.Merge(other)
+	if top := h.TopN(); 2 != len(top) || 7 != top[0] || 5 != top[1] {
+		t.Errorf("h.TopN(): %v", top)
+	}
+}
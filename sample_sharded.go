@@ -0,0 +1,236 @@
+package metrics
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// ShardedExpDecaySample is an exponentially-decaying sample like
+// ExpDecaySample for samples updated by many goroutines at once, such as
+// those of a busy service's timers.  Rather than one reservoir whose mutex,
+// and the global random source, every update contends for, it keeps one per
+// processor, rounded up to a power of two, each with a random source of its
+// own.  Since every shard keeps the values of highest priority of its own,
+// their union holds those of highest priority overall, which are the values
+// of the sample, so it's statistically the same as an ExpDecaySample.
+// Updates are cheaper under contention, and reads dearer, as are memory,
+// which is that of an ExpDecaySample for each shard.
+type ShardedExpDecaySample struct {
+	alpha         float64
+	reservoirSize int
+	shift         uint // 64 less the number of bits indexing shards
+	shards        []expDecayShard
+}
+
+// expDecayShard is the reservoir of a shard of a ShardedExpDecaySample.
+type expDecayShard struct {
+	count  int64 // First to keep it 64-bit aligned for atomic operations
+	mutex  sync.Mutex
+	rand   *rand.Rand
+	t0, t1 time.Time
+	values *expDecaySampleHeap
+	_      [64]byte // Keep shards' mutexes off each other's cache lines
+}
+
+// NewShardedExpDecaySample constructs a new sharded exponentially-decaying
+// sample with the given reservoir size and alpha, and a shard for each
+// processor.
+func NewShardedExpDecaySample(reservoirSize int, alpha float64) Sample {
+	if UseNilMetrics {
+		return NilSample{}
+	}
+	n, shift := 1, uint(64)
+	for n < runtime.GOMAXPROCS(0) {
+		n <<= 1
+		shift--
+	}
+	s := &ShardedExpDecaySample{
+		alpha:         alpha,
+		reservoirSize: reservoirSize,
+		shift:         shift,
+		shards:        make([]expDecayShard, n),
+	}
+	now := time.Now()
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.rand = rand.New(rand.NewSource(rand.Int63()))
+		shard.t0, shard.t1 = now, now.Add(rescaleThreshold)
+		shard.values = newExpDecaySampleHeap(reservoirSize)
+	}
+	return s
+}
+
+// Clear clears all samples.
+func (s *ShardedExpDecaySample) Clear() {
+	now := time.Now()
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mutex.Lock()
+		atomic.StoreInt64(&shard.count, 0)
+		shard.t0, shard.t1 = now, now.Add(rescaleThreshold)
+		shard.values.Clear()
+		shard.mutex.Unlock()
+	}
+}
+
+// Count returns the number of samples recorded, which may exceed the
+// reservoir size.
+func (s *ShardedExpDecaySample) Count() int64 {
+	var count int64
+	for i := range s.shards {
+		count += atomic.LoadInt64(&s.shards[i].count)
+	}
+	return count
+}
+
+// Max returns the maximum value in the sample, which may not be the maximum
+// value ever to be part of the sample.
+func (s *ShardedExpDecaySample) Max() int64 {
+	return SampleMax(s.Values())
+}
+
+// Mean returns the mean of the values in the sample.
+func (s *ShardedExpDecaySample) Mean() float64 {
+	return SampleMean(s.Values())
+}
+
+// Min returns the minimum value in the sample, which may not be the minimum
+// value ever to be part of the sample.
+func (s *ShardedExpDecaySample) Min() int64 {
+	return SampleMin(s.Values())
+}
+
+// Percentile returns an arbitrary percentile of values in the sample.
+func (s *ShardedExpDecaySample) Percentile(p float64) float64 {
+	return SamplePercentile(s.Values(), p)
+}
+
+// Percentiles returns a slice of arbitrary percentiles of values in the
+// sample.
+func (s *ShardedExpDecaySample) Percentiles(ps []float64) []float64 {
+	return SamplePercentiles(s.Values(), ps)
+}
+
+// Size returns the size of the sample, which is at most the reservoir size.
+func (s *ShardedExpDecaySample) Size() int {
+	return len(s.Values())
+}
+
+// Snapshot returns a read-only copy of the sample.  Each shard is locked
+// only while its reservoir is copied; the copies are merged afterwards.
+func (s *ShardedExpDecaySample) Snapshot() Sample {
+	values, count := s.collect()
+	return &SampleSnapshot{count: count, values: values}
+}
+
+// StdDev returns the standard deviation of the values in the sample.
+func (s *ShardedExpDecaySample) StdDev() float64 {
+	return SampleStdDev(s.Values())
+}
+
+// Sum returns the sum of the values in the sample.
+func (s *ShardedExpDecaySample) Sum() int64 {
+	return SampleSum(s.Values())
+}
+
+// Update samples a new value.
+func (s *ShardedExpDecaySample) Update(v int64) {
+	s.update(s.shard(), time.Now(), v)
+}
+
+// Values returns a copy of the values in the sample.
+func (s *ShardedExpDecaySample) Values() []int64 {
+	values, _ := s.collect()
+	return values
+}
+
+// Variance returns the variance of the values in the sample.
+func (s *ShardedExpDecaySample) Variance() float64 {
+	return SampleVariance(s.Values())
+}
+
+// collect copies the reservoir of every shard and returns the values of
+// highest priority among them, weighting each from the latest landmark of
+// any shard, along with the count.
+func (s *ShardedExpDecaySample) collect() ([]int64, int64) {
+	type shardCopy struct {
+		t0     time.Time
+		values []expDecaySample
+	}
+	copies := make([]shardCopy, len(s.shards))
+	var (
+		count  int64
+		latest time.Time
+	)
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mutex.Lock()
+		copies[i].t0 = shard.t0
+		copies[i].values = append([]expDecaySample(nil), shard.values.Values()...)
+		count += atomic.LoadInt64(&shard.count)
+		shard.mutex.Unlock()
+		if copies[i].t0.After(latest) {
+			latest = copies[i].t0
+		}
+	}
+	top := newExpDecaySampleHeap(s.reservoirSize)
+	for _, c := range copies {
+		scale := math.Exp(-s.alpha * latest.Sub(c.t0).Seconds())
+		for _, v := range c.values {
+			v.k *= scale
+			if top.Size() < s.reservoirSize {
+				top.Push(v)
+			} else if 0 < s.reservoirSize && top.Values()[0].k < v.k {
+				top.Pop()
+				top.Push(v)
+			}
+		}
+	}
+	values := make([]int64, top.Size())
+	for i, v := range top.Values() {
+		values[i] = v.v
+	}
+	return values, count
+}
+
+// shard returns a shard chosen by hashing the address of the calling
+// goroutine's stack, as ShardedCounter does.
+func (s *ShardedExpDecaySample) shard() *expDecayShard {
+	if 64 == s.shift {
+		return &s.shards[0]
+	}
+	var local byte
+	h := uint64(uintptr(unsafe.Pointer(&local))) * 0x9e3779b97f4a7c15 // Fibonacci hashing
+	return &s.shards[h>>s.shift]
+}
+
+// update samples a new value at a particular timestamp in the given shard,
+// as ExpDecaySample.update does.
+func (s *ShardedExpDecaySample) update(shard *expDecayShard, t time.Time, v int64) {
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	atomic.AddInt64(&shard.count, 1)
+	if shard.values.Size() == s.reservoirSize {
+		shard.values.Pop()
+	}
+	shard.values.Push(expDecaySample{
+		k: math.Exp(t.Sub(shard.t0).Seconds()*s.alpha) / shard.rand.Float64(),
+		v: v,
+	})
+	if t.After(shard.t1) {
+		values := shard.values.Values()
+		t0 := shard.t0
+		shard.values.Clear()
+		shard.t0 = t
+		shard.t1 = shard.t0.Add(rescaleThreshold)
+		for _, v := range values {
+			v.k = v.k * math.Exp(-s.alpha*shard.t0.Sub(t0).Seconds())
+			shard.values.Push(v)
+		}
+	}
+}
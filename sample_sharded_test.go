@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// BenchmarkExpDecaySampleParallel and BenchmarkShardedExpDecaySampleParallel
+// compare samples updated by 32 goroutines per processor at once, logging
+// the median and 99th percentile latency of an update.
+func BenchmarkExpDecaySampleParallel(b *testing.B) {
+	benchmarkSampleParallel(b, NewExpDecaySample(1028, 0.015))
+}
+
+func BenchmarkShardedExpDecaySampleParallel(b *testing.B) {
+	benchmarkSampleParallel(b, NewShardedExpDecaySample(1028, 0.015))
+}
+
+func benchmarkSampleParallel(b *testing.B, s Sample) {
+	var (
+		mutex     sync.Mutex
+		latencies []int64
+	)
+	b.SetParallelism(32)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var local []int64
+		for pb.Next() {
+			start := time.Now()
+			s.Update(1)
+			local = append(local, int64(time.Since(start)))
+		}
+		mutex.Lock()
+		latencies = append(latencies, local...)
+		mutex.Unlock()
+	})
+	b.StopTimer()
+	ps := SamplePercentiles(latencies, []float64{0.5, 0.99})
+	b.Logf("p50: %v, p99: %v", time.Duration(ps[0]), time.Duration(ps[1]))
+}
+
+func TestShardedExpDecaySample(t *testing.T) {
+	s := NewShardedExpDecaySample(100, 0.99)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 1; j <= 1000; j++ {
+				s.Update(int64(j))
+			}
+		}()
+	}
+	wg.Wait()
+	if count := s.Count(); 8000 != count {
+		t.Errorf("s.Count(): 8000 != %v\n", count)
+	}
+	if size := s.Size(); 100 != size {
+		t.Errorf("s.Size(): 100 != %v\n", size)
+	}
+	for _, v := range s.Values() {
+		if v < 1 || 1000 < v {
+			t.Fatalf("out of range [1, 1000]: %v\n", v)
+		}
+	}
+	snapshot := s.Snapshot()
+	s.Clear()
+	if count, size := snapshot.Count(), snapshot.Size(); 8000 != count || 100 != size {
+		t.Errorf("snapshot.Count(), snapshot.Size(): %v, %v", count, size)
+	}
+	if count, size := s.Count(), s.Size(); 0 != count || 0 != size {
+		t.Errorf("s.Count(), s.Size(): %v, %v", count, size)
+	}
+}
+
+// TestShardedExpDecaySampleStatistics checks the sharded sample against an
+// ExpDecaySample of the same values, within a tolerance since both are
+// random.
+func TestShardedExpDecaySampleStatistics(t *testing.T) {
+	s, sharded := NewExpDecaySample(1000, 0.015), NewShardedExpDecaySample(1000, 0.015)
+	for i := 1; i <= 100000; i++ {
+		s.Update(int64(i))
+		sharded.Update(int64(i))
+	}
+	if count := sharded.Count(); 100000 != count {
+		t.Errorf("sharded.Count(): 100000 != %v\n", count)
+	}
+	if size := sharded.Size(); 1000 != size {
+		t.Errorf("sharded.Size(): 1000 != %v\n", size)
+	}
+	if mean, want := sharded.Mean(), s.Mean(); 0.05 < math.Abs(mean-want)/want {
+		t.Errorf("sharded.Mean(): %v, not within 5%% of %v\n", mean, want)
+	}
+	ps := s.Percentiles([]float64{0.5, 0.99})
+	for i, p := range sharded.Percentiles([]float64{0.5, 0.99}) {
+		if 0.05 < math.Abs(p-ps[i])/ps[i] {
+			t.Errorf("percentile %d: %v, not within 5%% of %v\n", i, p, ps[i])
+		}
+	}
+}
+
+// TestShardedExpDecaySampleRescale checks that the values of shards which
+// have rescaled are weighted against those of shards which haven't.
+func TestShardedExpDecaySampleRescale(t *testing.T) {
+	s := NewShardedExpDecaySample(100, 0.015).(*ShardedExpDecaySample)
+	first, last := &s.shards[0], &s.shards[len(s.shards)-1]
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		s.update(first, now, 10)
+	}
+	for i := 0; i < 100; i++ {
+		s.update(last, now.Add(2*rescaleThreshold), 20)
+	}
+	if !last.t0.After(now) {
+		t.Fatal("last shard didn't rescale")
+	}
+	for _, v := range s.Values() {
+		if 20 != v {
+			t.Fatalf("old value in sample: %v\n", v)
+		}
+	}
+}
+
+func TestShardedExpDecaySampleNil(t *testing.T) {
+	UseNilMetrics = true
+	defer func() { UseNilMetrics = false }()
+	if _, ok := NewShardedExpDecaySample(100, 0.015).(NilSample); !ok {
+		t.Error("NewShardedExpDecaySample didn't return a NilSample")
+	}
+}
@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SlidingTimeWindowSample is a Sample of every value recorded within a
+// sliding window of time, such as the last minute, so that percentiles
+// reflect only recent behaviour rather than a decayed history which can lag
+// behind a latency spike.  Every value in the window is retained, so memory
+// grows with the rate of updates.
+type SlidingTimeWindowSample struct {
+	count  int64
+	mutex  sync.Mutex
+	now    func() time.Time
+	times  []time.Time
+	values []int64
+	window time.Duration
+}
+
+// NewSlidingTimeWindowSample constructs a new sample of the values recorded
+// within the last window.
+func NewSlidingTimeWindowSample(window time.Duration) Sample {
+	return NewSlidingTimeWindowSampleWithClock(window, systemClock{})
+}
+
+// NewSlidingTimeWindowSampleWithClock constructs a new sample like
+// NewSlidingTimeWindowSample, whose window is measured by c.
+func NewSlidingTimeWindowSampleWithClock(window time.Duration, c Clock) Sample {
+	if UseNilMetrics {
+		return NilSample{}
+	}
+	return &SlidingTimeWindowSample{now: c.Now, window: window}
+}
+
+// Clear clears all samples.
+func (s *SlidingTimeWindowSample) Clear() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.count = 0
+	s.times = nil
+	s.values = nil
+}
+
+// Count returns the number of samples recorded, which may exceed the number
+// within the window.
+func (s *SlidingTimeWindowSample) Count() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.count
+}
+
+// Max returns the maximum value within the window.
+func (s *SlidingTimeWindowSample) Max() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return SampleMax(s.trim())
+}
+
+// Mean returns the mean of the values within the window.
+func (s *SlidingTimeWindowSample) Mean() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return SampleMean(s.trim())
+}
+
+// Min returns the minimum value within the window.
+func (s *SlidingTimeWindowSample) Min() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return SampleMin(s.trim())
+}
+
+// Percentile returns an arbitrary percentile of values within the window.
+func (s *SlidingTimeWindowSample) Percentile(p float64) float64 {
+	return s.Percentiles([]float64{p})[0]
+}
+
+// Percentiles returns a slice of arbitrary percentiles of values within the
+// window.
+func (s *SlidingTimeWindowSample) Percentiles(ps []float64) []float64 {
+	return SamplePercentiles(s.Values(), ps)
+}
+
+// Size returns the number of values within the window.
+func (s *SlidingTimeWindowSample) Size() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.trim())
+}
+
+// Snapshot returns a read-only copy of the sample.
+func (s *SlidingTimeWindowSample) Snapshot() Sample {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	values := s.trim()
+	copied := make([]int64, len(values))
+	copy(copied, values)
+	return &SampleSnapshot{
+		count:  s.count,
+		values: copied,
+	}
+}
+
+// StdDev returns the standard deviation of the values within the window.
+func (s *SlidingTimeWindowSample) StdDev() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return SampleStdDev(s.trim())
+}
+
+// Sum returns the sum of the values within the window.
+func (s *SlidingTimeWindowSample) Sum() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return SampleSum(s.trim())
+}
+
+// Update samples a new value.
+func (s *SlidingTimeWindowSample) Update(v int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.count++
+	s.times = append(s.times, s.now())
+	s.values = append(s.values, v)
+	s.trim()
+}
+
+// Values returns a copy of the values within the window, oldest first.
+func (s *SlidingTimeWindowSample) Values() []int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	values := s.trim()
+	copied := make([]int64, len(values))
+	copy(copied, values)
+	return copied
+}
+
+// Variance returns the variance of the values within the window.
+func (s *SlidingTimeWindowSample) Variance() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return SampleVariance(s.trim())
+}
+
+// trim drops the values recorded before the window and returns those within
+// it.  It must be called with the mutex held.
+func (s *SlidingTimeWindowSample) trim() []int64 {
+	cutoff := s.now().Add(-s.window)
+	i := sort.Search(len(s.times), func(i int) bool { return s.times[i].After(cutoff) })
+	s.times = s.times[i:]
+	s.values = s.values[i:]
+	return s.values
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func BenchmarkSlidingTimeWindowSample(b *testing.B) {
+	benchmarkSample(b, NewSlidingTimeWindowSample(time.Second))
+}
+
+func TestSlidingTimeWindowSample(t *testing.T) {
+	now := time.Unix(1433160000, 0)
+	s := NewSlidingTimeWindowSample(time.Minute).(*SlidingTimeWindowSample)
+	s.now = func() time.Time { return now }
+	for i := 1; i <= 100; i++ {
+		s.Update(int64(i) * 1000)
+	}
+	now = now.Add(30 * time.Second)
+	for i := 1; i <= 10; i++ {
+		s.Update(int64(i))
+	}
+	if 110 != s.Count() || 110 != s.Size() || 100000 != s.Max() {
+		t.Errorf("before the spike slides out: %v %v %v", s.Count(), s.Size(), s.Max())
+	}
+
+	now = now.Add(30 * time.Second)
+	if 110 != s.Count() {
+		t.Errorf("s.Count(): 110 != %v", s.Count())
+	}
+	if 10 != s.Size() {
+		t.Errorf("s.Size(): 10 != %v", s.Size())
+	}
+	if 1 != s.Min() || 10 != s.Max() || 55 != s.Sum() || 5.5 != s.Mean() {
+		t.Errorf("%v %v %v %v", s.Min(), s.Max(), s.Sum(), s.Mean())
+	}
+	if p := s.Percentile(0.5); 5.5 != p {
+		t.Errorf("median: 5.5 != %v", p)
+	}
+
+	snapshot := s.Snapshot()
+	now = now.Add(time.Hour)
+	if 0 != s.Size() || 0 != len(s.Values()) || 0 != s.Max() {
+		t.Errorf("after the window: %v", s.Values())
+	}
+	if 10 != snapshot.Size() || 110 != snapshot.Count() {
+		t.Errorf("snapshot: %v %v", snapshot.Size(), snapshot.Count())
+	}
+
+	s.Update(47)
+	s.Clear()
+	if 0 != s.Count() || 0 != s.Size() {
+		t.Errorf("not cleared: %v %v", s.Count(), s.Size())
+	}
+}
+
+func TestSlidingTimeWindowSampleHistogram(t *testing.T) {
+	h := NewHistogram(NewSlidingTimeWindowSample(time.Minute))
+	h.Update(3)
+	h.Update(5)
+	if snapshot := h.Snapshot(); 2 != snapshot.Count() || 4 != snapshot.Mean() {
+		t.Errorf("%v %v", snapshot.Count(), snapshot.Mean())
+	}
+}
+
+func TestSlidingTimeWindowSampleWithClock(t *testing.T) {
+	clk := NewMockClock(time.Unix(1433160000, 0))
+	s := NewSlidingTimeWindowSampleWithClock(time.Minute, clk)
+	s.Update(47)
+	clk.Add(30 * time.Second)
+	if 1 != s.Size() {
+		t.Errorf("s.Size(): 1 != %v", s.Size())
+	}
+	clk.Add(30 * time.Second)
+	if 0 != s.Size() || 1 != s.Count() {
+		t.Errorf("s.Size(), s.Count(): %v, %v", s.Size(), s.Count())
+	}
+}
@@ -0,0 +1,243 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// StatsDConfig provides a container with configuration parameters for
+// the StatsD exporter
+type StatsDConfig struct {
+	Addr          *net.UDPAddr      // Network address of the StatsD or DogStatsD agent
+	Registry      Registry          // Registry to be exported
+	FlushInterval time.Duration     // Flush interval
+	Prefix        string            // Prefix to be prepended to metric names
+	Tags          map[string]string // Tags added to every line in the DogStatsD form
+	Percentiles   []float64         // Percentiles of histograms and timers to send, defaults to 0.5, 0.75, 0.95, 0.99 and 0.999 if nil, none if empty
+	ErrorHandler  func(error)       // Called with each flush error, defaults to log.Println
+	Filter        func(string) bool // Metrics whose names it returns false for aren't exported, all are if nil
+
+	// PlainStatsD omits tags, global and per-metric alike, for servers
+	// which reject the DogStatsD |#k:v form.  Metrics of the same name with
+	// different tags are then sent as lines of the same name.
+	PlainStatsD bool
+}
+
+// StatsD is a blocking exporter function which reports metrics in r to a
+// StatsD agent located at addr, flushing them every d duration and
+// prepending metric names with prefix.
+func StatsD(r Registry, d time.Duration, prefix string, addr *net.UDPAddr) {
+	StatsDWithConfig(StatsDConfig{
+		Addr:          addr,
+		Registry:      r,
+		FlushInterval: d,
+		Prefix:        prefix,
+	})
+}
+
+// StatsDWithConfig is a blocking exporter function which reports the metrics
+// in c.Registry to a StatsD agent every c.FlushInterval.  Counters and
+// meters are sent as counters of their change since the previous flush,
+// gauges as gauges, and timers and histograms as one timing or histogram
+// line per sampled percentile, timers in milliseconds.  Delivery is best
+// effort, as with any UDP.
+func StatsDWithConfig(c StatsDConfig) {
+	if err := StatsDWithConfigContext(context.Background(), c); nil != err {
+		c.handleError(err)
+	}
+}
+
+// StatsDWithConfigContext is like StatsDWithConfig but returns once ctx is
+// done, after a final flush.  Errors from flushes other than the final one
+// are passed to the ErrorHandler.  An error is returned at once if
+// c.FlushInterval isn't positive.
+func StatsDWithConfigContext(ctx context.Context, c StatsDConfig) error {
+	if c.FlushInterval <= 0 {
+		return errors.New("statsd: config has no positive FlushInterval")
+	}
+	e, err := newStatsDExporter(c)
+	if nil != err {
+		return err
+	}
+	defer e.conn.Close()
+	return FlushLoopWithConfig(ctx, FlushLoopConfig{
+		Registry:      c.Registry,
+		FlushInterval: c.FlushInterval,
+		Exporter:      e,
+		ErrorHandler:  c.ErrorHandler,
+	})
+}
+
+// NewStatsDExporter constructs a new Exporter which flushes the registry
+// it's given to a StatsD agent as configured by c, so that it can be run by
+// FlushLoop or be a sink of a FanoutExporter.  c.Registry, which it
+// ignores, needn't be set.
+func NewStatsDExporter(c StatsDConfig) (Exporter, error) {
+	if nil == c.Registry {
+		c.Registry = DefaultRegistry
+	}
+	return newStatsDExporter(c)
+}
+
+// handleError passes err to the ErrorHandler, or logs it if there is none.
+func (c *StatsDConfig) handleError(err error) {
+	if nil != c.ErrorHandler {
+		c.ErrorHandler(err)
+	} else {
+		log.Println(err)
+	}
+}
+
+// statsDExporter holds the state of a StatsD exporter between flushes.
+type statsDExporter struct {
+	config StatsDConfig
+	conn   net.Conn
+	counts map[string]int64 // Counts at the previous flush
+}
+
+func newStatsDExporter(c StatsDConfig) (*statsDExporter, error) {
+	if nil == c.Registry {
+		return nil, errors.New("statsd: config has no Registry to export")
+	}
+	if nil == c.Addr {
+		return nil, errors.New("statsd: config has no Addr")
+	}
+	conn, err := net.DialUDP("udp", nil, c.Addr)
+	if nil != err {
+		return nil, err
+	}
+	return &statsDExporter{config: c, conn: conn, counts: make(map[string]int64)}, nil
+}
+
+// Export flushes r, for FlushLoop.
+func (e *statsDExporter) Export(r Registry) error {
+	return e.ExportContext(context.Background(), r)
+}
+
+// ExportContext flushes r, for FlushLoop.
+func (e *statsDExporter) ExportContext(ctx context.Context, r Registry) error {
+	e.config.Registry = r
+	return e.flush()
+}
+
+// flush sends every metric in the registry, packing as many lines into each
+// datagram as fit.  It returns the first write error, after trying to send
+// every datagram.
+func (e *statsDExporter) flush() error {
+	return writeDatagrams(e.conn, e.lines(), "\n", defaultMaxDatagram)
+}
+
+// lines formats every metric in the registry as StatsD lines.
+func (e *statsDExporter) lines() []string {
+	c := &e.config
+	tagged := make(map[string]map[string]string)
+	exported := make(map[string]string)
+	c.Registry.Each(func(name string, i interface{}) {
+		if t, ok := i.(Tagged); ok {
+			tagged[name] = t.Tags()
+		}
+		exported[name] = exportName(name, i)
+	})
+	percentiles := percentilesOrDefault(c.Percentiles)
+	var lines []string
+	counts := make(map[string]int64)
+	c.Registry.Snapshot().Each(func(key string, i interface{}) {
+		name := key
+		if n, ok := exported[key]; ok {
+			name = n
+		}
+		if nil != c.Filter && !c.Filter(name) {
+			return
+		}
+		md := metadata(c.Registry, key, i)
+		var tags string
+		if !c.PlainStatsD {
+			tags = statsDTags(c.Tags, tagged[key])
+		}
+		if "" != c.Prefix {
+			name = c.Prefix + "." + name
+		}
+		put := func(value float64, typ string) {
+			line := name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + typ
+			if "c" == typ && 0 < md.SampleRate && md.SampleRate < 1 {
+				line += "|@" + strconv.FormatFloat(md.SampleRate, 'f', -1, 64)
+			}
+			lines = append(lines, line+tags)
+		}
+		count := func(n int64) {
+			counts[key] = n
+			if delta := n - e.counts[key]; 0 != delta {
+				put(float64(delta), "c")
+			}
+		}
+		gauge := func(v float64) {
+			// A signed value would adjust the gauge rather than set it.
+			if v < 0 {
+				put(0, "g")
+			}
+			put(v, "g")
+		}
+		switch metric := i.(type) {
+		case Counter:
+			count(metric.Count())
+		case Gauge:
+			gauge(float64(metric.Value()))
+		case GaugeFloat64:
+			gauge(metric.Value())
+		case Histogram:
+			if 0 < metric.Count() {
+				for _, p := range metric.Percentiles(percentiles) {
+					put(p, "h")
+				}
+			}
+		case Meter:
+			count(metric.Count())
+		case Timer:
+			if 0 < metric.Count() {
+				for _, p := range metric.Percentiles(percentiles) {
+					put(p/float64(time.Millisecond), "ms")
+				}
+			}
+		}
+	})
+	e.counts = counts
+	return lines
+}
+
+// statsDTags renders tags, overridden by those of the metric, in the
+// DogStatsD |#k:v,k:v form sorted by key, or the empty string if there are
+// none.
+func statsDTags(tags, metricTags map[string]string) string {
+	merged := make(map[string]string, len(tags)+len(metricTags))
+	for k, v := range tags {
+		merged[k] = v
+	}
+	for k, v := range metricTags {
+		merged[k] = v
+	}
+	if 0 == len(merged) {
+		return ""
+	}
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	b.WriteString("|#")
+	for i, k := range keys {
+		if 0 < i {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte(':')
+		b.WriteString(merged[k])
+	}
+	return b.String()
+}
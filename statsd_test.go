@@ -0,0 +1,235 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ExampleStatsD() {
+	addr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:8125")
+	go StatsD(DefaultRegistry, 1*time.Second, "some.prefix", addr)
+}
+
+func TestStatsD(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if nil != err {
+		t.Skip(err)
+	}
+	defer conn.Close()
+
+	r := NewRegistry()
+	c := GetOrRegisterCounter("c", r)
+	RegisterWithMetadata(r, "sampled", NewCounter(), Metadata{SampleRate: 0.1})
+	GetOrRegisterCounter("sampled", r).Inc(2)
+	GetOrRegisterGauge("g", r).Update(-3)
+	tm := GetOrRegisterTimer("t", r)
+	tm.Update(2 * time.Millisecond)
+	r.Register("login", timerWithTags{NewTimer(), map[string]string{"endpoint": "/login"}})
+	e, err := newStatsDExporter(StatsDConfig{
+		Addr:     conn.LocalAddr().(*net.UDPAddr),
+		Registry: r,
+		Prefix:   "p",
+		Tags:     map[string]string{"env": "prod"},
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer e.conn.Close()
+
+	c.Inc(40)
+	if err := e.flush(); nil != err {
+		t.Fatal(err)
+	}
+	buf := make([]byte, defaultMaxDatagram)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if nil != err {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(buf[:n]), "\n")
+	expected := []string{
+		"p.c:40|c|#env:prod",
+		"p.g:0|g|#env:prod",
+		"p.g:-3|g|#env:prod",
+		"p.sampled:2|c|@0.1|#env:prod",
+		"p.t:2|ms|#env:prod",
+		"p.t:2|ms|#env:prod",
+		"p.t:2|ms|#env:prod",
+		"p.t:2|ms|#env:prod",
+		"p.t:2|ms|#env:prod",
+	}
+	var got []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "p.login") {
+			t.Errorf("empty timer was sent: %s", line)
+		}
+		got = append(got, line)
+	}
+	if len(expected) != len(got) {
+		t.Fatalf("%q", got)
+	}
+	for _, line := range expected {
+		found := false
+		for i, g := range got {
+			if g == line {
+				got = append(got[:i], got[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("missing %q", line)
+		}
+	}
+
+	c.Inc(7)
+	for _, line := range e.lines() {
+		if strings.HasPrefix(line, "p.c:") && "p.c:7|c|#env:prod" != line {
+			t.Errorf("second flush: %s", line)
+		}
+	}
+}
+
+func TestStatsDTags(t *testing.T) {
+	if s := statsDTags(nil, nil); "" != s {
+		t.Errorf("%q", s)
+	}
+	s := statsDTags(map[string]string{"b": "1", "a": "2"}, map[string]string{"b": "3"})
+	if "|#a:2,b:3" != s {
+		t.Errorf("%q", s)
+	}
+}
+
+func TestStatsDPackets(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if nil != err {
+		t.Skip(err)
+	}
+	defer conn.Close()
+	r := NewRegistry()
+	for i := 0; i < 100; i++ {
+		GetOrRegisterGauge(strings.Repeat("x", 30)+string(rune('a'+i%26))+string(rune('a'+i/26)), r).Update(1)
+	}
+	e, _ := newStatsDExporter(StatsDConfig{Addr: conn.LocalAddr().(*net.UDPAddr), Registry: r})
+	defer e.conn.Close()
+	if err := e.flush(); nil != err {
+		t.Fatal(err)
+	}
+	var lines []string
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	for {
+		n, err := conn.Read(buf)
+		if nil != err {
+			break
+		}
+		if defaultMaxDatagram < n {
+			t.Errorf("packet of %d bytes", n)
+		}
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+	if 100 != len(lines) {
+		t.Errorf("lines: %v", len(lines))
+	}
+}
+
+func TestStatsDTaggedRegistry(t *testing.T) {
+	r := NewRegistry()
+	login := GetOrRegisterTaggedCounter("requests", map[string]string{"endpoint": "/login"}, r)
+	logout := GetOrRegisterTaggedCounter("requests", map[string]string{"endpoint": "/logout"}, r)
+	e := &statsDExporter{config: StatsDConfig{Registry: r}, counts: make(map[string]int64)}
+	login.Inc(1)
+	logout.Inc(2)
+	e.lines()
+	login.Inc(3)
+	logout.Inc(5)
+	lines := e.lines()
+	sort.Strings(lines)
+	want := []string{"requests:3|c|#endpoint:/login", "requests:5|c|#endpoint:/logout"}
+	if !reflect.DeepEqual(want, lines) {
+		t.Errorf("%q != %q", want, lines)
+	}
+}
+
+func TestStatsDWithConfigContext(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if nil != err {
+		t.Skip(err)
+	}
+	defer conn.Close()
+	r := NewRegistry()
+	GetOrRegisterCounter("c", r).Inc(47)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = StatsDWithConfigContext(ctx, StatsDConfig{
+		Addr:          conn.LocalAddr().(*net.UDPAddr),
+		Registry:      r,
+		FlushInterval: time.Hour,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	buf := make([]byte, defaultMaxDatagram)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if "c:47|c" != string(buf[:n]) {
+		t.Errorf("final flush: %q", buf[:n])
+	}
+	for _, d := range []time.Duration{0, -time.Second} {
+		if err := StatsDWithConfigContext(ctx, StatsDConfig{Addr: conn.LocalAddr().(*net.UDPAddr), Registry: r, FlushInterval: d}); nil == err {
+			t.Errorf("no error for FlushInterval %v", d)
+		}
+	}
+}
+
+func TestStatsDPlain(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterTaggedGauge("g", map[string]string{"k": "v"}, r).Update(47)
+	e := &statsDExporter{config: StatsDConfig{
+		Registry:    r,
+		Tags:        map[string]string{"env": "prod"},
+		PlainStatsD: true,
+	}, counts: make(map[string]int64)}
+	if lines := e.lines(); !reflect.DeepEqual([]string{"g:47|g"}, lines) {
+		t.Errorf("%q", lines)
+	}
+}
+
+func TestStatsDPercentiles(t *testing.T) {
+	r := NewRegistry()
+	h := GetOrRegisterHistogram("h", r, NewUniformSample(10))
+	h.Update(3)
+	h.Update(5)
+	e := &statsDExporter{config: StatsDConfig{
+		Registry:    r,
+		Percentiles: []float64{0.99},
+	}, counts: make(map[string]int64)}
+	if lines := e.lines(); !reflect.DeepEqual([]string{"h:5|h"}, lines) {
+		t.Errorf("%q", lines)
+	}
+	e.config.Percentiles = []float64{}
+	if lines := e.lines(); 0 != len(lines) {
+		t.Errorf("%q", lines)
+	}
+}
+
+func TestStatsDFilter(t *testing.T) {
+	r := NewRegistry()
+	GetOrRegisterGauge("keep", r).Update(1)
+	GetOrRegisterCounter("drop", r).Inc(2)
+	e := &statsDExporter{config: StatsDConfig{
+		Registry: r,
+		Filter:   func(name string) bool { return "drop" != name },
+	}, counts: make(map[string]int64)}
+	if lines := e.lines(); !reflect.DeepEqual([]string{"keep:1|g"}, lines) {
+		t.Errorf("%q", lines)
+	}
+}
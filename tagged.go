@@ -0,0 +1,329 @@
+package metrics
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TaggedName returns the name under which a metric of the given name and tags
+// is registered by a TaggedRegistry, such as requests{endpoint=/login}, so
+// that metrics of the same name with different tags are distinct.  Without
+// tags it is the name itself.
+func TaggedName(name string, tags map[string]string) string {
+	if 0 == len(tags) {
+		return name
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + tags[k]
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// TaggedRegistry is a Registry which tags every metric registered through it.
+// Metrics are registered in the underlying registry under their TaggedName
+// and implement Tagged, so that exporters report them by their own name with
+// their tags.
+type TaggedRegistry struct {
+	underlying Registry
+	tags       map[string]string
+}
+
+// NewTaggedRegistry returns a Registry which tags the metrics it registers in
+// parent with tags.  The tags of a TaggedRegistry parent are merged with them,
+// those given winning, and the prefix of a PrefixedRegistry parent is kept in
+// the names under which its metrics are exported, as by NewChildRegistry.
+func NewTaggedRegistry(parent Registry, tags map[string]string) Registry {
+	if nil == parent {
+		parent = DefaultRegistry
+	}
+	if _, ok := parent.(*PrefixedRegistry); ok {
+		return NewChildRegistry(parent, "", tags)
+	}
+	merged := make(map[string]string, len(tags))
+	if p, ok := parent.(*TaggedRegistry); ok {
+		parent = p.underlying
+		for k, v := range p.tags {
+			merged[k] = v
+		}
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return &TaggedRegistry{underlying: parent, tags: merged}
+}
+
+// Child returns a registry whose metrics carry the given prefix and this
+// one's tags merged with the given ones.  See NewChildRegistry.
+func (r *TaggedRegistry) Child(prefix string, tags map[string]string) Registry {
+	return NewChildRegistry(r, prefix, tags)
+}
+
+// Call the given function for each registered metric.
+func (r *TaggedRegistry) Each(fn func(string, interface{})) {
+	r.underlying.Each(fn)
+}
+
+// Get the metric by the given name and the registry's tags or nil if none is
+// registered.
+func (r *TaggedRegistry) Get(name string) interface{} {
+	return r.underlying.Get(TaggedName(name, r.tags))
+}
+
+// Gets an existing metric or registers the given one, tagged.
+// The interface can be the metric to register if not found in registry,
+// or a function returning the metric for lazy instantiation.
+func (r *TaggedRegistry) GetOrRegister(name string, metric interface{}) interface{} {
+	if v := reflect.ValueOf(metric); v.Kind() == reflect.Func {
+		return getOrRegisterTyped(TaggedName(name, r.tags), r.underlying, v.Type().Out(0), func() interface{} {
+			return tag(name, v.Call(nil)[0].Interface(), r.tags)
+		})
+	}
+	return r.underlying.GetOrRegister(TaggedName(name, r.tags), tag(name, metric, r.tags))
+}
+
+// Gets an existing metric with the registry's tags or registers the one
+// returned by the given function, tagged.
+func (r *TaggedRegistry) GetOrRegisterLazy(name string, f func() interface{}) interface{} {
+	return GetOrRegisterLazy(TaggedName(name, r.tags), r.underlying, func() interface{} {
+		return tag(name, f(), r.tags)
+	})
+}
+
+func (r *TaggedRegistry) getOrRegisterTyped(name string, t reflect.Type, f func() interface{}) interface{} {
+	return getOrRegisterTyped(TaggedName(name, r.tags), r.underlying, t, func() interface{} {
+		return tag(name, f(), r.tags)
+	})
+}
+
+// Get the metadata attached to the metric by the given name and the
+// registry's tags.
+func (r *TaggedRegistry) Metadata(name string) (Metadata, bool) {
+	if mr, ok := r.underlying.(MetadataRegistry); ok {
+		return mr.Metadata(TaggedName(name, r.tags))
+	}
+	return Metadata{}, false
+}
+
+// Register the given metric under the given name, tagged.
+func (r *TaggedRegistry) Register(name string, metric interface{}) error {
+	return r.underlying.Register(TaggedName(name, r.tags), tag(name, metric, r.tags))
+}
+
+// Register all the given metrics at once, tagged.
+func (r *TaggedRegistry) RegisterBatch(metrics map[string]interface{}) error {
+	tagged := make(map[string]interface{}, len(metrics))
+	for name, metric := range metrics {
+		tagged[TaggedName(name, r.tags)] = tag(name, metric, r.tags)
+	}
+	return r.underlying.RegisterBatch(tagged)
+}
+
+// Run all registered healthchecks.
+func (r *TaggedRegistry) RunHealthchecks() {
+	r.underlying.RunHealthchecks()
+}
+
+// Attach metadata to the metric by the given name and the registry's tags.
+func (r *TaggedRegistry) SetMetadata(name string, md Metadata) {
+	if mr, ok := r.underlying.(MetadataRegistry); ok {
+		mr.SetMetadata(TaggedName(name, r.tags), md)
+	}
+}
+
+// Snapshot every metric in the underlying registry.
+func (r *TaggedRegistry) Snapshot() Registry {
+	return r.underlying.Snapshot()
+}
+
+// Unregister the metric with the given name and the registry's tags.
+func (r *TaggedRegistry) Unregister(name string) {
+	r.underlying.Unregister(TaggedName(name, r.tags))
+}
+
+// Unregister all metrics.  (Mostly for testing.)
+func (r *TaggedRegistry) UnregisterAll() {
+	r.underlying.UnregisterAll()
+}
+
+// GetOrRegisterTaggedCounter returns an existing Counter or constructs and
+// registers a new StandardCounter with the given tags.
+func GetOrRegisterTaggedCounter(name string, tags map[string]string, r Registry) Counter {
+	return GetOrRegisterCounter(name, NewTaggedRegistry(r, tags))
+}
+
+// GetOrRegisterTaggedGauge returns an existing Gauge or constructs and
+// registers a new StandardGauge with the given tags.
+func GetOrRegisterTaggedGauge(name string, tags map[string]string, r Registry) Gauge {
+	return GetOrRegisterGauge(name, NewTaggedRegistry(r, tags))
+}
+
+// GetOrRegisterTaggedGaugeFloat64 returns an existing GaugeFloat64 or
+// constructs and registers a new StandardGaugeFloat64 with the given tags.
+func GetOrRegisterTaggedGaugeFloat64(name string, tags map[string]string, r Registry) GaugeFloat64 {
+	return GetOrRegisterGaugeFloat64(name, NewTaggedRegistry(r, tags))
+}
+
+// GetOrRegisterTaggedHistogram returns an existing Histogram or constructs
+// and registers a new StandardHistogram with the given tags.
+func GetOrRegisterTaggedHistogram(name string, tags map[string]string, r Registry, s Sample) Histogram {
+	return GetOrRegisterHistogram(name, NewTaggedRegistry(r, tags), s)
+}
+
+// GetOrRegisterTaggedBucketedHistogram returns an existing BucketedHistogram
+// or constructs and registers a new StandardBucketedHistogram with the given
+// tags.
+func GetOrRegisterTaggedBucketedHistogram(name string, tags map[string]string, r Registry, s Sample, bounds []int64) BucketedHistogram {
+	return GetOrRegisterBucketedHistogram(name, NewTaggedRegistry(r, tags), s, bounds)
+}
+
+// GetOrRegisterTaggedMeter returns an existing Meter or constructs and
+// registers a new StandardMeter with the given tags.
+func GetOrRegisterTaggedMeter(name string, tags map[string]string, r Registry) Meter {
+	return GetOrRegisterMeter(name, NewTaggedRegistry(r, tags))
+}
+
+// GetOrRegisterTaggedTimer returns an existing Timer or constructs and
+// registers a new StandardTimer with the given tags.
+func GetOrRegisterTaggedTimer(name string, tags map[string]string, r Registry) Timer {
+	return GetOrRegisterTimer(name, NewTaggedRegistry(r, tags))
+}
+
+// GetOrRegisterTaggedTopK returns an existing TopK or constructs and
+// registers a new StandardTopK with the given tags.
+func GetOrRegisterTaggedTopK(name string, tags map[string]string, r Registry, k int, window time.Duration) TopK {
+	return GetOrRegisterTopK(name, NewTaggedRegistry(r, tags), k, window)
+}
+
+// taggedMetric holds the name and tags of a metric registered by a
+// TaggedRegistry.
+type taggedMetric struct {
+	name string
+	tags map[string]string
+}
+
+// Tags returns the metric's tags.
+func (m taggedMetric) Tags() map[string]string { return m.tags }
+
+func (m taggedMetric) exportName() string { return m.name }
+
+type taggedCounter struct {
+	Counter
+	taggedMetric
+}
+
+// CountAndClear returns the count and resets it as one operation if the
+// underlying Counter can.
+func (c *taggedCounter) CountAndClear() int64 { return countAndClear(c.Counter) }
+
+type taggedGauge struct {
+	Gauge
+	taggedMetric
+}
+
+type taggedGaugeFloat64 struct {
+	GaugeFloat64
+	taggedMetric
+}
+
+type taggedHealthcheck struct {
+	Healthcheck
+	taggedMetric
+}
+
+type taggedHistogram struct {
+	Histogram
+	taggedMetric
+}
+
+// SnapshotAndClear returns a copy of the histogram and clears it as one
+// operation if the underlying Histogram can.
+func (h *taggedHistogram) SnapshotAndClear() Histogram { return snapshotAndClear(h.Histogram) }
+
+type taggedBucketedHistogram struct {
+	BucketedHistogram
+	taggedMetric
+}
+
+// SnapshotAndClear returns a copy of the histogram and clears it as one
+// operation if the underlying BucketedHistogram can.
+func (h *taggedBucketedHistogram) SnapshotAndClear() Histogram {
+	return snapshotAndClear(h.BucketedHistogram)
+}
+
+type taggedTopNHistogram struct {
+	TopNHistogram
+	taggedMetric
+}
+
+// SnapshotAndClear returns a copy of the histogram and clears it as one
+// operation if the underlying TopNHistogram can.
+func (h *taggedTopNHistogram) SnapshotAndClear() Histogram { return snapshotAndClear(h.TopNHistogram) }
+
+func (h *taggedTopNHistogram) peek() Histogram { return readSnapshot(h.TopNHistogram) }
+
+type taggedMeter struct {
+	Meter
+	taggedMetric
+}
+
+type taggedTimer struct {
+	Timer
+	taggedMetric
+}
+
+// SnapshotAndClear returns a copy of the timer and clears its sample as one
+// operation if the underlying Timer can.
+func (t *taggedTimer) SnapshotAndClear() Timer { return timerSnapshotAndClear(t.Timer) }
+
+type taggedTopK struct {
+	TopK
+	taggedMetric
+}
+
+// tag wraps i so that it implements Tagged with tags and is exported as name.
+// Metrics of other types are returned as they are.
+func tag(name string, i interface{}, tags map[string]string) interface{} {
+	t := taggedMetric{name: name, tags: tags}
+	switch metric := i.(type) {
+	case Counter:
+		return &taggedCounter{metric, t}
+	case Gauge:
+		return &taggedGauge{metric, t}
+	case GaugeFloat64:
+		return &taggedGaugeFloat64{metric, t}
+	case Healthcheck:
+		return &taggedHealthcheck{metric, t}
+	case BucketedHistogram:
+		return &taggedBucketedHistogram{metric, t}
+	case TopNHistogram:
+		return &taggedTopNHistogram{metric, t}
+	case Histogram:
+		return &taggedHistogram{metric, t}
+	case Meter:
+		return &taggedMeter{metric, t}
+	case Timer:
+		return &taggedTimer{metric, t}
+	case TopK:
+		return &taggedTopK{metric, t}
+	}
+	return i
+}
+
+// exportName returns the name under which the metric i, registered under
+// name, is exported: its own name if it was registered by a TaggedRegistry.
+func exportName(name string, i interface{}) string {
+	if m, ok := i.(interface {
+		exportName() string
+	}); ok {
+		return m.exportName()
+	}
+	return name
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import "testing"
+
+func TestTaggedName(t *testing.T) {
+	if name := TaggedName("requests", nil); "requests" != name {
+		t.Error(name)
+	}
+	if name := TaggedName("requests", map[string]string{"method": "GET", "endpoint": "/login"}); "requests{endpoint=/login,method=GET}" != name {
+		t.Error(name)
+	}
+}
+
+func TestGetOrRegisterTaggedCounter(t *testing.T) {
+	r := NewRegistry()
+	login := GetOrRegisterTaggedCounter("requests", map[string]string{"endpoint": "/login"}, r)
+	logout := GetOrRegisterTaggedCounter("requests", map[string]string{"endpoint": "/logout"}, r)
+	if login == logout {
+		t.Fatal("tag sets share a counter")
+	}
+	login.Inc(47)
+	if c := GetOrRegisterTaggedCounter("requests", map[string]string{"endpoint": "/login"}, r); 47 != c.Count() {
+		t.Error(c.Count())
+	}
+	if c := r.Get("requests{endpoint=/login}"); c != login {
+		t.Error(c)
+	}
+	if tags := login.(Tagged).Tags(); "/login" != tags["endpoint"] {
+		t.Error(tags)
+	}
+	if nil != r.Get("requests") {
+		t.Error("registered without tags")
+	}
+}
+
+func TestTaggedRegistry(t *testing.T) {
+	r := NewRegistry()
+	tr := NewTaggedRegistry(NewTaggedRegistry(r, map[string]string{"dc": "east", "az": "a"}), map[string]string{"az": "b"})
+	tm := GetOrRegisterTimer("latency", tr)
+	if tr.Get("latency") != tm || r.Get("latency{az=b,dc=east}") != tm {
+		t.Fatal("timer wasn't registered under its tagged name")
+	}
+	if err := tr.Register("latency", NewTimer()); nil == err {
+		t.Error("registered twice")
+	}
+	if name := exportName("latency{az=b,dc=east}", tm); "latency" != name {
+		t.Error(name)
+	}
+	tr.Unregister("latency")
+	if nil != r.Get("latency{az=b,dc=east}") {
+		t.Error("timer wasn't unregistered")
+	}
+}
+
+func TestTaggedCounterCountAndClear(t *testing.T) {
+	c := GetOrRegisterTaggedCounter("c", map[string]string{"k": "v"}, NewRegistry())
+	c.Inc(3)
+	if n := countAndClear(c); 3 != n || 0 != c.Count() {
+		t.Error(n, c.Count())
+	}
+}
+
+func TestTaggedTopNHistogram(t *testing.T) {
+	r := NewRegistry()
+	tr := NewTaggedRegistry(r, map[string]string{"route": "/"})
+	h := GetOrRegisterTopNHistogram("latency", tr, NewUniformSample(100), 2)
+	h.Update(3)
+	if again := GetOrRegisterTopNHistogram("latency", NewChildRegistry(r, "", map[string]string{"route": "/"}), NewUniformSample(100), 2); again != h {
+		t.Fatal("tagged TopNHistogram wasn't returned")
+	}
+	if _, ok := r.Get("latency{route=/}").(Tagged); !ok {
+		t.Error("TopNHistogram wasn't tagged")
+	}
+	if top := h.TopN(); 1 != len(top) || 3 != top[0] {
+		t.Errorf("h.TopN(): %v", top)
+	}
+}
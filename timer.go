@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"context"
 	"sync"
 	"time"
 )
@@ -8,6 +9,7 @@ import (
 // Timers capture the duration and rate of events.
 type Timer interface {
 	Count() int64
+	Exemplars() []Exemplar
 	Max() int64
 	Mean() float64
 	Min() int64
@@ -17,12 +19,18 @@ type Timer interface {
 	Rate5() float64
 	Rate15() float64
 	RateMean() float64
+	Record(start, end time.Time)
+	Reset()
+	Sample() Sample
 	Snapshot() Timer
+	Start() func()
 	StdDev() float64
+	Stop()
 	Sum() int64
 	Time(func())
 	Update(time.Duration)
 	UpdateSince(time.Time)
+	UpdateWithExemplar(time.Duration, string)
 	Variance() float64
 }
 
@@ -46,14 +54,10 @@ func NewCustomTimer(h Histogram, m Meter) Timer {
 	}
 }
 
-// NewRegisteredTimer constructs and registers a new StandardTimer.
+// NewRegisteredTimer constructs and registers a new StandardTimer, or returns
+// the Timer already registered under the given name.
 func NewRegisteredTimer(name string, r Registry) Timer {
-	c := NewTimer()
-	if nil == r {
-		r = DefaultRegistry
-	}
-	r.Register(name, c)
-	return c
+	return GetOrRegisterTimer(name, r)
 }
 
 // NewTimer constructs a new StandardTimer using an exponentially-decaying
@@ -68,6 +72,25 @@ func NewTimer() Timer {
 	}
 }
 
+// timerContextKey is the key of the Timer in a context made by NewTimerContext.
+type timerContextKey struct{}
+
+// NewTimerContext returns a copy of ctx carrying t, so that code deep in the
+// handling of a request can record into the timer of its route without it
+// being passed down.
+func NewTimerContext(ctx context.Context, t Timer) context.Context {
+	return context.WithValue(ctx, timerContextKey{}, t)
+}
+
+// TimerFromContext returns the Timer carried by ctx, or a NilTimer if it
+// carries none, so that defer TimerFromContext(ctx).Start()() is always safe.
+func TimerFromContext(ctx context.Context) Timer {
+	if t, ok := ctx.Value(timerContextKey{}).(Timer); ok {
+		return t
+	}
+	return NilTimer{}
+}
+
 // NilTimer is a no-op Timer.
 type NilTimer struct {
 	h Histogram
@@ -77,6 +100,9 @@ type NilTimer struct {
 // Count is a no-op.
 func (NilTimer) Count() int64 { return 0 }
 
+// Exemplars is a no-op.
+func (NilTimer) Exemplars() []Exemplar { return []Exemplar{} }
+
 // Max is a no-op.
 func (NilTimer) Max() int64 { return 0 }
 
@@ -106,12 +132,27 @@ func (NilTimer) Rate15() float64 { return 0.0 }
 // RateMean is a no-op.
 func (NilTimer) RateMean() float64 { return 0.0 }
 
+// Record is a no-op.
+func (NilTimer) Record(start, end time.Time) {}
+
+// Reset is a no-op.
+func (NilTimer) Reset() {}
+
+// Sample is a no-op.
+func (NilTimer) Sample() Sample { return NilSample{} }
+
 // Snapshot is a no-op.
 func (NilTimer) Snapshot() Timer { return NilTimer{} }
 
+// Start is a no-op.
+func (NilTimer) Start() func() { return func() {} }
+
 // StdDev is a no-op.
 func (NilTimer) StdDev() float64 { return 0.0 }
 
+// Stop is a no-op.
+func (NilTimer) Stop() {}
+
 // Sum is a no-op.
 func (NilTimer) Sum() int64 { return 0 }
 
@@ -124,15 +165,32 @@ func (NilTimer) Update(time.Duration) {}
 // UpdateSince is a no-op.
 func (NilTimer) UpdateSince(time.Time) {}
 
+// UpdateWithExemplar is a no-op.
+func (NilTimer) UpdateWithExemplar(time.Duration, string) {}
+
 // Variance is a no-op.
 func (NilTimer) Variance() float64 { return 0.0 }
 
+// NewTimerWithClock constructs a new StandardTimer like NewTimer, which
+// reads the time from c and whose Meter is built with it.
+func NewTimerWithClock(c Clock) Timer {
+	if UseNilMetrics {
+		return NilTimer{}
+	}
+	return &StandardTimer{
+		histogram: NewHistogram(NewExpDecaySample(1028, 0.015)),
+		meter:     NewMeterWithClock(c),
+		clock:     c,
+	}
+}
+
 // StandardTimer is the standard implementation of a Timer and uses a Histogram
 // and Meter.
 type StandardTimer struct {
 	histogram Histogram
 	meter     Meter
 	mutex     sync.Mutex
+	clock     Clock // Source of the time, the system clock if nil
 }
 
 // Count returns the number of events recorded.
@@ -186,6 +244,36 @@ func (t *StandardTimer) RateMean() float64 {
 	return t.meter.RateMean()
 }
 
+// Record the duration of an event that started and ended at the given times,
+// such as a tracing span.  If end is before start, which can happen when the
+// times come from different clocks, a duration of zero is recorded.
+func (t *StandardTimer) Record(start, end time.Time) {
+	d := end.Sub(start)
+	if d < 0 {
+		d = 0
+	}
+	t.Update(d)
+}
+
+// Exemplars returns the most recent exemplars of the timer's histogram,
+// oldest first.
+func (t *StandardTimer) Exemplars() []Exemplar {
+	return t.histogram.Exemplars()
+}
+
+// Reset clears the histogram and resets the meter.
+func (t *StandardTimer) Reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.histogram.Clear()
+	t.meter.Reset()
+}
+
+// Sample returns the Sample underlying the timer's histogram.
+func (t *StandardTimer) Sample() Sample {
+	return t.histogram.Sample()
+}
+
 // Snapshot returns a read-only copy of the timer.
 func (t *StandardTimer) Snapshot() Timer {
 	t.mutex.Lock()
@@ -196,11 +284,36 @@ func (t *StandardTimer) Snapshot() Timer {
 	}
 }
 
+// SnapshotAndClear returns a read-only copy of the timer and clears its
+// histogram, as one operation if the histogram can be, as
+// StandardHistogram.SnapshotAndClear describes.  Its rates carry on.
+func (t *StandardTimer) SnapshotAndClear() Timer {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return &TimerSnapshot{
+		histogram: snapshotAndClear(t.histogram).(*HistogramSnapshot),
+		meter:     t.meter.Snapshot().(*MeterSnapshot),
+	}
+}
+
+// Start starts timing an event and returns a function which records its
+// duration when called, as in defer t.Start()().
+func (t *StandardTimer) Start() func() {
+	ts := t.now()
+	return func() { t.UpdateSince(ts) }
+}
+
 // StdDev returns the standard deviation of the values in the sample.
 func (t *StandardTimer) StdDev() float64 {
 	return t.histogram.StdDev()
 }
 
+// Stop stops the timer's meter, as Meter.Stop does, so that its rates stop
+// counting later updates, though its histogram still records them.
+func (t *StandardTimer) Stop() {
+	t.meter.Stop()
+}
+
 // Sum returns the sum in the sample.
 func (t *StandardTimer) Sum() int64 {
 	return t.histogram.Sum()
@@ -208,9 +321,9 @@ func (t *StandardTimer) Sum() int64 {
 
 // Record the duration of the execution of the given function.
 func (t *StandardTimer) Time(f func()) {
-	ts := time.Now()
+	ts := t.now()
 	f()
-	t.Update(time.Since(ts))
+	t.Update(t.now().Sub(ts))
 }
 
 // Record the duration of an event.
@@ -225,10 +338,27 @@ func (t *StandardTimer) Update(d time.Duration) {
 func (t *StandardTimer) UpdateSince(ts time.Time) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
-	t.histogram.Update(int64(time.Since(ts)))
+	t.histogram.Update(int64(t.now().Sub(ts)))
+	t.meter.Mark(1)
+}
+
+// Record the duration of an event and keep it as an exemplar of the trace
+// by the given ID.
+func (t *StandardTimer) UpdateWithExemplar(d time.Duration, traceID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.histogram.UpdateWithExemplar(int64(d), traceID)
 	t.meter.Mark(1)
 }
 
+// now returns the time of the timer's clock.
+func (t *StandardTimer) now() time.Time {
+	if nil == t.clock {
+		return time.Now()
+	}
+	return t.clock.Now()
+}
+
 // Variance returns the variance of the values in the sample.
 func (t *StandardTimer) Variance() float64 {
 	return t.histogram.Variance()
@@ -244,6 +374,10 @@ type TimerSnapshot struct {
 // taken.
 func (t *TimerSnapshot) Count() int64 { return t.histogram.Count() }
 
+// Exemplars returns the most recent exemplars, oldest first, at the time the
+// snapshot was taken.
+func (t *TimerSnapshot) Exemplars() []Exemplar { return t.histogram.Exemplars() }
+
 // Max returns the maximum value at the time the snapshot was taken.
 func (t *TimerSnapshot) Max() int64 { return t.histogram.Max() }
 
@@ -281,13 +415,34 @@ func (t *TimerSnapshot) Rate15() float64 { return t.meter.Rate15() }
 // snapshot was taken.
 func (t *TimerSnapshot) RateMean() float64 { return t.meter.RateMean() }
 
+// Record panics.
+func (*TimerSnapshot) Record(start, end time.Time) {
+	panic("Record called on a TimerSnapshot")
+}
+
+// Reset panics.
+func (*TimerSnapshot) Reset() {
+	panic("Reset called on a TimerSnapshot")
+}
+
+// Sample returns the Sample at the time the snapshot was taken.
+func (t *TimerSnapshot) Sample() Sample { return t.histogram.Sample() }
+
 // Snapshot returns the snapshot.
 func (t *TimerSnapshot) Snapshot() Timer { return t }
 
+// Start panics.
+func (*TimerSnapshot) Start() func() {
+	panic("Start called on a TimerSnapshot")
+}
+
 // StdDev returns the standard deviation of the values at the time the snapshot
 // was taken.
 func (t *TimerSnapshot) StdDev() float64 { return t.histogram.StdDev() }
 
+// Stop is a no-op.
+func (*TimerSnapshot) Stop() {}
+
 // Sum returns the sum at the time the snapshot was taken.
 func (t *TimerSnapshot) Sum() int64 { return t.histogram.Sum() }
 
@@ -306,6 +461,24 @@ func (*TimerSnapshot) UpdateSince(time.Time) {
 	panic("UpdateSince called on a TimerSnapshot")
 }
 
+// UpdateWithExemplar panics.
+func (*TimerSnapshot) UpdateWithExemplar(time.Duration, string) {
+	panic("UpdateWithExemplar called on a TimerSnapshot")
+}
+
 // Variance returns the variance of the values at the time the snapshot was
 // taken.
 func (t *TimerSnapshot) Variance() float64 { return t.histogram.Variance() }
+
+// timerSnapshotAndClear returns a read-only copy of t and clears its sample,
+// as one operation if t has a SnapshotAndClear method.
+func timerSnapshotAndClear(t Timer) Timer {
+	if sc, ok := t.(interface {
+		SnapshotAndClear() Timer
+	}); ok {
+		return sc.SnapshotAndClear()
+	}
+	snapshot := t.Snapshot()
+	t.Sample().Clear()
+	return snapshot
+}
@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"context"
 	"math"
 	"testing"
 	"time"
@@ -79,3 +80,103 @@ func TestTimerZero(t *testing.T) {
 		t.Errorf("tm.RateMean(): 0.0 != %v\n", rateMean)
 	}
 }
+
+func TestTimerReset(t *testing.T) {
+	tm := NewTimer()
+	tm.Update(47)
+	tm.Reset()
+	if count := tm.Count(); 0 != count {
+		t.Errorf("tm.Count(): 0 != %v\n", count)
+	}
+	if max := tm.Max(); 0 != max {
+		t.Errorf("tm.Max(): 0 != %v\n", max)
+	}
+	if rateMean := tm.RateMean(); 0.0 != rateMean {
+		t.Errorf("tm.RateMean(): 0.0 != %v\n", rateMean)
+	}
+	tm.Update(48)
+	if count := tm.Count(); 1 != count {
+		t.Errorf("tm.Count(): 1 != %v\n", count)
+	}
+	if max := tm.Max(); 48 != max {
+		t.Errorf("tm.Max(): 48 != %v\n", max)
+	}
+}
+
+func TestTimerRecord(t *testing.T) {
+	tm := NewTimer()
+	start := time.Now()
+	tm.Record(start, start.Add(47*time.Millisecond))
+	if count := tm.Count(); 1 != count {
+		t.Errorf("tm.Count(): 1 != %v\n", count)
+	}
+	if max := tm.Max(); int64(47*time.Millisecond) != max {
+		t.Errorf("tm.Max(): 47e6 != %v\n", max)
+	}
+}
+
+func TestTimerRecordSkewed(t *testing.T) {
+	tm := NewTimer()
+	start := time.Now()
+	tm.Record(start, start.Add(-time.Second))
+	if count := tm.Count(); 1 != count {
+		t.Errorf("tm.Count(): 1 != %v\n", count)
+	}
+	if min := tm.Min(); 0 != min {
+		t.Errorf("tm.Min(): 0 != %v\n", min)
+	}
+}
+
+func TestTimerSample(t *testing.T) {
+	tm := NewTimer()
+	tm.Update(time.Second)
+	if v := tm.Sample().Values(); 1 != len(v) || int64(time.Second) != v[0] {
+		t.Errorf("tm.Sample().Values(): %v", v)
+	}
+	snapshot := tm.Snapshot()
+	tm.Update(time.Second)
+	if n := len(snapshot.Sample().Values()); 1 != n {
+		t.Errorf("snapshot.Sample(): 1 != %v values", n)
+	}
+}
+
+func TestTimerStart(t *testing.T) {
+	c := NewMockClock(time.Unix(1000, 0))
+	tm := NewTimerWithClock(c)
+	func() {
+		defer tm.Start()()
+		c.Add(2 * time.Second)
+	}()
+	if count, max := tm.Count(), tm.Max(); 1 != count || int64(2*time.Second) != max {
+		t.Errorf("tm.Count(), tm.Max(): %v, %v", count, max)
+	}
+}
+
+func TestTimerContext(t *testing.T) {
+	if _, ok := TimerFromContext(context.Background()).(NilTimer); !ok {
+		t.Fatal("no NilTimer from an empty context")
+	}
+	TimerFromContext(context.Background()).Start()()
+	tm := NewTimer()
+	ctx := NewTimerContext(context.Background(), tm)
+	TimerFromContext(ctx).Start()()
+	if count := tm.Count(); 1 != count {
+		t.Errorf("tm.Count(): 1 != %v\n", count)
+	}
+}
+
+func TestTimerSnapshotAndClear(t *testing.T) {
+	tm := NewTimer()
+	tm.Update(time.Second)
+	tm.Update(3 * time.Second)
+	s := timerSnapshotAndClear(tm)
+	if 2 != s.Count() || int64(3*time.Second) != s.Max() {
+		t.Errorf("snapshot: %d values, max %v", s.Count(), time.Duration(s.Max()))
+	}
+	if 0 != tm.Count() {
+		t.Errorf("%d values left", tm.Count())
+	}
+	if 0 == tm.RateMean() {
+		t.Error("rates cleared")
+	}
+}
@@ -0,0 +1,267 @@
+package metrics
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TopKs count occurrences of keys, such as endpoints or clients, and report
+// the k most frequent of them, so that hot or noisy values can be exported
+// as a bounded set of series rather than a Counter for every distinct one.
+type TopK interface {
+	Add(string, int64)
+	Clear()
+	Mark(string)
+	Snapshot() TopK
+	Top() []TopKCount
+}
+
+// TopKCount is a key and the number of times it occurred.
+type TopKCount struct {
+	Key   string
+	Count int64
+}
+
+// topKCapacity is the number of keys a StandardTopK counts per key it
+// reports, trading memory for accuracy.
+const topKCapacity = 4
+
+// GetOrRegisterTopK returns an existing TopK or constructs and registers a
+// new StandardTopK.
+func GetOrRegisterTopK(name string, r Registry, k int, window time.Duration) TopK {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return r.GetOrRegister(name, func() TopK { return NewTopK(k, window) }).(TopK)
+}
+
+// NewTopK constructs a new StandardTopK which reports the k most frequent
+// keys of each window, or of all those added since it was cleared if
+// window is zero.
+func NewTopK(k int, window time.Duration) TopK {
+	return NewTopKWithClock(k, window, systemClock{})
+}
+
+// NewTopKWithClock constructs a new StandardTopK like NewTopK, whose windows
+// are measured by c.
+func NewTopKWithClock(k int, window time.Duration, c Clock) TopK {
+	if UseNilMetrics {
+		return NilTopK{}
+	}
+	t := &StandardTopK{k: k, window: window, clock: c, start: c.Now()}
+	t.reset()
+	return t
+}
+
+// NewRegisteredTopK constructs and registers a new StandardTopK, or returns
+// the TopK already registered under the given name.
+func NewRegisteredTopK(name string, r Registry, k int, window time.Duration) TopK {
+	return GetOrRegisterTopK(name, r, k, window)
+}
+
+// TopKSnapshot is a read-only copy of another TopK.
+type TopKSnapshot []TopKCount
+
+// Add panics.
+func (TopKSnapshot) Add(string, int64) {
+	panic("Add called on a TopKSnapshot")
+}
+
+// Clear panics.
+func (TopKSnapshot) Clear() {
+	panic("Clear called on a TopKSnapshot")
+}
+
+// Mark panics.
+func (TopKSnapshot) Mark(string) {
+	panic("Mark called on a TopKSnapshot")
+}
+
+// Snapshot returns the snapshot.
+func (t TopKSnapshot) Snapshot() TopK { return t }
+
+// Top returns the most frequent keys at the time the snapshot was taken.
+func (t TopKSnapshot) Top() []TopKCount {
+	top := make([]TopKCount, len(t))
+	copy(top, t)
+	return top
+}
+
+// NilTopK is a no-op TopK.
+type NilTopK struct{}
+
+// Add is a no-op.
+func (NilTopK) Add(string, int64) {}
+
+// Clear is a no-op.
+func (NilTopK) Clear() {}
+
+// Mark is a no-op.
+func (NilTopK) Mark(string) {}
+
+// Snapshot is a no-op.
+func (NilTopK) Snapshot() TopK { return NilTopK{} }
+
+// Top is a no-op.
+func (NilTopK) Top() []TopKCount { return []TopKCount{} }
+
+// StandardTopK is the standard implementation of a TopK, using the
+// space-saving algorithm: it counts at most four times k keys, and a new key
+// takes the place of the least frequent, inheriting its count.  A reported
+// count may therefore overestimate, by at most the total added divided by
+// the number of keys counted, but any key whose count exceeds that bound is
+// reported.  With a window, Top reports the last complete window.
+type StandardTopK struct {
+	mutex    sync.Mutex
+	k        int
+	window   time.Duration
+	clock    Clock
+	start    time.Time // Start of the current window
+	counts   map[string]*topKEntry
+	heap     topKHeap
+	previous []TopKCount // Top of the last complete window
+}
+
+// Add counts n occurrences of key.
+func (t *StandardTopK) Add(key string, n int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.rotate(t.clock.Now())
+	if e, ok := t.counts[key]; ok {
+		e.count += n
+		heap.Fix(&t.heap, e.index)
+		return
+	}
+	if len(t.heap) < topKCapacity*t.k {
+		e := &topKEntry{key: key, count: n}
+		t.counts[key] = e
+		heap.Push(&t.heap, e)
+		return
+	}
+	if 0 == len(t.heap) {
+		return
+	}
+	e := t.heap[0]
+	delete(t.counts, e.key)
+	e.key = key
+	e.count += n
+	t.counts[key] = e
+	heap.Fix(&t.heap, 0)
+}
+
+// Clear forgets every key, including those of the last complete window.
+func (t *StandardTopK) Clear() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.reset()
+	t.previous = nil
+	t.start = t.clock.Now()
+}
+
+// Mark counts an occurrence of key.
+func (t *StandardTopK) Mark(key string) {
+	t.Add(key, 1)
+}
+
+// Snapshot returns a read-only copy of the TopK.
+func (t *StandardTopK) Snapshot() TopK {
+	return TopKSnapshot(t.Top())
+}
+
+// Top returns at most k of the most frequent keys, in descending order of
+// count.
+func (t *StandardTopK) Top() []TopKCount {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.rotate(t.clock.Now())
+	if 0 < t.window {
+		top := make([]TopKCount, len(t.previous))
+		copy(top, t.previous)
+		return top
+	}
+	return t.top()
+}
+
+// reset forgets the keys of the current window.  It should run with t.mutex
+// held.
+func (t *StandardTopK) reset() {
+	t.counts = make(map[string]*topKEntry)
+	t.heap = nil
+}
+
+// rotate starts a new window if the current one is over, keeping its top
+// keys unless it ended more than a window ago.  It should run with t.mutex
+// held.
+func (t *StandardTopK) rotate(now time.Time) {
+	if t.window <= 0 {
+		return
+	}
+	elapsed := now.Sub(t.start)
+	if elapsed < t.window {
+		return
+	}
+	t.previous = nil
+	if elapsed < 2*t.window {
+		t.previous = t.top()
+	}
+	t.reset()
+	t.start = t.start.Add(elapsed / t.window * t.window)
+}
+
+// top returns at most k of the most frequent keys of the current window.  It
+// should run with t.mutex held.
+func (t *StandardTopK) top() []TopKCount {
+	top := make(topKCounts, len(t.heap))
+	for i, e := range t.heap {
+		top[i] = TopKCount{e.key, e.count}
+	}
+	sort.Sort(top)
+	if t.k < len(top) {
+		top = top[:t.k]
+	}
+	return top
+}
+
+// topKEntry is a key counted by a StandardTopK and its index in the heap.
+type topKEntry struct {
+	key   string
+	count int64
+	index int
+}
+
+// topKHeap is a min-heap of entries by count.
+type topKHeap []*topKEntry
+
+func (h topKHeap) Len() int           { return len(h) }
+func (h topKHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *topKHeap) Push(x interface{}) {
+	e := x.(*topKEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// topKCounts sorts counts in descending order, ties by key.
+type topKCounts []TopKCount
+
+func (c topKCounts) Len() int { return len(c) }
+func (c topKCounts) Less(i, j int) bool {
+	if c[i].Count != c[j].Count {
+		return c[i].Count > c[j].Count
+	}
+	return c[i].Key < c[j].Key
+}
+func (c topKCounts) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
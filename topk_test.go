@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTopK(t *testing.T) {
+	tk := NewTopK(2, 0)
+	for key, n := range map[string]int{"/a": 3, "/b": 5, "/c": 1} {
+		for i := 0; i < n; i++ {
+			tk.Mark(key)
+		}
+	}
+	tk.Add("/a", 10)
+	top := tk.Top()
+	if 2 != len(top) || (TopKCount{"/a", 13}) != top[0] || (TopKCount{"/b", 5}) != top[1] {
+		t.Errorf("Top(): %v", top)
+	}
+	tk.Clear()
+	if top := tk.Top(); 0 != len(top) {
+		t.Errorf("Top() after Clear(): %v", top)
+	}
+}
+
+func TestTopKEviction(t *testing.T) {
+	tk := NewTopK(1, 0)
+	for i := 0; i < 100; i++ {
+		tk.Mark(fmt.Sprintf("noise%d", i))
+		tk.Mark("hot")
+		tk.Mark("hot")
+	}
+	if top := tk.Top(); 1 != len(top) || "hot" != top[0].Key || top[0].Count < 200 {
+		t.Errorf("Top(): %v", top)
+	}
+	if n := len(tk.(*StandardTopK).counts); topKCapacity < n {
+		t.Errorf("%d keys counted", n)
+	}
+}
+
+func TestTopKWindow(t *testing.T) {
+	tk := NewTopK(3, time.Minute).(*StandardTopK)
+	start := tk.start
+	tk.Mark("a")
+	tk.rotate(start.Add(30 * time.Second))
+	if top := tk.previous; 0 != len(top) {
+		t.Errorf("window not over: %v", top)
+	}
+	tk.rotate(start.Add(61 * time.Second))
+	tk.Add("b", 2)
+	if top := tk.previous; 1 != len(top) || (TopKCount{"a", 1}) != top[0] {
+		t.Errorf("last window: %v", top)
+	}
+	if !tk.start.Equal(start.Add(time.Minute)) {
+		t.Errorf("window started at %v", tk.start)
+	}
+	tk.rotate(start.Add(5 * time.Minute))
+	if top := tk.previous; 0 != len(top) {
+		t.Errorf("idle window: %v", top)
+	}
+}
+
+func TestTopKSnapshot(t *testing.T) {
+	tk := NewTopK(2, 0)
+	tk.Mark("a")
+	s := tk.Snapshot()
+	tk.Mark("b")
+	if top := s.Top(); 1 != len(top) || "a" != top[0].Key {
+		t.Errorf("Snapshot().Top(): %v", top)
+	}
+	defer func() {
+		if nil == recover() {
+			t.Error("Mark on a TopKSnapshot didn't panic")
+		}
+	}()
+	s.Mark("c")
+}
+
+func TestGetOrRegisterTopK(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredTopK("foo", r, 2, 0).Mark("a")
+	if top := GetOrRegisterTopK("foo", r, 2, 0).Top(); 1 != len(top) {
+		t.Fatal(top)
+	}
+}
+
+func TestTopKWithClock(t *testing.T) {
+	clk := NewMockClock(time.Unix(1433160000, 0))
+	tk := NewTopKWithClock(1, time.Minute, clk)
+	tk.Mark("a")
+	if top := tk.Top(); 0 != len(top) {
+		t.Fatalf("window not over: %v", top)
+	}
+	clk.Add(time.Minute)
+	if top := tk.Top(); 1 != len(top) || "a" != top[0].Key {
+		t.Errorf("last window: %v", top)
+	}
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Datapoint is a single value collected from a Registry during a flush of
+// the OpenTSDB exporter, along with the Metadata of the metric it was read
+// from.  Datapoints from the same flush share their Tags map, which must not
+// be modified.
+type Datapoint struct {
+	Metric    string            // Metric name, including the configured prefix
+	Timestamp int64             // Unix time of the flush, in seconds or milliseconds
+	Value     float64           // Value of the metric
+	Tags      map[string]string // Tags, including host
+	Metadata
+}
+
+// Transport delivers the datapoints collected during each flush of the
+// OpenTSDB exporter.  Send should return promptly once ctx is cancelled.
+type Transport interface {
+	Send(context.Context, []Datapoint) error
+}
+
+// ChannelTransport is a Transport which hands each flush's datapoints to
+// in-process consumers over a buffered channel.
+//
+// Send never blocks the exporter.  If the buffer is full when a flush
+// completes, because consumers have fallen behind, that flush's datapoints
+// are dropped in their entirety and counted by Dropped.  Consumers which
+// must see every flush should size the buffer for their worst-case lag.
+type ChannelTransport struct {
+	C       <-chan []Datapoint // Receives one slice per flush
+	c       chan []Datapoint
+	dropped int64
+}
+
+// NewChannelTransport constructs a new ChannelTransport buffering up to size
+// flushes.
+func NewChannelTransport(size int) *ChannelTransport {
+	c := make(chan []Datapoint, size)
+	return &ChannelTransport{C: c, c: c}
+}
+
+// Dropped returns the number of flushes dropped because the buffer was full.
+func (t *ChannelTransport) Dropped() int64 {
+	return atomic.LoadInt64(&t.dropped)
+}
+
+// Send delivers points to the channel or drops them if the buffer is full.
+// The slice is not reused by the exporter and belongs to the receiver.
+func (t *ChannelTransport) Send(ctx context.Context, points []Datapoint) error {
+	select {
+	case t.c <- points:
+	default:
+		atomic.AddInt64(&t.dropped, 1)
+	}
+	return nil
+}
+
+// MultiTransport returns a Transport which sends each flush to every one of
+// the given transports, such as a TelnetTransport and a ChannelTransport,
+// and returns the first error encountered.
+func MultiTransport(transports ...Transport) Transport {
+	return multiTransport(transports)
+}
+
+type multiTransport []Transport
+
+func (ts multiTransport) Send(ctx context.Context, points []Datapoint) error {
+	var err error
+	for _, t := range ts {
+		if e := t.Send(ctx, points); nil != e && nil == err {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestChannelTransport(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredCounter("foo", r).Inc(47)
+	ct := NewChannelTransport(1)
+	c := OpenTSDBConfig{Registry: r, Prefix: "prefix", Transport: ct}
+	if err := openTSDB(context.Background(), &c); nil != err {
+		t.Fatal(err)
+	}
+	points := <-ct.C
+	if 1 != len(points) {
+		t.Fatal(points)
+	}
+	if p := points[0]; "prefix.foo.count" != p.Metric || 47 != p.Value || getShortHostname() != p.Tags["host"] {
+		t.Fatal(p)
+	}
+}
+
+func TestChannelTransportDropsWhenFull(t *testing.T) {
+	ct := NewChannelTransport(1)
+	for i := 0; i < 3; i++ {
+		if err := ct.Send(context.Background(), []Datapoint{{Metric: "foo"}}); nil != err {
+			t.Fatal(err)
+		}
+	}
+	if dropped := ct.Dropped(); 2 != dropped {
+		t.Fatal(dropped)
+	}
+	if n := len(ct.C); 1 != n {
+		t.Fatal(n)
+	}
+}
+
+type errTransport struct{ err error }
+
+func (t errTransport) Send(context.Context, []Datapoint) error { return t.err }
+
+func TestMultiTransport(t *testing.T) {
+	ct := NewChannelTransport(1)
+	err := errors.New("broken")
+	if e := MultiTransport(errTransport{err}, ct).Send(context.Background(), []Datapoint{{Metric: "foo"}}); err != e {
+		t.Fatal(e)
+	}
+	if n := len(ct.C); 1 != n {
+		t.Fatal(n)
+	}
+}
@@ -0,0 +1,145 @@
+//go:build go1.18
+// +build go1.18
+
+package metrics
+
+import (
+	"reflect"
+)
+
+// Number is the type of the values of TypedGauges: integers are kept in a
+// Gauge and floating-point numbers in a GaugeFloat64.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 |
+		~float32 | ~float64
+}
+
+// TypedGauges hold a value of type T, such as Gauge[int64] or Gauge[float64]
+// would, so that callers needn't convert values or assert the types of the
+// metrics they're given.  They're registered and exported as the Gauge or
+// GaugeFloat64 they wrap.
+type TypedGauge[T Number] interface {
+	Snapshot() TypedGauge[T]
+	Update(T)
+	Value() T
+}
+
+// GetOrRegisterTypedGauge returns an existing TypedGauge or constructs and
+// registers a new one, a StandardGauge or StandardGaugeFloat64 as T is an
+// integer or floating-point number.
+func GetOrRegisterTypedGauge[T Number](name string, r Registry) TypedGauge[T] {
+	if isFloat[T]() {
+		return typedGaugeFloat64[T]{GetOrRegisterGaugeFloat64(name, r)}
+	}
+	return typedGauge[T]{GetOrRegisterGauge(name, r)}
+}
+
+// NewTypedGauge constructs a new TypedGauge of a StandardGauge or
+// StandardGaugeFloat64.
+func NewTypedGauge[T Number]() TypedGauge[T] {
+	if isFloat[T]() {
+		return typedGaugeFloat64[T]{NewGaugeFloat64()}
+	}
+	return typedGauge[T]{NewGauge()}
+}
+
+// NewRegisteredTypedGauge constructs and registers a new TypedGauge, or
+// returns the TypedGauge of the gauge already registered under the given
+// name.
+func NewRegisteredTypedGauge[T Number](name string, r Registry) TypedGauge[T] {
+	return GetOrRegisterTypedGauge[T](name, r)
+}
+
+// GetOrRegisterGaugeFunc returns an existing TypedGauge or constructs and
+// registers a new one of a FunctionalGauge or FunctionalGaugeFloat64 which
+// reads its value from f.
+func GetOrRegisterGaugeFunc[T Number](name string, r Registry, f func() T) TypedGauge[T] {
+	if isFloat[T]() {
+		return typedGaugeFloat64[T]{GetOrRegisterFunctionalGaugeFloat64(name, r, func() float64 { return float64(f()) })}
+	}
+	return typedGauge[T]{GetOrRegisterFunctionalGauge(name, r, func() int64 { return int64(f()) })}
+}
+
+// NewGaugeFunc constructs a new TypedGauge of a FunctionalGauge or
+// FunctionalGaugeFloat64 which reads its value from f.
+func NewGaugeFunc[T Number](f func() T) TypedGauge[T] {
+	if isFloat[T]() {
+		return typedGaugeFloat64[T]{NewFunctionalGaugeFloat64(func() float64 { return float64(f()) })}
+	}
+	return typedGauge[T]{NewFunctionalGauge(func() int64 { return int64(f()) })}
+}
+
+// NewRegisteredGaugeFunc constructs and registers a new TypedGauge of a
+// FunctionalGauge or FunctionalGaugeFloat64, or returns the TypedGauge of the
+// gauge already registered under the given name.
+func NewRegisteredGaugeFunc[T Number](name string, r Registry, f func() T) TypedGauge[T] {
+	return GetOrRegisterGaugeFunc(name, r, f)
+}
+
+// Lookup returns the metric registered under the given name as a T, such as
+// a Counter or a Timer, and whether there's one of that type.
+func Lookup[T any](name string, r Registry) (T, bool) {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	m, ok := r.Get(name).(T)
+	return m, ok
+}
+
+// LookupOrRegister returns the metric registered under the given name as a
+// T, or registers the one returned by f, which is only called if none is
+// registered.  If the metric registered is of another type, a
+// MetricTypeConflict is returned in place of the panic of a failed type
+// assertion, or of a registry with the ConflictReject policy.
+func LookupOrRegister[T any](name string, r Registry, f func() T) (m T, err error) {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	defer func() {
+		if p := recover(); nil != p {
+			conflict, ok := p.(MetricTypeConflict)
+			if !ok {
+				panic(p)
+			}
+			err = conflict
+		}
+	}()
+	i := getOrRegisterTyped(name, r, reflect.TypeOf((*T)(nil)).Elem(), func() interface{} { return f() })
+	m, ok := i.(T)
+	if !ok {
+		n := f()
+		stop(n)
+		return m, MetricTypeConflict{Name: name, Existing: i, New: n}
+	}
+	return m, nil
+}
+
+// isFloat reports whether T is a floating-point type.
+func isFloat[T Number]() bool {
+	switch reflect.TypeOf(T(0)).Kind() {
+	case reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// typedGauge is a TypedGauge of a Gauge.
+type typedGauge[T Number] struct {
+	Gauge
+}
+
+func (g typedGauge[T]) Snapshot() TypedGauge[T] { return typedGauge[T]{g.Gauge.Snapshot()} }
+func (g typedGauge[T]) Update(v T)              { g.Gauge.Update(int64(v)) }
+func (g typedGauge[T]) Value() T                { return T(g.Gauge.Value()) }
+
+// typedGaugeFloat64 is a TypedGauge of a GaugeFloat64.
+type typedGaugeFloat64[T Number] struct {
+	GaugeFloat64
+}
+
+func (g typedGaugeFloat64[T]) Snapshot() TypedGauge[T] {
+	return typedGaugeFloat64[T]{g.GaugeFloat64.Snapshot()}
+}
+func (g typedGaugeFloat64[T]) Update(v T) { g.GaugeFloat64.Update(float64(v)) }
+func (g typedGaugeFloat64[T]) Value() T   { return T(g.GaugeFloat64.Value()) }
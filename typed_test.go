@@ -0,0 +1,106 @@
+//go:build go1.18
+// +build go1.18
+
+package metrics
+
+import (
+	"testing"
+)
+
+func TestTypedGauge(t *testing.T) {
+	r := NewRegistry()
+	type bytes int64
+	g := GetOrRegisterTypedGauge[bytes]("used", r)
+	g.Update(47)
+	if v := g.Value(); 47 != v {
+		t.Errorf("g.Value(): 47 != %v\n", v)
+	}
+	if v := r.Get("used").(Gauge).Value(); 47 != v {
+		t.Errorf("registered Gauge: 47 != %v\n", v)
+	}
+	snapshot := g.Snapshot()
+	g.Update(1)
+	if v := snapshot.Value(); 47 != v {
+		t.Errorf("snapshot.Value(): 47 != %v\n", v)
+	}
+	f := GetOrRegisterTypedGauge[float64]("load", r)
+	f.Update(0.5)
+	if v := r.Get("load").(GaugeFloat64).Value(); 0.5 != v {
+		t.Errorf("registered GaugeFloat64: 0.5 != %v\n", v)
+	}
+	if v := GetOrRegisterTypedGauge[float64]("load", r).Value(); 0.5 != v {
+		t.Errorf("existing gauge: 0.5 != %v\n", v)
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	r := NewRegistry()
+	n := int32(3)
+	g := GetOrRegisterGaugeFunc("n", r, func() int32 { return n })
+	n = 4
+	if v := g.Value(); 4 != v {
+		t.Errorf("g.Value(): 4 != %v\n", v)
+	}
+	if _, ok := r.Get("n").(*FunctionalGauge); !ok {
+		t.Errorf("registered %T", r.Get("n"))
+	}
+	if v := NewGaugeFunc(func() float32 { return 1.5 }).Value(); 1.5 != v {
+		t.Errorf("1.5 != %v\n", v)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	r := NewRegistry()
+	NewRegisteredCounter("c", r).Inc(2)
+	if c, ok := Lookup[Counter]("c", r); !ok || 2 != c.Count() {
+		t.Errorf("Lookup[Counter]: %v, %v", c, ok)
+	}
+	if _, ok := Lookup[Timer]("c", r); ok {
+		t.Error("Lookup[Timer] of a Counter")
+	}
+	if _, ok := Lookup[Counter]("missing", r); ok {
+		t.Error("Lookup of a missing metric")
+	}
+	tm, err := LookupOrRegister("t", r, NewTimer)
+	if nil != err || nil == r.Get("t") {
+		t.Fatal(err)
+	}
+	if again, _ := LookupOrRegister("t", r, NewTimer); tm != again {
+		t.Error("LookupOrRegister didn't return the registered Timer")
+	}
+	if _, err := LookupOrRegister("c", r, NewTimer); nil == err {
+		t.Error("no error for a Counter registered as a Timer")
+	} else if _, ok := err.(MetricTypeConflict); !ok {
+		t.Errorf("err: %#v", err)
+	} else if "metric type conflict: c is a *metrics.StandardCounter, not a *metrics.StandardTimer" != err.Error() {
+		t.Error(err)
+	}
+}
+
+func TestLookupOrRegisterConflictPolicies(t *testing.T) {
+	for _, policy := range []ConflictPolicy{ConflictReject, ConflictReplace} {
+		r := NewRegistryWithConflictPolicy(policy)
+		calls := 0
+		newCounter := func() Counter {
+			calls++
+			return NewCounter()
+		}
+		c, err := LookupOrRegister("c", r, newCounter)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if again, err := LookupOrRegister("c", r, newCounter); nil != err || c != again {
+			t.Errorf("policy %v: %v, %v", policy, again, err)
+		}
+		if 1 != calls {
+			t.Errorf("policy %v: f was called %d times", policy, calls)
+		}
+	}
+	r := NewRegistryWithConflictPolicy(ConflictReject)
+	GetOrRegisterGauge("foo", r)
+	if _, err := LookupOrRegister("foo", r, NewCounter); nil == err {
+		t.Error("no error for a Gauge registered as a Counter")
+	} else if _, ok := err.(MetricTypeConflict); !ok {
+		t.Errorf("err: %#v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// defaultMaxDatagram is the largest datagram sent over UDP unless configured
+// otherwise, which fits a typical Ethernet MTU after IP and UDP headers.
+const defaultMaxDatagram = 1432
+
+// writeDatagrams writes lines to w, which sends each write as a datagram,
+// packing as many whole lines separated by sep into each as fit in max bytes
+// so that none is fragmented.  A line which wouldn't fit in a datagram of
+// its own is dropped.  The first error, or else a drop, is returned after
+// every datagram has been tried.
+func writeDatagrams(w io.Writer, lines []string, sep string, max int) error {
+	var (
+		packet  bytes.Buffer
+		first   error
+		dropped int
+	)
+	send := func() {
+		if 0 == packet.Len() {
+			return
+		}
+		if _, err := w.Write(packet.Bytes()); nil != err && nil == first {
+			first = err
+		}
+		packet.Reset()
+	}
+	for _, line := range lines {
+		if max < len(line) {
+			dropped++
+			continue
+		}
+		if 0 < packet.Len() && max < packet.Len()+len(sep)+len(line) {
+			send()
+		}
+		if 0 < packet.Len() {
+			packet.WriteString(sep)
+		}
+		packet.WriteString(line)
+	}
+	send()
+	if nil == first && 0 < dropped {
+		first = fmt.Errorf("udp: dropped %d lines longer than a datagram of %d bytes", dropped, max)
+	}
+	return first
+}